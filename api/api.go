@@ -2,13 +2,17 @@ package api
 
 // VgGroup defines the observed state of NodeStorageResourceStatus
 type VgGroup struct {
-	VGName  string    `json:"vgName,omitempty"`
-	PVCount uint64    `json:"pvCount,omitempty"`
-	LVCount uint64    `json:"lvCount,omitempty"`
-	VGAttr  string    `json:"vgAttr,omitempty"`
-	VGSize  uint64    `json:"vgSize,omitempty"`
-	VGFree  uint64    `json:"vgFree,omitempty"`
-	PVS     []*PVInfo `json:"pvs,omitempty"`
+	VGName       string `json:"vgName,omitempty"`
+	PVCount      uint64 `json:"pvCount,omitempty"`
+	LVCount      uint64 `json:"lvCount,omitempty"`
+	VGAttr       string `json:"vgAttr,omitempty"`
+	VGSize       uint64 `json:"vgSize,omitempty"`
+	VGFree       uint64 `json:"vgFree,omitempty"`
+	VGExtentSize uint64 `json:"vgExtentSize,omitempty"`
+	VGTags       string `json:"vgTags,omitempty"`
+	// MissingPVCount is the number of PVs of the VG that are missing.
+	MissingPVCount uint64    `json:"missingPvCount,omitempty"`
+	PVS            []*PVInfo `json:"pvs,omitempty"`
 }
 
 // PVInfo defines pv details
@@ -19,6 +23,71 @@ type PVInfo struct {
 	PVAttr string `json:"pvAttr,omitempty"`
 	PVSize uint64 `json:"pvSize,omitempty"`
 	PVFree uint64 `json:"pvFree,omitempty"`
+	PVTags string `json:"pvTags,omitempty"`
+	PVUUID string `json:"pvUuid,omitempty"`
+	// DeviceID is the stable device id recorded in the lvm devices file, such as a wwid.
+	DeviceID     string `json:"deviceId,omitempty"`
+	DeviceIDType string `json:"deviceIdType,omitempty"`
+}
+
+// DiskMaintenance defines the maintenance progress of a cordoned disk
+type DiskMaintenance struct {
+	// Disk is the device path of the cordoned disk.
+	Disk string `json:"disk"`
+	// VGName is the volume group the disk belonged to.
+	VGName string `json:"vgName,omitempty"`
+	// Phase is one of Cordoned, Draining, Drained or Failed.
+	Phase string `json:"phase,omitempty"`
+	// Progress is the percentage of extents moved to other PVs.
+	Progress string `json:"progress,omitempty"`
+	// Message is the detail of the last failure.
+	Message string `json:"message,omitempty"`
+}
+
+// RaidVolume defines the sync state of a LVM raid volume
+type RaidVolume struct {
+	// Name is the name of the logical volume.
+	Name string `json:"name"`
+	// VGName is the volume group of the logical volume.
+	VGName string `json:"vgName"`
+	// Level is the segment type of the logical volume, e.g. raid1, raid5 or raid10.
+	Level string `json:"level"`
+	// State is one of Synced, Syncing or Degraded.
+	State string `json:"state"`
+	// SyncPercent is the percentage of the raid images in sync.
+	SyncPercent string `json:"syncPercent,omitempty"`
+	// Health is the health status reported by lvm, e.g. partial or refresh needed.
+	Health string `json:"health,omitempty"`
+}
+
+// DiskHealth defines the SMART health of a disk
+type DiskHealth struct {
+	// Disk is the device path of the disk.
+	Disk string `json:"disk"`
+	// Group is the disk group the disk belongs to.
+	Group string `json:"group,omitempty"`
+	// Model is the model name of the disk.
+	Model string `json:"model,omitempty"`
+	// Serial is the serial number of the disk.
+	Serial string `json:"serial,omitempty"`
+	// Protocol is one of ATA, SCSI or NVMe.
+	Protocol string `json:"protocol,omitempty"`
+	// Passed is the result of the SMART overall-health self-assessment.
+	Passed bool `json:"passed"`
+	// Temperature is the current temperature in Celsius.
+	Temperature int64 `json:"temperature"`
+	// ReallocatedSectors is the number of reallocated sectors, or grown defects of SCSI disks.
+	ReallocatedSectors int64 `json:"reallocatedSectors"`
+	// PendingSectors is the number of sectors waiting to be remapped.
+	PendingSectors int64 `json:"pendingSectors"`
+	// MediaErrors is the number of uncorrectable media errors.
+	MediaErrors int64 `json:"mediaErrors"`
+	// PercentageUsed is the estimated percentage of the SSD endurance used.
+	PercentageUsed int64 `json:"percentageUsed"`
+	// Condition is one of Healthy, Warning or Failing.
+	Condition string `json:"condition"`
+	// Message describes the thresholds crossed.
+	Message string `json:"message,omitempty"`
 }
 
 // Disk defines disk details
@@ -114,4 +183,36 @@ type UdevInfo struct {
 
 // Raid defines raid details
 type Raid struct {
+	// Name is the array name, the same as the disk group name.
+	Name string `json:"name"`
+	// Path is the md device path, e.g. /dev/md127.
+	Path string `json:"path"`
+	// Level is the raid level, one of raid0, raid1, raid5 or raid10.
+	Level string `json:"level"`
+	// State is the array state reported by mdadm, e.g. clean, degraded, recovering.
+	State string `json:"state"`
+	// Size is the array size in bytes.
+	Size uint64 `json:"size"`
+	// RaidDevices is the number of member devices the array is built with.
+	RaidDevices int `json:"raidDevices"`
+	// ActiveDevices is the number of in-sync members.
+	ActiveDevices int `json:"activeDevices"`
+	// FailedDevices is the number of faulty members.
+	FailedDevices int `json:"failedDevices"`
+	// SpareDevices is the number of spare members, including rebuilding ones.
+	SpareDevices int `json:"spareDevices"`
+	// Degraded is true when the array lacks active members.
+	Degraded bool `json:"degraded"`
+	// RebuildProgress is the percentage of the resync or recovery in progress.
+	RebuildProgress string `json:"rebuildProgress,omitempty"`
+	// Members are the member devices and their states.
+	Members []RaidMember `json:"members,omitempty"`
+}
+
+// RaidMember defines a member device of a raid
+type RaidMember struct {
+	// Device is the device path of the member, empty if it has been removed.
+	Device string `json:"device,omitempty"`
+	// State is the member state, e.g. active sync, spare rebuilding, faulty, removed.
+	State string `json:"state"`
 }
@@ -0,0 +1,104 @@
+/*
+ Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// BackupPhase 备份所处的阶段
+type BackupPhase string
+
+const (
+	// BackupPending 等待校验pvc
+	BackupPending BackupPhase = "Pending"
+	// BackupInProgress carina-node创建快照并上传数据
+	BackupInProgress BackupPhase = "InProgress"
+	BackupCompleted  BackupPhase = "Completed"
+	BackupFailed     BackupPhase = "Failed"
+)
+
+// BackupSpec defines the desired state of Backup
+type BackupSpec struct {
+	// PersistentVolumeClaim name of the pvc to back up, in the namespace of the Backup
+	PersistentVolumeClaim string `json:"persistentVolumeClaim"`
+	// Full reads the whole volume even if an incremental backup of a thin volume is possible
+	// +optional
+	Full bool `json:"full,omitempty"`
+}
+
+// BackupStatus defines the observed state of Backup
+type BackupStatus struct {
+	Phase            BackupPhase `json:"phase,omitempty"`
+	NodeName         string      `json:"nodeName,omitempty"`
+	DeviceGroup      string      `json:"deviceGroup,omitempty"`
+	LogicVolume      string      `json:"logicVolume,omitempty"`
+	VolumeID         string      `json:"volumeID,omitempty"`
+	PersistentVolume string      `json:"persistentVolume,omitempty"`
+	// StorageClassName, VolumeMode and Size of the pvc, used when restoring
+	StorageClassName string                       `json:"storageClassName,omitempty"`
+	VolumeMode       *corev1.PersistentVolumeMode `json:"volumeMode,omitempty"`
+	Size             *resource.Quantity           `json:"size,omitempty"`
+	// Snapshot thin snapshot kept on the node, the next backup of the volume only reads the blocks changed since it
+	Snapshot string `json:"snapshot,omitempty"`
+	// Base the backup this incremental backup is based on
+	Base           string       `json:"base,omitempty"`
+	ReadBytes      int64        `json:"readBytes,omitempty"`
+	UploadedBytes  int64        `json:"uploadedBytes,omitempty"`
+	Message        string       `json:"message,omitempty"`
+	StartTime      *metav1.Time `json:"startTime,omitempty"`
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="PVC",type="string",JSONPath=".spec.persistentVolumeClaim"
+// +kubebuilder:printcolumn:name="NODE",type="string",JSONPath=".status.nodeName"
+// +kubebuilder:printcolumn:name="PHASE",type="string",JSONPath=".status.phase"
+// +kubebuilder:printcolumn:name="BASE",type="string",priority=1,JSONPath=".status.base"
+// +kubebuilder:printcolumn:name="UPLOADED",type="integer",priority=1,JSONPath=".status.uploadedBytes"
+// +kubebuilder:printcolumn:name="AGE",type="date",JSONPath=".metadata.creationTimestamp"
+// +kubebuilder:resource:shortName=cbackup
+
+// Backup is the Schema for the backups API
+type Backup struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   BackupSpec   `json:"spec,omitempty"`
+	Status BackupStatus `json:"status,omitempty"`
+}
+
+// IsFinished returns true if the backup is completed or failed.
+func (b *Backup) IsFinished() bool {
+	return b.Status.Phase == BackupCompleted || b.Status.Phase == BackupFailed
+}
+
+// +kubebuilder:object:root=true
+
+// BackupList contains a list of Backup
+type BackupList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Backup `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&Backup{}, &BackupList{})
+}
@@ -0,0 +1,83 @@
+/*
+ Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// DeviceGroupQuota limits of a device group, unset limits are not enforced
+type DeviceGroupQuota struct {
+	// Storage total size of the volumes, raid volumes count their mirrors and parity,
+	// the cache of bcache and lvmcache volumes counts in the cache device group
+	// +optional
+	Storage *resource.Quantity `json:"storage,omitempty"`
+	// Volumes number of volumes
+	// +optional
+	Volumes *int64 `json:"volumes,omitempty"`
+	// ExclusiveDisks number of raw disks used exclusively by one volume
+	// +optional
+	ExclusiveDisks *int64 `json:"exclusiveDisks,omitempty"`
+}
+
+// DeviceGroupUsage usage of a device group
+type DeviceGroupUsage struct {
+	Storage        resource.Quantity `json:"storage"`
+	Volumes        int64             `json:"volumes"`
+	ExclusiveDisks int64             `json:"exclusiveDisks"`
+}
+
+// CarinaStorageQuotaSpec defines the desired state of CarinaStorageQuota
+type CarinaStorageQuotaSpec struct {
+	// Hard limits of the namespace keyed by device group name
+	Hard map[string]DeviceGroupQuota `json:"hard"`
+}
+
+// CarinaStorageQuotaStatus defines the observed state of CarinaStorageQuota
+type CarinaStorageQuotaStatus struct {
+	// Used usage of the namespace keyed by device group name, for the device groups in Hard
+	Used           map[string]DeviceGroupUsage `json:"used,omitempty"`
+	LastUpdateTime *metav1.Time                `json:"lastUpdateTime,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="AGE",type="date",JSONPath=".metadata.creationTimestamp"
+// +kubebuilder:resource:shortName=csq
+
+// CarinaStorageQuota is the Schema for the carinastoragequotas API
+type CarinaStorageQuota struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   CarinaStorageQuotaSpec   `json:"spec,omitempty"`
+	Status CarinaStorageQuotaStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// CarinaStorageQuotaList contains a list of CarinaStorageQuota
+type CarinaStorageQuotaList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []CarinaStorageQuota `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&CarinaStorageQuota{}, &CarinaStorageQuotaList{})
+}
@@ -17,6 +17,7 @@ limitations under the License.
 package v1
 
 import (
+	"github.com/carina-io/carina"
 	"google.golang.org/grpc/codes"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -79,6 +80,15 @@ func (lv *LogicVolume) IsCompatibleWith(lv2 *LogicVolume) bool {
 	return true
 }
 
+// PVName returns the name of the pv backed by the LogicVolume, which differs from
+// the LogicVolume name once the volume is migrated to another node.
+func (lv *LogicVolume) PVName() string {
+	if name := lv.Annotations[carina.PersistentVolumeAnnotation]; name != "" {
+		return name
+	}
+	return lv.Name
+}
+
 // +kubebuilder:object:root=true
 
 // LogicVolumeList contains a list of LogicVolume
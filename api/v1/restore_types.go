@@ -0,0 +1,102 @@
+/*
+ Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// RestorePhase 恢复所处的阶段
+type RestorePhase string
+
+const (
+	// RestorePending 等待校验备份
+	RestorePending RestorePhase = "Pending"
+	// RestoreProvisioning 创建pvc，等待pv绑定
+	RestoreProvisioning RestorePhase = "Provisioning"
+	// RestoreInProgress carina-node下载数据写入卷
+	RestoreInProgress RestorePhase = "InProgress"
+	RestoreCompleted  RestorePhase = "Completed"
+	RestoreFailed     RestorePhase = "Failed"
+)
+
+// RestoreSpec defines the desired state of Restore
+type RestoreSpec struct {
+	// Backup name of the completed Backup, in the namespace of the Restore
+	Backup string `json:"backup"`
+	// PersistentVolumeClaim name of the new pvc created in the namespace of the Restore
+	PersistentVolumeClaim string `json:"persistentVolumeClaim"`
+	// StorageClassName defaults to the storage class of the backed up pvc
+	// +optional
+	StorageClassName string `json:"storageClassName,omitempty"`
+	// NodeName node the volume is created on, defaults to the node with the most allocatable capacity
+	// +optional
+	NodeName string `json:"nodeName,omitempty"`
+}
+
+// RestoreStatus defines the observed state of Restore
+type RestoreStatus struct {
+	Phase            RestorePhase `json:"phase,omitempty"`
+	NodeName         string       `json:"nodeName,omitempty"`
+	DeviceGroup      string       `json:"deviceGroup,omitempty"`
+	LogicVolume      string       `json:"logicVolume,omitempty"`
+	VolumeID         string       `json:"volumeID,omitempty"`
+	PersistentVolume string       `json:"persistentVolume,omitempty"`
+	// SourceVolume and BackupID locate the backup in the object storage
+	SourceVolume   string       `json:"sourceVolume,omitempty"`
+	BackupID       string       `json:"backupID,omitempty"`
+	RestoredBytes  int64        `json:"restoredBytes,omitempty"`
+	Message        string       `json:"message,omitempty"`
+	StartTime      *metav1.Time `json:"startTime,omitempty"`
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="BACKUP",type="string",JSONPath=".spec.backup"
+// +kubebuilder:printcolumn:name="PVC",type="string",JSONPath=".spec.persistentVolumeClaim"
+// +kubebuilder:printcolumn:name="NODE",type="string",JSONPath=".status.nodeName"
+// +kubebuilder:printcolumn:name="PHASE",type="string",JSONPath=".status.phase"
+// +kubebuilder:printcolumn:name="AGE",type="date",JSONPath=".metadata.creationTimestamp"
+// +kubebuilder:resource:shortName=crestore
+
+// Restore is the Schema for the restores API
+type Restore struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   RestoreSpec   `json:"spec,omitempty"`
+	Status RestoreStatus `json:"status,omitempty"`
+}
+
+// IsFinished returns true if the restore is completed or failed.
+func (r *Restore) IsFinished() bool {
+	return r.Status.Phase == RestoreCompleted || r.Status.Phase == RestoreFailed
+}
+
+// +kubebuilder:object:root=true
+
+// RestoreList contains a list of Restore
+type RestoreList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Restore `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&Restore{}, &RestoreList{})
+}
@@ -0,0 +1,109 @@
+/*
+ Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// MigrationPhase 卷迁移所处的阶段
+type MigrationPhase string
+
+const (
+	// MigrationPending 等待校验源卷
+	MigrationPending MigrationPhase = "Pending"
+	// MigrationProvisioning 在目标节点创建LogicVolume
+	MigrationProvisioning MigrationPhase = "Provisioning"
+	// MigrationSyncing 应用运行期间全量复制数据
+	MigrationSyncing MigrationPhase = "Syncing"
+	// MigrationQuiescing 等待使用该卷的pod停止
+	MigrationQuiescing MigrationPhase = "Quiescing"
+	// MigrationFinalSync 应用停止后同步增量数据
+	MigrationFinalSync MigrationPhase = "FinalSync"
+	// MigrationRebinding 重建pv，指向目标节点的卷
+	MigrationRebinding MigrationPhase = "Rebinding"
+	// MigrationRetiring 删除源卷
+	MigrationRetiring  MigrationPhase = "Retiring"
+	MigrationCompleted MigrationPhase = "Completed"
+	MigrationFailed    MigrationPhase = "Failed"
+)
+
+// VolumeMigrationSpec defines the desired state of VolumeMigration
+type VolumeMigrationSpec struct {
+	// PersistentVolume name of the pv to migrate
+	PersistentVolume string `json:"persistentVolume"`
+	// TargetNode node the volume is moved to
+	TargetNode string `json:"targetNode"`
+	// TargetDeviceGroup device group on the target node, defaults to the device group of the source volume
+	// +optional
+	TargetDeviceGroup string `json:"targetDeviceGroup,omitempty"`
+}
+
+// VolumeMigrationStatus defines the observed state of VolumeMigration
+type VolumeMigrationStatus struct {
+	Phase        MigrationPhase `json:"phase,omitempty"`
+	SourceNode   string         `json:"sourceNode,omitempty"`
+	SourceVolume string         `json:"sourceVolume,omitempty"`
+	TargetVolume string         `json:"targetVolume,omitempty"`
+	// TargetEndpoint and TargetFingerprint are published by the target carina-node,
+	// the source carina-node streams to the endpoint and pins the tls certificate by its sha256 fingerprint
+	TargetEndpoint    string       `json:"targetEndpoint,omitempty"`
+	TargetFingerprint string       `json:"targetFingerprint,omitempty"`
+	TotalBytes        int64        `json:"totalBytes,omitempty"`
+	SyncedBytes       int64        `json:"syncedBytes,omitempty"`
+	InitialSynced     bool         `json:"initialSynced,omitempty"`
+	FinalSynced       bool         `json:"finalSynced,omitempty"`
+	Message           string       `json:"message,omitempty"`
+	StartTime         *metav1.Time `json:"startTime,omitempty"`
+	CompletionTime    *metav1.Time `json:"completionTime,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="PV",type="string",JSONPath=".spec.persistentVolume"
+// +kubebuilder:printcolumn:name="SOURCE",type="string",JSONPath=".status.sourceNode"
+// +kubebuilder:printcolumn:name="TARGET",type="string",JSONPath=".spec.targetNode"
+// +kubebuilder:printcolumn:name="PHASE",type="string",JSONPath=".status.phase"
+// +kubebuilder:printcolumn:name="SYNCED",type="integer",priority=1,JSONPath=".status.syncedBytes"
+// +kubebuilder:resource:scope=Cluster,shortName=vmig
+
+// VolumeMigration is the Schema for the volumemigrations API
+type VolumeMigration struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   VolumeMigrationSpec   `json:"spec,omitempty"`
+	Status VolumeMigrationStatus `json:"status,omitempty"`
+}
+
+// IsFinished returns true if the migration is completed or failed.
+func (vm *VolumeMigration) IsFinished() bool {
+	return vm.Status.Phase == MigrationCompleted || vm.Status.Phase == MigrationFailed
+}
+
+// +kubebuilder:object:root=true
+
+// VolumeMigrationList contains a list of VolumeMigration
+type VolumeMigrationList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []VolumeMigration `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&VolumeMigration{}, &VolumeMigrationList{})
+}
@@ -53,6 +53,15 @@ type NodeStorageResourceStatus struct {
 	Disks []api.Disk `json:"disks,,omitempty"`
 	// +optional
 	RAIDs []api.Raid `json:"raids,omitempty"`
+	// RaidVolumes represents the sync state of LVM raid volumes
+	// +optional
+	RaidVolumes []api.RaidVolume `json:"raidVolumes,omitempty"`
+	// DiskMaintenances represents the progress of cordoned disks
+	// +optional
+	DiskMaintenances []api.DiskMaintenance `json:"diskMaintenances,omitempty"`
+	// DiskHealth represents the SMART health of disks in disk groups
+	// +optional
+	DiskHealth []api.DiskHealth `json:"diskHealth,omitempty"`
 }
 
 // +kubebuilder:object:root=true
@@ -134,6 +134,21 @@ func (in *NodeStorageResourceStatus) DeepCopyInto(out *NodeStorageResourceStatus
 		*out = make([]api.Raid, len(*in))
 		copy(*out, *in)
 	}
+	if in.RaidVolumes != nil {
+		in, out := &in.RaidVolumes, &out.RaidVolumes
+		*out = make([]api.RaidVolume, len(*in))
+		copy(*out, *in)
+	}
+	if in.DiskMaintenances != nil {
+		in, out := &in.DiskMaintenances, &out.DiskMaintenances
+		*out = make([]api.DiskMaintenance, len(*in))
+		copy(*out, *in)
+	}
+	if in.DiskHealth != nil {
+		in, out := &in.DiskHealth, &out.DiskHealth
+		*out = make([]api.DiskHealth, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeStorageResourceStatus.
@@ -99,6 +99,8 @@ func subMain() error {
 	dec, _ := admission.NewDecoder(scheme)
 	wh := mgr.GetWebhookServer()
 	wh.Register("/pod/mutate", hook.PodMutator(mgr, dec))
+	wh.Register("/storageclass/validate", hook.StorageClassValidator(mgr, dec))
+	wh.Register("/pvc/validate", hook.PVCValidator(mgr, dec))
 	//wh.Register("/pvc/mutate", hook.PVCMutator(mgr.GetClient(), dec))
 
 	ctx := ctrl.SetupSignalHandler()
@@ -113,6 +115,40 @@ func subMain() error {
 		return err
 	}
 
+	vmcontroller := controllers.NewVolumeMigrationReconciler(
+		mgr.GetClient(),
+		mgr.GetEventRecorderFor("volumemigration-controller"),
+	)
+	if err := vmcontroller.SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "VolumeMigration")
+		return err
+	}
+
+	backupController := controllers.NewBackupReconciler(
+		mgr.GetClient(),
+		mgr.GetAPIReader(),
+		mgr.GetEventRecorderFor("backup-controller"),
+	)
+	if err := backupController.SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "Backup")
+		return err
+	}
+
+	restoreController := controllers.NewRestoreReconciler(
+		mgr.GetClient(),
+		mgr.GetEventRecorderFor("restore-controller"),
+	)
+	if err := restoreController.SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "Restore")
+		return err
+	}
+
+	quotaController := controllers.NewCarinaStorageQuotaReconciler(mgr.GetClient())
+	if err := quotaController.SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "CarinaStorageQuota")
+		return err
+	}
+
 	//+kubebuilder:scaffold:builder
 
 	// Add health checker to manager
@@ -127,10 +163,14 @@ func subMain() error {
 		return err
 	}
 	n := k8s.NewNodeService(mgr, lvService)
+	lsService, err := k8s.NewLogicSnapshotService(mgr)
+	if err != nil {
+		return err
+	}
 
 	grpcServer := grpc.NewServer()
 	csi.RegisterIdentityServer(grpcServer, driver.NewIdentityService(checker.Ready))
-	csi.RegisterControllerServer(grpcServer, driver.NewControllerService(lvService, n))
+	csi.RegisterControllerServer(grpcServer, driver.NewControllerService(lvService, n, lsService, k8s.NewQuotaService(mgr)))
 
 	// gRPC service itself should run even when the manager is *not* a leader
 	// because CSI sidecar containers choose a leader.
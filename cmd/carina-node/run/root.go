@@ -27,9 +27,10 @@ import (
 )
 
 var config struct {
-	csiSocket   string
-	metricsAddr string
-	zapOpts     zap.Options
+	csiSocket     string
+	metricsAddr   string
+	migrationAddr string
+	zapOpts       zap.Options
 }
 
 var rootCmd = &cobra.Command{
@@ -61,6 +62,7 @@ func init() {
 	fs := rootCmd.Flags()
 	fs.StringVar(&config.csiSocket, "csi-address", carina.DefaultCSISocket, "UNIX domain socket filename for CSI")
 	fs.StringVar(&config.metricsAddr, "metrics-addr", ":8080", "Listen address for metrics")
+	fs.StringVar(&config.migrationAddr, "migration-addr", ":8089", "Listen address for receiving volume migration data")
 
 	goflags := flag.NewFlagSet("klog", flag.ExitOnError)
 	klog.InitFlags(goflags)
@@ -20,8 +20,11 @@ import (
 	"context"
 	"errors"
 	"github.com/carina-io/carina"
+	"github.com/carina-io/carina/pkg/configuration"
 	"github.com/carina-io/carina/runners"
+	corev1 "k8s.io/api/core/v1"
 	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"os"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -79,10 +82,15 @@ func subMain() error {
 
 	// 初始化磁盘管理服务
 	dm := deviceManager.NewDeviceManager(nodeName, mgr.GetCache(), mgr.GetClient())
+	dm.ClusterID, err = clusterID(mgr.GetAPIReader())
+	if err != nil {
+		setupLog.Error(err, "unable to get cluster id, volumes won't be tagged and orphan volumes won't be cleaned up")
+	}
 
 	// pod io controller
 	podIOController := controllers.NewPodIOReconciler(
 		mgr.GetClient(),
+		mgr.GetEventRecorderFor("podio-node"),
 		nodeName,
 		dm.Partition,
 	)
@@ -100,6 +108,57 @@ func subMain() error {
 		setupLog.Error(err, "unable to create controller", "controller", "LogicalVolume")
 		return err
 	}
+	// logic snapshot controller
+	lsController := controllers.NewLogicSnapshotReconciler(
+		mgr.GetClient(),
+		mgr.GetEventRecorderFor("logicsnapshot-node"),
+		dm,
+	)
+	if err = lsController.SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "LogicSnapshot")
+		return err
+	}
+
+	// volume migration controller, transfer volume data between nodes
+	vmController, err := controllers.NewVolumeMigrationNodeReconciler(
+		mgr.GetClient(),
+		mgr.GetAPIReader(),
+		mgr.GetEventRecorderFor("volumemigration-node"),
+		dm,
+		config.migrationAddr,
+		advertiseHost(nodeName, mgr.GetAPIReader()),
+	)
+	if err != nil {
+		setupLog.Error(err, "unable to create migration server")
+		return err
+	}
+	if err = vmController.SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "VolumeMigration")
+		return err
+	}
+
+	// backup and restore controllers, transfer volume data from and to object storage
+	backupController := controllers.NewBackupNodeReconciler(
+		mgr.GetClient(),
+		mgr.GetAPIReader(),
+		mgr.GetEventRecorderFor("backup-node"),
+		dm,
+	)
+	if err = backupController.SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "Backup")
+		return err
+	}
+
+	restoreController := controllers.NewRestoreNodeReconciler(
+		mgr.GetClient(),
+		mgr.GetAPIReader(),
+		mgr.GetEventRecorderFor("restore-node"),
+		dm,
+	)
+	if err = restoreController.SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "Restore")
+		return err
+	}
 
 	//+kubebuilder:scaffold:builder
 
@@ -143,6 +202,26 @@ func subMain() error {
 		return err
 	}
 
+	// add thin pool check to manager, extend thin pool automatically
+	if err = mgr.Add(runners.NewThinPoolCheck(dm)); err != nil {
+		return err
+	}
+
+	// add disk maintenance to manager, drain cordoned disks out of vg
+	if err = mgr.Add(runners.NewDiskMaintenance(dm)); err != nil {
+		return err
+	}
+
+	// add raid check to manager, create md raid and replace failed members
+	if err = mgr.Add(runners.NewRaidCheck(dm)); err != nil {
+		return err
+	}
+
+	// add disk health check to manager, collect smart info of disks
+	if err = mgr.Add(runners.NewDiskHealthCheck(dm, mgr.GetEventRecorderFor("carina-node"))); err != nil {
+		return err
+	}
+
 	// add nsr reconciler to manager
 	if err = mgr.Add(runners.NewNodeStorageResourceReconciler(mgr, dm)); err != nil {
 		return err
@@ -168,3 +247,43 @@ func checkFunc(dm *deviceManager.DeviceManager, c client.Reader) func() error {
 		return c.Get(ctx, types.NamespacedName{Name: carina.CSIPluginName}, &drv)
 	}
 }
+
+//+kubebuilder:rbac:groups="",resources=namespaces,verbs=get
+
+// clusterID 优先使用配置的clusterId，否则使用kube-system命名空间的uid
+func clusterID(c client.Reader) (string, error) {
+	if id := configuration.ClusterID(); id != "" {
+		return id, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var ns corev1.Namespace
+	if err := c.Get(ctx, types.NamespacedName{Name: metav1.NamespaceSystem}, &ns); err != nil {
+		return "", err
+	}
+	return string(ns.UID), nil
+}
+
+//+kubebuilder:rbac:groups="",resources=nodes,verbs=get
+
+// advertiseHost 源节点连接本节点接收迁移数据的地址，优先使用pod ip，否则使用节点的InternalIP
+func advertiseHost(nodeName string, c client.Reader) string {
+	if ip := os.Getenv("POD_IP"); ip != "" {
+		return ip
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var node corev1.Node
+	if err := c.Get(ctx, types.NamespacedName{Name: nodeName}, &node); err != nil {
+		setupLog.Error(err, "unable to get node address, advertise node name for volume migration")
+		return nodeName
+	}
+	for _, addr := range node.Status.Addresses {
+		if addr.Type == corev1.NodeInternalIP {
+			return addr.Address
+		}
+	}
+	return nodeName
+}
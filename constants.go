@@ -29,11 +29,37 @@ const (
 
 	// LogicVolumeFinalizer LogicalVolumeFinalizer is the name of LogicalVolume finalizer
 	LogicVolumeFinalizer = "carina.storage.io/logicvolume"
+	// LogicSnapshotFinalizer is the name of LogicSnapshot finalizer
+	LogicSnapshotFinalizer = "carina.storage.io/logicsnapshot"
+	// VolumeMigrationFinalizer is the name of VolumeMigration finalizer
+	VolumeMigrationFinalizer = "carina.storage.io/volumemigration"
+	// BackupFinalizer is the name of Backup finalizer, removes the backup from the object storage
+	BackupFinalizer = "carina.storage.io/backup"
+	// BackupSnapshotFinalizer is the name of Backup finalizer, removes the snapshot kept on the node for incremental backups
+	BackupSnapshotFinalizer = "carina.storage.io/backup-snapshot"
+	// RestoreFinalizer is the name of Restore finalizer
+	RestoreFinalizer = "carina.storage.io/restore"
 	// ResizeRequestedAtKey is the key of LogicalVolume that represents the timestamp of the resize request.
 	ResizeRequestedAtKey = "carina.storage.io/resize-requested-at"
 
 	//  ExclusivityDisk  true or false  is the key indicates that only the disk is used by one pod
 	ExclusivityDisk = "carina.storage.io/exclusively-raw-disk"
+	// ThinProvisioning true or false, provision lvm volume from the thin pool of device group
+	ThinProvisioning = "carina.storage.io/thin-provisioning"
+	// VolumeEncryption true or false, encrypt volume with LUKS, passphrase is from node-stage/node-publish secret
+	VolumeEncryption = "carina.storage.io/encryption"
+	// VolumeRaidLevel value: raid1|raid5|raid10, create lvm raid volume to tolerate a single disk failure
+	VolumeRaidLevel = "carina.storage.io/raid-level"
+	// VolumeMirrors number of additional copies of raid1|raid10 volume, defaults to 1
+	VolumeMirrors = "carina.storage.io/mirrors"
+	// VolumeStripes number of stripes of striped|raid5|raid10 volume, raid5|raid10 defaults to 2
+	VolumeStripes = "carina.storage.io/stripes"
+	// VolumeStripeSize size of each stripe, e.g. 64k
+	VolumeStripeSize = "carina.storage.io/stripe-size"
+	// EncryptionPassphraseKey secret key of current LUKS passphrase
+	EncryptionPassphraseKey = "encryptionPassphrase"
+	// EncryptionPreviousPassphraseKey secret key of previous LUKS passphrase, used for key rotation
+	EncryptionPreviousPassphraseKey = "previousEncryptionPassphrase"
 
 	VolumeManagerType = "carina.io/volume-manage-type"
 
@@ -45,12 +71,31 @@ const (
 	VolumeCacheDiskType   = "carina.storage.io/cache-disk-group-name"
 	// VolumeCacheDiskRatio value: 1-100 Cache Capacity Ratio
 	VolumeCacheDiskRatio = "carina.storage.io/cache-disk-ratio"
-	// VolumeCachePolicy value: writethrough|writeback|writearound
+	// VolumeCachePolicy value: writethrough|writeback|writearound, lvmcache supports writethrough|writeback|writecache
 	VolumeCachePolicy = "carina.storage.io/cache-policy"
-
-	// MinRequestSizeGb pvc
-	// default size in GiB for volumes (PVC or inline ephemeral volumes) w/o capacity requests.
-	MinRequestSizeGb = 1
+	// VolumeCacheBackend value: bcache|lvmcache, defaults to bcache
+	VolumeCacheBackend = "carina.storage.io/cache-backend"
+	BcacheBackend      = "bcache"
+	LvmCacheBackend    = "lvmcache"
+	// CacheGroupTagPrefix pv tag of the cache disks joined the vg of the backend disk group, carina.storage.io/cache-group:<cache disk group>
+	CacheGroupTagPrefix = "carina.storage.io/cache-group:"
+
+	// Owner tags of the lvm volumes created by carina, carina.storage.io/cluster:<cluster id> marks the volume owned by the cluster,
+	// the others record the pv, pvc <namespace>/<name> and the creation time in unix seconds
+	OwnerClusterTagPrefix = "carina.storage.io/cluster:"
+	OwnerPVTagPrefix      = "carina.storage.io/pv:"
+	OwnerPVCTagPrefix     = "carina.storage.io/pvc:"
+	OwnerCreatedTagPrefix = "carina.storage.io/created:"
+	// CloneCompletedTag lv tag of the volume cloned from a thick source, set after its data is copied
+	CloneCompletedTag = "carina.storage.io/clone-completed"
+
+	// DefaultRequestSize pvc
+	// default size in bytes for volumes (PVC or inline ephemeral volumes) w/o capacity requests.
+	DefaultRequestSize = 1 << 30
+	// VolumeAlignment volume sizes are rounded up to the default lvm extent size, which is also a multiple of the partition alignment
+	VolumeAlignment = 4 << 20
+	// PartitionAlignment raw partitions start and end on 1MiB boundaries
+	PartitionAlignment = 1 << 20
 	// AnnSelectedNode This annotation is added to a PVC that has been triggered by scheduler to
 	// be dynamically provisioned. Its value is the name of the selected node.
 	AnnSelectedNode = "volume.kubernetes.io/selected-node"
@@ -68,12 +113,20 @@ const (
 	VolumeCacheBlock       = "carina.storage.io/cache/block"
 	VolumeCacheBucket      = "carina.storage.io/cache/bucket"
 
+	// VolumeDataSourceKind volume content source, snapshot or volume
+	VolumeDataSourceKind = "carina.storage.io/data-source-kind"
+	VolumeDataSourceID   = "carina.storage.io/data-source-id"
+	SnapshotSourceKind   = "snapshot"
+	VolumeSourceKind     = "volume"
+
 	// TopologyNodeKey topology
 	// TopologyZoneKey is the key of topology that represents zone name.
 	TopologyNodeKey = "topology.carina.storage.io/node"
 
 	// DeviceCapacityKeyPrefix device plugin
 	DeviceCapacityKeyPrefix = "carina.storage.io/"
+	// ThinCapacityKeyPrefix thin pool capacity of device group, with overcommit
+	ThinCapacityKeyPrefix = "thin.carina.storage.io/"
 	// DeviceVGSSD support disk type
 	DeviceVGSSD = "carina-vg-ssd"
 	DeviceVGHDD = "carina-vg-hdd"
@@ -87,14 +140,41 @@ const (
 
 	AllowPodMigrationIfNodeNotready = "carina.storage.io/allow-pod-migration-if-node-notready"
 
+	// VolumeMigrationAnnotation annotation of the source LogicVolume, name of the VolumeMigration moving the volume
+	VolumeMigrationAnnotation = "carina.storage.io/migration"
+	// PersistentVolumeAnnotation annotation of LogicVolume whose name differs from the pv, e.g. the target of a migration
+	PersistentVolumeAnnotation = "carina.storage.io/persistent-volume"
+	// MigrationSourcePVAnnotation annotation of VolumeMigration, the pv recorded before it is recreated on the target node
+	MigrationSourcePVAnnotation = "carina.storage.io/source-pv"
+	// MigrationTokenKey secret key of the token authenticating the migration stream between carina-node agents
+	MigrationTokenKey = "token"
+
+	// RestoreAnnotation annotation of the pvc and LogicVolume created by a Restore, the volume can not be published until the data is restored
+	RestoreAnnotation = "carina.storage.io/restore"
+	// BackupAccessKeyID and BackupSecretAccessKey secret keys of the object storage credentials
+	BackupAccessKeyID     = "accessKeyID"
+	BackupSecretAccessKey = "secretAccessKey"
+
+	// DiskCordonAnnotation annotation of NodeStorageResource, comma separated device paths of cordoned disks
+	DiskCordonAnnotation = "carina.storage.io/cordoned-disks"
+
 	CarinaPrefix = "carina.io"
 
 	ConfigSourceAnnotationKey = "kubernetes.io/config.source"
 	// Updates from Kubernetes API Server
 	ApiserverSource = "api"
 
-	ThinPrefix   = "thin-"
-	VolumePrefix = "volume-"
+	ThinPrefix     = "thin-"
+	VolumePrefix   = "volume-"
+	SnapshotPrefix = "snapshot-"
+	// LuksPrefix dm-crypt mapping name of encrypted volume
+	LuksPrefix = "luks-"
+	// LvmCachePrefix cache volume of lvmcache volume
+	LvmCachePrefix = "lvmcache-"
+	// ClonePrefix temporary snapshot of the thick source volume while cloning
+	ClonePrefix = "clone-"
+	// ThinPoolName thin pool shared by thin volumes of device group
+	ThinPoolName = ThinPrefix + "pool"
 
 	ResourceExhausted = "don't have enough space"
 )
@@ -0,0 +1,285 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/carina-io/carina"
+	carinav1 "github.com/carina-io/carina/api/v1"
+	"github.com/carina-io/carina/pkg/backup"
+	"github.com/carina-io/carina/pkg/configuration"
+	"github.com/carina-io/carina/utils"
+	"github.com/carina-io/carina/utils/log"
+)
+
+// backupWaitInterval 等待同一pv的其他备份或恢复结束的间隔
+const backupWaitInterval = 30 * time.Second
+
+// BackupReconciler 校验备份的pvc并交由卷所在节点的carina-node上传，删除Backup时清理对象存储中的备份数据
+type BackupReconciler struct {
+	client.Client
+	apiReader client.Reader
+	recorder  record.EventRecorder
+}
+
+// +kubebuilder:rbac:groups=carina.storage.io,resources=backups,verbs=get;list;watch;update;patch
+// +kubebuilder:rbac:groups=carina.storage.io,resources=backups/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=carina.storage.io,resources=restores,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=persistentvolumeclaims,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get
+
+func NewBackupReconciler(client client.Client, apiReader client.Reader, recorder record.EventRecorder) *BackupReconciler {
+	return &BackupReconciler{
+		Client:    client,
+		apiReader: apiReader,
+		recorder:  recorder,
+	}
+}
+
+func (r *BackupReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	b := new(carinav1.Backup)
+	if err := r.Get(ctx, req.NamespacedName, b); err != nil {
+		if !apierrs.IsNotFound(err) {
+			log.Error(err, " unable to fetch Backup")
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, nil
+	}
+
+	if b.DeletionTimestamp != nil {
+		if !utils.ContainsString(b.Finalizers, carina.BackupFinalizer) {
+			return ctrl.Result{}, nil
+		}
+		log.Info("Start finalizing Backup name ", req.NamespacedName)
+		return r.finalize(ctx, b)
+	}
+
+	if !utils.ContainsString(b.Finalizers, carina.BackupFinalizer) {
+		b2 := b.DeepCopy()
+		b2.Finalizers = append(b2.Finalizers, carina.BackupFinalizer)
+		return ctrl.Result{}, r.Patch(ctx, b2, client.MergeFrom(b))
+	}
+
+	if b.Status.Phase != "" && b.Status.Phase != carinav1.BackupPending {
+		return ctrl.Result{}, nil
+	}
+	result, err := r.prepare(ctx, b)
+	if err != nil {
+		log.Error(err, " failed to reconcile Backup name ", req.NamespacedName)
+	}
+	return result, err
+}
+
+func (r *BackupReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&carinav1.Backup{}).
+		Complete(r)
+}
+
+// prepare 校验pvc，记录卷所在节点，同一pv同时只进行一个备份
+func (r *BackupReconciler) prepare(ctx context.Context, b *carinav1.Backup) (ctrl.Result, error) {
+	if _, err := configuration.BackupStorageConfig(); err != nil {
+		return ctrl.Result{}, r.fail(ctx, b, err.Error())
+	}
+
+	pvc := new(corev1.PersistentVolumeClaim)
+	if err := r.Get(ctx, client.ObjectKey{Namespace: b.Namespace, Name: b.Spec.PersistentVolumeClaim}, pvc); err != nil {
+		if apierrs.IsNotFound(err) {
+			return ctrl.Result{}, r.fail(ctx, b, fmt.Sprintf("persistent volume claim %s not found", b.Spec.PersistentVolumeClaim))
+		}
+		return ctrl.Result{}, err
+	}
+	if pvc.Status.Phase != corev1.ClaimBound {
+		return ctrl.Result{}, r.fail(ctx, b, fmt.Sprintf("persistent volume claim %s is not bound", pvc.Name))
+	}
+	pv := new(corev1.PersistentVolume)
+	if err := r.Get(ctx, client.ObjectKey{Name: pvc.Spec.VolumeName}, pv); err != nil {
+		return ctrl.Result{}, err
+	}
+	if pv.Spec.CSI == nil || pv.Spec.CSI.Driver != carina.CSIPluginName {
+		return ctrl.Result{}, r.fail(ctx, b, fmt.Sprintf("persistent volume %s is not provisioned by carina", pv.Name))
+	}
+	lv, err := logicVolumeByVolumeID(ctx, r.Client, pv.Spec.CSI.VolumeHandle)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if lv == nil {
+		return ctrl.Result{}, r.fail(ctx, b, fmt.Sprintf("logic volume of %s not found", pv.Spec.CSI.VolumeHandle))
+	}
+	// 裸盘分区以及bcache卷不支持快照，无法备份
+	if lv.Annotations[carina.VolumeManagerType] != carina.LvmVolumeType || len(lv.OwnerReferences) > 0 {
+		return ctrl.Result{}, r.fail(ctx, b, fmt.Sprintf("volume %s is not a lvm volume and can not be backed up", lv.Name))
+	}
+	if name, ok := lv.Annotations[carina.VolumeMigrationAnnotation]; ok {
+		return ctrl.Result{}, r.fail(ctx, b, fmt.Sprintf("volume %s is being migrated by %s", lv.Name, name))
+	}
+	if name, ok := lv.Annotations[carina.RestoreAnnotation]; ok {
+		return ctrl.Result{}, r.fail(ctx, b, fmt.Sprintf("volume %s is being restored by %s", lv.Name, name))
+	}
+
+	busy, err := r.volumeBusy(ctx, pv.Name, b.UID)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if busy != "" {
+		return ctrl.Result{RequeueAfter: backupWaitInterval}, r.setMessage(ctx, b, carinav1.BackupPending, busy)
+	}
+
+	size := lv.Spec.Size
+	if lv.Status.CurrentSize != nil {
+		size = *lv.Status.CurrentSize
+	}
+	now := metav1.Now()
+	b2 := b.DeepCopy()
+	b2.Status.Phase = carinav1.BackupInProgress
+	b2.Status.NodeName = lv.Spec.NodeName
+	b2.Status.DeviceGroup = lv.Spec.DeviceGroup
+	b2.Status.LogicVolume = lv.Name
+	b2.Status.VolumeID = lv.Status.VolumeID
+	b2.Status.PersistentVolume = pv.Name
+	if pvc.Spec.StorageClassName != nil {
+		b2.Status.StorageClassName = *pvc.Spec.StorageClassName
+	}
+	b2.Status.VolumeMode = pvc.Spec.VolumeMode
+	b2.Status.Size = &size
+	b2.Status.Message = ""
+	b2.Status.StartTime = &now
+	if err := r.Status().Patch(ctx, b2, client.MergeFrom(b)); err != nil {
+		return ctrl.Result{}, err
+	}
+	r.recorder.Event(b, corev1.EventTypeNormal, "Started", fmt.Sprintf("back up volume %s on node %s", lv.Name, lv.Spec.NodeName))
+	return ctrl.Result{}, nil
+}
+
+// volumeBusy pv正在进行的其他备份或恢复，备份期间上传的数据块尚未被Manifest引用，不能清理
+func (r *BackupReconciler) volumeBusy(ctx context.Context, pvName string, uid types.UID) (string, error) {
+	backupList := new(carinav1.BackupList)
+	if err := r.List(ctx, backupList); err != nil {
+		return "", err
+	}
+	for _, other := range backupList.Items {
+		if other.UID != uid && other.Status.PersistentVolume == pvName && other.Status.Phase == carinav1.BackupInProgress {
+			return fmt.Sprintf("waiting for backup %s/%s", other.Namespace, other.Name), nil
+		}
+	}
+	restoreList := new(carinav1.RestoreList)
+	if err := r.List(ctx, restoreList); err != nil {
+		return "", err
+	}
+	for _, restore := range restoreList.Items {
+		if restore.Status.SourceVolume == pvName && !restore.IsFinished() && restore.Status.Phase != carinav1.RestorePending {
+			return fmt.Sprintf("waiting for restore %s/%s", restore.Namespace, restore.Name), nil
+		}
+	}
+	return "", nil
+}
+
+// finalize 删除对象存储中的Manifest以及不再被引用的数据块
+func (r *BackupReconciler) finalize(ctx context.Context, b *carinav1.Backup) (ctrl.Result, error) {
+	if b.Status.PersistentVolume != "" {
+		busy, err := r.volumeBusy(ctx, b.Status.PersistentVolume, b.UID)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if busy != "" {
+			log.Infof("backup %s/%s is %s before removing data", b.Namespace, b.Name, busy)
+			return ctrl.Result{RequeueAfter: backupWaitInterval}, nil
+		}
+		repo, err := backupRepository(ctx, r.apiReader)
+		if err != nil {
+			log.Error(err, " failed to remove backup data name ", b.Namespace, "/", b.Name)
+			return ctrl.Result{}, err
+		}
+		if err := repo.DeleteBackup(ctx, b.Status.PersistentVolume, string(b.UID)); err != nil {
+			log.Error(err, " failed to remove backup data name ", b.Namespace, "/", b.Name)
+			return ctrl.Result{}, err
+		}
+	}
+
+	b2 := b.DeepCopy()
+	b2.Finalizers = utils.SliceRemoveString(b2.Finalizers, carina.BackupFinalizer)
+	if err := r.Patch(ctx, b2, client.MergeFrom(b)); err != nil {
+		return ctrl.Result{}, err
+	}
+	log.Infof("backup %s/%s removed", b.Namespace, b.Name)
+	return ctrl.Result{}, nil
+}
+
+func (r *BackupReconciler) fail(ctx context.Context, b *carinav1.Backup, message string) error {
+	log.Warnf("backup %s/%s failed %s", b.Namespace, b.Name, message)
+	now := metav1.Now()
+	b2 := b.DeepCopy()
+	b2.Status.Phase = carinav1.BackupFailed
+	b2.Status.Message = message
+	b2.Status.CompletionTime = &now
+	if err := r.Status().Patch(ctx, b2, client.MergeFrom(b)); err != nil {
+		return err
+	}
+	r.recorder.Event(b, corev1.EventTypeWarning, "Failed", message)
+	return nil
+}
+
+func (r *BackupReconciler) setMessage(ctx context.Context, b *carinav1.Backup, phase carinav1.BackupPhase, message string) error {
+	if b.Status.Phase == phase && b.Status.Message == message {
+		return nil
+	}
+	b2 := b.DeepCopy()
+	b2.Status.Phase = phase
+	b2.Status.Message = message
+	return r.Status().Patch(ctx, b2, client.MergeFrom(b))
+}
+
+// backupRepository 按配置的backupStorage以及carina命名空间中的凭据访问备份仓库
+func backupRepository(ctx context.Context, reader client.Reader) (*backup.Repository, error) {
+	config, err := configuration.BackupStorageConfig()
+	if err != nil {
+		return nil, err
+	}
+	secret := new(corev1.Secret)
+	key := client.ObjectKey{Namespace: configuration.RuntimeNamespace(), Name: config.CredentialSecret}
+	if err := reader.Get(ctx, key, secret); err != nil {
+		return nil, fmt.Errorf("get backup storage credentials: %w", err)
+	}
+	accessKeyID, secretAccessKey := string(secret.Data[carina.BackupAccessKeyID]), string(secret.Data[carina.BackupSecretAccessKey])
+	if accessKeyID == "" || secretAccessKey == "" {
+		return nil, errors.New("backup storage credentials are empty")
+	}
+	store, err := backup.NewS3Client(backup.S3Config{
+		Endpoint:           config.Endpoint,
+		Region:             config.Region,
+		Bucket:             config.Bucket,
+		AccessKeyID:        accessKeyID,
+		SecretAccessKey:    secretAccessKey,
+		InsecureSkipVerify: config.InsecureSkipVerify,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return backup.NewRepository(store, config.Prefix), nil
+}
@@ -0,0 +1,368 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+
+	"github.com/carina-io/carina"
+	carinav1 "github.com/carina-io/carina/api/v1"
+	"github.com/carina-io/carina/pkg/backup"
+	deviceManager "github.com/carina-io/carina/pkg/devicemanager"
+	"github.com/carina-io/carina/pkg/devicemanager/types"
+	"github.com/carina-io/carina/pkg/devicemanager/volume"
+	"github.com/carina-io/carina/utils"
+	"github.com/carina-io/carina/utils/log"
+)
+
+// backupProgressInterval 更新备份以及恢复进度的间隔
+const backupProgressInterval = 10 * time.Second
+
+// BackupNodeReconciler 在卷所在节点创建快照，读取快照上传至对象存储。
+// thin卷保留最近一次备份的快照，下次备份只读取两个快照之间变化的数据块；普通卷的快照在备份结束后删除
+type BackupNodeReconciler struct {
+	client.Client
+	apiReader client.Reader
+	recorder  record.EventRecorder
+	dm        *deviceManager.DeviceManager
+
+	mu      sync.Mutex
+	running map[string]context.CancelFunc
+}
+
+// +kubebuilder:rbac:groups=carina.storage.io,resources=backups,verbs=get;list;watch;update;patch
+// +kubebuilder:rbac:groups=carina.storage.io,resources=backups/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get
+
+func NewBackupNodeReconciler(client client.Client, apiReader client.Reader, recorder record.EventRecorder, dm *deviceManager.DeviceManager) *BackupNodeReconciler {
+	return &BackupNodeReconciler{
+		Client:    client,
+		apiReader: apiReader,
+		recorder:  recorder,
+		dm:        dm,
+		running:   map[string]context.CancelFunc{},
+	}
+}
+
+func (r *BackupNodeReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	b := new(carinav1.Backup)
+	if err := r.Get(ctx, req.NamespacedName, b); err != nil {
+		if !apierrs.IsNotFound(err) {
+			log.Error(err, " unable to fetch Backup")
+			return ctrl.Result{}, err
+		}
+		r.cancel(req.String())
+		return ctrl.Result{}, nil
+	}
+
+	if b.DeletionTimestamp != nil {
+		r.cancel(req.String())
+		if !utils.ContainsString(b.Finalizers, carina.BackupSnapshotFinalizer) {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, r.releaseSnapshot(ctx, b)
+	}
+
+	if b.Status.Phase == carinav1.BackupInProgress && b.Status.NodeName == r.dm.NodeName {
+		r.start(req.String(), b)
+	}
+	return ctrl.Result{}, nil
+}
+
+func (r *BackupNodeReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&carinav1.Backup{}).
+		WithEventFilter(&backupFilter{r.dm.NodeName}).
+		WithOptions(controller.Options{
+			MaxConcurrentReconciles: 2,
+		}).
+		Complete(r)
+}
+
+// start 后台执行备份，carina-node重启后重新执行
+func (r *BackupNodeReconciler) start(key string, b *carinav1.Backup) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.running[key]; ok {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	r.running[key] = cancel
+
+	go func() {
+		defer r.cancel(key)
+		if err := r.backup(ctx, b); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Errorf("backup %s failed %s", key, err.Error())
+			r.recorder.Event(b, corev1.EventTypeWarning, "Failed", fmt.Sprintf("back up volume %s failed: %s", b.Status.VolumeID, err.Error()))
+			if err := r.releaseSnapshot(context.Background(), b); err != nil {
+				log.Errorf("release snapshot of backup %s failed %s", key, err.Error())
+			}
+			if perr := r.patchStatus(b, func(status *carinav1.BackupStatus) {
+				now := metav1.Now()
+				status.Phase = carinav1.BackupFailed
+				status.Message = err.Error()
+				status.Snapshot = ""
+				status.CompletionTime = &now
+			}); perr != nil {
+				log.Errorf("update backup %s status failed %s", key, perr.Error())
+			}
+		}
+	}()
+}
+
+func (r *BackupNodeReconciler) backup(ctx context.Context, b *carinav1.Backup) error {
+	repo, err := backupRepository(ctx, r.apiReader)
+	if err != nil {
+		return err
+	}
+	volumeManager := r.dm.VolumeManager.WithContext(ctx)
+	lvInfo, err := volumeManager.VolumeInfo(b.Status.VolumeID, b.Status.DeviceGroup)
+	if err != nil {
+		return fmt.Errorf("get volume %s: %w", b.Status.VolumeID, err)
+	}
+
+	// 先加finalizer再创建快照，保证Backup删除时快照被清理
+	if !utils.ContainsString(b.Finalizers, carina.BackupSnapshotFinalizer) {
+		b2 := b.DeepCopy()
+		b2.Finalizers = append(b2.Finalizers, carina.BackupSnapshotFinalizer)
+		if err := r.Patch(ctx, b2, client.MergeFrom(b)); err != nil {
+			return err
+		}
+	}
+	snapshot := backupSnapshotName(b)
+	if err := volumeManager.CreateSnapshot(snapshot, b.Status.VolumeID, b.Status.DeviceGroup); err != nil {
+		return fmt.Errorf("create snapshot of %s: %w", b.Status.VolumeID, err)
+	}
+	r.dm.NoticeUpdateCapacity(deviceManager.BackupController, nil)
+	snapInfo, err := volumeManager.VolumeInfo(volume.SnapshotName(snapshot), b.Status.DeviceGroup)
+	if err != nil {
+		return fmt.Errorf("get snapshot %s: %w", snapshot, err)
+	}
+
+	manifest := &backup.Manifest{
+		ID:     string(b.UID),
+		Backup: b.Namespace + "/" + b.Name,
+		Volume: b.Status.PersistentVolume,
+		Size:   int64(lvInfo.LVSize),
+	}
+	opts := backup.BackupOptions{}
+	thin := lvInfo.PoolLV != ""
+	if thin && !b.Spec.Full {
+		opts.Base, opts.Changed = r.incrementalBase(ctx, repo, b, snapshot)
+	}
+	lastUpdate := time.Now()
+	opts.Progress = func(read, uploaded int64) {
+		if time.Since(lastUpdate) < backupProgressInterval {
+			return
+		}
+		lastUpdate = time.Now()
+		_ = r.patchStatus(b, func(status *carinav1.BackupStatus) {
+			status.ReadBytes = read
+			status.UploadedBytes = uploaded
+		})
+	}
+
+	log.Infof("backup %s/%s volume %s, incremental %t", b.Namespace, b.Name, b.Status.VolumeID, opts.Base != nil)
+	stats, err := repo.Backup(ctx, lvPath(snapInfo), manifest, opts)
+	if err != nil {
+		return err
+	}
+
+	// 普通卷的快照按卷大小占用vg空间，备份结束即删除
+	if !thin {
+		if err := r.releaseSnapshot(ctx, b); err != nil {
+			return err
+		}
+		snapshot = ""
+	}
+	if err := r.patchStatus(b, func(status *carinav1.BackupStatus) {
+		now := metav1.Now()
+		status.Phase = carinav1.BackupCompleted
+		status.Snapshot = snapshot
+		status.Base = ""
+		if opts.Base != nil {
+			status.Base = opts.Base.Backup
+		}
+		status.ReadBytes = stats.Read
+		status.UploadedBytes = stats.Uploaded
+		status.Message = ""
+		status.CompletionTime = &now
+	}); err != nil {
+		return err
+	}
+	log.Infof("backup %s/%s completed, read %d bytes, uploaded %d bytes", b.Namespace, b.Name, stats.Read, stats.Uploaded)
+	r.recorder.Event(b, corev1.EventTypeNormal, "Completed", fmt.Sprintf("read %d bytes, uploaded %d bytes", stats.Read, stats.Uploaded))
+
+	// thin卷只保留最近一次备份的快照
+	if thin {
+		r.releaseOlderSnapshots(ctx, b)
+	}
+	return nil
+}
+
+// incrementalBase 查找同一pv最近一次保留了快照的备份，返回其Manifest以及两个快照之间变化的数据范围，
+// 无法增量备份时返回nil，退化为全量读取
+func (r *BackupNodeReconciler) incrementalBase(ctx context.Context, repo *backup.Repository, b *carinav1.Backup, snapshot string) (*backup.Manifest, []types.BlockRange) {
+	backupList := new(carinav1.BackupList)
+	if err := r.List(ctx, backupList, client.InNamespace(b.Namespace)); err != nil {
+		log.Warnf("list backups failed %s, full backup", err.Error())
+		return nil, nil
+	}
+	var base *carinav1.Backup
+	for i, other := range backupList.Items {
+		if other.UID == b.UID || other.DeletionTimestamp != nil || other.Status.Phase != carinav1.BackupCompleted ||
+			other.Status.PersistentVolume != b.Status.PersistentVolume || other.Status.Snapshot == "" || other.Status.CompletionTime == nil {
+			continue
+		}
+		if base == nil || base.Status.CompletionTime.Before(other.Status.CompletionTime) {
+			base = &backupList.Items[i]
+		}
+	}
+	if base == nil {
+		return nil, nil
+	}
+
+	manifest, err := repo.GetManifest(ctx, base.Status.PersistentVolume, string(base.UID))
+	if err != nil {
+		log.Warnf("get manifest of backup %s/%s failed %s, full backup", base.Namespace, base.Name, err.Error())
+		return nil, nil
+	}
+	changed, err := r.dm.VolumeManager.WithContext(ctx).SnapshotDelta(base.Status.Snapshot, snapshot, b.Status.DeviceGroup)
+	if err != nil {
+		log.Warnf("compare snapshot %s with %s failed %s, full backup", base.Status.Snapshot, snapshot, err.Error())
+		return nil, nil
+	}
+	return manifest, changed
+}
+
+// releaseOlderSnapshots 删除同一pv较早备份保留的快照
+func (r *BackupNodeReconciler) releaseOlderSnapshots(ctx context.Context, b *carinav1.Backup) {
+	backupList := new(carinav1.BackupList)
+	if err := r.List(ctx, backupList, client.InNamespace(b.Namespace)); err != nil {
+		log.Warnf("list backups failed %s", err.Error())
+		return
+	}
+	for i, other := range backupList.Items {
+		if other.UID == b.UID || other.Status.PersistentVolume != b.Status.PersistentVolume || other.Status.Snapshot == "" {
+			continue
+		}
+		if err := r.releaseSnapshot(ctx, &backupList.Items[i]); err != nil {
+			log.Warnf("release snapshot of backup %s/%s failed %s", other.Namespace, other.Name, err.Error())
+			continue
+		}
+		if err := r.patchStatus(&other, func(status *carinav1.BackupStatus) {
+			status.Snapshot = ""
+		}); err != nil && !apierrs.IsNotFound(err) {
+			log.Warnf("update backup %s/%s status failed %s", other.Namespace, other.Name, err.Error())
+		}
+	}
+}
+
+// releaseSnapshot 删除备份的快照并移除对应的finalizer
+func (r *BackupNodeReconciler) releaseSnapshot(ctx context.Context, b *carinav1.Backup) error {
+	if err := r.dm.VolumeManager.WithContext(ctx).DeleteSnapshot(backupSnapshotName(b), b.Status.DeviceGroup); err != nil {
+		log.Error(err, " failed to remove snapshot of backup ", b.Namespace, "/", b.Name)
+		return err
+	}
+	r.dm.NoticeUpdateCapacity(deviceManager.BackupController, nil)
+
+	latest := new(carinav1.Backup)
+	if err := r.Get(ctx, client.ObjectKeyFromObject(b), latest); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if !utils.ContainsString(latest.Finalizers, carina.BackupSnapshotFinalizer) {
+		return nil
+	}
+	b2 := latest.DeepCopy()
+	b2.Finalizers = utils.SliceRemoveString(b2.Finalizers, carina.BackupSnapshotFinalizer)
+	return r.Patch(ctx, b2, client.MergeFrom(latest))
+}
+
+func (r *BackupNodeReconciler) patchStatus(b *carinav1.Backup, mutate func(status *carinav1.BackupStatus)) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	latest := new(carinav1.Backup)
+	if err := r.Get(ctx, client.ObjectKeyFromObject(b), latest); err != nil {
+		return err
+	}
+	b2 := latest.DeepCopy()
+	mutate(&b2.Status)
+	return r.Status().Patch(ctx, b2, client.MergeFrom(latest))
+}
+
+func (r *BackupNodeReconciler) cancel(key string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if cancel, ok := r.running[key]; ok {
+		cancel()
+		delete(r.running, key)
+	}
+}
+
+// backupSnapshotName 备份使用的快照名称，lv名称为snapshot-backup-<uid>
+func backupSnapshotName(b *carinav1.Backup) string {
+	return "backup-" + string(b.UID)
+}
+
+// filter Backup
+type backupFilter struct {
+	nodeName string
+}
+
+func (f backupFilter) filter(b *carinav1.Backup) bool {
+	if b == nil {
+		return false
+	}
+	return b.Status.NodeName == f.nodeName
+}
+
+func (f backupFilter) Create(e event.CreateEvent) bool {
+	return f.filter(e.Object.(*carinav1.Backup))
+}
+
+func (f backupFilter) Delete(e event.DeleteEvent) bool {
+	return f.filter(e.Object.(*carinav1.Backup))
+}
+
+func (f backupFilter) Update(e event.UpdateEvent) bool {
+	newBackup := e.ObjectNew.(*carinav1.Backup)
+	oldBackup := e.ObjectOld.(*carinav1.Backup)
+	if newBackup.ResourceVersion == oldBackup.ResourceVersion {
+		return false
+	}
+	return f.filter(newBackup) || f.filter(oldBackup)
+}
+
+func (f backupFilter) Generic(e event.GenericEvent) bool {
+	return f.filter(e.Object.(*carinav1.Backup))
+}
@@ -0,0 +1,109 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	carinav1 "github.com/carina-io/carina/api/v1"
+	"github.com/carina-io/carina/pkg/quota"
+	"github.com/carina-io/carina/utils/log"
+)
+
+// CarinaStorageQuotaReconciler 统计命名空间下LogicVolume的用量，更新到CarinaStorageQuota的status中，
+// 配额的校验在CreateVolume以及pvc的准入校验中进行
+type CarinaStorageQuotaReconciler struct {
+	client.Client
+}
+
+// +kubebuilder:rbac:groups=carina.storage.io,resources=carinastoragequotas,verbs=get;list;watch
+// +kubebuilder:rbac:groups=carina.storage.io,resources=carinastoragequotas/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=carina.storage.io,resources=logicvolumes,verbs=get;list;watch
+
+func NewCarinaStorageQuotaReconciler(client client.Client) *CarinaStorageQuotaReconciler {
+	return &CarinaStorageQuotaReconciler{
+		Client: client,
+	}
+}
+
+func (r *CarinaStorageQuotaReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	q := new(carinav1.CarinaStorageQuota)
+	if err := r.Get(ctx, req.NamespacedName, q); err != nil {
+		if !apierrs.IsNotFound(err) {
+			log.Error(err, " unable to fetch CarinaStorageQuota")
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, nil
+	}
+	if q.DeletionTimestamp != nil {
+		return ctrl.Result{}, nil
+	}
+
+	lvList := new(carinav1.LogicVolumeList)
+	if err := r.List(ctx, lvList); err != nil {
+		return ctrl.Result{}, err
+	}
+	used := quota.Status(q, quota.NamespaceUsage(q.Namespace, lvList.Items, nil))
+	if q.Status.LastUpdateTime != nil && equality.Semantic.DeepEqual(used, q.Status.Used) {
+		return ctrl.Result{}, nil
+	}
+
+	q2 := q.DeepCopy()
+	q2.Status.Used = used
+	q2.Status.LastUpdateTime = &metav1.Time{Time: time.Now()}
+	if err := r.Status().Patch(ctx, q2, client.MergeFrom(q)); err != nil {
+		log.Error(err, " failed to update CarinaStorageQuota status name ", req.NamespacedName)
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{}, nil
+}
+
+func (r *CarinaStorageQuotaReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&carinav1.CarinaStorageQuota{}).
+		Watches(&source.Kind{Type: &carinav1.LogicVolume{}}, handler.EnqueueRequestsFromMapFunc(r.logicVolumeToQuotas)).
+		Complete(r)
+}
+
+// logicVolumeToQuotas LogicVolume变化时更新其所在命名空间的全部配额
+func (r *CarinaStorageQuotaReconciler) logicVolumeToQuotas(obj client.Object) []reconcile.Request {
+	lv, ok := obj.(*carinav1.LogicVolume)
+	if !ok || lv.Spec.NameSpace == "" {
+		return nil
+	}
+	quotaList := new(carinav1.CarinaStorageQuotaList)
+	if err := r.List(context.Background(), quotaList, client.InNamespace(lv.Spec.NameSpace)); err != nil {
+		log.Error(err, " failed to list CarinaStorageQuota namespace ", lv.Spec.NameSpace)
+		return nil
+	}
+	requests := make([]reconcile.Request, 0, len(quotaList.Items))
+	for _, q := range quotaList.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: q.Namespace, Name: q.Name}})
+	}
+	return requests
+}
@@ -0,0 +1,217 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	corev1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+
+	"github.com/carina-io/carina"
+	carinav1 "github.com/carina-io/carina/api/v1"
+	deviceManager "github.com/carina-io/carina/pkg/devicemanager"
+	"github.com/carina-io/carina/pkg/devicemanager/volume"
+	"github.com/carina-io/carina/utils"
+	"github.com/carina-io/carina/utils/log"
+)
+
+// LogicSnapshotReconciler reconciles a LogicSnapshot object
+type LogicSnapshotReconciler struct {
+	client.Client
+	recorder record.EventRecorder
+	dm       *deviceManager.DeviceManager
+}
+
+// +kubebuilder:rbac:groups=carina.storage.io,resources=logicsnapshots,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=carina.storage.io,resources=logicsnapshots/status,verbs=get;update;patch
+
+func NewLogicSnapshotReconciler(client client.Client, recorder record.EventRecorder, dm *deviceManager.DeviceManager) *LogicSnapshotReconciler {
+	return &LogicSnapshotReconciler{
+		Client:   client,
+		recorder: recorder,
+		dm:       dm,
+	}
+}
+
+func (r *LogicSnapshotReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	ls := new(carinav1.LogicSnapshot)
+	if err := r.Get(ctx, req.NamespacedName, ls); err != nil {
+		if !apierrs.IsNotFound(err) {
+			log.Error(err, " unable to fetch LogicSnapshot")
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, nil
+	}
+
+	if ls.ObjectMeta.DeletionTimestamp == nil {
+		if ls.Status.SnapshotID != "" {
+			return ctrl.Result{}, nil
+		}
+		err := r.createSnapshot(ctx, ls)
+		if err != nil {
+			log.Error(err, " failed to create snapshot name ", ls.Name)
+		}
+		return ctrl.Result{}, err
+	}
+
+	// finalization
+	if !utils.ContainsString(ls.Finalizers, carina.LogicSnapshotFinalizer) {
+		// Our finalizer has finished, so the reconciler can do nothing.
+		return ctrl.Result{}, nil
+	}
+
+	log.Info("Start finalizing LogicSnapshot name ", ls.Name)
+	return ctrl.Result{}, r.removeSnapshotIfExists(ctx, ls)
+}
+
+func (r *LogicSnapshotReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&carinav1.LogicSnapshot{}).
+		WithEventFilter(&logicSnapshotFilter{r.dm.NodeName}).
+		WithOptions(controller.Options{
+			MaxConcurrentReconciles: 5,
+		}).
+		Complete(r)
+}
+
+func (r *LogicSnapshotReconciler) createSnapshot(ctx context.Context, ls *carinav1.LogicSnapshot) error {
+	log.Info("Start to create snapshot name ", ls.Name)
+
+	// When ls.Status.Code is not codes.OK (== 0), createSnapshot has already failed.
+	// LogicSnapshot CRD will be deleted soon by the controller.
+	if ls.Status.Code != codes.OK {
+		return nil
+	}
+
+	err := utils.UntilMaxRetry(func() error {
+		return r.dm.VolumeManager.WithContext(ctx).CreateSnapshot(ls.Name, ls.Spec.SourceVolumeID, ls.Spec.DeviceGroup)
+	}, 3, 1*time.Second)
+
+	if err != nil {
+		ls.Status.Code = codes.Internal
+		if err.Error() == carina.ResourceExhausted {
+			ls.Status.Code = codes.ResourceExhausted
+		}
+		ls.Status.Message = err.Error()
+		ls.Status.Status = "Failed"
+		r.recorder.Event(ls, corev1.EventTypeWarning, "CreateSnapshotFailed", fmt.Sprintf("create snapshot failed node: %s, time: %s, error: %s", r.dm.NodeName, time.Now().Format("2006-01-02T15:04:05.000Z"), err.Error()))
+	} else {
+		now := metav1.Now()
+		ls.Status.SnapshotID = volume.SnapshotName(ls.Name)
+		ls.Status.ReadyToUse = true
+		ls.Status.CreationTime = &now
+		ls.Status.Code = codes.OK
+		ls.Status.Message = ""
+		ls.Status.Status = "Success"
+		r.recorder.Event(ls, corev1.EventTypeNormal, "CreateSnapshotSuccess", fmt.Sprintf("create snapshot success node: %s, time: %s", r.dm.NodeName, time.Now().Format("2006-01-02T15:04:05.000Z")))
+	}
+
+	if err := r.syncNoticeUpdateCapacity(ls); err != nil {
+		return err
+	}
+
+	if err := r.Status().Update(ctx, ls); err != nil {
+		log.Error(err, " failed to update status name ", ls.Name, " uid ", ls.UID)
+		return err
+	}
+
+	log.Info("Created new snapshot name ", ls.Name, " uid ", ls.UID, " status.snapshotID ", ls.Status.SnapshotID, " status.message ", ls.Status.Message)
+	return nil
+}
+
+func (r *LogicSnapshotReconciler) removeSnapshotIfExists(ctx context.Context, ls *carinav1.LogicSnapshot) error {
+	log.Info("Start to remove snapshot name ", ls.Name)
+
+	// Finalizer's process ( DeleteSnapshot then removeString ) is not atomic,
+	// so checking existence of snapshot to ensure its idempotence
+	err := utils.UntilMaxRetry(func() error {
+		return r.dm.VolumeManager.WithContext(ctx).DeleteSnapshot(ls.Name, ls.Spec.DeviceGroup)
+	}, 3, 1*time.Second)
+	if err != nil {
+		log.Error(err, " failed to remove snapshot name ", ls.Name, " uid ", ls.UID)
+		return err
+	}
+
+	if err = r.syncNoticeUpdateCapacity(ls); err != nil {
+		return err
+	}
+
+	ls2 := ls.DeepCopy()
+	ls2.Finalizers = utils.SliceRemoveString(ls2.Finalizers, carina.LogicSnapshotFinalizer)
+	patch := client.MergeFrom(ls)
+	if err = r.Patch(ctx, ls2, patch); err != nil {
+		log.Error(err, " failed to remove finalizer name ", ls.Name)
+		return err
+	}
+	log.Info("Snapshot already removed name ", ls.Name, " uid ", ls.UID)
+	return nil
+}
+
+func (r *LogicSnapshotReconciler) syncNoticeUpdateCapacity(ls *carinav1.LogicSnapshot) error {
+	done := make(chan struct{})
+	r.dm.NoticeUpdateCapacity(deviceManager.LogicSnapshotController, done)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		log.Errorf("Update nsr capacity timeout(5s), snapshot name %s uid %s", ls.Name, ls.UID)
+		return fmt.Errorf("update nsr capacity timeout(5s)")
+	}
+	return nil
+}
+
+// filter logicSnapshot
+type logicSnapshotFilter struct {
+	nodeName string
+}
+
+func (f logicSnapshotFilter) filter(ls *carinav1.LogicSnapshot) bool {
+	if ls == nil {
+		return false
+	}
+	return ls.Spec.NodeName == f.nodeName
+}
+
+func (f logicSnapshotFilter) Create(e event.CreateEvent) bool {
+	return f.filter(e.Object.(*carinav1.LogicSnapshot))
+}
+
+func (f logicSnapshotFilter) Delete(e event.DeleteEvent) bool {
+	return f.filter(e.Object.(*carinav1.LogicSnapshot))
+}
+
+func (f logicSnapshotFilter) Update(e event.UpdateEvent) bool {
+	newLogicSnapshot := e.ObjectNew.(*carinav1.LogicSnapshot)
+	oldLogicSnapshot := e.ObjectOld.(*carinav1.LogicSnapshot)
+	if newLogicSnapshot.ResourceVersion == oldLogicSnapshot.ResourceVersion {
+		return false
+	}
+	return f.filter(newLogicSnapshot) || f.filter(oldLogicSnapshot)
+}
+
+func (f logicSnapshotFilter) Generic(e event.GenericEvent) bool {
+	return f.filter(e.Object.(*carinav1.LogicSnapshot))
+}
@@ -35,6 +35,7 @@ import (
 	"github.com/carina-io/carina"
 	carinav1 "github.com/carina-io/carina/api/v1"
 	deviceManager "github.com/carina-io/carina/pkg/devicemanager"
+	"github.com/carina-io/carina/pkg/devicemanager/types"
 	"github.com/carina-io/carina/utils"
 	"github.com/carina-io/carina/utils/log"
 )
@@ -111,15 +112,22 @@ func (r *LogicVolumeReconciler) removeLVIfExists(ctx context.Context, lv *carina
 	// Finalizer's process ( RemoveLV then removeString ) is not atomic,
 	// so checking existence of LV to ensure its idempotence
 	var err error
+	// 加密卷需先关闭dm-crypt映射，否则底层设备处于占用状态无法删除
+	if lv.Annotations[carina.VolumeEncryption] == "true" {
+		if err = r.dm.Crypt.Close(carina.LuksPrefix + lv.Status.VolumeID); err != nil {
+			log.Error(err, " failed to close encrypted device of volume ", lv.Status.VolumeID)
+			return err
+		}
+	}
 	switch lv.Annotations[carina.VolumeManagerType] {
 	case carina.LvmVolumeType:
 		err = utils.UntilMaxRetry(func() error {
-			return r.dm.VolumeManager.DeleteVolume(lv.Name, lv.Spec.DeviceGroup)
+			return r.dm.VolumeManager.WithContext(ctx).DeleteVolume(lv.Name, lv.Spec.DeviceGroup)
 		}, 3, 1*time.Second)
 
 	case carina.RawVolumeType:
 		err = utils.UntilMaxRetry(func() error {
-			return r.dm.Partition.DeletePartition(utils.PartitionName(lv.Name), lv.Spec.DeviceGroup)
+			return r.dm.Partition.WithContext(ctx).DeletePartition(utils.PartitionName(lv.Name), lv.Spec.DeviceGroup)
 		}, 3, 1*time.Second)
 	default:
 		log.Errorf("Delete LogicVolume: Create with no support volume type undefined %s", lv.Annotations[carina.VolumeManagerType])
@@ -155,11 +163,26 @@ func (r *LogicVolumeReconciler) createLV(ctx context.Context, lv *carinav1.Logic
 		return nil
 	}
 	reqBytes := lv.Spec.Size.Value()
+	owner := r.dm.VolumeOwner(lv)
 
 	switch lv.Annotations[carina.VolumeManagerType] {
 	case carina.LvmVolumeType:
 		err := utils.UntilMaxRetry(func() error {
-			return r.dm.VolumeManager.CreateVolume(lv.Name, lv.Spec.DeviceGroup, uint64(reqBytes), 1)
+			// 从快照或已有卷创建
+			if sourceID, ok := lv.Annotations[carina.VolumeDataSourceID]; ok {
+				return r.dm.VolumeManager.WithContext(ctx).CreateVolumeFromSource(lv.Name, lv.Spec.DeviceGroup, sourceID, uint64(reqBytes), 1, owner)
+			}
+			if lv.Annotations[carina.VolumeCacheBackend] == carina.LvmCacheBackend {
+				return r.dm.VolumeManager.WithContext(ctx).CreateCacheVolume(lv.Name, lv.Spec.DeviceGroup, lv.Annotations[carina.VolumeCacheDiskType], uint64(reqBytes), lvmCacheBytes(lv, reqBytes), lv.Annotations[carina.VolumeCachePolicy], owner)
+			}
+			if lv.Annotations[carina.ThinProvisioning] == "true" {
+				return r.dm.VolumeManager.WithContext(ctx).CreateThinVolume(lv.Name, lv.Spec.DeviceGroup, uint64(reqBytes), r.thinOvercommit(lv.Spec.DeviceGroup), owner)
+			}
+			layout, err := types.NewLvLayout(lv.Annotations)
+			if err != nil {
+				return err
+			}
+			return r.dm.VolumeManager.WithContext(ctx).CreateVolume(lv.Name, lv.Spec.DeviceGroup, uint64(reqBytes), 1, layout, owner)
 		}, 3, 1*time.Second)
 
 		if err != nil {
@@ -177,7 +200,7 @@ func (r *LogicVolumeReconciler) createLV(ctx context.Context, lv *carinav1.Logic
 			lv.Status.Message = ""
 			lv.Status.Status = "Success"
 
-			lvInfo, _ := r.dm.VolumeManager.VolumeInfo(lv.Status.VolumeID, lv.Spec.DeviceGroup)
+			lvInfo, _ := r.dm.VolumeManager.WithContext(ctx).VolumeInfo(lv.Status.VolumeID, lv.Spec.DeviceGroup)
 			if lvInfo != nil {
 				lv.Status.DeviceMajor = lvInfo.LVKernelMajor
 				lv.Status.DeviceMinor = lvInfo.LVKernelMinor
@@ -191,7 +214,14 @@ func (r *LogicVolumeReconciler) createLV(ctx context.Context, lv *carinav1.Logic
 		}
 		err := utils.UntilMaxRetry(func() error {
 			log.Info("name: ", utils.PartitionName(lv.Name), " group: ", lv.Spec.DeviceGroup, " size: ", uint64(reqBytes))
-			return r.dm.Partition.CreatePartition(utils.PartitionName(lv.Name), lv.Spec.DeviceGroup, uint64(reqBytes))
+			if err := r.dm.Partition.WithContext(ctx).CreatePartition(utils.PartitionName(lv.Name), lv.Spec.DeviceGroup, uint64(reqBytes), owner); err != nil {
+				return err
+			}
+			// 从已有卷创建
+			if sourceID, ok := lv.Annotations[carina.VolumeDataSourceID]; ok {
+				return r.dm.Partition.WithContext(ctx).CopyPartition(utils.PartitionName(lv.Name), utils.PartitionName(sourceID), lv.Spec.DeviceGroup)
+			}
+			return nil
 		}, 3, 1*time.Second)
 
 		if err != nil {
@@ -208,7 +238,7 @@ func (r *LogicVolumeReconciler) createLV(ctx context.Context, lv *carinav1.Logic
 			lv.Status.Message = ""
 			lv.Status.Status = "Success"
 
-			diskInfo, err := r.dm.Partition.ScanDisk(lv.Spec.DeviceGroup)
+			diskInfo, err := r.dm.Partition.WithContext(ctx).ScanDisk(lv.Spec.DeviceGroup)
 
 			if err != nil {
 				return fmt.Errorf("lv: %s,disk scan group: %s,err:%s", lv.Name, lv.Spec.DeviceGroup, err)
@@ -259,7 +289,17 @@ func (r *LogicVolumeReconciler) expandLV(ctx context.Context, lv *carinav1.Logic
 	switch lv.Annotations[carina.VolumeManagerType] {
 	case carina.LvmVolumeType:
 		err := utils.UntilMaxRetry(func() error {
-			return r.dm.VolumeManager.ResizeVolume(lv.Name, lv.Spec.DeviceGroup, uint64(reqBytes), 1)
+			if lv.Annotations[carina.VolumeCacheBackend] == carina.LvmCacheBackend {
+				return r.dm.VolumeManager.WithContext(ctx).ResizeCacheVolume(lv.Name, lv.Spec.DeviceGroup, lv.Annotations[carina.VolumeCacheDiskType], uint64(reqBytes), lvmCacheBytes(lv, reqBytes), lv.Annotations[carina.VolumeCachePolicy])
+			}
+			if lv.Annotations[carina.ThinProvisioning] == "true" {
+				return r.dm.VolumeManager.WithContext(ctx).ResizeThinVolume(lv.Name, lv.Spec.DeviceGroup, uint64(reqBytes), r.thinOvercommit(lv.Spec.DeviceGroup))
+			}
+			layout, err := types.NewLvLayout(lv.Annotations)
+			if err != nil {
+				return err
+			}
+			return r.dm.VolumeManager.WithContext(ctx).ResizeVolume(lv.Name, lv.Spec.DeviceGroup, uint64(reqBytes), 1, layout)
 		}, 3, 1*time.Second)
 		if err != nil {
 			if err.Error() == carina.ResourceExhausted {
@@ -284,7 +324,7 @@ func (r *LogicVolumeReconciler) expandLV(ctx context.Context, lv *carinav1.Logic
 			return fmt.Errorf("Extend lv: %s doesn't using an exclusive disk", lv.Name)
 		}
 		err := utils.UntilMaxRetry(func() error {
-			return r.dm.Partition.UpdatePartition(utils.PartitionName(lv.Name), lv.Spec.DeviceGroup, uint64(reqBytes))
+			return r.dm.Partition.WithContext(ctx).UpdatePartition(utils.PartitionName(lv.Name), lv.Spec.DeviceGroup, uint64(reqBytes))
 		}, 3, 1*time.Second)
 		if err != nil {
 			if err.Error() == carina.ResourceExhausted {
@@ -320,6 +360,23 @@ func (r *LogicVolumeReconciler) expandLV(ctx context.Context, lv *carinav1.Logic
 	return nil
 }
 
+// thinOvercommit device group配置的thin pool超分比例
+func (r *LogicVolumeReconciler) thinOvercommit(deviceGroup string) float64 {
+	if ds, ok := r.dm.GetNodeDiskSelectGroup()[deviceGroup]; ok {
+		return ds.ThinOvercommit()
+	}
+	return 1
+}
+
+// lvmCacheBytes lvmcache卷按缓存比例计算缓存大小，按lvm默认PE大小对齐
+func lvmCacheBytes(lv *carinav1.LogicVolume, reqBytes int64) uint64 {
+	ratio, err := strconv.ParseInt(lv.Annotations[carina.VolumeCacheDiskRatio], 10, 64)
+	if err != nil || ratio < 1 {
+		return 0
+	}
+	return uint64((reqBytes*ratio/100 + carina.VolumeAlignment - 1) / carina.VolumeAlignment * carina.VolumeAlignment)
+}
+
 // filter logicVolume
 type logicVolumeFilter struct {
 	nodeName string
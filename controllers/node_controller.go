@@ -162,8 +162,12 @@ func (r *NodeReconciler) getNeedRebuildVolume(ctx context.Context) (map[string]c
 		if len(lv.OwnerReferences) > 0 {
 			continue
 		}
+		// 正在迁移的卷由VolumeMigration处理
+		if _, ok := lv.Annotations[carina.VolumeMigrationAnnotation]; ok {
+			continue
+		}
 		// 删除没有对应pv的logic volume
-		pvPhase, ok := pvMap[lv.Name]
+		pvPhase, ok := pvMap[lv.PVName()]
 		if lv.Status.Status != "" && !ok {
 			if lv.Finalizers != nil && utils.ContainsString(lv.Finalizers, carina.LogicVolumeFinalizer) {
 				log.Infof("remove logic volume %s", lv.Name)
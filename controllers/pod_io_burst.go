@@ -0,0 +1,116 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	"github.com/carina-io/carina/utils/iolimit"
+	"github.com/carina-io/carina/utils/log"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const burstInterval = time.Second
+
+// podBurst 记录pod各设备的突发令牌桶和上一次的io.stat采样
+type podBurst struct {
+	blkIO   *iolimit.PodBlkIO
+	buckets map[string]*iolimit.BurstBucket
+	stats   map[string]iolimit.IOStat
+	last    time.Time
+}
+
+// resetBurst 配置变更后重建令牌桶，调用方需持有burstLock
+func (r *PodIOReconciler) resetBurst(blkIO *iolimit.PodBlkIO) {
+	uid := types.UID(blkIO.PodUid)
+	delete(r.bursts, uid)
+	if !iolimit.IsCgroup2() {
+		return
+	}
+	buckets := map[string]*iolimit.BurstBucket{}
+	for deviceNo, iolt := range blkIO.DeviceIOSet {
+		if iolt.Burst == nil {
+			continue
+		}
+		buckets[deviceNo] = iolimit.NewBurstBucket(iolt.Burst.Seconds)
+	}
+	if len(buckets) == 0 {
+		return
+	}
+	r.bursts[uid] = &podBurst{
+		blkIO:   blkIO,
+		buckets: buckets,
+	}
+}
+
+func (r *PodIOReconciler) deleteBurst(uid types.UID) {
+	r.burstLock.Lock()
+	defer r.burstLock.Unlock()
+	delete(r.bursts, uid)
+}
+
+// runBurst 定时采样io.stat，设备达到基础限速时消耗令牌临时放宽io.max，令牌耗尽后恢复
+func (r *PodIOReconciler) runBurst(ctx context.Context) error {
+	ticker := time.NewTicker(burstInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			r.checkBursts()
+		}
+	}
+}
+
+func (r *PodIOReconciler) checkBursts() {
+	r.burstLock.Lock()
+	defer r.burstLock.Unlock()
+	for uid, pb := range r.bursts {
+		now := time.Now()
+		stats, err := iolimit.GetIOStat(pb.blkIO)
+		if err != nil {
+			// pod已经删除或者cgroup不存在
+			log.Warnf("Failed to get io stat, stop burst of pod %s, error: %s", uid, err.Error())
+			delete(r.bursts, uid)
+			continue
+		}
+		for deviceNo, bucket := range pb.buckets {
+			iolt := pb.blkIO.DeviceIOSet[deviceNo]
+			saturated := false
+			if prev, ok := pb.stats[deviceNo]; ok {
+				saturated = iolimit.Saturated(iolt, prev, stats[deviceNo], now.Sub(pb.last))
+			}
+			bursting := bucket.Bursting()
+			if bucket.Next(now, saturated) == bursting {
+				continue
+			}
+			limit := iolt
+			if !bursting {
+				limit = iolt.BurstLimit()
+			}
+			if err := iolimit.SetIOMax(pb.blkIO, deviceNo, limit); err != nil {
+				log.Errorf("Failed to switch burst of pod %s device %s, error: %s", uid, deviceNo, err.Error())
+				continue
+			}
+			log.Infof("Pod %s device %s burst: %v", uid, deviceNo, !bursting)
+		}
+		pb.stats = stats
+		pb.last = now
+	}
+}
@@ -23,18 +23,28 @@ import (
 	"github.com/carina-io/carina/pkg/devicemanager/partition"
 	"github.com/carina-io/carina/utils/iolimit"
 	"k8s.io/kubectl/pkg/util/qos"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/carina-io/carina/utils"
 	"github.com/carina-io/carina/utils/log"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/workqueue"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 )
 
 const (
@@ -45,24 +55,33 @@ const (
 // PodReconciler reconciles a Node object
 type PodIOReconciler struct {
 	client.Client
+	recorder  record.EventRecorder
 	nodeName  string
 	ioCache   sync.Map
 	partition partition.LocalPartition
+	// 写io.max的操作都需要持有burstLock，避免突发切换覆盖新的配置
+	burstLock sync.Mutex
+	bursts    map[types.UID]*podBurst
 }
 
 // +kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch;update;patch
 // +kubebuilder:rbac:groups="",resources=persistentvolumes,verbs=get;list;watch;delete
+// +kubebuilder:rbac:groups="",resources=persistentvolumeclaims,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
 
 func NewPodIOReconciler(
 	client client.Client,
+	recorder record.EventRecorder,
 	nodeName string,
 	partition partition.LocalPartition,
 ) *PodIOReconciler {
 	return &PodIOReconciler{
 		Client:    client,
+		recorder:  recorder,
 		nodeName:  nodeName,
 		ioCache:   sync.Map{},
 		partition: partition,
+		bursts:    map[types.UID]*podBurst{},
 	}
 }
 
@@ -76,6 +95,7 @@ func (r *PodIOReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl
 
 	if pod.DeletionTimestamp != nil {
 		r.ioCache.Delete(pod.UID)
+		r.deleteBurst(pod.UID)
 		return ctrl.Result{}, nil
 	}
 
@@ -98,61 +118,97 @@ func (r *PodIOReconciler) SetupWithManager(mgr ctrl.Manager) error {
 		return err
 	}
 
-	err = mgr.GetFieldIndexer().IndexField(ctx, &corev1.PersistentVolume{}, "pvIndex", func(object client.Object) []string {
-		pv := object.(*corev1.PersistentVolume)
-		if pv == nil {
-			return nil
-		}
-		if pv.Spec.CSI == nil {
-			return nil
-		}
-		if pv.Spec.CSI.Driver != carina.CSIPluginName {
-			return nil
-		}
-		if pv.Status.Phase != corev1.VolumeBound {
-			return nil
-		}
-		if pv.Spec.ClaimRef == nil {
-			return nil
-		}
-		return []string{fmt.Sprintf("%s-%s", pv.Spec.ClaimRef.Namespace, pv.Spec.ClaimRef.Name)}
-	})
+	err = mgr.GetFieldIndexer().IndexField(ctx, &corev1.PersistentVolume{}, "pvIndex", pvIndex)
 	if err != nil {
 		return err
 	}
 
+	// 突发限速依赖io.stat采样，只在cgroup v2下运行
+	if iolimit.IsCgroup2() {
+		if err = mgr.Add(manager.RunnableFunc(r.runBurst)); err != nil {
+			return err
+		}
+	}
+
 	return ctrl.NewControllerManagedBy(mgr).
-		WithEventFilter(podFilter{r.nodeName}).
 		WithOptions(controller.Options{
 			RateLimiter:             workqueue.NewItemFastSlowRateLimiter(10*time.Second, 60*time.Second, 5),
 			MaxConcurrentReconciles: 5,
 		}).
-		For(&corev1.Pod{}).
+		For(&corev1.Pod{}, builder.WithPredicates(podFilter{r.nodeName})).
+		Watches(&source.Kind{Type: &corev1.PersistentVolumeClaim{}}, handler.EnqueueRequestsFromMapFunc(r.pvcToPods),
+			builder.WithPredicates(pvcAnnotationFilter{})).
 		Complete(r)
 }
 
 func (r *PodIOReconciler) handleSinglePodCGroupConfig(ctx context.Context, pod *corev1.Pod) error {
-	newPodIOLimit := r.getPodIOLimit(pod)
-	oldPodIOLimit, ok := r.ioCache.Load(pod.UID)
-	if ok && newPodIOLimit.Equal(oldPodIOLimit.(*iolimit.IOLimit)) {
+	blkIO, warnings := r.getPodBlkIO(ctx, pod)
+	oldDeviceIOSet, ok := r.ioCache.Load(pod.UID)
+	if ok && blkIO.DeviceIOSet.Equal(oldDeviceIOSet.(iolimit.DeviceIOSet)) {
 		log.Debug("Pod's io throttles hasn't changed, ignore it, namespace: " + pod.Namespace + ", name: " + pod.Name)
 		return nil
 	}
+	for _, warning := range warnings {
+		r.recorder.Event(pod, corev1.EventTypeWarning, "InvalidIOLimit", warning)
+	}
+	if !ok && blkIO.DeviceIOSet.IsEmpty() {
+		r.ioCache.Store(pod.UID, blkIO.DeviceIOSet)
+		return nil
+	}
 
 	log.Infof("Need to update pod's cgroup blkio, namespace: %s, name: %s", pod.Namespace, pod.Name)
-	if err := iolimit.SetIOLimit(r.getPodBlkIO(ctx, pod)); err != nil {
+	r.burstLock.Lock()
+	effective, err := iolimit.SetIOLimit(blkIO)
+	if err == nil {
+		r.resetBurst(blkIO)
+	}
+	r.burstLock.Unlock()
+	if err != nil {
 		return err
 	}
-	r.ioCache.Store(pod.UID, newPodIOLimit)
+	r.ioCache.Store(pod.UID, blkIO.DeviceIOSet)
+
+	deviceNos := make([]string, 0, len(effective))
+	for deviceNo := range effective {
+		deviceNos = append(deviceNos, deviceNo)
+	}
+	sort.Strings(deviceNos)
+	settings := make([]string, 0, len(deviceNos))
+	for _, deviceNo := range deviceNos {
+		settings = append(settings, fmt.Sprintf("%s %s", deviceNo, effective[deviceNo]))
+	}
+	r.recorder.Eventf(pod, corev1.EventTypeNormal, "IOLimitApplied", "effective io settings: %s", strings.Join(settings, "; "))
 	return nil
 }
 
-func (r *PodIOReconciler) getPodBlkIO(ctx context.Context, pod *corev1.Pod) *iolimit.PodBlkIO {
+// pvIndex 已绑定的carina pv按pvc的namespace-name索引
+func pvIndex(object client.Object) []string {
+	pv := object.(*corev1.PersistentVolume)
+	if pv == nil {
+		return nil
+	}
+	if pv.Spec.CSI == nil {
+		return nil
+	}
+	if pv.Spec.CSI.Driver != carina.CSIPluginName {
+		return nil
+	}
+	if pv.Status.Phase != corev1.VolumeBound {
+		return nil
+	}
+	if pv.Spec.ClaimRef == nil {
+		return nil
+	}
+	return []string{fmt.Sprintf("%s-%s", pv.Spec.ClaimRef.Namespace, pv.Spec.ClaimRef.Name)}
+}
+
+// getPodBlkIO 按设备号解析pod各卷的io配置，优先级为pvc注解、存储类参数、pod注解，返回无效配置的告警
+func (r *PodIOReconciler) getPodBlkIO(ctx context.Context, pod *corev1.Pod) (*iolimit.PodBlkIO, []string) {
 	if pod == nil {
-		return &iolimit.PodBlkIO{}
+		return &iolimit.PodBlkIO{}, nil
 	}
 	deviceIOSet := iolimit.DeviceIOSet{}
-	iolt := r.getPodIOLimit(pod)
+	podIOLimit, warnings := r.getPodIOLimit(pod)
 	for _, volume := range pod.Spec.Volumes {
 		if volume.VolumeSource.PersistentVolumeClaim == nil {
 			continue
@@ -180,43 +236,146 @@ func (r *PodIOReconciler) getPodBlkIO(ctx context.Context, pod *corev1.Pod) *iol
 			continue
 		}
 
+		// 存储类参数保存在pv的volumeAttributes中
+		iolt, scWarnings := parseIOLimit(pvInfo.Spec.CSI.VolumeAttributes, podIOLimit, fmt.Sprintf("storageclass %s", pvInfo.Spec.StorageClassName))
+		warnings = append(warnings, scWarnings...)
+		pvc := &corev1.PersistentVolumeClaim{}
+		if err := r.Get(ctx, client.ObjectKey{Namespace: pod.Namespace, Name: volume.VolumeSource.PersistentVolumeClaim.ClaimName}, pvc); err != nil {
+			log.Warnf("Failed to get pvc %s/%s, ignore its io annotations, error: %s", pod.Namespace, volume.VolumeSource.PersistentVolumeClaim.ClaimName, err.Error())
+		} else {
+			var pvcWarnings []string
+			iolt, pvcWarnings = parseIOLimit(pvc.Annotations, iolt, fmt.Sprintf("pvc %s", pvc.Name))
+			warnings = append(warnings, pvcWarnings...)
+		}
 		deviceIOSet[deviceNo] = iolt
 	}
 	return &iolimit.PodBlkIO{
 		PodUid:      string(pod.UID),
 		PodQos:      qos.GetPodQOS(pod),
 		DeviceIOSet: deviceIOSet,
-	}
+	}, warnings
 }
 
-func (r *PodIOReconciler) getPodIOLimit(pod *corev1.Pod) *iolimit.IOLimit {
+// getPodIOLimit pod注解中的io配置，作为未在存储类和pvc中设置时的默认值
+func (r *PodIOReconciler) getPodIOLimit(pod *corev1.Pod) (*iolimit.IOLimit, []string) {
 	if pod == nil {
-		return &iolimit.IOLimit{}
-	}
-	iolt := &iolimit.IOLimit{}
-	for _, throttle := range iolimit.GetSupportedIOThrottles() {
-		var throttleVal uint64
-		var err error
-		newValue, ok := pod.Annotations[fmt.Sprintf("%s/%s", KubernetesCustomized, throttle)]
-		if ok {
-			if throttleVal, err = strconv.ParseUint(newValue, 10, 64); err != nil {
-				log.Warnf("Failed to parse %s， will use default value 0", newValue)
+		return &iolimit.IOLimit{}, nil
+	}
+	return parseIOLimit(pod.Annotations, &iolimit.IOLimit{}, "pod")
+}
+
+// parseIOLimit 在base的基础上解析限速、io.weight、io.latency和突发配置，
+// 无效的配置不覆盖base中的值，并作为告警返回
+func parseIOLimit(annotations map[string]string, base *iolimit.IOLimit, source string) (*iolimit.IOLimit, []string) {
+	iolt := *base
+	if base.Burst != nil {
+		burst := *base.Burst
+		iolt.Burst = &burst
+	}
+	var warnings []string
+	for _, setting := range ioLimitKeys() {
+		value, ok := annotations[fmt.Sprintf("%s/%s", KubernetesCustomized, setting)]
+		if !ok {
+			continue
+		}
+		if setting == iolimit.IOLatency {
+			latency, err := parseIOLatency(value)
+			if err != nil {
+				warnings = append(warnings, fmt.Sprintf("%s: invalid %s %q: %s", source, setting, value, err.Error()))
+				continue
 			}
+			iolt.Latency = latency
+			continue
 		}
-		switch throttle {
+		v, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("%s: invalid %s %q: %s", source, setting, value, err.Error()))
+			continue
+		}
+		switch setting {
 		case iolimit.BlkIOThrottleReadBPS:
-			iolt.Rbps = throttleVal
+			iolt.Rbps = v
+			continue
 		case iolimit.BlkIOThrottleReadIOPS:
-			iolt.Riops = throttleVal
+			iolt.Riops = v
+			continue
 		case iolimit.BlkIOThrottleWriteBPS:
-			iolt.Wbps = throttleVal
+			iolt.Wbps = v
+			continue
 		case iolimit.BlkIOThrottleWriteIOPS:
-			iolt.Wiops = throttleVal
-		default:
-			log.Warnf("Unsupported throttle type %s", throttle)
+			iolt.Wiops = v
+			continue
+		}
+		if setting == iolimit.IOWeight {
+			if v < iolimit.MinIOWeight || v > iolimit.MaxIOWeight {
+				warnings = append(warnings, fmt.Sprintf("%s: %s %d out of range [%d, %d]", source, setting, v, iolimit.MinIOWeight, iolimit.MaxIOWeight))
+				continue
+			}
+			iolt.Weight = v
+			continue
+		}
+		if iolt.Burst == nil {
+			iolt.Burst = &iolimit.IOBurst{}
+		}
+		switch setting {
+		case iolimit.IOBurstReadBPS:
+			iolt.Burst.Rbps = v
+		case iolimit.IOBurstReadIOPS:
+			iolt.Burst.Riops = v
+		case iolimit.IOBurstWriteBPS:
+			iolt.Burst.Wbps = v
+		case iolimit.IOBurstWriteIOPS:
+			iolt.Burst.Wiops = v
+		case iolimit.IOBurstSeconds:
+			iolt.Burst.Seconds = v
+		}
+	}
+	if err := iolt.Validate(); err != nil {
+		warnings = append(warnings, fmt.Sprintf("%s: burst is disabled: %s", source, err.Error()))
+		iolt.Burst = nil
+	}
+	return &iolt, warnings
+}
+
+func ioLimitKeys() []string {
+	return append(iolimit.GetSupportedIOThrottles(), iolimit.GetSupportedIOSettings()...)
+}
+
+// parseIOLatency 支持"10ms"这样的时长或以微秒为单位的整数
+func parseIOLatency(value string) (uint64, error) {
+	if v, err := strconv.ParseUint(value, 10, 64); err == nil {
+		if v == 0 {
+			return 0, fmt.Errorf("must be greater than 0")
+		}
+		return v, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+	if d < time.Microsecond {
+		return 0, fmt.Errorf("must be at least 1us")
+	}
+	return uint64(d / time.Microsecond), nil
+}
+
+// pvcToPods pvc注解变更时重新配置本节点上使用该pvc的pod
+func (r *PodIOReconciler) pvcToPods(obj client.Object) []reconcile.Request {
+	podList := &corev1.PodList{}
+	if err := r.List(context.Background(), podList, client.InNamespace(obj.GetNamespace()), client.MatchingFields{"combinedIndex": r.nodeName}); err != nil {
+		log.Errorf("Failed to list pods of pvc %s/%s, error: %s", obj.GetNamespace(), obj.GetName(), err.Error())
+		return nil
+	}
+	var requests []reconcile.Request
+	for _, pod := range podList.Items {
+		for _, volume := range pod.Spec.Volumes {
+			if volume.PersistentVolumeClaim != nil && volume.PersistentVolumeClaim.ClaimName == obj.GetName() {
+				requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&pod)})
+				break
+			}
 		}
 	}
-	return iolt
+	return requests
 }
 
 // filter carina pod
@@ -237,18 +396,13 @@ func (p podFilter) filter(pod *corev1.Pod) bool {
 	if pod.Status.Phase == corev1.PodPending || pod.Status.Phase == corev1.PodSucceeded {
 		return false
 	}
-	var ioThrottleExist bool
-	for _, ioThrottle := range iolimit.GetSupportedIOThrottles() {
-		if _, ok := pod.Annotations[fmt.Sprintf("%s/%s", carina.CSIPluginName, ioThrottle)]; ok {
-			ioThrottleExist = true
-			break
+	// io配置也可以来自pvc注解，使用pvc的pod都需要处理
+	for _, volume := range pod.Spec.Volumes {
+		if volume.VolumeSource.PersistentVolumeClaim != nil {
+			return true
 		}
 	}
-	if !ioThrottleExist {
-		return false
-	}
-
-	return true
+	return false
 }
 
 func (p podFilter) Create(e event.CreateEvent) bool {
@@ -271,3 +425,30 @@ func (p podFilter) Update(e event.UpdateEvent) bool {
 func (p podFilter) Generic(e event.GenericEvent) bool {
 	return false
 }
+
+// filter pvc whose io annotations changed
+type pvcAnnotationFilter struct {
+	predicate.Funcs
+}
+
+func (p pvcAnnotationFilter) Create(e event.CreateEvent) bool {
+	return false
+}
+
+func (p pvcAnnotationFilter) Delete(e event.DeleteEvent) bool {
+	return false
+}
+
+func (p pvcAnnotationFilter) Update(e event.UpdateEvent) bool {
+	for _, setting := range ioLimitKeys() {
+		key := fmt.Sprintf("%s/%s", KubernetesCustomized, setting)
+		if e.ObjectNew.GetAnnotations()[key] != e.ObjectOld.GetAnnotations()[key] {
+			return true
+		}
+	}
+	return false
+}
+
+func (p pvcAnnotationFilter) Generic(e event.GenericEvent) bool {
+	return false
+}
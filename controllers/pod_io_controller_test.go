@@ -0,0 +1,169 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/carina-io/carina"
+	"github.com/carina-io/carina/pkg/devicemanager/partition"
+	"github.com/carina-io/carina/pkg/devicemanager/types"
+	"github.com/carina-io/carina/utils/iolimit"
+)
+
+// fakePartition 所有设备号都存在
+type fakePartition struct {
+	partition.LocalPartition
+}
+
+func (f *fakePartition) GetDevice(deviceNumber string) (*types.LocalDisk, error) {
+	return &types.LocalDisk{}, nil
+}
+
+func ioKey(setting string) string {
+	return KubernetesCustomized + "/" + setting
+}
+
+func TestGetPodBlkIO(t *testing.T) {
+	cases := []struct {
+		name         string
+		pod          map[string]string
+		storageClass map[string]string
+		pvc          map[string]string
+		want         *iolimit.IOLimit
+		wantWarnings []string
+	}{
+		{
+			name: "pod only",
+			pod:  map[string]string{ioKey(iolimit.BlkIOThrottleReadBPS): "100"},
+			want: &iolimit.IOLimit{Rbps: 100},
+		},
+		{
+			name:         "storageclass overrides pod",
+			pod:          map[string]string{ioKey(iolimit.BlkIOThrottleReadBPS): "100"},
+			storageClass: map[string]string{ioKey(iolimit.BlkIOThrottleReadBPS): "200"},
+			want:         &iolimit.IOLimit{Rbps: 200},
+		},
+		{
+			name:         "pvc overrides storageclass",
+			pod:          map[string]string{ioKey(iolimit.BlkIOThrottleReadBPS): "100"},
+			storageClass: map[string]string{ioKey(iolimit.BlkIOThrottleReadBPS): "200"},
+			pvc:          map[string]string{ioKey(iolimit.BlkIOThrottleReadBPS): "300"},
+			want:         &iolimit.IOLimit{Rbps: 300},
+		},
+		{
+			name:         "merge per key",
+			pod:          map[string]string{ioKey(iolimit.IOWeight): "50", ioKey(iolimit.BlkIOThrottleWriteBPS): "1000"},
+			storageClass: map[string]string{ioKey(iolimit.BlkIOThrottleReadBPS): "200", ioKey(iolimit.BlkIOThrottleWriteBPS): "2000"},
+			pvc:          map[string]string{ioKey(iolimit.BlkIOThrottleWriteIOPS): "30", ioKey(iolimit.IOLatency): "10ms"},
+			want:         &iolimit.IOLimit{Rbps: 200, Wbps: 2000, Wiops: 30, Weight: 50, Latency: 10000},
+		},
+		{
+			name:         "invalid pvc value keeps storageclass value",
+			storageClass: map[string]string{ioKey(iolimit.BlkIOThrottleReadBPS): "200"},
+			pvc:          map[string]string{ioKey(iolimit.BlkIOThrottleReadBPS): "1Mi"},
+			want:         &iolimit.IOLimit{Rbps: 200},
+			wantWarnings: []string{`pvc data: invalid blkio.throttle.read_bps_device "1Mi": strconv.ParseUint: parsing "1Mi": invalid syntax`},
+		},
+		{
+			name:         "invalid values of every source are reported",
+			pod:          map[string]string{ioKey(iolimit.IOWeight): "0"},
+			storageClass: map[string]string{ioKey(iolimit.IOLatency): "fast"},
+			pvc:          map[string]string{ioKey(iolimit.BlkIOThrottleWriteIOPS): "-1"},
+			want:         &iolimit.IOLimit{},
+			wantWarnings: []string{
+				"pod: io.weight 0 out of range [1, 10000]",
+				`storageclass csi-carina-sc: invalid io.latency "fast": time: invalid duration "fast"`,
+				`pvc data: invalid blkio.throttle.write_iops_device "-1": strconv.ParseUint: parsing "-1": invalid syntax`,
+			},
+		},
+	}
+	for _, c := range cases {
+		attributes := map[string]string{carina.VolumeDeviceMajor: "253", carina.VolumeDeviceMinor: "4"}
+		for k, v := range c.storageClass {
+			attributes[k] = v
+		}
+		pv := &corev1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: "pvc-1"},
+			Spec: corev1.PersistentVolumeSpec{
+				StorageClassName:       "csi-carina-sc",
+				ClaimRef:               &corev1.ObjectReference{Namespace: "default", Name: "data"},
+				PersistentVolumeSource: corev1.PersistentVolumeSource{CSI: &corev1.CSIPersistentVolumeSource{Driver: carina.CSIPluginName, VolumeAttributes: attributes}},
+			},
+			Status: corev1.PersistentVolumeStatus{Phase: corev1.VolumeBound},
+		}
+		pvc := &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "data", Annotations: c.pvc}}
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "app", Annotations: c.pod},
+			Spec: corev1.PodSpec{Volumes: []corev1.Volume{
+				{Name: "data", VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "data"}}},
+				{Name: "config", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}},
+			}},
+		}
+		cli := fake.NewClientBuilder().WithObjects(pv, pvc).WithIndex(&corev1.PersistentVolume{}, "pvIndex", pvIndex).Build()
+		r := NewPodIOReconciler(cli, nil, "node1", &fakePartition{})
+
+		blkIO, warnings := r.getPodBlkIO(context.Background(), pod)
+		assert.Equal(t, iolimit.DeviceIOSet{"253:4": c.want}, blkIO.DeviceIOSet, c.name)
+		assert.Equal(t, c.wantWarnings, warnings, c.name)
+	}
+}
+
+func TestParseIOLimit(t *testing.T) {
+	base := &iolimit.IOLimit{Rbps: 100, Burst: &iolimit.IOBurst{Rbps: 1000, Seconds: 10}}
+	cases := []struct {
+		name         string
+		annotations  map[string]string
+		want         *iolimit.IOLimit
+		wantWarnings []string
+	}{
+		{
+			name: "keep base",
+			want: &iolimit.IOLimit{Rbps: 100, Burst: &iolimit.IOBurst{Rbps: 1000, Seconds: 10}},
+		},
+		{
+			name:        "override burst key",
+			annotations: map[string]string{ioKey(iolimit.IOBurstSeconds): "30", ioKey(iolimit.IOLatency): "500"},
+			want:        &iolimit.IOLimit{Rbps: 100, Latency: 500, Burst: &iolimit.IOBurst{Rbps: 1000, Seconds: 30}},
+		},
+		{
+			name:         "invalid burst disables burst",
+			annotations:  map[string]string{ioKey(iolimit.IOBurstWriteBPS): "1000"},
+			want:         &iolimit.IOLimit{Rbps: 100},
+			wantWarnings: []string{"pvc data: burst is disabled: io.burst.write_bps_device requires the corresponding throttle"},
+		},
+		{
+			name:         "weight out of range",
+			annotations:  map[string]string{ioKey(iolimit.IOWeight): "10001", ioKey(iolimit.IOLatency): "0"},
+			want:         &iolimit.IOLimit{Rbps: 100, Burst: &iolimit.IOBurst{Rbps: 1000, Seconds: 10}},
+			wantWarnings: []string{"pvc data: io.weight 10001 out of range [1, 10000]", `pvc data: invalid io.latency "0": must be greater than 0`},
+		},
+	}
+	for _, c := range cases {
+		iolt, warnings := parseIOLimit(c.annotations, base, "pvc data")
+		assert.Equal(t, c.want, iolt, c.name)
+		assert.ElementsMatch(t, c.wantWarnings, warnings, c.name)
+	}
+	// base不会被修改
+	assert.Equal(t, &iolimit.IOLimit{Rbps: 100, Burst: &iolimit.IOBurst{Rbps: 1000, Seconds: 10}}, base)
+}
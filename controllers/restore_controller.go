@@ -0,0 +1,323 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/carina-io/carina"
+	carinav1 "github.com/carina-io/carina/api/v1"
+	carinav1beta1 "github.com/carina-io/carina/api/v1beta1"
+	"github.com/carina-io/carina/pkg/csidriver/driver/util"
+	"github.com/carina-io/carina/utils"
+	"github.com/carina-io/carina/utils/log"
+)
+
+// restoreBindInterval 等待pvc绑定的间隔
+const restoreBindInterval = 10 * time.Second
+
+// RestoreReconciler 为恢复创建新的pvc，pv绑定后标记LogicVolume，交由卷所在节点的carina-node写入备份数据，
+// 数据写入前卷不能被pod使用
+type RestoreReconciler struct {
+	client.Client
+	recorder record.EventRecorder
+}
+
+// +kubebuilder:rbac:groups=carina.storage.io,resources=restores,verbs=get;list;watch;update;patch
+// +kubebuilder:rbac:groups=carina.storage.io,resources=restores/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=carina.storage.io,resources=backups,verbs=get;list;watch
+// +kubebuilder:rbac:groups=carina.storage.io,resources=logicvolumes,verbs=get;list;watch;update;patch
+// +kubebuilder:rbac:groups=carina.storage.io,resources=nodestorageresources,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=persistentvolumeclaims,verbs=get;list;watch;create;delete
+// +kubebuilder:rbac:groups="",resources=nodes,verbs=get;list;watch
+// +kubebuilder:rbac:groups=storage.k8s.io,resources=storageclasses,verbs=get;list;watch
+
+func NewRestoreReconciler(client client.Client, recorder record.EventRecorder) *RestoreReconciler {
+	return &RestoreReconciler{
+		Client:   client,
+		recorder: recorder,
+	}
+}
+
+func (r *RestoreReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	restore := new(carinav1.Restore)
+	if err := r.Get(ctx, req.NamespacedName, restore); err != nil {
+		if !apierrs.IsNotFound(err) {
+			log.Error(err, " unable to fetch Restore")
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, nil
+	}
+
+	if restore.DeletionTimestamp != nil {
+		if !utils.ContainsString(restore.Finalizers, carina.RestoreFinalizer) {
+			return ctrl.Result{}, nil
+		}
+		log.Info("Start finalizing Restore name ", req.NamespacedName)
+		return ctrl.Result{}, r.finalize(ctx, restore)
+	}
+
+	if !utils.ContainsString(restore.Finalizers, carina.RestoreFinalizer) {
+		restore2 := restore.DeepCopy()
+		restore2.Finalizers = append(restore2.Finalizers, carina.RestoreFinalizer)
+		return ctrl.Result{}, r.Patch(ctx, restore2, client.MergeFrom(restore))
+	}
+
+	var result ctrl.Result
+	var err error
+	switch restore.Status.Phase {
+	case "", carinav1.RestorePending:
+		result, err = r.prepare(ctx, restore)
+	case carinav1.RestoreProvisioning:
+		result, err = r.provision(ctx, restore)
+	case carinav1.RestoreFailed:
+		// carina-node写入失败时删除未完成恢复的pvc
+		err = r.cleanup(ctx, restore)
+	}
+	if err != nil {
+		log.Error(err, " failed to reconcile Restore name ", req.NamespacedName, " phase ", restore.Status.Phase)
+	}
+	return result, err
+}
+
+func (r *RestoreReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&carinav1.Restore{}).
+		Complete(r)
+}
+
+// prepare 校验备份，选择节点并创建pvc
+func (r *RestoreReconciler) prepare(ctx context.Context, restore *carinav1.Restore) (ctrl.Result, error) {
+	b := new(carinav1.Backup)
+	if err := r.Get(ctx, client.ObjectKey{Namespace: restore.Namespace, Name: restore.Spec.Backup}, b); err != nil {
+		if apierrs.IsNotFound(err) {
+			return ctrl.Result{}, r.fail(ctx, restore, fmt.Sprintf("backup %s not found", restore.Spec.Backup))
+		}
+		return ctrl.Result{}, err
+	}
+	switch {
+	case b.DeletionTimestamp != nil || b.Status.Phase == carinav1.BackupFailed:
+		return ctrl.Result{}, r.fail(ctx, restore, fmt.Sprintf("backup %s is not available", b.Name))
+	case b.Status.Phase != carinav1.BackupCompleted || b.Status.Size == nil:
+		log.Infof("restore %s/%s waiting for backup %s", restore.Namespace, restore.Name, b.Name)
+		return ctrl.Result{RequeueAfter: backupWaitInterval}, nil
+	}
+
+	key := client.ObjectKey{Namespace: restore.Namespace, Name: restore.Spec.PersistentVolumeClaim}
+	err := r.Get(ctx, key, new(corev1.PersistentVolumeClaim))
+	if err == nil {
+		return ctrl.Result{}, r.fail(ctx, restore, fmt.Sprintf("persistent volume claim %s already exists", key.Name))
+	}
+	if !apierrs.IsNotFound(err) {
+		return ctrl.Result{}, err
+	}
+
+	storageClassName := restore.Spec.StorageClassName
+	if storageClassName == "" {
+		storageClassName = b.Status.StorageClassName
+	}
+	sc := new(storagev1.StorageClass)
+	if err := r.Get(ctx, client.ObjectKey{Name: storageClassName}, sc); err != nil {
+		if apierrs.IsNotFound(err) {
+			return ctrl.Result{}, r.fail(ctx, restore, fmt.Sprintf("storage class %s not found", storageClassName))
+		}
+		return ctrl.Result{}, err
+	}
+	if sc.Provisioner != carina.CSIPluginName {
+		return ctrl.Result{}, r.fail(ctx, restore, fmt.Sprintf("storage class %s is not provisioned by carina", storageClassName))
+	}
+	nodeName := restore.Spec.NodeName
+	if nodeName == "" {
+		deviceGroup := util.GetDeviceGroup(sc.Parameters[carina.DeviceDiskKey])
+		if nodeName, err = r.selectNode(ctx, deviceGroup, b.Status.Size.Value()); err != nil {
+			return ctrl.Result{}, err
+		}
+		if nodeName == "" {
+			return ctrl.Result{}, r.fail(ctx, restore, fmt.Sprintf("no node has %s allocatable in device group %s", b.Status.Size.String(), deviceGroup))
+		}
+	}
+
+	pvc := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      key.Name,
+			Namespace: key.Namespace,
+			Annotations: map[string]string{
+				carina.RestoreAnnotation: restore.Name,
+				// 与WaitForFirstConsumer模式调度器选择节点后的注解相同，external-provisioner据此在该节点创建卷
+				carina.AnnSelectedNode: nodeName,
+			},
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes:      []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+			StorageClassName: &storageClassName,
+			VolumeMode:       b.Status.VolumeMode,
+			Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceStorage: *b.Status.Size},
+			},
+		},
+	}
+	log.Infof("create persistent volume claim %s/%s on node %s", pvc.Namespace, pvc.Name, nodeName)
+	if err := r.Create(ctx, pvc); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	now := metav1.Now()
+	restore2 := restore.DeepCopy()
+	restore2.Status.Phase = carinav1.RestoreProvisioning
+	restore2.Status.NodeName = nodeName
+	restore2.Status.SourceVolume = b.Status.PersistentVolume
+	restore2.Status.BackupID = string(b.UID)
+	restore2.Status.Message = ""
+	restore2.Status.StartTime = &now
+	if err := r.Status().Patch(ctx, restore2, client.MergeFrom(restore)); err != nil {
+		return ctrl.Result{}, err
+	}
+	r.recorder.Event(restore, corev1.EventTypeNormal, "Started", fmt.Sprintf("restore backup %s to persistent volume claim %s on node %s", b.Name, pvc.Name, nodeName))
+	return ctrl.Result{RequeueAfter: restoreBindInterval}, nil
+}
+
+// provision 等待pvc绑定，标记LogicVolume正在恢复，交由carina-node写入数据
+func (r *RestoreReconciler) provision(ctx context.Context, restore *carinav1.Restore) (ctrl.Result, error) {
+	pvc := new(corev1.PersistentVolumeClaim)
+	if err := r.Get(ctx, client.ObjectKey{Namespace: restore.Namespace, Name: restore.Spec.PersistentVolumeClaim}, pvc); err != nil {
+		if apierrs.IsNotFound(err) {
+			return ctrl.Result{}, r.fail(ctx, restore, fmt.Sprintf("persistent volume claim %s not found", restore.Spec.PersistentVolumeClaim))
+		}
+		return ctrl.Result{}, err
+	}
+	if pvc.Status.Phase != corev1.ClaimBound {
+		return ctrl.Result{RequeueAfter: restoreBindInterval}, nil
+	}
+	pv := new(corev1.PersistentVolume)
+	if err := r.Get(ctx, client.ObjectKey{Name: pvc.Spec.VolumeName}, pv); err != nil {
+		return ctrl.Result{}, err
+	}
+	if pv.Spec.CSI == nil {
+		return ctrl.Result{}, r.fail(ctx, restore, fmt.Sprintf("persistent volume %s is not provisioned by carina", pv.Name))
+	}
+	lv, err := logicVolumeByVolumeID(ctx, r.Client, pv.Spec.CSI.VolumeHandle)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if lv == nil {
+		return ctrl.Result{RequeueAfter: restoreBindInterval}, nil
+	}
+	if lv.Annotations[carina.VolumeManagerType] != carina.LvmVolumeType || len(lv.OwnerReferences) > 0 {
+		return ctrl.Result{}, r.fail(ctx, restore, fmt.Sprintf("volume %s is not a lvm volume and can not be restored", lv.Name))
+	}
+
+	if lv.Annotations[carina.RestoreAnnotation] == "" {
+		lv2 := lv.DeepCopy()
+		if lv2.Annotations == nil {
+			lv2.Annotations = map[string]string{}
+		}
+		lv2.Annotations[carina.RestoreAnnotation] = restore.Namespace + "/" + restore.Name
+		if err := r.Patch(ctx, lv2, client.MergeFrom(lv)); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	restore2 := restore.DeepCopy()
+	restore2.Status.Phase = carinav1.RestoreInProgress
+	restore2.Status.NodeName = lv.Spec.NodeName
+	restore2.Status.DeviceGroup = lv.Spec.DeviceGroup
+	restore2.Status.LogicVolume = lv.Name
+	restore2.Status.VolumeID = lv.Status.VolumeID
+	restore2.Status.PersistentVolume = pv.Name
+	return ctrl.Result{}, r.Status().Patch(ctx, restore2, client.MergeFrom(restore))
+}
+
+// selectNode 选择device group可分配容量最多的就绪节点
+func (r *RestoreReconciler) selectNode(ctx context.Context, deviceGroup string, size int64) (string, error) {
+	nsrList := new(carinav1beta1.NodeStorageResourceList)
+	if err := r.List(ctx, nsrList); err != nil {
+		return "", err
+	}
+	var selected string
+	var max int64
+	for _, nsr := range nsrList.Items {
+		allocatable, ok := nsr.Status.Allocatable[carina.DeviceCapacityKeyPrefix+deviceGroup]
+		if !ok || allocatable.Value() < size || allocatable.Value() <= max {
+			continue
+		}
+		node := new(corev1.Node)
+		if err := r.Get(ctx, client.ObjectKey{Name: nsr.Spec.NodeName}, node); err != nil || !nodeReady(node) {
+			continue
+		}
+		selected, max = nsr.Spec.NodeName, allocatable.Value()
+	}
+	return selected, nil
+}
+
+func (r *RestoreReconciler) fail(ctx context.Context, restore *carinav1.Restore, message string) error {
+	log.Warnf("restore %s/%s failed %s", restore.Namespace, restore.Name, message)
+	now := metav1.Now()
+	restore2 := restore.DeepCopy()
+	restore2.Status.Phase = carinav1.RestoreFailed
+	restore2.Status.Message = message
+	restore2.Status.CompletionTime = &now
+	if err := r.Status().Patch(ctx, restore2, client.MergeFrom(restore)); err != nil {
+		return err
+	}
+	r.recorder.Event(restore, corev1.EventTypeWarning, "Failed", message)
+	return nil
+}
+
+// cleanup 删除恢复创建的pvc，恢复完成后pvc归用户所有，不再删除
+func (r *RestoreReconciler) cleanup(ctx context.Context, restore *carinav1.Restore) error {
+	if restore.Status.Phase == carinav1.RestoreCompleted || restore.Status.NodeName == "" {
+		return nil
+	}
+	pvc := new(corev1.PersistentVolumeClaim)
+	err := r.Get(ctx, client.ObjectKey{Namespace: restore.Namespace, Name: restore.Spec.PersistentVolumeClaim}, pvc)
+	if err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if pvc.Annotations[carina.RestoreAnnotation] != restore.Name || pvc.DeletionTimestamp != nil {
+		return nil
+	}
+	log.Infof("delete persistent volume claim %s/%s of unfinished restore", pvc.Namespace, pvc.Name)
+	return client.IgnoreNotFound(r.Delete(ctx, pvc))
+}
+
+func (r *RestoreReconciler) finalize(ctx context.Context, restore *carinav1.Restore) error {
+	if err := r.cleanup(ctx, restore); err != nil {
+		return err
+	}
+	restore2 := restore.DeepCopy()
+	restore2.Finalizers = utils.SliceRemoveString(restore2.Finalizers, carina.RestoreFinalizer)
+	return r.Patch(ctx, restore2, client.MergeFrom(restore))
+}
+
+func nodeReady(node *corev1.Node) bool {
+	for _, s := range node.Status.Conditions {
+		if s.Type == corev1.NodeReady && s.Status == corev1.ConditionTrue {
+			return true
+		}
+	}
+	return false
+}
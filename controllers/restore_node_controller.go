@@ -0,0 +1,245 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+
+	"github.com/carina-io/carina"
+	carinav1 "github.com/carina-io/carina/api/v1"
+	"github.com/carina-io/carina/pkg/backup"
+	deviceManager "github.com/carina-io/carina/pkg/devicemanager"
+	"github.com/carina-io/carina/utils/log"
+)
+
+// RestoreNodeReconciler 在新卷所在节点从对象存储下载备份写入卷，完成后移除LogicVolume的恢复标记，卷才能被pod使用
+type RestoreNodeReconciler struct {
+	client.Client
+	apiReader client.Reader
+	recorder  record.EventRecorder
+	dm        *deviceManager.DeviceManager
+
+	mu      sync.Mutex
+	running map[string]context.CancelFunc
+}
+
+// +kubebuilder:rbac:groups=carina.storage.io,resources=restores,verbs=get;list;watch
+// +kubebuilder:rbac:groups=carina.storage.io,resources=restores/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=carina.storage.io,resources=logicvolumes,verbs=get;list;watch;update;patch
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get
+
+func NewRestoreNodeReconciler(client client.Client, apiReader client.Reader, recorder record.EventRecorder, dm *deviceManager.DeviceManager) *RestoreNodeReconciler {
+	return &RestoreNodeReconciler{
+		Client:    client,
+		apiReader: apiReader,
+		recorder:  recorder,
+		dm:        dm,
+		running:   map[string]context.CancelFunc{},
+	}
+}
+
+func (r *RestoreNodeReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	restore := new(carinav1.Restore)
+	if err := r.Get(ctx, req.NamespacedName, restore); err != nil {
+		if !apierrs.IsNotFound(err) {
+			log.Error(err, " unable to fetch Restore")
+			return ctrl.Result{}, err
+		}
+		r.cancel(req.String())
+		return ctrl.Result{}, nil
+	}
+
+	if restore.DeletionTimestamp != nil {
+		r.cancel(req.String())
+		return ctrl.Result{}, nil
+	}
+
+	if restore.Status.Phase == carinav1.RestoreInProgress && restore.Status.NodeName == r.dm.NodeName {
+		r.start(req.String(), restore)
+	}
+	return ctrl.Result{}, nil
+}
+
+func (r *RestoreNodeReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&carinav1.Restore{}).
+		WithEventFilter(&restoreFilter{r.dm.NodeName}).
+		WithOptions(controller.Options{
+			MaxConcurrentReconciles: 2,
+		}).
+		Complete(r)
+}
+
+// start 后台执行恢复，carina-node重启后从头重新写入
+func (r *RestoreNodeReconciler) start(key string, restore *carinav1.Restore) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.running[key]; ok {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	r.running[key] = cancel
+
+	go func() {
+		defer r.cancel(key)
+		if err := r.restore(ctx, restore); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Errorf("restore %s failed %s", key, err.Error())
+			r.recorder.Event(restore, corev1.EventTypeWarning, "Failed", fmt.Sprintf("restore volume %s failed: %s", restore.Status.VolumeID, err.Error()))
+			if perr := r.patchStatus(restore, func(status *carinav1.RestoreStatus) {
+				now := metav1.Now()
+				status.Phase = carinav1.RestoreFailed
+				status.Message = err.Error()
+				status.CompletionTime = &now
+			}); perr != nil {
+				log.Errorf("update restore %s status failed %s", key, perr.Error())
+			}
+		}
+	}()
+}
+
+func (r *RestoreNodeReconciler) restore(ctx context.Context, restore *carinav1.Restore) error {
+	repo, err := backupRepository(ctx, r.apiReader)
+	if err != nil {
+		return err
+	}
+	manifest, err := repo.GetManifest(ctx, restore.Status.SourceVolume, restore.Status.BackupID)
+	if err != nil {
+		return err
+	}
+	lvInfo, err := r.dm.VolumeManager.WithContext(ctx).VolumeInfo(restore.Status.VolumeID, restore.Status.DeviceGroup)
+	if err != nil {
+		return fmt.Errorf("get volume %s: %w", restore.Status.VolumeID, err)
+	}
+	if lvOpen(lvInfo) {
+		return fmt.Errorf("volume %s is in use", restore.Status.VolumeID)
+	}
+
+	lastUpdate := time.Now()
+	opts := backup.RestoreOptions{
+		// thin卷新分配的数据块读出为0，无需写入全0的数据块
+		SkipZero: lvInfo.PoolLV != "",
+		Progress: func(written int64) {
+			if time.Since(lastUpdate) < backupProgressInterval {
+				return
+			}
+			lastUpdate = time.Now()
+			_ = r.patchStatus(restore, func(status *carinav1.RestoreStatus) {
+				status.RestoredBytes = written
+			})
+		},
+	}
+	log.Infof("restore %s/%s backup %s to volume %s", restore.Namespace, restore.Name, manifest.Backup, restore.Status.VolumeID)
+	written, err := repo.Restore(ctx, manifest, lvPath(lvInfo), opts)
+	if err != nil {
+		return err
+	}
+
+	lv := new(carinav1.LogicVolume)
+	if err := r.Get(ctx, client.ObjectKey{Name: restore.Status.LogicVolume}, lv); err != nil {
+		return err
+	}
+	if _, ok := lv.Annotations[carina.RestoreAnnotation]; ok {
+		lv2 := lv.DeepCopy()
+		delete(lv2.Annotations, carina.RestoreAnnotation)
+		if err := r.Patch(ctx, lv2, client.MergeFrom(lv)); err != nil {
+			return err
+		}
+	}
+
+	if err := r.patchStatus(restore, func(status *carinav1.RestoreStatus) {
+		now := metav1.Now()
+		status.Phase = carinav1.RestoreCompleted
+		status.RestoredBytes = written
+		status.Message = ""
+		status.CompletionTime = &now
+	}); err != nil {
+		return err
+	}
+	log.Infof("restore %s/%s completed, wrote %d bytes", restore.Namespace, restore.Name, written)
+	r.recorder.Event(restore, corev1.EventTypeNormal, "Completed", fmt.Sprintf("wrote %d bytes to volume %s", written, restore.Status.VolumeID))
+	return nil
+}
+
+func (r *RestoreNodeReconciler) patchStatus(restore *carinav1.Restore, mutate func(status *carinav1.RestoreStatus)) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	latest := new(carinav1.Restore)
+	if err := r.Get(ctx, client.ObjectKeyFromObject(restore), latest); err != nil {
+		return err
+	}
+	restore2 := latest.DeepCopy()
+	mutate(&restore2.Status)
+	return r.Status().Patch(ctx, restore2, client.MergeFrom(latest))
+}
+
+func (r *RestoreNodeReconciler) cancel(key string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if cancel, ok := r.running[key]; ok {
+		cancel()
+		delete(r.running, key)
+	}
+}
+
+// filter Restore
+type restoreFilter struct {
+	nodeName string
+}
+
+func (f restoreFilter) filter(restore *carinav1.Restore) bool {
+	if restore == nil {
+		return false
+	}
+	return restore.Status.NodeName == f.nodeName
+}
+
+func (f restoreFilter) Create(e event.CreateEvent) bool {
+	return f.filter(e.Object.(*carinav1.Restore))
+}
+
+func (f restoreFilter) Delete(e event.DeleteEvent) bool {
+	return f.filter(e.Object.(*carinav1.Restore))
+}
+
+func (f restoreFilter) Update(e event.UpdateEvent) bool {
+	newRestore := e.ObjectNew.(*carinav1.Restore)
+	oldRestore := e.ObjectOld.(*carinav1.Restore)
+	if newRestore.ResourceVersion == oldRestore.ResourceVersion {
+		return false
+	}
+	return f.filter(newRestore) || f.filter(oldRestore)
+}
+
+func (f restoreFilter) Generic(e event.GenericEvent) bool {
+	return f.filter(e.Object.(*carinav1.Restore))
+}
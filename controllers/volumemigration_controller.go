@@ -0,0 +1,601 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	corev1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/carina-io/carina"
+	carinav1 "github.com/carina-io/carina/api/v1"
+	"github.com/carina-io/carina/pkg/configuration"
+	"github.com/carina-io/carina/utils"
+	"github.com/carina-io/carina/utils/log"
+)
+
+// VolumeMigrationReconciler 推进卷迁移的各个阶段：校验源卷、在目标节点创建LogicVolume、
+// 等待carina-node全量同步、等待应用停止、增量同步、重建pv指向目标卷，最后删除源卷
+type VolumeMigrationReconciler struct {
+	client.Client
+	recorder record.EventRecorder
+}
+
+// +kubebuilder:rbac:groups=carina.storage.io,resources=volumemigrations,verbs=get;list;watch;update;patch
+// +kubebuilder:rbac:groups=carina.storage.io,resources=volumemigrations/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=carina.storage.io,resources=logicvolumes,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=persistentvolumes,verbs=get;list;watch;create;delete;patch
+// +kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;create;delete
+
+func NewVolumeMigrationReconciler(client client.Client, recorder record.EventRecorder) *VolumeMigrationReconciler {
+	return &VolumeMigrationReconciler{
+		Client:   client,
+		recorder: recorder,
+	}
+}
+
+func (r *VolumeMigrationReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	vm := new(carinav1.VolumeMigration)
+	if err := r.Get(ctx, req.NamespacedName, vm); err != nil {
+		if !apierrs.IsNotFound(err) {
+			log.Error(err, " unable to fetch VolumeMigration")
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, nil
+	}
+
+	if vm.DeletionTimestamp != nil {
+		if !utils.ContainsString(vm.Finalizers, carina.VolumeMigrationFinalizer) {
+			return ctrl.Result{}, nil
+		}
+		log.Info("Start finalizing VolumeMigration name ", vm.Name)
+		return r.finalize(ctx, vm)
+	}
+
+	if !utils.ContainsString(vm.Finalizers, carina.VolumeMigrationFinalizer) {
+		vm2 := vm.DeepCopy()
+		vm2.Finalizers = append(vm2.Finalizers, carina.VolumeMigrationFinalizer)
+		return ctrl.Result{}, r.Patch(ctx, vm2, client.MergeFrom(vm))
+	}
+
+	var result ctrl.Result
+	var err error
+	switch vm.Status.Phase {
+	case "", carinav1.MigrationPending:
+		result, err = r.prepare(ctx, vm)
+	case carinav1.MigrationProvisioning:
+		result, err = r.provision(ctx, vm)
+	case carinav1.MigrationSyncing:
+		if vm.Status.InitialSynced {
+			err = r.setPhase(ctx, vm, carinav1.MigrationQuiescing, "")
+		}
+	case carinav1.MigrationQuiescing, carinav1.MigrationFinalSync:
+		result, err = r.quiesce(ctx, vm)
+	case carinav1.MigrationRebinding:
+		result, err = r.rebind(ctx, vm)
+	case carinav1.MigrationRetiring:
+		result, err = r.retire(ctx, vm)
+	}
+	if err != nil {
+		log.Error(err, " failed to reconcile VolumeMigration name ", vm.Name, " phase ", vm.Status.Phase)
+	}
+	return result, err
+}
+
+func (r *VolumeMigrationReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&carinav1.VolumeMigration{}).
+		Owns(&carinav1.LogicVolume{}).
+		Complete(r)
+}
+
+// prepare 校验源卷，生成传输token，标记源卷正在迁移
+func (r *VolumeMigrationReconciler) prepare(ctx context.Context, vm *carinav1.VolumeMigration) (ctrl.Result, error) {
+	pv := new(corev1.PersistentVolume)
+	if err := r.Get(ctx, client.ObjectKey{Name: vm.Spec.PersistentVolume}, pv); err != nil {
+		if apierrs.IsNotFound(err) {
+			return ctrl.Result{}, r.fail(ctx, vm, fmt.Sprintf("persistent volume %s not found", vm.Spec.PersistentVolume))
+		}
+		return ctrl.Result{}, err
+	}
+	if pv.Spec.CSI == nil || pv.Spec.CSI.Driver != carina.CSIPluginName {
+		return ctrl.Result{}, r.fail(ctx, vm, fmt.Sprintf("persistent volume %s is not provisioned by carina", pv.Name))
+	}
+	if pv.Spec.ClaimRef == nil || pv.Status.Phase != corev1.VolumeBound {
+		return ctrl.Result{}, r.fail(ctx, vm, fmt.Sprintf("persistent volume %s is not bound", pv.Name))
+	}
+
+	source, err := logicVolumeByVolumeID(ctx, r.Client, pv.Spec.CSI.VolumeHandle)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if source == nil {
+		return ctrl.Result{}, r.fail(ctx, vm, fmt.Sprintf("logic volume of %s not found", pv.Spec.CSI.VolumeHandle))
+	}
+	// 裸盘分区以及bcache卷不支持迁移
+	if source.Annotations[carina.VolumeManagerType] != carina.LvmVolumeType || len(source.OwnerReferences) > 0 {
+		return ctrl.Result{}, r.fail(ctx, vm, fmt.Sprintf("volume %s is not a lvm volume and can not be migrated", source.Name))
+	}
+	if name, ok := source.Annotations[carina.VolumeMigrationAnnotation]; ok && name != vm.Name {
+		return ctrl.Result{}, r.fail(ctx, vm, fmt.Sprintf("volume %s is being migrated by %s", source.Name, name))
+	}
+	if source.Spec.NodeName == vm.Spec.TargetNode {
+		return ctrl.Result{}, r.fail(ctx, vm, fmt.Sprintf("volume %s is already on node %s", source.Name, vm.Spec.TargetNode))
+	}
+	if err := r.Get(ctx, client.ObjectKey{Name: vm.Spec.TargetNode}, new(corev1.Node)); err != nil {
+		if apierrs.IsNotFound(err) {
+			return ctrl.Result{}, r.fail(ctx, vm, fmt.Sprintf("node %s not found", vm.Spec.TargetNode))
+		}
+		return ctrl.Result{}, err
+	}
+
+	if err := r.createToken(ctx, vm); err != nil {
+		return ctrl.Result{}, err
+	}
+	if source.Annotations[carina.VolumeMigrationAnnotation] != vm.Name {
+		source2 := source.DeepCopy()
+		if source2.Annotations == nil {
+			source2.Annotations = map[string]string{}
+		}
+		source2.Annotations[carina.VolumeMigrationAnnotation] = vm.Name
+		if err := r.Patch(ctx, source2, client.MergeFrom(source)); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	size := source.Spec.Size
+	if source.Status.CurrentSize != nil {
+		size = *source.Status.CurrentSize
+	}
+	now := metav1.Now()
+	vm2 := vm.DeepCopy()
+	vm2.Status.Phase = carinav1.MigrationProvisioning
+	vm2.Status.SourceNode = source.Spec.NodeName
+	vm2.Status.SourceVolume = source.Name
+	vm2.Status.TargetVolume = fmt.Sprintf("%s-%s", source.PVName(), string(vm.UID)[:8])
+	vm2.Status.TotalBytes = size.Value()
+	vm2.Status.StartTime = &now
+	vm2.Status.Message = ""
+	if err := r.Status().Patch(ctx, vm2, client.MergeFrom(vm)); err != nil {
+		return ctrl.Result{}, err
+	}
+	r.recorder.Event(vm, corev1.EventTypeNormal, "Started", fmt.Sprintf("migrate volume %s from node %s to node %s", source.Name, source.Spec.NodeName, vm.Spec.TargetNode))
+	return ctrl.Result{}, nil
+}
+
+// provision 在目标节点创建与源卷相同的LogicVolume，迁移完成前归属于VolumeMigration
+func (r *VolumeMigrationReconciler) provision(ctx context.Context, vm *carinav1.VolumeMigration) (ctrl.Result, error) {
+	target := new(carinav1.LogicVolume)
+	err := r.Get(ctx, client.ObjectKey{Name: vm.Status.TargetVolume}, target)
+	if err != nil && !apierrs.IsNotFound(err) {
+		return ctrl.Result{}, err
+	}
+	if apierrs.IsNotFound(err) {
+		source := new(carinav1.LogicVolume)
+		if err := r.Get(ctx, client.ObjectKey{Name: vm.Status.SourceVolume}, source); err != nil {
+			return ctrl.Result{}, err
+		}
+		target = r.targetLogicVolume(vm, source)
+		log.Infof("create target logic volume %s on node %s", target.Name, target.Spec.NodeName)
+		return ctrl.Result{}, r.Create(ctx, target)
+	}
+
+	if target.Status.Code != codes.OK {
+		return ctrl.Result{}, r.fail(ctx, vm, fmt.Sprintf("create volume on node %s failed: %s", vm.Spec.TargetNode, target.Status.Message))
+	}
+	if target.Status.VolumeID == "" {
+		return ctrl.Result{}, nil
+	}
+	return ctrl.Result{}, r.setPhase(ctx, vm, carinav1.MigrationSyncing, "")
+}
+
+func (r *VolumeMigrationReconciler) targetLogicVolume(vm *carinav1.VolumeMigration, source *carinav1.LogicVolume) *carinav1.LogicVolume {
+	annotations := map[string]string{}
+	for k, v := range source.Annotations {
+		annotations[k] = v
+	}
+	// 数据由源卷复制，不再从快照或已有卷创建
+	for _, k := range []string{carina.VolumeDataSourceKind, carina.VolumeDataSourceID, carina.ResizeRequestedAtKey, carina.VolumeMigrationAnnotation} {
+		delete(annotations, k)
+	}
+	annotations[carina.PersistentVolumeAnnotation] = source.PVName()
+
+	deviceGroup := vm.Spec.TargetDeviceGroup
+	if deviceGroup == "" {
+		deviceGroup = source.Spec.DeviceGroup
+	}
+	size := source.Spec.Size
+	if source.Status.CurrentSize != nil && source.Status.CurrentSize.Cmp(size) > 0 {
+		size = *source.Status.CurrentSize
+	}
+	return &carinav1.LogicVolume{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "LogicVolume",
+			APIVersion: "carina.storage.io/v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        vm.Status.TargetVolume,
+			Annotations: annotations,
+			Finalizers:  []string{carina.LogicVolumeFinalizer},
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: carinav1.GroupVersion.String(),
+				Kind:       "VolumeMigration",
+				Name:       vm.Name,
+				UID:        vm.UID,
+			}},
+		},
+		Spec: carinav1.LogicVolumeSpec{
+			NodeName:    vm.Spec.TargetNode,
+			DeviceGroup: deviceGroup,
+			Size:        size,
+			NameSpace:   source.Spec.NameSpace,
+			Pvc:         source.Spec.Pvc,
+		},
+	}
+}
+
+// quiesce 全量同步完成后等待使用该卷的pod停止，再由源节点同步增量数据，
+// 增量同步期间若有pod重新启动，回到等待阶段
+func (r *VolumeMigrationReconciler) quiesce(ctx context.Context, vm *carinav1.VolumeMigration) (ctrl.Result, error) {
+	source := new(carinav1.LogicVolume)
+	if err := r.Get(ctx, client.ObjectKey{Name: vm.Status.SourceVolume}, source); err != nil {
+		return ctrl.Result{}, err
+	}
+	pods, err := r.podsUsingClaim(ctx, source.Spec.NameSpace, source.Spec.Pvc)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if len(pods) > 0 {
+		message := fmt.Sprintf("waiting for pods %v using pvc %s/%s to stop", pods, source.Spec.NameSpace, source.Spec.Pvc)
+		if vm.Status.Phase != carinav1.MigrationQuiescing || vm.Status.Message != message {
+			vm2 := vm.DeepCopy()
+			vm2.Status.Phase = carinav1.MigrationQuiescing
+			vm2.Status.FinalSynced = false
+			vm2.Status.Message = message
+			if err := r.Status().Patch(ctx, vm2, client.MergeFrom(vm)); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+		return ctrl.Result{RequeueAfter: 10 * time.Second}, nil
+	}
+
+	if vm.Status.Phase == carinav1.MigrationQuiescing {
+		return ctrl.Result{}, r.setPhase(ctx, vm, carinav1.MigrationFinalSync, "")
+	}
+	if vm.Status.FinalSynced {
+		return ctrl.Result{}, r.setPhase(ctx, vm, carinav1.MigrationRebinding, "")
+	}
+	return ctrl.Result{RequeueAfter: 10 * time.Second}, nil
+}
+
+// rebind pv的volumeHandle以及nodeAffinity不可修改，记录原pv后将其删除并重建，
+// 新pv指向目标卷，claimRef不变，pvc会重新绑定到新pv
+func (r *VolumeMigrationReconciler) rebind(ctx context.Context, vm *carinav1.VolumeMigration) (ctrl.Result, error) {
+	target := new(carinav1.LogicVolume)
+	if err := r.Get(ctx, client.ObjectKey{Name: vm.Status.TargetVolume}, target); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	pv := new(corev1.PersistentVolume)
+	err := r.Get(ctx, client.ObjectKey{Name: vm.Spec.PersistentVolume}, pv)
+	if err != nil && !apierrs.IsNotFound(err) {
+		return ctrl.Result{}, err
+	}
+
+	if apierrs.IsNotFound(err) {
+		saved := new(corev1.PersistentVolume)
+		if err := json.Unmarshal([]byte(vm.Annotations[carina.MigrationSourcePVAnnotation]), saved); err != nil {
+			return ctrl.Result{}, fmt.Errorf("decode recorded persistent volume %s: %w", vm.Spec.PersistentVolume, err)
+		}
+		newPV := targetPersistentVolume(saved, target)
+		log.Infof("recreate persistent volume %s with volume %s on node %s", newPV.Name, target.Status.VolumeID, target.Spec.NodeName)
+		if err := r.Create(ctx, newPV); err != nil {
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{RequeueAfter: 2 * time.Second}, nil
+	}
+
+	if pv.Spec.CSI != nil && pv.Spec.CSI.VolumeHandle == target.Status.VolumeID {
+		if pv.Status.Phase != corev1.VolumeBound {
+			return ctrl.Result{RequeueAfter: 5 * time.Second}, nil
+		}
+		// pv已指向目标卷，目标卷不再归属于VolumeMigration
+		if len(target.OwnerReferences) > 0 {
+			target2 := target.DeepCopy()
+			target2.OwnerReferences = nil
+			if err := r.Patch(ctx, target2, client.MergeFrom(target)); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+		r.recorder.Event(vm, corev1.EventTypeNormal, "Rebound", fmt.Sprintf("persistent volume %s is bound to volume %s on node %s", pv.Name, target.Status.VolumeID, target.Spec.NodeName))
+		return ctrl.Result{}, r.setPhase(ctx, vm, carinav1.MigrationRetiring, "")
+	}
+
+	// 删除前记录原pv，用于重建
+	if _, ok := vm.Annotations[carina.MigrationSourcePVAnnotation]; !ok {
+		saved := pv.DeepCopy()
+		saved.ObjectMeta = metav1.ObjectMeta{
+			Name:        pv.Name,
+			Labels:      pv.Labels,
+			Annotations: pv.Annotations,
+		}
+		saved.Status = corev1.PersistentVolumeStatus{}
+		data, err := json.Marshal(saved)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		vm2 := vm.DeepCopy()
+		if vm2.Annotations == nil {
+			vm2.Annotations = map[string]string{}
+		}
+		vm2.Annotations[carina.MigrationSourcePVAnnotation] = string(data)
+		return ctrl.Result{}, r.Patch(ctx, vm2, client.MergeFrom(vm))
+	}
+
+	// 回收策略改为Retain，避免删除pv时源卷被删除
+	if pv.Spec.PersistentVolumeReclaimPolicy != corev1.PersistentVolumeReclaimRetain || len(pv.Finalizers) > 0 {
+		pv2 := pv.DeepCopy()
+		pv2.Spec.PersistentVolumeReclaimPolicy = corev1.PersistentVolumeReclaimRetain
+		pv2.Finalizers = nil
+		if err := r.Patch(ctx, pv2, client.MergeFrom(pv)); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+	if pv.DeletionTimestamp == nil {
+		log.Infof("delete persistent volume %s of volume %s", pv.Name, pv.Spec.CSI.VolumeHandle)
+		if err := r.Delete(ctx, pv); err != nil && !apierrs.IsNotFound(err) {
+			return ctrl.Result{}, err
+		}
+	}
+	return ctrl.Result{RequeueAfter: 2 * time.Second}, nil
+}
+
+// targetPersistentVolume 由原pv生成指向目标卷的pv
+func targetPersistentVolume(saved *corev1.PersistentVolume, target *carinav1.LogicVolume) *corev1.PersistentVolume {
+	pv := saved.DeepCopy()
+	if pv.Spec.ClaimRef != nil {
+		pv.Spec.ClaimRef.ResourceVersion = ""
+	}
+
+	csi := pv.Spec.CSI
+	csi.VolumeHandle = target.Status.VolumeID
+	attributes := map[string]string{}
+	for k, v := range csi.VolumeAttributes {
+		attributes[k] = v
+	}
+	attributes[carina.DeviceDiskKey] = target.Spec.DeviceGroup
+	attributes[carina.VolumeDevicePath] = fmt.Sprintf("/dev/%s/%s", target.Spec.DeviceGroup, target.Status.VolumeID)
+	attributes[carina.VolumeDeviceNode] = target.Spec.NodeName
+	attributes[carina.VolumeDeviceMajor] = fmt.Sprintf("%d", target.Status.DeviceMajor)
+	attributes[carina.VolumeDeviceMinor] = fmt.Sprintf("%d", target.Status.DeviceMinor)
+	csi.VolumeAttributes = attributes
+
+	pv.Spec.NodeAffinity = &corev1.VolumeNodeAffinity{
+		Required: &corev1.NodeSelector{
+			NodeSelectorTerms: []corev1.NodeSelectorTerm{{
+				MatchExpressions: []corev1.NodeSelectorRequirement{{
+					Key:      carina.TopologyNodeKey,
+					Operator: corev1.NodeSelectorOpIn,
+					Values:   []string{target.Spec.NodeName},
+				}},
+			}},
+		},
+	}
+	return pv
+}
+
+// retire 删除源卷，由源节点的carina-node删除lvm卷
+func (r *VolumeMigrationReconciler) retire(ctx context.Context, vm *carinav1.VolumeMigration) (ctrl.Result, error) {
+	source := new(carinav1.LogicVolume)
+	err := r.Get(ctx, client.ObjectKey{Name: vm.Status.SourceVolume}, source)
+	if err != nil && !apierrs.IsNotFound(err) {
+		return ctrl.Result{}, err
+	}
+	if err == nil {
+		if source.DeletionTimestamp == nil {
+			log.Infof("delete source logic volume %s on node %s", source.Name, source.Spec.NodeName)
+			if err := r.Delete(ctx, source); err != nil && !apierrs.IsNotFound(err) {
+				return ctrl.Result{}, err
+			}
+		}
+		return ctrl.Result{RequeueAfter: 5 * time.Second}, nil
+	}
+
+	if err := r.deleteToken(ctx, vm); err != nil {
+		return ctrl.Result{}, err
+	}
+	now := metav1.Now()
+	vm2 := vm.DeepCopy()
+	vm2.Status.Phase = carinav1.MigrationCompleted
+	vm2.Status.Message = ""
+	vm2.Status.CompletionTime = &now
+	if err := r.Status().Patch(ctx, vm2, client.MergeFrom(vm)); err != nil {
+		return ctrl.Result{}, err
+	}
+	r.recorder.Event(vm, corev1.EventTypeNormal, "Completed", fmt.Sprintf("volume %s migrated to node %s", vm.Spec.PersistentVolume, vm.Spec.TargetNode))
+	return ctrl.Result{}, nil
+}
+
+// fail pv重建前迁移失败，删除目标卷，源卷继续使用
+func (r *VolumeMigrationReconciler) fail(ctx context.Context, vm *carinav1.VolumeMigration, message string) error {
+	log.Warnf("volume migration %s failed %s", vm.Name, message)
+	if err := r.rollback(ctx, vm); err != nil {
+		return err
+	}
+	now := metav1.Now()
+	vm2 := vm.DeepCopy()
+	vm2.Status.Phase = carinav1.MigrationFailed
+	vm2.Status.Message = message
+	vm2.Status.CompletionTime = &now
+	if err := r.Status().Patch(ctx, vm2, client.MergeFrom(vm)); err != nil {
+		return err
+	}
+	r.recorder.Event(vm, corev1.EventTypeWarning, "Failed", message)
+	return nil
+}
+
+// rollback 删除目标卷以及token，移除源卷的迁移标记
+func (r *VolumeMigrationReconciler) rollback(ctx context.Context, vm *carinav1.VolumeMigration) error {
+	if vm.Status.TargetVolume != "" {
+		target := new(carinav1.LogicVolume)
+		err := r.Get(ctx, client.ObjectKey{Name: vm.Status.TargetVolume}, target)
+		if err != nil && !apierrs.IsNotFound(err) {
+			return err
+		}
+		if err == nil && target.DeletionTimestamp == nil {
+			log.Infof("delete target logic volume %s on node %s", target.Name, target.Spec.NodeName)
+			if err := r.Delete(ctx, target); err != nil && !apierrs.IsNotFound(err) {
+				return err
+			}
+		}
+	}
+
+	if vm.Status.SourceVolume != "" {
+		source := new(carinav1.LogicVolume)
+		err := r.Get(ctx, client.ObjectKey{Name: vm.Status.SourceVolume}, source)
+		if err != nil && !apierrs.IsNotFound(err) {
+			return err
+		}
+		if err == nil && source.Annotations[carina.VolumeMigrationAnnotation] == vm.Name {
+			source2 := source.DeepCopy()
+			delete(source2.Annotations, carina.VolumeMigrationAnnotation)
+			if err := r.Patch(ctx, source2, client.MergeFrom(source)); err != nil {
+				return err
+			}
+		}
+	}
+	return r.deleteToken(ctx, vm)
+}
+
+// finalize pv重建后迁移无法回退，删除VolumeMigration时仍需完成剩余阶段
+func (r *VolumeMigrationReconciler) finalize(ctx context.Context, vm *carinav1.VolumeMigration) (ctrl.Result, error) {
+	switch vm.Status.Phase {
+	case carinav1.MigrationRebinding:
+		return r.rebind(ctx, vm)
+	case carinav1.MigrationRetiring:
+		return r.retire(ctx, vm)
+	case carinav1.MigrationCompleted:
+	default:
+		if err := r.rollback(ctx, vm); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	vm2 := vm.DeepCopy()
+	vm2.Finalizers = utils.SliceRemoveString(vm2.Finalizers, carina.VolumeMigrationFinalizer)
+	if err := r.Patch(ctx, vm2, client.MergeFrom(vm)); err != nil {
+		log.Error(err, " failed to remove finalizer name ", vm.Name)
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{}, nil
+}
+
+func (r *VolumeMigrationReconciler) setPhase(ctx context.Context, vm *carinav1.VolumeMigration, phase carinav1.MigrationPhase, message string) error {
+	log.Infof("volume migration %s phase %s -> %s", vm.Name, vm.Status.Phase, phase)
+	vm2 := vm.DeepCopy()
+	vm2.Status.Phase = phase
+	vm2.Status.Message = message
+	return r.Status().Patch(ctx, vm2, client.MergeFrom(vm))
+}
+
+func logicVolumeByVolumeID(ctx context.Context, reader client.Reader, volumeID string) (*carinav1.LogicVolume, error) {
+	lvList := new(carinav1.LogicVolumeList)
+	if err := reader.List(ctx, lvList); err != nil {
+		return nil, err
+	}
+	for i, lv := range lvList.Items {
+		if lv.Status.VolumeID == volumeID {
+			return &lvList.Items[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// podsUsingClaim 使用pvc且尚未结束的pod
+func (r *VolumeMigrationReconciler) podsUsingClaim(ctx context.Context, namespace, claim string) ([]string, error) {
+	podList := new(corev1.PodList)
+	if err := r.List(ctx, podList, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+	var pods []string
+	for _, p := range podList.Items {
+		if p.Status.Phase == corev1.PodSucceeded || p.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		for _, vol := range p.Spec.Volumes {
+			if vol.PersistentVolumeClaim != nil && vol.PersistentVolumeClaim.ClaimName == claim {
+				pods = append(pods, p.Name)
+				break
+			}
+		}
+	}
+	return pods, nil
+}
+
+// createToken 生成源节点与目标节点之间传输数据使用的token
+func (r *VolumeMigrationReconciler) createToken(ctx context.Context, vm *carinav1.VolumeMigration) error {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return err
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      migrationSecretName(vm),
+			Namespace: configuration.RuntimeNamespace(),
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: carinav1.GroupVersion.String(),
+				Kind:       "VolumeMigration",
+				Name:       vm.Name,
+				UID:        vm.UID,
+			}},
+		},
+		Data: map[string][]byte{carina.MigrationTokenKey: []byte(hex.EncodeToString(buf))},
+	}
+	if err := r.Create(ctx, secret); err != nil && !apierrs.IsAlreadyExists(err) {
+		return err
+	}
+	return nil
+}
+
+func (r *VolumeMigrationReconciler) deleteToken(ctx context.Context, vm *carinav1.VolumeMigration) error {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      migrationSecretName(vm),
+			Namespace: configuration.RuntimeNamespace(),
+		},
+	}
+	if err := r.Delete(ctx, secret); err != nil && !apierrs.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+func migrationSecretName(vm *carinav1.VolumeMigration) string {
+	return fmt.Sprintf("carina-migration-%s", vm.UID)
+}
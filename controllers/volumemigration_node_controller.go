@@ -0,0 +1,397 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+
+	"github.com/carina-io/carina"
+	carinav1 "github.com/carina-io/carina/api/v1"
+	"github.com/carina-io/carina/pkg/configuration"
+	deviceManager "github.com/carina-io/carina/pkg/devicemanager"
+	"github.com/carina-io/carina/pkg/devicemanager/types"
+	"github.com/carina-io/carina/pkg/migration"
+	"github.com/carina-io/carina/utils/log"
+)
+
+const (
+	// migrationRetryInterval 数据传输失败后重试的间隔
+	migrationRetryInterval = 30 * time.Second
+	// migrationProgressInterval 更新传输进度的间隔
+	migrationProgressInterval = 10 * time.Second
+)
+
+// VolumeMigrationNodeReconciler 负责卷迁移的数据传输，目标节点发布接收地址并将数据写入目标卷，
+// 源节点在全量同步以及增量同步阶段读取源卷发送至目标节点
+type VolumeMigrationNodeReconciler struct {
+	client.Client
+	apiReader client.Reader
+	recorder  record.EventRecorder
+	dm        *deviceManager.DeviceManager
+	server    *migration.Server
+
+	mu        sync.Mutex
+	transfers map[string]*migrationTransfer
+	// digests 已发送数据块的摘要，仅保存在内存中，carina-node重启后增量同步退化为全量同步
+	digests map[string]*migration.Digests
+}
+
+type migrationTransfer struct {
+	cancel   context.CancelFunc
+	running  bool
+	failedAt time.Time
+}
+
+// +kubebuilder:rbac:groups=carina.storage.io,resources=volumemigrations,verbs=get;list;watch
+// +kubebuilder:rbac:groups=carina.storage.io,resources=volumemigrations/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get
+
+func NewVolumeMigrationNodeReconciler(client client.Client, apiReader client.Reader, recorder record.EventRecorder, dm *deviceManager.DeviceManager, listenAddr, advertiseHost string) (*VolumeMigrationNodeReconciler, error) {
+	r := &VolumeMigrationNodeReconciler{
+		Client:    client,
+		apiReader: apiReader,
+		recorder:  recorder,
+		dm:        dm,
+		transfers: map[string]*migrationTransfer{},
+		digests:   map[string]*migration.Digests{},
+	}
+	server, err := migration.NewServer(listenAddr, advertiseHost, r.authorize)
+	if err != nil {
+		return nil, err
+	}
+	r.server = server
+	return r, nil
+}
+
+func (r *VolumeMigrationNodeReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	vm := new(carinav1.VolumeMigration)
+	if err := r.Get(ctx, req.NamespacedName, vm); err != nil {
+		if !apierrs.IsNotFound(err) {
+			log.Error(err, " unable to fetch VolumeMigration")
+			return ctrl.Result{}, err
+		}
+		r.forget(req.Name)
+		return ctrl.Result{}, nil
+	}
+
+	if vm.DeletionTimestamp != nil || vm.IsFinished() {
+		r.forget(vm.Name)
+		return ctrl.Result{}, nil
+	}
+
+	if vm.Spec.TargetNode == r.dm.NodeName {
+		if err := r.publishEndpoint(ctx, vm); err != nil {
+			log.Error(err, " failed to publish migration endpoint name ", vm.Name)
+			return ctrl.Result{}, err
+		}
+	}
+
+	if vm.Status.SourceNode == r.dm.NodeName {
+		switch {
+		case vm.Status.Phase == carinav1.MigrationSyncing && !vm.Status.InitialSynced:
+			r.startTransfer(vm, false)
+		case vm.Status.Phase == carinav1.MigrationFinalSync && !vm.Status.FinalSynced:
+			r.startTransfer(vm, true)
+		default:
+			return ctrl.Result{}, nil
+		}
+		// 传输失败后等待重试
+		return ctrl.Result{RequeueAfter: migrationRetryInterval}, nil
+	}
+	return ctrl.Result{}, nil
+}
+
+func (r *VolumeMigrationNodeReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	if err := mgr.Add(r.server); err != nil {
+		return err
+	}
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&carinav1.VolumeMigration{}).
+		WithEventFilter(&volumeMigrationFilter{r.dm.NodeName}).
+		WithOptions(controller.Options{
+			MaxConcurrentReconciles: 2,
+		}).
+		Complete(r)
+}
+
+// publishEndpoint 目标节点发布接收地址以及证书指纹，carina-node重启后证书变化需要重新发布
+func (r *VolumeMigrationNodeReconciler) publishEndpoint(ctx context.Context, vm *carinav1.VolumeMigration) error {
+	if vm.Status.TargetEndpoint == r.server.Endpoint() && vm.Status.TargetFingerprint == r.server.Fingerprint() {
+		return nil
+	}
+	vm2 := vm.DeepCopy()
+	vm2.Status.TargetEndpoint = r.server.Endpoint()
+	vm2.Status.TargetFingerprint = r.server.Fingerprint()
+	log.Infof("publish migration %s endpoint %s", vm.Name, vm2.Status.TargetEndpoint)
+	return r.Status().Patch(ctx, vm2, client.MergeFrom(vm))
+}
+
+func (r *VolumeMigrationNodeReconciler) startTransfer(vm *carinav1.VolumeMigration, final bool) {
+	if vm.Status.TargetEndpoint == "" || vm.Status.TargetFingerprint == "" {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t, ok := r.transfers[vm.Name]
+	if ok && (t.running || time.Since(t.failedAt) < migrationRetryInterval) {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	r.transfers[vm.Name] = &migrationTransfer{cancel: cancel, running: true}
+	digests, ok := r.digests[vm.Name]
+	if !ok {
+		digests = &migration.Digests{}
+		r.digests[vm.Name] = digests
+	}
+
+	go func() {
+		defer cancel()
+		sent, err := r.transfer(ctx, vm, final, digests)
+
+		r.mu.Lock()
+		if t, ok := r.transfers[vm.Name]; ok {
+			t.running = false
+			if err != nil {
+				t.failedAt = time.Now()
+			}
+		}
+		r.mu.Unlock()
+
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Errorf("migrate volume %s to node %s failed %s", vm.Status.SourceVolume, vm.Spec.TargetNode, err.Error())
+			r.recorder.Event(vm, corev1.EventTypeWarning, "SyncFailed", fmt.Sprintf("sync volume to node %s failed: %s", vm.Spec.TargetNode, err.Error()))
+		} else {
+			log.Infof("migrate volume %s to node %s synced %d bytes, final %t", vm.Status.SourceVolume, vm.Spec.TargetNode, sent, final)
+			r.recorder.Event(vm, corev1.EventTypeNormal, "Synced", fmt.Sprintf("synced %d bytes to node %s", sent, vm.Spec.TargetNode))
+		}
+		if perr := r.patchStatus(vm.Name, func(status *carinav1.VolumeMigrationStatus) {
+			status.SyncedBytes = sent
+			if err != nil {
+				status.Message = err.Error()
+				return
+			}
+			status.Message = ""
+			if final {
+				status.FinalSynced = true
+			} else {
+				status.InitialSynced = true
+			}
+		}); perr != nil {
+			log.Errorf("update migration %s status failed %s", vm.Name, perr.Error())
+		}
+	}()
+}
+
+// transfer 读取源卷发送至目标节点，返回发送的字节数
+func (r *VolumeMigrationNodeReconciler) transfer(ctx context.Context, vm *carinav1.VolumeMigration, final bool, digests *migration.Digests) (int64, error) {
+	source, target := new(carinav1.LogicVolume), new(carinav1.LogicVolume)
+	if err := r.Get(ctx, client.ObjectKey{Name: vm.Status.SourceVolume}, source); err != nil {
+		return 0, err
+	}
+	if err := r.Get(ctx, client.ObjectKey{Name: vm.Status.TargetVolume}, target); err != nil {
+		return 0, err
+	}
+	lvInfo, err := r.dm.VolumeManager.WithContext(ctx).VolumeInfo(source.Status.VolumeID, source.Spec.DeviceGroup)
+	if err != nil {
+		return 0, fmt.Errorf("get volume %s: %w", source.Status.VolumeID, err)
+	}
+	// 增量同步前卷必须已经卸载，否则仍有数据写入
+	if final && lvOpen(lvInfo) {
+		return 0, fmt.Errorf("volume %s is still open, waiting for the application to stop", source.Status.VolumeID)
+	}
+	if final && len(*digests) == 0 {
+		log.Warnf("no digests of migration %s, final sync copies the whole volume", vm.Name)
+	}
+
+	token, err := r.token(ctx, vm)
+	if err != nil {
+		return 0, err
+	}
+	conn, err := migration.Dial(ctx, vm.Status.TargetEndpoint, vm.Status.TargetFingerprint)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	// 取消时关闭连接，中断阻塞的读写
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	lastUpdate := time.Now()
+	progress := func(sent int64) {
+		if time.Since(lastUpdate) < migrationProgressInterval {
+			return
+		}
+		lastUpdate = time.Now()
+		_ = r.patchStatus(vm.Name, func(status *carinav1.VolumeMigrationStatus) {
+			status.SyncedBytes = sent
+		})
+	}
+	hdr := migration.Header{
+		Migration: vm.Name,
+		Token:     token,
+		Volume:    target.Status.VolumeID,
+		Size:      int64(lvInfo.LVSize),
+		Final:     final,
+	}
+	return migration.Send(ctx, conn, lvPath(lvInfo), hdr, digests, progress)
+}
+
+// authorize 目标节点校验源节点的请求，返回目标卷的设备路径
+func (r *VolumeMigrationNodeReconciler) authorize(hdr *migration.Header) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	vm := new(carinav1.VolumeMigration)
+	if err := r.apiReader.Get(ctx, client.ObjectKey{Name: hdr.Migration}, vm); err != nil {
+		return "", fmt.Errorf("get migration %s: %w", hdr.Migration, err)
+	}
+	token, err := r.token(ctx, vm)
+	if err != nil {
+		return "", err
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(hdr.Token)) != 1 {
+		return "", errors.New("invalid migration token")
+	}
+	if vm.Spec.TargetNode != r.dm.NodeName {
+		return "", fmt.Errorf("migration %s does not target node %s", vm.Name, r.dm.NodeName)
+	}
+	if vm.Status.Phase != carinav1.MigrationSyncing && vm.Status.Phase != carinav1.MigrationFinalSync {
+		return "", fmt.Errorf("migration %s is in phase %s", vm.Name, vm.Status.Phase)
+	}
+
+	target := new(carinav1.LogicVolume)
+	if err := r.apiReader.Get(ctx, client.ObjectKey{Name: vm.Status.TargetVolume}, target); err != nil {
+		return "", err
+	}
+	if target.Status.VolumeID == "" || target.Status.VolumeID != hdr.Volume {
+		return "", fmt.Errorf("volume %s is not the target of migration %s", hdr.Volume, vm.Name)
+	}
+	lvInfo, err := r.dm.VolumeManager.WithContext(ctx).VolumeInfo(target.Status.VolumeID, target.Spec.DeviceGroup)
+	if err != nil {
+		return "", fmt.Errorf("get volume %s: %w", target.Status.VolumeID, err)
+	}
+	if hdr.Size > int64(lvInfo.LVSize) {
+		return "", fmt.Errorf("volume %s size %d is smaller than source size %d", target.Status.VolumeID, lvInfo.LVSize, hdr.Size)
+	}
+	return lvPath(lvInfo), nil
+}
+
+func (r *VolumeMigrationNodeReconciler) token(ctx context.Context, vm *carinav1.VolumeMigration) (string, error) {
+	secret := new(corev1.Secret)
+	key := client.ObjectKey{Namespace: configuration.RuntimeNamespace(), Name: migrationSecretName(vm)}
+	if err := r.apiReader.Get(ctx, key, secret); err != nil {
+		return "", fmt.Errorf("get migration token: %w", err)
+	}
+	token := string(secret.Data[carina.MigrationTokenKey])
+	if token == "" {
+		return "", errors.New("migration token is empty")
+	}
+	return token, nil
+}
+
+func (r *VolumeMigrationNodeReconciler) patchStatus(name string, mutate func(status *carinav1.VolumeMigrationStatus)) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	vm := new(carinav1.VolumeMigration)
+	if err := r.Get(ctx, client.ObjectKey{Name: name}, vm); err != nil {
+		return err
+	}
+	vm2 := vm.DeepCopy()
+	mutate(&vm2.Status)
+	return r.Status().Patch(ctx, vm2, client.MergeFrom(vm))
+}
+
+// forget 迁移结束或删除后停止传输并释放摘要
+func (r *VolumeMigrationNodeReconciler) forget(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if t, ok := r.transfers[name]; ok {
+		t.cancel()
+		delete(r.transfers, name)
+	}
+	delete(r.digests, name)
+}
+
+// lvOpen lv_attr第6位为o时设备处于打开状态
+func lvOpen(lv *types.LvInfo) bool {
+	return len(lv.LVAttr) > 5 && lv.LVAttr[5] == 'o'
+}
+
+func lvPath(lv *types.LvInfo) string {
+	if lv.LVPath != "" {
+		return lv.LVPath
+	}
+	return "/dev/" + lv.VGName + "/" + lv.LVName
+}
+
+// filter VolumeMigration
+type volumeMigrationFilter struct {
+	nodeName string
+}
+
+func (f volumeMigrationFilter) filter(vm *carinav1.VolumeMigration) bool {
+	if vm == nil {
+		return false
+	}
+	return vm.Spec.TargetNode == f.nodeName || vm.Status.SourceNode == f.nodeName
+}
+
+func (f volumeMigrationFilter) Create(e event.CreateEvent) bool {
+	return f.filter(e.Object.(*carinav1.VolumeMigration))
+}
+
+func (f volumeMigrationFilter) Delete(e event.DeleteEvent) bool {
+	return f.filter(e.Object.(*carinav1.VolumeMigration))
+}
+
+func (f volumeMigrationFilter) Update(e event.UpdateEvent) bool {
+	newVolumeMigration := e.ObjectNew.(*carinav1.VolumeMigration)
+	oldVolumeMigration := e.ObjectOld.(*carinav1.VolumeMigration)
+	if newVolumeMigration.ResourceVersion == oldVolumeMigration.ResourceVersion {
+		return false
+	}
+	return f.filter(newVolumeMigration) || f.filter(oldVolumeMigration)
+}
+
+func (f volumeMigrationFilter) Generic(e event.GenericEvent) bool {
+	return f.filter(e.Object.(*carinav1.VolumeMigration))
+}
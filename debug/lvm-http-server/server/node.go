@@ -48,7 +48,7 @@ func CreateVolume(c echo.Context) error {
 	vgName := c.FormValue("vg_name")
 	size := c.FormValue("size")
 	req, _ := strconv.ParseUint(size, 10, 64)
-	err := dm.VolumeManager.CreateVolume(lvName, vgName, req, 1)
+	err := dm.VolumeManager.CreateVolume(lvName, vgName, req, 1, nil, nil)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, err.Error())
 	}
@@ -60,7 +60,7 @@ func ResizeVolume(c echo.Context) error {
 	vgName := c.FormValue("vg_name")
 	size := c.FormValue("size")
 	req, _ := strconv.ParseUint(size, 10, 64)
-	err := dm.VolumeManager.ResizeVolume(lvName, vgName, req, 1)
+	err := dm.VolumeManager.ResizeVolume(lvName, vgName, req, 1, nil)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, err.Error())
 	}
@@ -9,6 +9,11 @@ function install() {
   fi
 
   kubectl apply -f crd-logicvolume.yaml
+  kubectl apply -f crd-logicsnapshot.yaml
+  kubectl apply -f crd-volumemigration.yaml
+  kubectl apply -f crd-backup.yaml
+  kubectl apply -f crd-restore.yaml
+  kubectl apply -f crd-carinastoragequota.yaml
   kubectl apply -f crd-nodestoreresource.yaml
 
   kubectl apply -f csi-controller-rbac.yaml
@@ -47,6 +52,21 @@ function uninstall() {
   if [ `kubectl get lv | wc -l` == 0 ]; then
     kubectl delete -f crd-logicvolume.yaml
   fi
+  if [ `kubectl get lsnap | wc -l` == 0 ]; then
+    kubectl delete -f crd-logicsnapshot.yaml
+  fi
+  if [ `kubectl get vmig | wc -l` == 0 ]; then
+    kubectl delete -f crd-volumemigration.yaml
+  fi
+  if [ `kubectl get cbackup -A | wc -l` == 0 ]; then
+    kubectl delete -f crd-backup.yaml
+  fi
+  if [ `kubectl get crestore -A | wc -l` == 0 ]; then
+    kubectl delete -f crd-restore.yaml
+  fi
+  if [ `kubectl get csq -A | wc -l` == 0 ]; then
+    kubectl delete -f crd-carinastoragequota.yaml
+  fi
   kubectl delete -f crd-nodestoreresource.yaml
   kubectl delete -f storageclass-lvm.yaml
   kubectl delete -f storageclass-raw.yaml
@@ -220,7 +220,7 @@ func testCreatePvc() {
 
 			capacity := node.Status.Capacity.Name(corev1.ResourceName(fmt.Sprintf("carina.storage.io/%s", diskGroup)), resource.BinarySI).Value()
 			allocatable := node.Status.Allocatable.Name(corev1.ResourceName(fmt.Sprintf("carina.storage.io/%s", diskGroup)), resource.BinarySI).Value()
-			if capacity != allocatable+10+7 {
+			if capacity != allocatable+(10+7)<<30 {
 				log.Infof("failed to allocatable node. capacity: %d, allocatable: %d", capacity, allocatable)
 				return fmt.Errorf("failed to allocatable node. capacity: %d, allocatable: %d", capacity, allocatable)
 			}
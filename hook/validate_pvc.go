@@ -0,0 +1,226 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package hook
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	"github.com/carina-io/carina"
+	carinav1 "github.com/carina-io/carina/api/v1"
+	"github.com/carina-io/carina/getter"
+	"github.com/carina-io/carina/pkg/configuration"
+	lvtypes "github.com/carina-io/carina/pkg/devicemanager/types"
+	"github.com/carina-io/carina/pkg/quota"
+	"github.com/carina-io/carina/utils/log"
+)
+
+// +kubebuilder:webhook:webhookVersions=v1,path=/pvc/validate,mutating=false,failurePolicy=ignore,matchPolicy=equivalent,groups="",resources=persistentvolumeclaims,verbs=create;update,versions=v1,sideEffects=none,name=pvc-hook.carina.storage.io
+// +kubebuilder:rbac:groups=storage.k8s.io,resources=storageclasses,verbs=get;list;watch
+// +kubebuilder:rbac:groups=carina.storage.io,resources=carinastoragequotas,verbs=get;list;watch
+
+// 本地卷只能被单个节点读写
+var supportedAccessModes = []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce, corev1.ReadWriteOncePod}
+
+// pvcValidator validates PVCs using carina StorageClasses.
+type pvcValidator struct {
+	client  client.Client
+	getter  *getter.RetryGetter
+	decoder *admission.Decoder
+}
+
+// PVCValidator creates a validating webhook for PVCs.
+func PVCValidator(mgr manager.Manager, dec *admission.Decoder) http.Handler {
+	return &webhook.Admission{Handler: pvcValidator{mgr.GetClient(), getter.NewRetryGetter(mgr), dec}}
+}
+
+// Handle implements admission.Handler interface.
+func (v pvcValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	pvc := &corev1.PersistentVolumeClaim{}
+	if err := v.decoder.Decode(req, pvc); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	if pvc.Spec.StorageClassName == nil || *pvc.Spec.StorageClassName == "" {
+		return admission.Allowed("no storage class")
+	}
+
+	var sc storagev1.StorageClass
+	if err := v.getter.Get(ctx, types.NamespacedName{Name: *pvc.Spec.StorageClassName}, &sc); err != nil {
+		if !apierrs.IsNotFound(err) {
+			return admission.Errored(http.StatusInternalServerError, err)
+		}
+		// storageclass可以在pvc之后创建
+		return admission.Allowed("storage class not found")
+	}
+	if sc.Provisioner != carina.CSIPluginName {
+		return admission.Allowed("not a carina storage class")
+	}
+
+	var charge quota.GroupUsage
+	switch req.Operation {
+	case admissionv1.Create:
+		if errs := validatePVC(pvc, &sc); len(errs) > 0 {
+			log.Warnf("reject persistent volume claim %s/%s: %s", req.Namespace, pvc.Name, strings.Join(errs, "; "))
+			return admission.Denied(strings.Join(errs, "; "))
+		}
+		charge = claimCharge(pvc, &sc, configuration.DiskSelector())
+	case admissionv1.Update:
+		oldPvc := &corev1.PersistentVolumeClaim{}
+		if err := v.decoder.DecodeRaw(req.OldObject, oldPvc); err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+		// 只有已绑定的pvc扩容时校验配额
+		size := pvc.Spec.Resources.Requests.Storage().Value()
+		if pvc.Spec.VolumeName == "" || size <= oldPvc.Spec.Resources.Requests.Storage().Value() {
+			return admission.Allowed("")
+		}
+		var err error
+		charge, err = v.expansionCharge(ctx, pvc.Spec.VolumeName, size)
+		if err != nil {
+			return admission.Errored(http.StatusInternalServerError, err)
+		}
+	}
+	if len(charge) == 0 {
+		return admission.Allowed("")
+	}
+
+	if err := v.checkQuota(ctx, pvc, charge); err != nil {
+		log.Warnf("reject persistent volume claim %s/%s: %s", req.Namespace, pvc.Name, err.Error())
+		return admission.Denied(err.Error())
+	}
+	return admission.Allowed("")
+}
+
+// expansionCharge 已绑定的卷扩容至size后的用量，卷不存在时不校验
+func (v pvcValidator) expansionCharge(ctx context.Context, volumeName string, size int64) (quota.GroupUsage, error) {
+	lv := new(carinav1.LogicVolume)
+	if err := v.client.Get(ctx, types.NamespacedName{Name: volumeName}, lv); err != nil {
+		return nil, client.IgnoreNotFound(err)
+	}
+	lvList := new(carinav1.LogicVolumeList)
+	if err := v.client.List(ctx, lvList); err != nil {
+		return nil, err
+	}
+	var owned []carinav1.LogicVolume
+	for _, candidate := range lvList.Items {
+		if metav1.IsControlledBy(&candidate, lv) {
+			owned = append(owned, candidate)
+		}
+	}
+	return quota.ExpansionCharge(lv, owned, alignRequestBytes(size)), nil
+}
+
+// checkQuota 校验pvc所在命名空间的配额，pvc已有的卷不计入已用量；
+// 并发创建的pvc在CreateVolume中再次校验
+func (v pvcValidator) checkQuota(ctx context.Context, pvc *corev1.PersistentVolumeClaim, charge quota.GroupUsage) error {
+	quotaList := new(carinav1.CarinaStorageQuotaList)
+	if err := v.client.List(ctx, quotaList, client.InNamespace(pvc.Namespace)); err != nil || len(quotaList.Items) == 0 {
+		return nil
+	}
+	lvList := new(carinav1.LogicVolumeList)
+	if err := v.client.List(ctx, lvList); err != nil {
+		return nil
+	}
+	used := quota.NamespaceUsage(pvc.Namespace, lvList.Items, func(lv *carinav1.LogicVolume) bool {
+		return lv.Spec.Pvc == pvc.Name
+	})
+	return quota.Check(quotaList.Items, used, charge)
+}
+
+// validatePVC 校验pvc访问模式以及数据源是否被storageclass对应的卷类型支持
+func validatePVC(pvc *corev1.PersistentVolumeClaim, sc *storagev1.StorageClass) []string {
+	var errs []string
+	for _, mode := range pvc.Spec.AccessModes {
+		if !containsAccessMode(supportedAccessModes, mode) {
+			errs = append(errs, fmt.Sprintf("access mode %s is not supported by storage class %s, carina volumes only support %s and %s", mode, sc.Name, corev1.ReadWriteOnce, corev1.ReadWriteOncePod))
+		}
+	}
+
+	if pvc.Spec.DataSource != nil || pvc.Spec.DataSourceRef != nil {
+		cacheDiskRatio := sc.Parameters[carina.VolumeCacheDiskRatio]
+		if cacheDiskRatio != "" && cacheDiskRatio != "0" {
+			errs = append(errs, fmt.Sprintf("data source is not supported for cache volumes of storage class %s", sc.Name))
+		}
+		if layout, err := lvtypes.NewLvLayout(sc.Parameters); err == nil && layout != nil {
+			errs = append(errs, fmt.Sprintf("data source is not supported for striped or raid volumes of storage class %s", sc.Name))
+		}
+	}
+	return errs
+}
+
+func containsAccessMode(modes []corev1.PersistentVolumeAccessMode, mode corev1.PersistentVolumeAccessMode) bool {
+	for _, m := range modes {
+		if m == mode {
+			return true
+		}
+	}
+	return false
+}
+
+// claimCharge 按storageclass参数估算pvc创建的卷占用的磁盘组用量，与CreateVolume的计算方式一致；
+// 磁盘组由调度决定时无法预先确定，不校验
+func claimCharge(pvc *corev1.PersistentVolumeClaim, sc *storagev1.StorageClass, selectors []configuration.DiskSelectorItem) quota.GroupUsage {
+	params := sc.Parameters
+	size := int64(carina.DefaultRequestSize)
+	if request, ok := pvc.Spec.Resources.Requests[corev1.ResourceStorage]; ok && request.Value() > 0 {
+		size = request.Value()
+	}
+	size = alignRequestBytes(size)
+
+	charge := quota.GroupUsage{}
+	if cacheDiskRatio := params[carina.VolumeCacheDiskRatio]; cacheDiskRatio != "" && cacheDiskRatio != "0" {
+		backend, cache := params[carina.VolumeBackendDiskType], params[carina.VolumeCacheDiskType]
+		if backend == "" || cache == "" {
+			return nil
+		}
+		charge.Add(backend, quota.Usage{Storage: size, Volumes: 1})
+		charge.Add(cache, quota.Usage{Storage: quota.CacheSize(size, cacheDiskRatio)})
+		return charge
+	}
+
+	group, found := lookupDiskGroup(selectors, params[carina.DeviceDiskKey])
+	if !found {
+		return nil
+	}
+	u := quota.Usage{Storage: size, Volumes: 1}
+	if layout, err := lvtypes.NewLvLayout(params); err == nil {
+		u.Storage = int64(layout.AllocSize(uint64(size)))
+	}
+	if strings.ToLower(group.Policy) == "raw" && params[carina.ExclusivityDisk] == "true" {
+		u.ExclusiveDisks = 1
+	}
+	charge.Add(group.Name, u)
+	return charge
+}
+
+// alignRequestBytes 与CreateVolume相同，按lvm默认PE大小向上对齐
+func alignRequestBytes(size int64) int64 {
+	return (size + carina.VolumeAlignment - 1) / carina.VolumeAlignment * carina.VolumeAlignment
+}
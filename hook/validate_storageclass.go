@@ -0,0 +1,204 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package hook
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	storagev1 "k8s.io/api/storage/v1"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	"github.com/carina-io/carina"
+	"github.com/carina-io/carina/pkg/configuration"
+	"github.com/carina-io/carina/pkg/devicemanager/types"
+	"github.com/carina-io/carina/utils"
+	"github.com/carina-io/carina/utils/log"
+)
+
+// +kubebuilder:webhook:webhookVersions=v1,path=/storageclass/validate,mutating=false,failurePolicy=ignore,matchPolicy=equivalent,groups=storage.k8s.io,resources=storageclasses,verbs=create,versions=v1,sideEffects=none,name=storageclass-hook.carina.storage.io
+
+// fsTypeKey storageclass中指定文件系统的参数
+const fsTypeKey = "csi.storage.k8s.io/fstype"
+
+// 节点支持格式化以及扩容的文件系统
+var supportedFsTypes = []string{"ext3", "ext4", "xfs"}
+
+// bcache以及lvmcache支持的缓存策略
+var (
+	bcachePolicies   = []string{"writethrough", "writeback", "writearound"}
+	lvmcachePolicies = []string{"writethrough", "writeback", "writecache"}
+)
+
+// storageClassValidator validates carina StorageClasses.
+type storageClassValidator struct {
+	decoder *admission.Decoder
+}
+
+// StorageClassValidator creates a validating webhook for StorageClasses.
+func StorageClassValidator(mgr manager.Manager, dec *admission.Decoder) http.Handler {
+	return &webhook.Admission{Handler: storageClassValidator{dec}}
+}
+
+// Handle implements admission.Handler interface.
+func (v storageClassValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	sc := &storagev1.StorageClass{}
+	if err := v.decoder.Decode(req, sc); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	if sc.Provisioner != carina.CSIPluginName {
+		return admission.Allowed("not a carina storage class")
+	}
+
+	if errs := validateStorageClassParameters(sc.Parameters, configuration.DiskSelector()); len(errs) > 0 {
+		log.Warnf("reject storage class %s: %s", sc.Name, strings.Join(errs, "; "))
+		return admission.Denied(strings.Join(errs, "; "))
+	}
+	return admission.Allowed("")
+}
+
+// validateStorageClassParameters 按照当前磁盘组配置以及驱动支持的能力校验storageclass参数，与CreateVolume的校验保持一致
+func validateStorageClassParameters(params map[string]string, selectors []configuration.DiskSelectorItem) []string {
+	var errs []string
+	groups := diskGroupNames(selectors)
+
+	for _, key := range []string{carina.ExclusivityDisk, carina.ThinProvisioning, carina.VolumeEncryption} {
+		if value, ok := params[key]; ok && value != "true" && value != "false" {
+			errs = append(errs, fmt.Sprintf("%s %s, should be true or false", key, value))
+		}
+	}
+	if fsType, ok := params[fsTypeKey]; ok && !utils.ContainsString(supportedFsTypes, strings.ToLower(fsType)) {
+		errs = append(errs, fmt.Sprintf("%s %s is not supported, should be one of %s", fsTypeKey, fsType, strings.Join(supportedFsTypes, ", ")))
+	}
+
+	var raw bool
+	if name, ok := params[carina.DeviceDiskKey]; ok {
+		group, found := lookupDiskGroup(selectors, name)
+		if !found {
+			errs = append(errs, fmt.Sprintf("%s %s is not a configured disk group, available disk groups: %s", carina.DeviceDiskKey, name, strings.Join(groups, ", ")))
+		}
+		raw = found && strings.ToLower(group.Policy) == "raw"
+	}
+	if params[carina.ExclusivityDisk] == "true" && !raw {
+		errs = append(errs, fmt.Sprintf("%s only applies to disk groups with RAW policy, set %s to a RAW disk group", carina.ExclusivityDisk, carina.DeviceDiskKey))
+	}
+	if params[carina.ThinProvisioning] == "true" && raw {
+		errs = append(errs, fmt.Sprintf("%s doesn't support disk groups with RAW policy", carina.ThinProvisioning))
+	}
+
+	cacheDiskRatio := params[carina.VolumeCacheDiskRatio]
+	cache := cacheDiskRatio != "" && cacheDiskRatio != "0"
+	if cache {
+		errs = append(errs, validateCacheParameters(params, selectors, groups, raw)...)
+	} else if params[carina.VolumeBackendDiskType] != "" || params[carina.VolumeCacheDiskType] != "" {
+		errs = append(errs, fmt.Sprintf("%s and %s require %s", carina.VolumeBackendDiskType, carina.VolumeCacheDiskType, carina.VolumeCacheDiskRatio))
+	}
+
+	layout, err := types.NewLvLayout(params)
+	if err != nil {
+		errs = append(errs, err.Error())
+	}
+	if layout != nil && (raw || params[carina.ThinProvisioning] == "true" || cache) {
+		errs = append(errs, fmt.Sprintf("%s, %s and %s only support lvm volume without thin provisioning or cache", carina.VolumeRaidLevel, carina.VolumeStripes, carina.VolumeMirrors))
+	}
+	return errs
+}
+
+// validateCacheParameters 校验bcache以及lvmcache卷的参数
+func validateCacheParameters(params map[string]string, selectors []configuration.DiskSelectorItem, groups []string, raw bool) []string {
+	var errs []string
+	cacheDiskRatio := params[carina.VolumeCacheDiskRatio]
+	if ratio, err := strconv.ParseInt(cacheDiskRatio, 10, 64); err != nil || ratio < 1 || ratio >= 100 {
+		errs = append(errs, fmt.Sprintf("%s %s, should be in 1-99", carina.VolumeCacheDiskRatio, cacheDiskRatio))
+	}
+	if raw {
+		errs = append(errs, fmt.Sprintf("%s doesn't support disk groups with RAW policy", carina.VolumeCacheDiskRatio))
+	}
+
+	backend, msg := resolveCacheGroup(params, selectors, groups, carina.VolumeBackendDiskType)
+	if msg != "" {
+		errs = append(errs, msg)
+	}
+	cache, msg := resolveCacheGroup(params, selectors, groups, carina.VolumeCacheDiskType)
+	if msg != "" {
+		errs = append(errs, msg)
+	}
+
+	cachePolicy := params[carina.VolumeCachePolicy]
+	switch cacheBackend := params[carina.VolumeCacheBackend]; cacheBackend {
+	case "", carina.BcacheBackend:
+		if cachePolicy != "" && !utils.ContainsString(bcachePolicies, cachePolicy) {
+			errs = append(errs, fmt.Sprintf("%s %s, should be one of %s", carina.VolumeCachePolicy, cachePolicy, strings.Join(bcachePolicies, ", ")))
+		}
+	case carina.LvmCacheBackend:
+		if cachePolicy != "" && !utils.ContainsString(lvmcachePolicies, cachePolicy) {
+			errs = append(errs, fmt.Sprintf("%s %s, should be one of %s", carina.VolumeCachePolicy, cachePolicy, strings.Join(lvmcachePolicies, ", ")))
+		}
+		// lvmcache的缓存磁盘加入后端磁盘组的vg，需要在磁盘组配置中指定cacheGroup
+		if backend != nil && cache != nil && !strings.EqualFold(backend.CacheGroup, cache.Name) {
+			errs = append(errs, fmt.Sprintf("disk group %s is not configured with cacheGroup %s, required by %s %s", backend.Name, cache.Name, carina.VolumeCacheBackend, carina.LvmCacheBackend))
+		}
+	default:
+		errs = append(errs, fmt.Sprintf("%s %s, should be %s or %s", carina.VolumeCacheBackend, cacheBackend, carina.BcacheBackend, carina.LvmCacheBackend))
+	}
+	return errs
+}
+
+// resolveCacheGroup 查找缓存卷使用的磁盘组，磁盘组必须存在且不是RAW策略
+func resolveCacheGroup(params map[string]string, selectors []configuration.DiskSelectorItem, groups []string, key string) (*configuration.DiskSelectorItem, string) {
+	name := params[key]
+	if name == "" {
+		return nil, fmt.Sprintf("%s can not be empty when %s is set", key, carina.VolumeCacheDiskRatio)
+	}
+	group, found := lookupDiskGroup(selectors, name)
+	if !found {
+		return nil, fmt.Sprintf("%s %s is not a configured disk group, available disk groups: %s", key, name, strings.Join(groups, ", "))
+	}
+	if strings.ToLower(group.Policy) == "raw" {
+		return nil, fmt.Sprintf("%s %s is a RAW disk group, cache volumes only support lvm disk groups", key, name)
+	}
+	return &group, ""
+}
+
+// lookupDiskGroup 按名称查找磁盘组，兼容旧版本storageclass中的ssd以及hdd
+func lookupDiskGroup(selectors []configuration.DiskSelectorItem, name string) (configuration.DiskSelectorItem, bool) {
+	name = strings.ToLower(name)
+	for _, candidate := range []string{name, "carina-vg-" + name} {
+		for _, ds := range selectors {
+			if strings.ToLower(ds.Name) == candidate {
+				return ds, true
+			}
+		}
+		if !utils.ContainsString([]string{"ssd", "hdd"}, name) {
+			break
+		}
+	}
+	return configuration.DiskSelectorItem{}, false
+}
+
+func diskGroupNames(selectors []configuration.DiskSelectorItem) []string {
+	names := make([]string, 0, len(selectors))
+	for _, ds := range selectors {
+		names = append(names, ds.Name)
+	}
+	return names
+}
@@ -0,0 +1,93 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package hook
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+
+	"github.com/carina-io/carina"
+	"github.com/carina-io/carina/pkg/configuration"
+)
+
+func TestValidateStorageClassParameters(t *testing.T) {
+	selectors := []configuration.DiskSelectorItem{
+		{Name: "carina-vg-ssd", Policy: "LVM", CacheGroup: "carina-vg-nvme"},
+		{Name: "carina-vg-nvme", Policy: "LVM"},
+		{Name: "carina-vg-hdd", Policy: "LVM"},
+		{Name: "carina-raw-hdd", Policy: "RAW"},
+	}
+	table := []struct {
+		params map[string]string
+		errs   int
+	}{
+		{params: map[string]string{}, errs: 0},
+		{params: map[string]string{carina.DeviceDiskKey: "carina-vg-ssd", fsTypeKey: "xfs"}, errs: 0},
+		{params: map[string]string{carina.DeviceDiskKey: "SSD"}, errs: 0},
+		{params: map[string]string{carina.DeviceDiskKey: "carina-vg-unknown"}, errs: 1},
+		{params: map[string]string{carina.DeviceDiskKey: "carina-vg-ssd", fsTypeKey: "btrfs"}, errs: 1},
+		{params: map[string]string{carina.DeviceDiskKey: "carina-raw-hdd", carina.ExclusivityDisk: "true"}, errs: 0},
+		{params: map[string]string{carina.DeviceDiskKey: "carina-vg-hdd", carina.ExclusivityDisk: "true"}, errs: 1},
+		{params: map[string]string{carina.DeviceDiskKey: "carina-raw-hdd", carina.ThinProvisioning: "yes"}, errs: 1},
+		{params: map[string]string{carina.DeviceDiskKey: "carina-raw-hdd", carina.ThinProvisioning: "true"}, errs: 1},
+		{params: map[string]string{carina.VolumeBackendDiskType: "carina-vg-hdd", carina.VolumeCacheDiskType: "carina-vg-ssd", carina.VolumeCacheDiskRatio: "50", carina.VolumeCachePolicy: "writeback"}, errs: 0},
+		{params: map[string]string{carina.VolumeBackendDiskType: "carina-vg-hdd", carina.VolumeCacheDiskType: "carina-vg-ssd", carina.VolumeCacheDiskRatio: "100"}, errs: 1},
+		{params: map[string]string{carina.VolumeBackendDiskType: "carina-vg-hdd", carina.VolumeCacheDiskType: "carina-vg-ssd", carina.VolumeCacheDiskRatio: "50", carina.VolumeCachePolicy: "writecache"}, errs: 1},
+		{params: map[string]string{carina.VolumeBackendDiskType: "carina-raw-hdd", carina.VolumeCacheDiskRatio: "50"}, errs: 2},
+		{params: map[string]string{carina.VolumeBackendDiskType: "carina-vg-hdd", carina.VolumeCacheDiskType: "carina-vg-ssd"}, errs: 1},
+		{params: map[string]string{carina.VolumeBackendDiskType: "carina-vg-ssd", carina.VolumeCacheDiskType: "carina-vg-nvme", carina.VolumeCacheDiskRatio: "20", carina.VolumeCacheBackend: carina.LvmCacheBackend, carina.VolumeCachePolicy: "writecache"}, errs: 0},
+		{params: map[string]string{carina.VolumeBackendDiskType: "carina-vg-hdd", carina.VolumeCacheDiskType: "carina-vg-nvme", carina.VolumeCacheDiskRatio: "20", carina.VolumeCacheBackend: carina.LvmCacheBackend}, errs: 1},
+		{params: map[string]string{carina.VolumeBackendDiskType: "carina-vg-hdd", carina.VolumeCacheDiskType: "carina-vg-nvme", carina.VolumeCacheDiskRatio: "20", carina.VolumeCacheBackend: "dm-cache"}, errs: 1},
+		{params: map[string]string{carina.DeviceDiskKey: "carina-vg-hdd", carina.VolumeRaidLevel: "raid1"}, errs: 0},
+		{params: map[string]string{carina.DeviceDiskKey: "carina-vg-hdd", carina.VolumeRaidLevel: "raid5", carina.VolumeMirrors: "1"}, errs: 1},
+		{params: map[string]string{carina.DeviceDiskKey: "carina-vg-hdd", carina.VolumeStripes: "2", carina.ThinProvisioning: "true"}, errs: 1},
+	}
+
+	for i, e := range table {
+		errs := validateStorageClassParameters(e.params, selectors)
+		if len(errs) != e.errs {
+			t.Errorf("case %d: expected %d errors, got %v", i, e.errs, errs)
+		}
+	}
+}
+
+func TestValidatePVC(t *testing.T) {
+	sc := &storagev1.StorageClass{Parameters: map[string]string{carina.DeviceDiskKey: "carina-vg-hdd"}}
+	cacheSC := &storagev1.StorageClass{Parameters: map[string]string{carina.VolumeCacheDiskRatio: "50"}}
+	dataSource := &corev1.TypedLocalObjectReference{Kind: "PersistentVolumeClaim", Name: "source"}
+
+	table := []struct {
+		pvc  corev1.PersistentVolumeClaimSpec
+		sc   *storagev1.StorageClass
+		errs int
+	}{
+		{pvc: corev1.PersistentVolumeClaimSpec{AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce}}, sc: sc, errs: 0},
+		{pvc: corev1.PersistentVolumeClaimSpec{AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOncePod}}, sc: sc, errs: 0},
+		{pvc: corev1.PersistentVolumeClaimSpec{AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce, corev1.ReadWriteMany}}, sc: sc, errs: 1},
+		{pvc: corev1.PersistentVolumeClaimSpec{AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadOnlyMany}, DataSource: dataSource}, sc: sc, errs: 1},
+		{pvc: corev1.PersistentVolumeClaimSpec{AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce}, DataSource: dataSource}, sc: cacheSC, errs: 1},
+	}
+
+	for i, e := range table {
+		errs := validatePVC(&corev1.PersistentVolumeClaim{Spec: e.pvc}, e.sc)
+		if len(errs) != e.errs {
+			t.Errorf("case %d: expected %d errors, got %v", i, e.errs, errs)
+		}
+	}
+}
@@ -0,0 +1,458 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package backup
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/carina-io/carina/pkg/devicemanager/types"
+)
+
+const (
+	// ChunkSize 备份数据按4MiB分块，以数据块的sha256为对象名去重
+	ChunkSize = 4 << 20
+	// DefaultWorkers 并发上传以及下载的数据块数量
+	DefaultWorkers = 4
+)
+
+var zeroChunk = make([]byte, ChunkSize)
+
+// Manifest 一次备份的数据块列表，是备份完成的标志，所有数据块上传后才写入
+type Manifest struct {
+	// ID Backup的uid
+	ID string `json:"id"`
+	// Backup namespace/name of the Backup
+	Backup string `json:"backup"`
+	// Volume 备份的pv，同一pv的备份共享数据块
+	Volume    string `json:"volume"`
+	Size      int64  `json:"size"`
+	ChunkSize int64  `json:"chunkSize"`
+	// Chunks 各数据块的sha256，全零的数据块为空
+	Chunks []string `json:"chunks"`
+	// Base 增量备份基于的备份
+	Base         string    `json:"base,omitempty"`
+	CreationTime time.Time `json:"creationTime"`
+}
+
+// BackupOptions 备份选项
+type BackupOptions struct {
+	// Base 增量备份基于的备份，为空时读取整个卷
+	Base *Manifest
+	// Changed 相对Base变化的数据范围，只读取这些范围所在的数据块
+	Changed []types.BlockRange
+	// Progress 已读取的字节数以及上传的压缩后字节数
+	Progress func(read, uploaded int64)
+	Workers  int
+}
+
+// RestoreOptions 恢复选项
+type RestoreOptions struct {
+	// SkipZero 新建的thin卷读取为全零，全零的数据块无需写入
+	SkipZero bool
+	// Progress 已写入的字节数
+	Progress func(written int64)
+	Workers  int
+}
+
+// BackupStats 备份读取的字节数以及上传的压缩后字节数
+type BackupStats struct {
+	Read     int64
+	Uploaded int64
+}
+
+// Repository 对象存储中的备份仓库
+//
+//	<prefix>/volumes/<pv>/backups/<backup uid>.json  备份的Manifest
+//	<prefix>/volumes/<pv>/chunks/<sha256[:2]>/<sha256> gzip压缩的数据块
+type Repository struct {
+	store  ObjectStore
+	prefix string
+}
+
+func NewRepository(store ObjectStore, prefix string) *Repository {
+	return &Repository{store: store, prefix: strings.Trim(prefix, "/")}
+}
+
+func (r *Repository) volumePrefix(volume string) string {
+	return path.Join(r.prefix, "volumes", volume) + "/"
+}
+
+func (r *Repository) manifestKey(volume, id string) string {
+	return r.volumePrefix(volume) + "backups/" + id + ".json"
+}
+
+func (r *Repository) chunkKey(volume, digest string) string {
+	return r.volumePrefix(volume) + "chunks/" + digest[:2] + "/" + digest
+}
+
+// GetManifest 读取备份的Manifest
+func (r *Repository) GetManifest(ctx context.Context, volume, id string) (*Manifest, error) {
+	data, err := r.store.GetObject(ctx, r.manifestKey(volume, id))
+	if err != nil {
+		return nil, err
+	}
+	m := &Manifest{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("decode manifest %s failed: %w", id, err)
+	}
+	if m.ChunkSize <= 0 || int64(len(m.Chunks)) != (m.Size+m.ChunkSize-1)/m.ChunkSize {
+		return nil, fmt.Errorf("manifest %s is corrupted", id)
+	}
+	return m, nil
+}
+
+// Backup 读取设备上传数据块，最后写入Manifest。
+// 指定Base时只读取Changed范围所在的数据块，其余数据块沿用Base，与Base相同的数据块不重复上传
+func (r *Repository) Backup(ctx context.Context, device string, m *Manifest, opts BackupOptions) (BackupStats, error) {
+	stats := BackupStats{}
+	f, err := os.Open(device)
+	if err != nil {
+		return stats, err
+	}
+	defer f.Close()
+
+	m.ChunkSize = ChunkSize
+	count := int((m.Size + ChunkSize - 1) / ChunkSize)
+	m.Chunks = make([]string, count)
+	dirty := make([]bool, count)
+	known := map[string]bool{}
+	if base := opts.Base; base != nil && base.ChunkSize == ChunkSize && base.Volume == m.Volume {
+		m.Base = base.ID
+		copy(m.Chunks, base.Chunks)
+		for _, digest := range base.Chunks {
+			known[digest] = true
+		}
+		for _, br := range opts.Changed {
+			if br.Length == 0 {
+				continue
+			}
+			for i := br.Offset / ChunkSize; i <= (br.Offset+br.Length-1)/ChunkSize && i < uint64(count); i++ {
+				dirty[i] = true
+			}
+		}
+		// 卷扩容后新增的数据块
+		for i := len(base.Chunks); i < count; i++ {
+			dirty[i] = true
+		}
+		// 缩小的卷最后一个数据块长度变化
+		if int64(len(base.Chunks)) == int64(count) && base.Size != m.Size && count > 0 {
+			dirty[count-1] = true
+		}
+	} else {
+		m.Base = ""
+		for i := range dirty {
+			dirty[i] = true
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	type job struct {
+		index int
+		data  []byte
+	}
+	jobs := make(chan job)
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+		uploaded int64
+		mu       sync.Mutex
+	)
+	fail := func(err error) {
+		errOnce.Do(func() {
+			firstErr = err
+			cancel()
+		})
+	}
+	for w := 0; w < workers(opts.Workers); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				digest, n, err := r.putChunk(ctx, m.Volume, j.data, known, &mu)
+				if err != nil {
+					fail(err)
+					continue
+				}
+				atomic.AddInt64(&uploaded, n)
+				m.Chunks[j.index] = digest
+			}
+		}()
+	}
+
+	var read int64
+	for i := 0; i < count && ctx.Err() == nil; i++ {
+		if !dirty[i] {
+			continue
+		}
+		offset := int64(i) * ChunkSize
+		length := m.Size - offset
+		if length > ChunkSize {
+			length = ChunkSize
+		}
+		data := make([]byte, length)
+		if _, err := f.ReadAt(data, offset); err != nil {
+			fail(fmt.Errorf("read %s at %d failed: %w", device, offset, err))
+			break
+		}
+		select {
+		case jobs <- job{index: i, data: data}:
+		case <-ctx.Done():
+		}
+		read += length
+		if opts.Progress != nil {
+			opts.Progress(read, atomic.LoadInt64(&uploaded))
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	stats.Read, stats.Uploaded = read, uploaded
+	if firstErr != nil {
+		return stats, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return stats, err
+	}
+
+	m.CreationTime = time.Now().UTC()
+	data, err := json.Marshal(m)
+	if err != nil {
+		return stats, err
+	}
+	return stats, r.store.PutObject(ctx, r.manifestKey(m.Volume, m.ID), data)
+}
+
+// putChunk 上传数据块，返回数据块的sha256以及上传的字节数，全零的数据块不上传
+func (r *Repository) putChunk(ctx context.Context, volume string, data []byte, known map[string]bool, mu *sync.Mutex) (string, int64, error) {
+	if bytes.Equal(data, zeroChunk[:len(data)]) {
+		return "", 0, nil
+	}
+	sum := sha256.Sum256(data)
+	digest := hex.EncodeToString(sum[:])
+
+	mu.Lock()
+	exists := known[digest]
+	mu.Unlock()
+	if exists {
+		return digest, 0, nil
+	}
+	key := r.chunkKey(volume, digest)
+	exists, err := r.store.HeadObject(ctx, key)
+	if err != nil {
+		return "", 0, err
+	}
+	var n int64
+	if !exists {
+		var buf bytes.Buffer
+		w, _ := gzip.NewWriterLevel(&buf, gzip.BestSpeed)
+		if _, err := w.Write(data); err != nil {
+			return "", 0, err
+		}
+		if err := w.Close(); err != nil {
+			return "", 0, err
+		}
+		if err := r.store.PutObject(ctx, key, buf.Bytes()); err != nil {
+			return "", 0, err
+		}
+		n = int64(buf.Len())
+	}
+	mu.Lock()
+	known[digest] = true
+	mu.Unlock()
+	return digest, n, nil
+}
+
+// Restore 下载备份的数据块写入设备，返回写入的字节数
+func (r *Repository) Restore(ctx context.Context, m *Manifest, device string, opts RestoreOptions) (int64, error) {
+	f, err := os.OpenFile(device, os.O_WRONLY, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+	if size < m.Size {
+		return 0, fmt.Errorf("device %s size %d is smaller than backup size %d", device, size, m.Size)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	indexes := make(chan int)
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+		written  int64
+	)
+	fail := func(err error) {
+		errOnce.Do(func() {
+			firstErr = err
+			cancel()
+		})
+	}
+	for w := 0; w < workers(opts.Workers); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				offset := int64(i) * m.ChunkSize
+				length := m.Size - offset
+				if length > m.ChunkSize {
+					length = m.ChunkSize
+				}
+				digest := m.Chunks[i]
+				if digest == "" && opts.SkipZero {
+					continue
+				}
+				data := make([]byte, length)
+				if digest != "" {
+					chunk, err := r.getChunk(ctx, m.Volume, digest)
+					if err != nil {
+						fail(err)
+						continue
+					}
+					if int64(len(chunk)) != length {
+						fail(fmt.Errorf("chunk %s length %d mismatch %d", digest, len(chunk), length))
+						continue
+					}
+					data = chunk
+				}
+				if _, err := f.WriteAt(data, offset); err != nil {
+					fail(fmt.Errorf("write %s at %d failed: %w", device, offset, err))
+					continue
+				}
+				n := atomic.AddInt64(&written, length)
+				if opts.Progress != nil {
+					opts.Progress(n)
+				}
+			}
+		}()
+	}
+dispatch:
+	for i := range m.Chunks {
+		select {
+		case indexes <- i:
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(indexes)
+	wg.Wait()
+
+	if firstErr != nil {
+		return written, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return written, err
+	}
+	return written, f.Sync()
+}
+
+// getChunk 下载并解压数据块，校验sha256
+func (r *Repository) getChunk(ctx context.Context, volume, digest string) ([]byte, error) {
+	data, err := r.store.GetObject(ctx, r.chunkKey(volume, digest))
+	if err != nil {
+		return nil, err
+	}
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("chunk %s is corrupted: %w", digest, err)
+	}
+	chunk, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("chunk %s is corrupted: %w", digest, err)
+	}
+	sum := sha256.Sum256(chunk)
+	if hex.EncodeToString(sum[:]) != digest {
+		return nil, fmt.Errorf("chunk %s checksum mismatch", digest)
+	}
+	return chunk, nil
+}
+
+// DeleteBackup 删除备份的Manifest，然后删除该pv所有备份都不再引用的数据块
+// 调用方需保证该pv没有正在进行的备份以及恢复
+func (r *Repository) DeleteBackup(ctx context.Context, volume, id string) error {
+	if err := r.store.DeleteObject(ctx, r.manifestKey(volume, id)); err != nil {
+		return err
+	}
+	_, err := r.GarbageCollect(ctx, volume)
+	return err
+}
+
+// GarbageCollect 删除pv的备份不再引用的数据块，返回删除的数据块数量
+func (r *Repository) GarbageCollect(ctx context.Context, volume string) (int, error) {
+	manifests, err := r.store.ListObjects(ctx, r.volumePrefix(volume)+"backups/")
+	if err != nil {
+		return 0, err
+	}
+	referenced := map[string]bool{}
+	for _, key := range manifests {
+		id := strings.TrimSuffix(path.Base(key), ".json")
+		m, err := r.GetManifest(ctx, volume, id)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			// 无法确认引用关系时不删除数据块
+			return 0, err
+		}
+		for _, digest := range m.Chunks {
+			referenced[digest] = true
+		}
+	}
+
+	chunks, err := r.store.ListObjects(ctx, r.volumePrefix(volume)+"chunks/")
+	if err != nil {
+		return 0, err
+	}
+	deleted := 0
+	for _, key := range chunks {
+		if referenced[path.Base(key)] {
+			continue
+		}
+		if err := r.store.DeleteObject(ctx, key); err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+func workers(n int) int {
+	if n <= 0 {
+		return DefaultWorkers
+	}
+	return n
+}
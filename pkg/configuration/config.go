@@ -19,17 +19,20 @@ package configuration
 import (
 	"errors"
 	"fmt"
+	"net/url"
 	"os"
 	"reflect"
 	"regexp"
 	"strconv"
 	"strings"
 
+	"github.com/carina-io/carina"
 	"github.com/carina-io/carina/utils"
 	"github.com/carina-io/carina/utils/log"
 	"github.com/fsnotify/fsnotify"
 	"github.com/mitchellh/mapstructure"
 	"github.com/spf13/viper"
+	"k8s.io/apimachinery/pkg/api/resource"
 )
 
 // 配置文件路径
@@ -63,12 +66,115 @@ type DiskSelectorItem struct {
 	Re        []string `json:"re"`
 	Policy    string   `json:"policy"`
 	NodeLabel string   `json:"nodeLabel"`
+	// Overcommit thin pool超分比例，未配置时为1
+	Overcommit float64 `json:"overcommit"`
+	// 以下条件基于udev属性匹配磁盘，不受设备名变化影响，与re同时配置时需全部满足
+	// Serial ID_SERIAL 正则
+	Serial []string `json:"serial"`
+	// Wwn ID_WWN 正则
+	Wwn []string `json:"wwn"`
+	// Model ID_MODEL 正则
+	Model []string `json:"model"`
+	// IdPath ID_PATH 正则
+	IdPath []string `json:"idPath"`
+	// ById /dev/disk/by-id/ 下符号链接名称正则
+	ById []string `json:"byId"`
+	// Rotational true为机械盘, false为固态盘, 未配置不限制
+	Rotational string `json:"rotational"`
+	// MinSize MaxSize 磁盘容量范围, 例如 100Gi 2Ti
+	MinSize string `json:"minSize"`
+	MaxSize string `json:"maxSize"`
+	// RaidLevel RAID策略的阵列级别 raid0/raid1/raid5/raid10
+	RaidLevel string `json:"raidLevel"`
+	// RaidDevices RAID策略的阵列成员数量，未配置时为该级别的最少成员数
+	RaidDevices int `json:"raidDevices"`
+	// CacheGroup lvmcache使用的缓存磁盘组，该磁盘组的磁盘加入本磁盘组的vg并打上缓存标签
+	CacheGroup string `json:"cacheGroup"`
+	// ReservedSpace vg保留空间，绝对值(如20Gi)或百分比(如5%)，未配置时保留10Gi
+	ReservedSpace string `json:"reservedSpace"`
+	// MaxAllocatableRatio vg可分配容量占vg容量的最大比例(0-1]，未配置时不限制
+	MaxAllocatableRatio float64 `json:"maxAllocatableRatio"`
+}
+
+// raidMinDevices 各RAID级别最少成员数
+var raidMinDevices = map[string]int{
+	"raid0":  2,
+	"raid1":  2,
+	"raid5":  3,
+	"raid10": 4,
+}
+
+// HasUdevSelector 是否配置了udev属性匹配条件
+func (d DiskSelectorItem) HasUdevSelector() bool {
+	return len(d.Serial) > 0 || len(d.Wwn) > 0 || len(d.Model) > 0 || len(d.IdPath) > 0 || len(d.ById) > 0
+}
+
+// SizeRange 磁盘容量范围(字节)，未配置时为0
+func (d DiskSelectorItem) SizeRange() (uint64, uint64) {
+	var minSize, maxSize uint64
+	if q, err := resource.ParseQuantity(d.MinSize); err == nil {
+		minSize = uint64(q.Value())
+	}
+	if q, err := resource.ParseQuantity(d.MaxSize); err == nil {
+		maxSize = uint64(q.Value())
+	}
+	return minSize, maxSize
+}
+
+// RaidSpec RAID阵列级别以及成员数量，级别兼容1、raid1两种写法
+func (d DiskSelectorItem) RaidSpec() (string, int) {
+	level := strings.ToLower(d.RaidLevel)
+	if !strings.HasPrefix(level, "raid") {
+		level = "raid" + level
+	}
+	devices := d.RaidDevices
+	if devices == 0 {
+		devices = raidMinDevices[level]
+	}
+	return level, devices
+}
+
+// ThinOvercommit thin卷可分配容量与物理容量的比例
+func (d DiskSelectorItem) ThinOvercommit() float64 {
+	if d.Overcommit < 1 {
+		return 1
+	}
+	return d.Overcommit
+}
+
+// ReservedBytes vg保留空间(字节)，按reservedSpace以及maxAllocatableRatio计算，取两者中较大的保留空间
+func (d DiskSelectorItem) ReservedBytes(size uint64) uint64 {
+	reserved := uint64(carina.DefaultReservedSpace)
+	if percent, ok := parsePercent(d.ReservedSpace); ok {
+		reserved = uint64(float64(size) * percent / 100)
+	} else if q, err := resource.ParseQuantity(d.ReservedSpace); err == nil {
+		reserved = uint64(q.Value())
+	}
+	if d.MaxAllocatableRatio > 0 && d.MaxAllocatableRatio < 1 {
+		if r := size - uint64(float64(size)*d.MaxAllocatableRatio); r > reserved {
+			reserved = r
+		}
+	}
+	return reserved
+}
+
+// parsePercent 解析5%形式的百分比
+func parsePercent(s string) (float64, bool) {
+	if !strings.HasSuffix(s, "%") {
+		return 0, false
+	}
+	percent, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(s, "%")), 64)
+	if err != nil {
+		return 0, false
+	}
+	return percent, true
 }
 
 type Disk struct {
 	DiskSelectors     []DiskSelectorItem `json:"diskSelectors"`
 	DiskScanInterval  int64              `json:"diskScanInterval"`
 	SchedulerStrategy string             `json:"schedulerStrategy"`
+	ClusterID         string             `json:"clusterId"`
 }
 
 func init() {
@@ -163,6 +269,56 @@ func SchedulerStrategy() string {
 	return schedulerStrategy
 }
 
+// ClusterID 卷归属标签中的集群标识，为空时使用kube-system命名空间的uid
+// 集群标识用于区分多个集群共享的节点上各自创建的卷，设置后不能修改
+func ClusterID() string {
+	return GlobalConfig.GetString("clusterId")
+}
+
+// BackupStorage 备份使用的S3兼容对象存储，访问凭据保存在carina所在命名空间的secret中
+type BackupStorage struct {
+	// Endpoint 对象存储地址，例如 https://minio.example.com:9000
+	Endpoint string `json:"endpoint"`
+	Region   string `json:"region"`
+	Bucket   string `json:"bucket"`
+	// Prefix 备份数据在bucket中的路径前缀
+	Prefix string `json:"prefix"`
+	// CredentialSecret secret名称，包含accessKeyID以及secretAccessKey
+	CredentialSecret string `json:"credentialSecret"`
+	// InsecureSkipVerify 不校验对象存储的tls证书
+	InsecureSkipVerify bool `json:"insecureSkipVerify"`
+}
+
+// BackupStorageConfig 备份存储配置，未配置时返回错误
+// 不使用opt解析，自定义的DecodeHook会将所有map解析到diskConfig
+func BackupStorageConfig() (BackupStorage, error) {
+	storage := BackupStorage{}
+	if !GlobalConfig.IsSet("backupStorage") {
+		return storage, errors.New("backupStorage is not configured")
+	}
+	if err := GlobalConfig.UnmarshalKey("backupStorage", &storage); err != nil {
+		return storage, fmt.Errorf("backupStorage is invalid: %w", err)
+	}
+	if storage.Region == "" {
+		storage.Region = "us-east-1"
+	}
+	return storage, validateBackupStorage(storage)
+}
+
+func validateBackupStorage(storage BackupStorage) error {
+	u, err := url.Parse(storage.Endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("backupStorage endpoint should be a http or https url: %s", storage.Endpoint)
+	}
+	if storage.Bucket == "" {
+		return errors.New("backupStorage bucket should not be empty")
+	}
+	if storage.CredentialSecret == "" {
+		return errors.New("backupStorage credentialSecret should not be empty")
+	}
+	return nil
+}
+
 func RuntimeNamespace() string {
 	namespace := os.Getenv("NAMESPACE")
 	if namespace == "" {
@@ -176,6 +332,8 @@ func validate(disk Disk) error {
 	var diskNameRegexp = regexp.MustCompile("^([A-Za-z0-9][-A-Za-z0-9_.]*)?[A-Za-z0-9]$")
 	var diskScanRegexp = regexp.MustCompile("(?i)^([0-9]*)?$")
 	var schedulerStrategyRegexp = regexp.MustCompile("(?i)^(spreadout|binpack)?$")
+	// clusterId会写入lvm标签，只能包含lvm标签允许的字符
+	var clusterIDRegexp = regexp.MustCompile("^[A-Za-z0-9_+.=!:&#-]*$")
 
 	if !diskScanRegexp.MatchString(strconv.FormatInt(disk.DiskScanInterval, 10)) {
 		return fmt.Errorf("diskScanInterval must be a number: %s", strconv.FormatInt(disk.DiskScanInterval, 10))
@@ -183,6 +341,9 @@ func validate(disk Disk) error {
 	if !schedulerStrategyRegexp.MatchString(disk.SchedulerStrategy) {
 		return fmt.Errorf("SchedulerStrategy must either binpack or spradout : %s", disk.SchedulerStrategy)
 	}
+	if !clusterIDRegexp.MatchString(disk.ClusterID) {
+		return fmt.Errorf("clusterId should consist of alphanumeric characters or '_+.=!:&#-': %s", disk.ClusterID)
+	}
 	for _, dc := range disk.DiskSelectors {
 		if len(dc.Name) == 0 {
 			return errors.New("disk name should not be empty")
@@ -190,17 +351,113 @@ func validate(disk Disk) error {
 		if !diskNameRegexp.MatchString(dc.Name) {
 			return fmt.Errorf("disk name should consist of alphanumeric characters, '-', '_' or '.', and should start and end with an alphanumeric character: %s", dc.Name)
 		}
-		if len(dc.Re) == 0 {
+		if len(dc.Re) == 0 && !dc.HasUdevSelector() {
 			log.Warnf("disk regexp should not be empty: %s", dc.Re)
 		}
+		for _, re := range [][]string{dc.Re, dc.Serial, dc.Wwn, dc.Model, dc.IdPath, dc.ById} {
+			if _, err := regexp.Compile(strings.Join(re, "|")); err != nil {
+				return fmt.Errorf("disk selector regexp is invalid: %s %v", dc.Name, err)
+			}
+		}
+		if !utils.ContainsString([]string{"", "true", "false"}, strings.ToLower(dc.Rotational)) {
+			return fmt.Errorf("rotational should be true or false: %s %s", dc.Name, dc.Rotational)
+		}
+		for _, size := range []string{dc.MinSize, dc.MaxSize} {
+			if size == "" {
+				continue
+			}
+			if _, err := resource.ParseQuantity(size); err != nil {
+				return fmt.Errorf("disk size is invalid: %s %s %v", dc.Name, size, err)
+			}
+		}
+		if minSize, maxSize := dc.SizeRange(); maxSize > 0 && minSize > maxSize {
+			return fmt.Errorf("minSize should not be greater than maxSize: %s", dc.Name)
+		}
+		if err := validateReservedSpace(dc); err != nil {
+			return err
+		}
+		if dc.Overcommit != 0 && dc.Overcommit < 1 {
+			return fmt.Errorf("overcommit should not be less than 1: %s %v", dc.Name, dc.Overcommit)
+		}
+		if strings.ToLower(dc.Policy) == "raid" {
+			level, devices := dc.RaidSpec()
+			minDevices, ok := raidMinDevices[level]
+			if !ok {
+				return fmt.Errorf("raidLevel should be one of raid0, raid1, raid5 or raid10: %s %s", dc.Name, dc.RaidLevel)
+			}
+			if devices < minDevices {
+				return fmt.Errorf("raidDevices of %s should not be less than %d: %s %d", level, minDevices, dc.Name, devices)
+			}
+		}
 		if vgGroup[dc.Name] {
 			return fmt.Errorf("duplicate vg group: %s", dc.Name)
 		}
 		vgGroup[dc.Name] = true
 	}
+	return validateCacheGroup(disk.DiskSelectors)
+}
+
+// validateReservedSpace reservedSpace为非负容量或[0,100)的百分比，maxAllocatableRatio在(0,1]之间
+func validateReservedSpace(dc DiskSelectorItem) error {
+	if dc.ReservedSpace != "" {
+		if strings.HasSuffix(dc.ReservedSpace, "%") {
+			percent, ok := parsePercent(dc.ReservedSpace)
+			if !ok || percent < 0 || percent >= 100 {
+				return fmt.Errorf("reservedSpace percentage should be in [0, 100): %s %s", dc.Name, dc.ReservedSpace)
+			}
+		} else if q, err := resource.ParseQuantity(dc.ReservedSpace); err != nil || q.Sign() < 0 {
+			return fmt.Errorf("reservedSpace is invalid: %s %s", dc.Name, dc.ReservedSpace)
+		}
+	}
+	if dc.MaxAllocatableRatio < 0 || dc.MaxAllocatableRatio > 1 {
+		return fmt.Errorf("maxAllocatableRatio should be in (0, 1]: %s %v", dc.Name, dc.MaxAllocatableRatio)
+	}
+	return nil
+}
+
+// validateCacheGroup 缓存磁盘组与后端磁盘组必须都是LVM策略，且一个缓存磁盘组只能被一个磁盘组使用
+func validateCacheGroup(selectors []DiskSelectorItem) error {
+	groups := map[string]DiskSelectorItem{}
+	for _, dc := range selectors {
+		groups[dc.Name] = dc
+	}
+	backends := map[string]string{}
+	for _, dc := range selectors {
+		if dc.CacheGroup == "" {
+			continue
+		}
+		cache, ok := groups[dc.CacheGroup]
+		if !ok {
+			return fmt.Errorf("cache group not found: %s %s", dc.Name, dc.CacheGroup)
+		}
+		if dc.CacheGroup == dc.Name {
+			return fmt.Errorf("cache group should not be itself: %s", dc.Name)
+		}
+		if strings.ToLower(dc.Policy) != "lvm" || strings.ToLower(cache.Policy) != "lvm" {
+			return fmt.Errorf("cache group only supports LVM policy: %s %s", dc.Name, dc.CacheGroup)
+		}
+		if cache.CacheGroup != "" {
+			return fmt.Errorf("cache group should not have a cache group: %s", dc.CacheGroup)
+		}
+		if backend, ok := backends[dc.CacheGroup]; ok {
+			return fmt.Errorf("cache group %s is used by both %s and %s", dc.CacheGroup, backend, dc.Name)
+		}
+		backends[dc.CacheGroup] = dc.Name
+	}
 	return nil
 }
 
+// CacheBackends 缓存磁盘组与使用它的后端磁盘组的对应关系
+func CacheBackends(selectors []DiskSelectorItem) map[string]string {
+	backends := map[string]string{}
+	for _, dc := range selectors {
+		if dc.CacheGroup != "" {
+			backends[dc.CacheGroup] = dc.Name
+		}
+	}
+	return backends
+}
+
 func GetRawDeviceGroupRe(diskType string) []string {
 	deviceGroup := strings.ToLower(diskType)
 	currentDiskSelector := diskConfig.DiskSelectors
@@ -41,3 +41,50 @@ func TestUnmarshalWithDecoderOptions(t *testing.T) {
 	log.Info(diskConfig)
 
 }
+
+func TestReservedBytes(t *testing.T) {
+	table := []struct {
+		item   DiskSelectorItem
+		size   uint64
+		result uint64
+	}{
+		{item: DiskSelectorItem{}, size: 100 << 30, result: 10 << 30},
+		{item: DiskSelectorItem{ReservedSpace: "2Gi"}, size: 100 << 30, result: 2 << 30},
+		{item: DiskSelectorItem{ReservedSpace: "5%"}, size: 100 << 30, result: 5 << 30},
+		{item: DiskSelectorItem{ReservedSpace: "0"}, size: 100 << 30, result: 0},
+		{item: DiskSelectorItem{ReservedSpace: "1Gi", MaxAllocatableRatio: 0.9}, size: 100 << 30, result: 10 << 30},
+		{item: DiskSelectorItem{ReservedSpace: "20Gi", MaxAllocatableRatio: 0.9}, size: 100 << 30, result: 20 << 30},
+		{item: DiskSelectorItem{ReservedSpace: "1Gi", MaxAllocatableRatio: 1}, size: 100 << 30, result: 1 << 30},
+	}
+
+	for _, e := range table {
+		if r := e.item.ReservedBytes(e.size); r != e.result {
+			t.Errorf("ReservedBytes of %+v expected %d, got %d", e.item, e.result, r)
+		}
+	}
+
+	if err := validateReservedSpace(DiskSelectorItem{Name: "ssd", ReservedSpace: "100%"}); err == nil {
+		t.Error("reservedSpace 100% should be invalid")
+	}
+	if err := validateReservedSpace(DiskSelectorItem{Name: "ssd", MaxAllocatableRatio: 1.5}); err == nil {
+		t.Error("maxAllocatableRatio 1.5 should be invalid")
+	}
+}
+
+func TestValidateBackupStorage(t *testing.T) {
+	table := []struct {
+		storage BackupStorage
+		valid   bool
+	}{
+		{storage: BackupStorage{Endpoint: "https://minio.example.com:9000", Bucket: "carina", CredentialSecret: "carina-backup"}, valid: true},
+		{storage: BackupStorage{Endpoint: "minio.example.com:9000", Bucket: "carina", CredentialSecret: "carina-backup"}, valid: false},
+		{storage: BackupStorage{Endpoint: "http://minio.example.com", CredentialSecret: "carina-backup"}, valid: false},
+		{storage: BackupStorage{Endpoint: "http://minio.example.com", Bucket: "carina"}, valid: false},
+	}
+
+	for _, e := range table {
+		if err := validateBackupStorage(e.storage); (err == nil) != e.valid {
+			t.Errorf("validateBackupStorage of %+v expected valid %t, got %v", e.storage, e.valid, err)
+		}
+	}
+}
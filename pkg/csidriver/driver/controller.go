@@ -21,12 +21,16 @@ import (
 	"errors"
 	"fmt"
 	"github.com/carina-io/carina"
+	carinav1 "github.com/carina-io/carina/api/v1"
 	"github.com/carina-io/carina/pkg/csidriver/driver/util"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/carina-io/carina/pkg/csidriver/driver/k8s"
+	"github.com/carina-io/carina/pkg/devicemanager/types"
+	"github.com/carina-io/carina/pkg/quota"
 	"github.com/carina-io/carina/utils"
 	"github.com/carina-io/carina/utils/log"
 	"github.com/carina-io/carina/utils/mutx"
@@ -34,20 +38,24 @@ import (
 
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 // NewControllerService returns a new ControllerServer.
-func NewControllerService(lvService *k8s.LogicVolumeService, nodeService *k8s.NodeService) csi.ControllerServer {
-	return &controllerService{lvService: lvService, nodeService: nodeService, mutex: mutx.NewGlobalLocks()}
+func NewControllerService(lvService *k8s.LogicVolumeService, nodeService *k8s.NodeService, lsService *k8s.LogicSnapshotService, quotaService *k8s.QuotaService) csi.ControllerServer {
+	return &controllerService{lvService: lvService, nodeService: nodeService, lsService: lsService, quotaService: quotaService, mutex: mutx.NewGlobalLocks()}
 }
 
 type controllerService struct {
 	csi.UnimplementedControllerServer
 	mutex *mutx.GlobalLocks
 
-	lvService   *k8s.LogicVolumeService
-	nodeService *k8s.NodeService
+	lvService    *k8s.LogicVolumeService
+	nodeService  *k8s.NodeService
+	lsService    *k8s.LogicSnapshotService
+	quotaService *k8s.QuotaService
 }
 
 func (s controllerService) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest) (*csi.CreateVolumeResponse, error) {
@@ -75,9 +83,6 @@ func (s controllerService) CreateVolume(ctx context.Context, req *csi.CreateVolu
 		" content_source ", source,
 		" accessibility_requirements ", req.GetAccessibilityRequirements().String())
 
-	if source != nil {
-		return nil, status.Error(codes.InvalidArgument, "volume_content_source not supported")
-	}
 	if capabilities == nil {
 		return nil, status.Error(codes.InvalidArgument, "no volume capabilities are provided")
 	}
@@ -111,7 +116,7 @@ func (s controllerService) CreateVolume(ctx context.Context, req *csi.CreateVolu
 		}
 	}
 
-	requestGb, err := convertRequestCapacity(req.GetCapacityRange().GetRequiredBytes(), req.GetCapacityRange().GetLimitBytes())
+	requestBytes, err := convertRequestCapacity(req.GetCapacityRange().GetRequiredBytes(), req.GetCapacityRange().GetLimitBytes())
 	if err != nil {
 		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
@@ -123,6 +128,11 @@ func (s controllerService) CreateVolume(ctx context.Context, req *csi.CreateVolu
 		return nil, status.Errorf(codes.Internal, "can not find pvc %s %s", namespace, pvcName)
 	}
 
+	// 从快照或已有卷创建，数据只能在源卷所在节点及设备组内复制
+	if source != nil {
+		return s.CreateVolumeFromSource(ctx, req, nodeName, requestBytes)
+	}
+
 	// default LvmVolumeType
 	volumeType := carina.LvmVolumeType
 	if util.CheckRawDeviceGroup(deviceGroup) {
@@ -134,15 +144,32 @@ func (s controllerService) CreateVolume(ctx context.Context, req *csi.CreateVolu
 		exclusivityDisk = true
 	}
 
+	// 条带以及raid卷，只支持普通lvm卷
+	layout, err := types.NewLvLayout(req.GetParameters())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
 	// if bcache type, need create two lvm volume
 	cacheDiskRatio := req.GetParameters()[carina.VolumeCacheDiskRatio]
+	if layout != nil && (volumeType != carina.LvmVolumeType || req.GetParameters()[carina.ThinProvisioning] == "true" || (cacheDiskRatio != "" && cacheDiskRatio != "0")) {
+		return nil, status.Errorf(codes.InvalidArgument, "%s, %s and %s only support lvm volume without thin provisioning or cache", carina.VolumeRaidLevel, carina.VolumeStripes, carina.VolumeMirrors)
+	}
+	// raid卷的副本以及校验条带同样占用磁盘组容量
+	allocBytes := int64(layout.AllocSize(uint64(requestBytes)))
+
 	if cacheDiskRatio != "" && cacheDiskRatio != "0" {
-		return s.CreateBcacheVolume(ctx, req, nodeName, requestGb)
+		if req.GetParameters()[carina.VolumeCacheBackend] == carina.LvmCacheBackend {
+			return s.CreateLvmCacheVolume(ctx, req, nodeName, requestBytes)
+		}
+		return s.CreateBcacheVolume(ctx, req, nodeName, requestBytes)
 	}
 
+	thin := volumeType == carina.LvmVolumeType && req.GetParameters()[carina.ThinProvisioning] == "true"
+
 	// sc parameter未设置device group, raw disk's deviceGroup need handle
 	if nodeName != "" {
-		deviceGroup, err = s.nodeService.SelectDeviceGroup(ctx, requestGb, exclusivityDisk, nodeName, volumeType, deviceGroup)
+		deviceGroup, err = s.nodeService.SelectDeviceGroup(ctx, allocBytes, exclusivityDisk, nodeName, volumeType, deviceGroup, thin)
 		if err != nil {
 			return nil, status.Errorf(codes.Internal, "failed to get device group %v", err)
 		}
@@ -158,7 +185,7 @@ func (s controllerService) CreateVolume(ctx context.Context, req *csi.CreateVolu
 		// - https://github.com/container-storage-interface/spec/blob/release-1.1/spec.md#createvolume
 		// - https://github.com/kubernetes-csi/csi-test/blob/6738ab2206eac88874f0a3ede59b40f680f59f43/pkg/sanity/controller.go#L404-L428
 		log.Info("start to decide node")
-		nodeName, deviceGroup, err = s.nodeService.SelectNode(ctx, requestGb, volumeType, deviceGroup, req.GetAccessibilityRequirements(), exclusivityDisk)
+		nodeName, deviceGroup, err = s.nodeService.SelectNode(ctx, allocBytes, volumeType, deviceGroup, req.GetAccessibilityRequirements(), exclusivityDisk, thin)
 		log.Info("nodeName:", nodeName, " deviceGroup:", deviceGroup)
 		if err != nil {
 			return nil, status.Errorf(codes.Internal, "failed to select node,  err: %v", err)
@@ -180,7 +207,27 @@ func (s controllerService) CreateVolume(ctx context.Context, req *csi.CreateVolu
 	if volumeType == carina.RawVolumeType {
 		annotation[carina.ExclusivityDisk] = fmt.Sprint(exclusivityDisk)
 	}
-	volumeID, deviceMajor, deviceMinor, err := s.lvService.CreateVolume(ctx, namespace, pvcName, nodeName, deviceGroup, pvName, requestGb, metav1.OwnerReference{}, annotation)
+	if thin {
+		annotation[carina.ThinProvisioning] = "true"
+	}
+	if req.GetParameters()[carina.VolumeEncryption] == "true" {
+		annotation[carina.VolumeEncryption] = "true"
+	}
+	for k, v := range layout.Annotations() {
+		annotation[k] = v
+	}
+	charge := quota.Usage{Storage: allocBytes, Volumes: 1}
+	if exclusivityDisk {
+		charge.ExclusiveDisks = 1
+	}
+	usage := quota.GroupUsage{}
+	usage.Add(deviceGroup, charge)
+	release, err := s.quotaService.Reserve(ctx, namespace, pvcName, usage)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	volumeID, deviceMajor, deviceMinor, err := s.lvService.CreateVolume(ctx, namespace, pvcName, nodeName, deviceGroup, pvName, requestBytes, metav1.OwnerReference{}, annotation)
 	if err != nil {
 		_, ok := status.FromError(err)
 		if !ok {
@@ -188,7 +235,7 @@ func (s controllerService) CreateVolume(ctx context.Context, req *csi.CreateVolu
 		}
 		return nil, err
 	}
-	log.Infof("CreateVolume: Successful create pvcName %s node %s deviceGroup %s pvName %s size %d", pvcName, nodeName, deviceGroup, pvName, requestGb)
+	log.Infof("CreateVolume: Successful create pvcName %s node %s deviceGroup %s pvName %s size %d", pvcName, nodeName, deviceGroup, pvName, requestBytes)
 
 	//Append necessary parameters
 	volumeContext[carina.DeviceDiskKey] = deviceGroup
@@ -199,7 +246,7 @@ func (s controllerService) CreateVolume(ctx context.Context, req *csi.CreateVolu
 
 	return &csi.CreateVolumeResponse{
 		Volume: &csi.Volume{
-			CapacityBytes: requestGb << 30,
+			CapacityBytes: requestBytes,
 			VolumeId:      volumeID,
 			VolumeContext: volumeContext,
 			ContentSource: source,
@@ -288,7 +335,9 @@ func (s controllerService) GetCapacity(ctx context.Context, req *csi.GetCapacity
 		exclusivityDisk = true
 	}
 
-	capacity, err := s.nodeService.GetTotalCapacity(ctx, deviceGroup, topology, exclusivityDisk)
+	thin := volumeType == carina.LvmVolumeType && req.GetParameters()[carina.ThinProvisioning] == "true"
+
+	capacity, err := s.nodeService.GetTotalCapacity(ctx, deviceGroup, topology, exclusivityDisk, thin)
 	if err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
 	}
@@ -303,6 +352,9 @@ func (s controllerService) ControllerGetCapabilities(context.Context, *csi.Contr
 		csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
 		csi.ControllerServiceCapability_RPC_GET_CAPACITY,
 		csi.ControllerServiceCapability_RPC_EXPAND_VOLUME,
+		csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT,
+		csi.ControllerServiceCapability_RPC_LIST_SNAPSHOTS,
+		csi.ControllerServiceCapability_RPC_CLONE_VOLUME,
 	}
 
 	csiCaps := make([]*csi.ControllerServiceCapability, len(capabilities))
@@ -348,14 +400,14 @@ func (s controllerService) ControllerExpandVolume(ctx context.Context, req *csi.
 		return nil, status.Error(codes.Internal, "can not expand no exclusivity disk")
 	}
 
-	requestGb, err := convertRequestCapacity(req.GetCapacityRange().GetRequiredBytes(), req.GetCapacityRange().GetLimitBytes())
+	requestBytes, err := convertRequestCapacity(req.GetCapacityRange().GetRequiredBytes(), req.GetCapacityRange().GetLimitBytes())
 	if err != nil {
 		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
 
 	currentSize := lv.Status.CurrentSize
 	if currentSize == nil {
-		// fill currentGb for old volume created in v0.3.0 or before.
+		// fill currentSize for old volume created in v0.3.0 or before.
 		err := s.lvService.UpdateLogicVolumeCurrentSize(ctx, volumeID, &lv.Spec.Size)
 		if err != nil {
 			return nil, status.Error(codes.Internal, err.Error())
@@ -363,25 +415,39 @@ func (s controllerService) ControllerExpandVolume(ctx context.Context, req *csi.
 		currentSize = &lv.Spec.Size
 	}
 
-	currentGb := currentSize.Value() >> 30
-	if requestGb <= currentGb {
+	currentBytes := currentSize.Value()
+	if requestBytes <= currentBytes {
 		// "NodeExpansionRequired" is still true because it is unknown
 		// whether node expansion is completed or not.
 		return &csi.ControllerExpandVolumeResponse{
-			CapacityBytes:         currentGb << 30,
+			CapacityBytes:         currentBytes,
 			NodeExpansionRequired: true,
 		}, nil
 	}
 	capacity, err := s.nodeService.GetCapacityByNodeName(ctx, lv.Spec.NodeName, lv.Spec.DeviceGroup)
+	if lv.Annotations[carina.ThinProvisioning] == "true" {
+		capacity, err = s.nodeService.GetThinCapacityByNodeName(ctx, lv.Spec.NodeName, lv.Spec.DeviceGroup)
+	}
 	if err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 
-	if capacity < (requestGb - currentGb) {
+	// raid卷按副本以及校验条带占用的空间计算
+	layout, err := types.NewLvLayout(lv.Annotations)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if capacity < int64(layout.AllocSize(uint64(requestBytes-currentBytes))) {
 		return nil, status.Error(codes.Internal, "not enough space")
 	}
 
-	err = s.lvService.ExpandVolume(ctx, volumeID, requestGb)
+	release, err := s.quotaService.ReserveExpansion(ctx, lv, requestBytes)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	err = s.lvService.ExpandVolume(ctx, volumeID, requestBytes)
 	if err != nil {
 		_, ok := status.FromError(err)
 		if !ok {
@@ -390,9 +456,9 @@ func (s controllerService) ControllerExpandVolume(ctx context.Context, req *csi.
 		return nil, err
 	}
 
-	// if bcache enable
+	// if bcache enable, lvmcache卷的缓存由节点扩容时重建
 	cacheDiskRatio := lv.Annotations[carina.VolumeCacheDiskRatio]
-	if cacheDiskRatio != "" {
+	if cacheDiskRatio != "" && lv.Annotations[carina.VolumeCacheBackend] != carina.LvmCacheBackend {
 		go func() {
 			timeCtx, cancel := context.WithTimeout(context.Background(), 120*time.Second)
 			defer cancel()
@@ -405,9 +471,9 @@ func (s controllerService) ControllerExpandVolume(ctx context.Context, req *csi.
 				log.Errorf("carina.storage.io/cache-disk-ratio %s, Should be in 1-100", cacheDiskRatio)
 			}
 			cacheVolumeName := "volume-cache-" + lv.Name[6:]
-			cacheRequestGb := requestGb * ratio / 100
+			cacheRequestBytes := alignRequestBytes(requestBytes * ratio / 100)
 
-			err = s.lvService.ExpandVolume(timeCtx, cacheVolumeName, cacheRequestGb)
+			err = s.lvService.ExpandVolume(timeCtx, cacheVolumeName, cacheRequestBytes)
 			if err != nil {
 				_, ok := status.FromError(err)
 				if !ok {
@@ -418,7 +484,7 @@ func (s controllerService) ControllerExpandVolume(ctx context.Context, req *csi.
 	}
 
 	return &csi.ControllerExpandVolumeResponse{
-		CapacityBytes:         requestGb << 30,
+		CapacityBytes:         requestBytes,
 		NodeExpansionRequired: true,
 	}, nil
 }
@@ -438,12 +504,17 @@ func convertRequestCapacity(requestBytes, limitBytes int64) (int64, error) {
 	}
 
 	if requestBytes == 0 {
-		return carina.MinRequestSizeGb, nil
+		requestBytes = carina.DefaultRequestSize
 	}
-	return (requestBytes-1)>>30 + 1, nil
+	return alignRequestBytes(requestBytes), nil
 }
 
-func (s controllerService) CreateBcacheVolume(ctx context.Context, req *csi.CreateVolumeRequest, nodeName string, requestGb int64) (*csi.CreateVolumeResponse, error) {
+// alignRequestBytes 按lvm默认PE大小向上对齐，节点上会再按vg实际PE大小或分区对齐
+func alignRequestBytes(requestBytes int64) int64 {
+	return (requestBytes + carina.VolumeAlignment - 1) / carina.VolumeAlignment * carina.VolumeAlignment
+}
+
+func (s controllerService) CreateBcacheVolume(ctx context.Context, req *csi.CreateVolumeRequest, nodeName string, requestBytes int64) (*csi.CreateVolumeResponse, error) {
 	pvName := req.GetName()
 	if pvName == "" {
 		return nil, status.Error(codes.InvalidArgument, "invalid pv name")
@@ -479,11 +550,11 @@ func (s controllerService) CreateBcacheVolume(ctx context.Context, req *csi.Crea
 		return nil, status.Errorf(codes.FailedPrecondition, "%s %s, Should be in 1-100", carina.VolumeCacheDiskRatio, cacheDiskRatio)
 	}
 
-	cacheRequestGb := requestGb * ratio / 100
-	backendRequestGb := requestGb
+	cacheRequestBytes := alignRequestBytes(requestBytes * ratio / 100)
+	backendRequestBytes := requestBytes
 
-	if cacheRequestGb <= 0 || backendRequestGb <= 0 {
-		return nil, status.Errorf(codes.FailedPrecondition, "pvc request capacity and cache ratio are inappropriate, cacheRequestGb is %d", cacheRequestGb)
+	if cacheRequestBytes <= 0 || backendRequestBytes <= 0 {
+		return nil, status.Errorf(codes.FailedPrecondition, "pvc request capacity and cache ratio are inappropriate, cacheRequestBytes is %d", cacheRequestBytes)
 	}
 
 	backendVolumeName := pvName
@@ -493,7 +564,7 @@ func (s controllerService) CreateBcacheVolume(ctx context.Context, req *csi.Crea
 
 	if nodeName == "" {
 		log.Info("start to decide node")
-		nodeName, err := s.nodeService.SelectMultiVolumeNode(ctx, backendDeviceGroup, cacheDeviceGroup, backendRequestGb, cacheRequestGb, requirements)
+		nodeName, err := s.nodeService.SelectMultiVolumeNode(ctx, backendDeviceGroup, cacheDeviceGroup, backendRequestBytes, cacheRequestBytes, requirements)
 		if err != nil {
 			return nil, status.Errorf(codes.Internal, "failed to select node, err: %v", err)
 		}
@@ -502,12 +573,25 @@ func (s controllerService) CreateBcacheVolume(ctx context.Context, req *csi.Crea
 		}
 	}
 
+	// 后端卷以及缓存卷一起校验配额，避免只创建出后端卷
+	charge := quota.GroupUsage{}
+	charge.Add(backendDeviceGroup, quota.Usage{Storage: backendRequestBytes, Volumes: 1})
+	charge.Add(cacheDeviceGroup, quota.Usage{Storage: cacheRequestBytes})
+	release, err := s.quotaService.Reserve(ctx, namespace, pvcName, charge)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
 	annotation := map[string]string{
 		carina.VolumeCacheDiskRatio: cacheDiskRatio,
 		carina.VolumeManagerType:    carina.LvmVolumeType,
 	}
+	if req.GetParameters()[carina.VolumeEncryption] == "true" {
+		annotation[carina.VolumeEncryption] = "true"
+	}
 
-	backendDiskVolumeID, backendDiskDeviceMajor, backendDiskDeviceMinor, err := s.lvService.CreateVolume(ctx, namespace, pvcName, nodeName, backendDeviceGroup, backendVolumeName, backendRequestGb, metav1.OwnerReference{}, annotation)
+	backendDiskVolumeID, backendDiskDeviceMajor, backendDiskDeviceMinor, err := s.lvService.CreateVolume(ctx, namespace, pvcName, nodeName, backendDeviceGroup, backendVolumeName, backendRequestBytes, metav1.OwnerReference{}, annotation)
 	if err != nil {
 		s, ok := status.FromError(err)
 		if s.Code() != codes.AlreadyExists {
@@ -537,7 +621,7 @@ func (s controllerService) CreateBcacheVolume(ctx context.Context, req *csi.Crea
 		BlockOwnerDeletion: &blockOwnerDeletion,
 	}
 
-	cacheDiskVolumeID, cacheDiskDeviceMajor, cacheDiskDeviceMinor, err := s.lvService.CreateVolume(ctx, namespace, pvcName, nodeName, cacheDeviceGroup, cacheVolumeName, cacheRequestGb, owner, annotation)
+	cacheDiskVolumeID, cacheDiskDeviceMajor, cacheDiskDeviceMinor, err := s.lvService.CreateVolume(ctx, namespace, pvcName, nodeName, cacheDeviceGroup, cacheVolumeName, cacheRequestBytes, owner, annotation)
 	if err != nil {
 		_, ok := status.FromError(err)
 		if !ok {
@@ -563,7 +647,7 @@ func (s controllerService) CreateBcacheVolume(ctx context.Context, req *csi.Crea
 
 	return &csi.CreateVolumeResponse{
 		Volume: &csi.Volume{
-			CapacityBytes: requestGb << 30,
+			CapacityBytes: requestBytes,
 			VolumeId:      backendDiskVolumeID,
 			VolumeContext: volumeContext,
 			AccessibleTopology: []*csi.Topology{
@@ -574,3 +658,416 @@ func (s controllerService) CreateBcacheVolume(ctx context.Context, req *csi.Crea
 		},
 	}, nil
 }
+
+// CreateLvmCacheVolume 创建lvmcache卷，后端磁盘组的vg中包含缓存磁盘组的磁盘，节点上由一个LogicVolume创建lv并挂载缓存
+func (s controllerService) CreateLvmCacheVolume(ctx context.Context, req *csi.CreateVolumeRequest, nodeName string, requestBytes int64) (*csi.CreateVolumeResponse, error) {
+	pvName := strings.ToLower(req.GetName())
+	requirements := req.GetAccessibilityRequirements()
+
+	backendDeviceGroup := strings.ToLower(req.GetParameters()[carina.VolumeBackendDiskType])
+	cacheDeviceGroup := strings.ToLower(req.GetParameters()[carina.VolumeCacheDiskType])
+	cacheDiskRatio := req.GetParameters()[carina.VolumeCacheDiskRatio]
+	cachePolicy := req.GetParameters()[carina.VolumeCachePolicy]
+
+	if backendDeviceGroup == "" {
+		return nil, status.Errorf(codes.FailedPrecondition, "%s %s, can not be empty", carina.VolumeBackendDiskType, backendDeviceGroup)
+	}
+	if cacheDeviceGroup == "" {
+		return nil, status.Errorf(codes.FailedPrecondition, "%s %s, can not be empty", carina.VolumeCacheDiskType, cacheDeviceGroup)
+	}
+
+	if cachePolicy == "" {
+		cachePolicy = "writethrough"
+	}
+	if !utils.ContainsString([]string{"writethrough", "writeback", "writecache"}, cachePolicy) {
+		return nil, status.Errorf(codes.FailedPrecondition, "%s %s, Should be writethrough, writeback or writecache", carina.VolumeCachePolicy, cachePolicy)
+	}
+
+	ratio, err := strconv.ParseInt(cacheDiskRatio, 10, 64)
+	if err != nil || ratio < 1 || ratio >= 100 {
+		return nil, status.Errorf(codes.FailedPrecondition, "%s %s, Should be in 1-100", carina.VolumeCacheDiskRatio, cacheDiskRatio)
+	}
+	cacheRequestBytes := alignRequestBytes(requestBytes * ratio / 100)
+	if cacheRequestBytes <= 0 {
+		return nil, status.Errorf(codes.FailedPrecondition, "pvc request capacity and cache ratio are inappropriate, cacheRequestBytes is %d", cacheRequestBytes)
+	}
+
+	pvcName := req.Parameters["csi.storage.k8s.io/pvc/name"]
+	namespace := req.Parameters["csi.storage.k8s.io/pvc/namespace"]
+
+	if nodeName == "" {
+		log.Info("start to decide node")
+		nodeName, err = s.nodeService.SelectMultiVolumeNode(ctx, backendDeviceGroup, cacheDeviceGroup, requestBytes, cacheRequestBytes, requirements)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to select node, err: %v", err)
+		}
+		if nodeName == "" {
+			return nil, status.Error(codes.Internal, "can not find any node")
+		}
+	}
+
+	charge := quota.GroupUsage{}
+	charge.Add(backendDeviceGroup, quota.Usage{Storage: requestBytes, Volumes: 1})
+	charge.Add(cacheDeviceGroup, quota.Usage{Storage: cacheRequestBytes})
+	release, err := s.quotaService.Reserve(ctx, namespace, pvcName, charge)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	log.Infof("CreateVolume: Starting to Create lvmcache volume %s with: pvcName(%s), pvcNameSpace(%s),nodeSelected(%s), storageSelected(%s), cacheSelected(%s)", req.GetName(), pvcName, namespace, nodeName, backendDeviceGroup, cacheDeviceGroup)
+
+	annotation := map[string]string{
+		carina.VolumeManagerType:    carina.LvmVolumeType,
+		carina.VolumeCacheBackend:   carina.LvmCacheBackend,
+		carina.VolumeCacheDiskType:  cacheDeviceGroup,
+		carina.VolumeCacheDiskRatio: cacheDiskRatio,
+		carina.VolumeCachePolicy:    cachePolicy,
+	}
+	if req.GetParameters()[carina.VolumeEncryption] == "true" {
+		annotation[carina.VolumeEncryption] = "true"
+	}
+
+	volumeID, deviceMajor, deviceMinor, err := s.lvService.CreateVolume(ctx, namespace, pvcName, nodeName, backendDeviceGroup, pvName, requestBytes, metav1.OwnerReference{}, annotation)
+	if err != nil {
+		_, ok := status.FromError(err)
+		if !ok {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		return nil, err
+	}
+
+	// pv csi VolumeAttributes
+	volumeContext := req.GetParameters()
+	volumeContext[carina.DeviceDiskKey] = backendDeviceGroup
+	volumeContext[carina.VolumeDevicePath] = fmt.Sprintf("/dev/%s/volume-%s", backendDeviceGroup, pvName)
+	volumeContext[carina.VolumeDeviceNode] = nodeName
+	volumeContext[carina.VolumeDeviceMajor] = fmt.Sprintf("%d", deviceMajor)
+	volumeContext[carina.VolumeDeviceMinor] = fmt.Sprintf("%d", deviceMinor)
+	volumeContext[carina.VolumeCachePolicy] = cachePolicy
+
+	return &csi.CreateVolumeResponse{
+		Volume: &csi.Volume{
+			CapacityBytes: requestBytes,
+			VolumeId:      volumeID,
+			VolumeContext: volumeContext,
+			AccessibleTopology: []*csi.Topology{
+				{
+					Segments: map[string]string{carina.TopologyNodeKey: nodeName},
+				},
+			},
+		},
+	}, nil
+}
+
+// CreateVolumeFromSource creates a volume on the node and device group of the snapshot or volume
+// referenced by volume_content_source, the data is copied by the node.
+func (s controllerService) CreateVolumeFromSource(ctx context.Context, req *csi.CreateVolumeRequest, nodeName string, requestBytes int64) (*csi.CreateVolumeResponse, error) {
+	pvName := strings.ToLower(req.GetName())
+	source := req.GetVolumeContentSource()
+	pvcName := req.Parameters["csi.storage.k8s.io/pvc/name"]
+	namespace := req.Parameters["csi.storage.k8s.io/pvc/namespace"]
+
+	cacheDiskRatio := req.GetParameters()[carina.VolumeCacheDiskRatio]
+	if cacheDiskRatio != "" && cacheDiskRatio != "0" {
+		return nil, status.Error(codes.InvalidArgument, "volume_content_source not supported for bcache volume")
+	}
+	if layout, err := types.NewLvLayout(req.GetParameters()); err != nil || layout != nil {
+		return nil, status.Error(codes.InvalidArgument, "volume_content_source not supported for striped or raid volume")
+	}
+
+	var sourceKind, sourceID, sourceNode, deviceGroup string
+	var sourceSize resource.Quantity
+	var thin, encrypted bool
+	volumeType := carina.LvmVolumeType
+
+	switch {
+	case source.GetSnapshot() != nil:
+		sourceKind = carina.SnapshotSourceKind
+		sourceID = source.GetSnapshot().GetSnapshotId()
+		ls, err := s.lsService.GetLogicSnapshotBySnapshotId(ctx, sourceID)
+		if err != nil {
+			if err == k8s.ErrSnapshotNotFound {
+				return nil, status.Errorf(codes.NotFound, "LogicSnapshot for snapshot id %s is not found", sourceID)
+			}
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		if !ls.Status.ReadyToUse {
+			return nil, status.Errorf(codes.Unavailable, "snapshot %s is not ready to use", sourceID)
+		}
+		sourceNode = ls.Spec.NodeName
+		deviceGroup = ls.Spec.DeviceGroup
+		sourceSize = ls.Spec.Size
+		// thin卷的快照同样是thin卷，克隆卷按thin卷扩容以及计算容量
+		if lv, err := s.lvService.GetLogicVolumeByVolumeId(ctx, ls.Spec.SourceVolumeID); err == nil {
+			thin = lv.Annotations[carina.ThinProvisioning] == "true"
+			encrypted = lv.Annotations[carina.VolumeEncryption] == "true"
+		}
+	case source.GetVolume() != nil:
+		sourceKind = carina.VolumeSourceKind
+		sourceID = source.GetVolume().GetVolumeId()
+		lv, err := s.lvService.GetLogicVolumeByVolumeId(ctx, sourceID)
+		if err != nil {
+			if err == k8s.ErrVolumeNotFound {
+				return nil, status.Errorf(codes.NotFound, "LogicalVolume for volume id %s is not found", sourceID)
+			}
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		if ratio := lv.Annotations[carina.VolumeCacheDiskRatio]; ratio != "" && ratio != "0" {
+			return nil, status.Errorf(codes.InvalidArgument, "clone of bcache volume %s not supported", sourceID)
+		}
+		if t, ok := lv.Annotations[carina.VolumeManagerType]; ok {
+			volumeType = t
+		}
+		if volumeType == carina.RawVolumeType && lv.Annotations[carina.ExclusivityDisk] == "true" {
+			return nil, status.Errorf(codes.InvalidArgument, "clone of exclusive disk volume %s not supported", sourceID)
+		}
+		thin = lv.Annotations[carina.ThinProvisioning] == "true"
+		encrypted = lv.Annotations[carina.VolumeEncryption] == "true"
+		sourceNode = lv.Spec.NodeName
+		deviceGroup = lv.Spec.DeviceGroup
+		sourceSize = lv.Spec.Size
+		if lv.Status.CurrentSize != nil {
+			sourceSize = *lv.Status.CurrentSize
+		}
+	default:
+		return nil, status.Error(codes.InvalidArgument, "unsupported volume_content_source")
+	}
+
+	// 克隆卷复制了源卷的LUKS头，加密设置必须与源卷一致
+	if encrypted != (req.GetParameters()[carina.VolumeEncryption] == "true") {
+		return nil, status.Errorf(codes.InvalidArgument, "%s of volume must be the same as source %s", carina.VolumeEncryption, sourceID)
+	}
+
+	if requestBytes < sourceSize.Value() {
+		return nil, status.Errorf(codes.OutOfRange, "requested capacity %d is smaller than source %s", requestBytes, sourceSize.String())
+	}
+
+	// 调度器已经选定节点，但与源卷不在同一节点，需要重新调度
+	if nodeName != "" && nodeName != sourceNode {
+		return nil, status.Errorf(codes.ResourceExhausted, "source %s is on node %s, can not provision on node %s", sourceID, sourceNode, nodeName)
+	}
+	nodeName = sourceNode
+
+	var capacity int64
+	var err error
+	if thin {
+		capacity, err = s.nodeService.GetThinCapacityByNodeName(ctx, nodeName, deviceGroup)
+	} else {
+		capacity, err = s.nodeService.GetCapacityByNodeName(ctx, nodeName, deviceGroup)
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if capacity < requestBytes {
+		return nil, status.Errorf(codes.ResourceExhausted, "not enough space on node %s device group %s", nodeName, deviceGroup)
+	}
+
+	charge := quota.GroupUsage{}
+	charge.Add(deviceGroup, quota.Usage{Storage: requestBytes, Volumes: 1})
+	release, err := s.quotaService.Reserve(ctx, namespace, pvcName, charge)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	log.Infof("CreateVolume: Starting to Create %s volume %s from %s %s with: pvcName(%s), pvcNameSpace(%s),nodeSelected(%s), storageSelected(%s)", volumeType, req.GetName(), sourceKind, sourceID, pvcName, namespace, nodeName, deviceGroup)
+
+	annotation := map[string]string{
+		carina.VolumeManagerType:    volumeType,
+		carina.VolumeDataSourceKind: sourceKind,
+		carina.VolumeDataSourceID:   sourceID,
+	}
+	if volumeType == carina.RawVolumeType {
+		annotation[carina.ExclusivityDisk] = "false"
+	}
+	if thin {
+		annotation[carina.ThinProvisioning] = "true"
+	}
+	if encrypted {
+		annotation[carina.VolumeEncryption] = "true"
+	}
+	volumeID, deviceMajor, deviceMinor, err := s.lvService.CreateVolume(ctx, namespace, pvcName, nodeName, deviceGroup, pvName, requestBytes, metav1.OwnerReference{}, annotation)
+	if err != nil {
+		_, ok := status.FromError(err)
+		if !ok {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		return nil, err
+	}
+	log.Infof("CreateVolume: Successful create pvcName %s node %s deviceGroup %s pvName %s size %d from %s %s", pvcName, nodeName, deviceGroup, pvName, requestBytes, sourceKind, sourceID)
+
+	// pv csi VolumeAttributes
+	volumeContext := req.GetParameters()
+	volumeContext[carina.DeviceDiskKey] = deviceGroup
+	volumeContext[carina.VolumeDevicePath] = fmt.Sprintf("/dev/%s/volume-%s", deviceGroup, pvName)
+	volumeContext[carina.VolumeDeviceNode] = nodeName
+	volumeContext[carina.VolumeDeviceMajor] = fmt.Sprintf("%d", deviceMajor)
+	volumeContext[carina.VolumeDeviceMinor] = fmt.Sprintf("%d", deviceMinor)
+	volumeContext[carina.VolumeDataSourceKind] = sourceKind
+	volumeContext[carina.VolumeDataSourceID] = sourceID
+
+	return &csi.CreateVolumeResponse{
+		Volume: &csi.Volume{
+			CapacityBytes: requestBytes,
+			VolumeId:      volumeID,
+			VolumeContext: volumeContext,
+			ContentSource: source,
+			AccessibleTopology: []*csi.Topology{
+				{
+					Segments: map[string]string{carina.TopologyNodeKey: nodeName},
+				},
+			},
+		},
+	}, nil
+}
+
+func (s controllerService) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotRequest) (*csi.CreateSnapshotResponse, error) {
+	log.Info("CreateSnapshot called name ", req.GetName(),
+		" source_volume_id ", req.GetSourceVolumeId(),
+		" parameters ", req.GetParameters(),
+		" num_secrets ", len(req.GetSecrets()))
+
+	name := req.GetName()
+	if name == "" {
+		return nil, status.Error(codes.InvalidArgument, "invalid name")
+	}
+	name = strings.ToLower(name)
+	sourceVolumeID := req.GetSourceVolumeId()
+	if sourceVolumeID == "" {
+		return nil, status.Error(codes.InvalidArgument, "source volume id is nil")
+	}
+
+	if acquired := s.mutex.TryAcquire(name); !acquired {
+		log.Warnf("an operation with the given snapshot %s already exists", name)
+		return nil, status.Errorf(codes.Aborted, "an operation with the given snapshot %s already exists", name)
+	}
+	defer s.mutex.Release(name)
+
+	lv, err := s.lvService.GetLogicVolumeByVolumeId(ctx, sourceVolumeID)
+	if err != nil {
+		if err == k8s.ErrVolumeNotFound {
+			return nil, status.Errorf(codes.NotFound, "LogicalVolume for volume id %s is not found", sourceVolumeID)
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	if lv.Annotations[carina.VolumeManagerType] != carina.LvmVolumeType {
+		return nil, status.Errorf(codes.InvalidArgument, "snapshot only support lvm volume, volume id %s", sourceVolumeID)
+	}
+	if lv.Annotations[carina.VolumeCacheDiskRatio] != "" {
+		return nil, status.Errorf(codes.InvalidArgument, "snapshot not support bcache volume, volume id %s", sourceVolumeID)
+	}
+
+	size := lv.Spec.Size
+	if lv.Status.CurrentSize != nil {
+		size = *lv.Status.CurrentSize
+	}
+
+	ls, err := s.lsService.CreateSnapshot(ctx, lv.Spec.NodeName, lv.Spec.DeviceGroup, sourceVolumeID, name, size)
+	if err != nil {
+		_, ok := status.FromError(err)
+		if !ok {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		return nil, err
+	}
+	log.Infof("CreateSnapshot: Successful create snapshot %s source %s node %s deviceGroup %s", name, sourceVolumeID, lv.Spec.NodeName, lv.Spec.DeviceGroup)
+
+	return &csi.CreateSnapshotResponse{
+		Snapshot: convertSnapshot(ls),
+	}, nil
+}
+
+func (s controllerService) DeleteSnapshot(ctx context.Context, req *csi.DeleteSnapshotRequest) (*csi.DeleteSnapshotResponse, error) {
+	log.Info("DeleteSnapshot called snapshot_id ", req.GetSnapshotId(), " num_secrets ", len(req.GetSecrets()))
+	if len(req.GetSnapshotId()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "snapshot_id is not provided")
+	}
+
+	err := s.lsService.DeleteSnapshot(ctx, req.GetSnapshotId())
+	if err != nil {
+		log.Error(err, " DeleteSnapshot failed snapshot_id ", req.GetSnapshotId())
+		_, ok := status.FromError(err)
+		if !ok {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		return nil, err
+	}
+
+	return &csi.DeleteSnapshotResponse{}, nil
+}
+
+func (s controllerService) ListSnapshots(ctx context.Context, req *csi.ListSnapshotsRequest) (*csi.ListSnapshotsResponse, error) {
+	log.Info("ListSnapshots called snapshot_id ", req.GetSnapshotId(),
+		" source_volume_id ", req.GetSourceVolumeId(),
+		" max_entries ", req.GetMaxEntries(),
+		" starting_token ", req.GetStartingToken())
+
+	if req.GetMaxEntries() < 0 {
+		return nil, status.Error(codes.InvalidArgument, "max_entries must not be negative")
+	}
+
+	var snapshots []carinav1.LogicSnapshot
+	if req.GetSnapshotId() != "" {
+		ls, err := s.lsService.GetLogicSnapshotBySnapshotId(ctx, req.GetSnapshotId())
+		if err != nil {
+			if err == k8s.ErrSnapshotNotFound {
+				return &csi.ListSnapshotsResponse{}, nil
+			}
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		snapshots = append(snapshots, *ls)
+	} else {
+		lsList, err := s.lsService.ListLogicSnapshots(ctx)
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		snapshots = lsList
+	}
+
+	entries := []*csi.ListSnapshotsResponse_Entry{}
+	for i := range snapshots {
+		if req.GetSourceVolumeId() != "" && snapshots[i].Spec.SourceVolumeID != req.GetSourceVolumeId() {
+			continue
+		}
+		entries = append(entries, &csi.ListSnapshotsResponse_Entry{Snapshot: convertSnapshot(&snapshots[i])})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Snapshot.SnapshotId < entries[j].Snapshot.SnapshotId
+	})
+
+	start := 0
+	if req.GetStartingToken() != "" {
+		var err error
+		start, err = strconv.Atoi(req.GetStartingToken())
+		if err != nil || start < 0 || start > len(entries) {
+			return nil, status.Errorf(codes.Aborted, "invalid starting_token %s", req.GetStartingToken())
+		}
+	}
+
+	end := len(entries)
+	nextToken := ""
+	if req.GetMaxEntries() > 0 && start+int(req.GetMaxEntries()) < end {
+		end = start + int(req.GetMaxEntries())
+		nextToken = strconv.Itoa(end)
+	}
+
+	return &csi.ListSnapshotsResponse{
+		Entries:   entries[start:end],
+		NextToken: nextToken,
+	}, nil
+}
+
+func convertSnapshot(ls *carinav1.LogicSnapshot) *csi.Snapshot {
+	snapshot := &csi.Snapshot{
+		SizeBytes:      ls.Spec.Size.Value(),
+		SnapshotId:     ls.Status.SnapshotID,
+		SourceVolumeId: ls.Spec.SourceVolumeID,
+		ReadyToUse:     ls.Status.ReadyToUse,
+	}
+	if ls.Status.CreationTime != nil {
+		snapshot.CreationTime = timestamppb.New(ls.Status.CreationTime.Time)
+	}
+	return snapshot
+}
@@ -0,0 +1,179 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+
+	"github.com/carina-io/carina"
+	carinav1 "github.com/carina-io/carina/api/v1"
+	carinav1beta1 "github.com/carina-io/carina/api/v1beta1"
+	"github.com/carina-io/carina/pkg/csidriver/driver/k8s"
+)
+
+// fakeManager 只提供k8s service用到的client，索引在创建fake client时注册
+type fakeManager struct {
+	manager.Manager
+	client client.Client
+}
+
+func (m *fakeManager) GetClient() client.Client {
+	return m.client
+}
+
+func (m *fakeManager) GetAPIReader() client.Reader {
+	return m.client
+}
+
+func (m *fakeManager) GetFieldIndexer() client.FieldIndexer {
+	return noopIndexer{}
+}
+
+type noopIndexer struct{}
+
+func (noopIndexer) IndexField(ctx context.Context, obj client.Object, field string, extractValue client.IndexerFunc) error {
+	return nil
+}
+
+func newTestControllerService(t *testing.T, objs ...client.Object) (*controllerService, client.Client) {
+	scheme := runtime.NewScheme()
+	assert.NoError(t, clientgoscheme.AddToScheme(scheme))
+	assert.NoError(t, carinav1.AddToScheme(scheme))
+	assert.NoError(t, carinav1beta1.AddToScheme(scheme))
+	cli := fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).
+		WithIndex(&carinav1.LogicVolume{}, "status.volumeID", func(o client.Object) []string {
+			return []string{o.(*carinav1.LogicVolume).Status.VolumeID}
+		}).
+		WithIndex(&carinav1.LogicVolume{}, "spec.nodeName", func(o client.Object) []string {
+			return []string{o.(*carinav1.LogicVolume).Spec.NodeName}
+		}).
+		WithIndex(&carinav1.LogicSnapshot{}, "status.snapshotID", func(o client.Object) []string {
+			return []string{o.(*carinav1.LogicSnapshot).Status.SnapshotID}
+		}).Build()
+	mgr := &fakeManager{client: cli}
+	lvService, err := k8s.NewLogicVolumeService(mgr)
+	assert.NoError(t, err)
+	lsService, err := k8s.NewLogicSnapshotService(mgr)
+	assert.NoError(t, err)
+	return &controllerService{
+		lvService:    lvService,
+		nodeService:  k8s.NewNodeService(mgr, lvService),
+		lsService:    lsService,
+		quotaService: k8s.NewQuotaService(mgr),
+	}, cli
+}
+
+// completeLogicVolume 模拟节点创建lv后回写volumeID
+func completeLogicVolume(ctx context.Context, cli client.Client, name string) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(50 * time.Millisecond):
+		}
+		lv := new(carinav1.LogicVolume)
+		if err := cli.Get(ctx, client.ObjectKey{Name: name}, lv); err != nil {
+			continue
+		}
+		lv.Status.VolumeID = "volume-" + name
+		_ = cli.Status().Update(ctx, lv)
+		return
+	}
+}
+
+func TestCreateVolumeFromSnapshot(t *testing.T) {
+	cases := []struct {
+		name       string
+		thin       bool
+		ready      bool
+		encryption string
+		wantCode   codes.Code
+	}{
+		// 源卷是thin卷时按thin pool超分后的容量检查
+		{name: "thin source", thin: true, ready: true},
+		{name: "thick source exceeds allocatable", ready: true, wantCode: codes.ResourceExhausted},
+		{name: "snapshot not ready", thin: true, wantCode: codes.Unavailable},
+		{name: "encryption mismatch", thin: true, ready: true, encryption: "true", wantCode: codes.InvalidArgument},
+	}
+	for _, c := range cases {
+		source := &carinav1.LogicVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: "pvc-src", Annotations: map[string]string{carina.VolumeManagerType: carina.LvmVolumeType}},
+			Spec:       carinav1.LogicVolumeSpec{NodeName: "node1", DeviceGroup: "carina-vg-ssd", Size: resource.MustParse("10Gi")},
+			Status:     carinav1.LogicVolumeStatus{VolumeID: "volume-pvc-src"},
+		}
+		if c.thin {
+			source.Annotations[carina.ThinProvisioning] = "true"
+		}
+		snapshot := &carinav1.LogicSnapshot{
+			ObjectMeta: metav1.ObjectMeta{Name: "snapshot-1"},
+			Spec:       carinav1.LogicSnapshotSpec{NodeName: "node1", DeviceGroup: "carina-vg-ssd", SourceVolumeID: "volume-pvc-src", Size: resource.MustParse("10Gi")},
+			Status:     carinav1.LogicSnapshotStatus{SnapshotID: "snapshot-1", ReadyToUse: c.ready},
+		}
+		nsr := &carinav1beta1.NodeStorageResource{
+			ObjectMeta: metav1.ObjectMeta{Name: "node1"},
+			Status: carinav1beta1.NodeStorageResourceStatus{Allocatable: map[string]resource.Quantity{
+				carina.DeviceCapacityKeyPrefix + "carina-vg-ssd": resource.MustParse("5Gi"),
+				carina.ThinCapacityKeyPrefix + "carina-vg-ssd":   resource.MustParse("50Gi"),
+			}},
+		}
+		s, cli := newTestControllerService(t, source, snapshot, nsr)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		go completeLogicVolume(ctx, cli, "pvc-clone")
+		req := &csi.CreateVolumeRequest{
+			Name: "pvc-clone",
+			Parameters: map[string]string{
+				"csi.storage.k8s.io/pvc/name":      "clone",
+				"csi.storage.k8s.io/pvc/namespace": "default",
+				carina.VolumeEncryption:            c.encryption,
+			},
+			VolumeContentSource: &csi.VolumeContentSource{Type: &csi.VolumeContentSource_Snapshot{
+				Snapshot: &csi.VolumeContentSource_SnapshotSource{SnapshotId: "snapshot-1"},
+			}},
+		}
+		resp, err := s.CreateVolumeFromSource(ctx, req, "", 10<<30)
+		cancel()
+
+		lv := new(carinav1.LogicVolume)
+		lvErr := cli.Get(context.Background(), client.ObjectKey{Name: "pvc-clone"}, lv)
+		if c.wantCode != codes.OK {
+			assert.Equal(t, c.wantCode, status.Code(err), c.name)
+			// 校验失败时不创建LogicVolume
+			assert.Error(t, lvErr, c.name)
+			continue
+		}
+		assert.NoError(t, err, c.name)
+		assert.Equal(t, "volume-pvc-clone", resp.GetVolume().GetVolumeId(), c.name)
+		assert.NoError(t, lvErr, c.name)
+		assert.Equal(t, "true", lv.Annotations[carina.ThinProvisioning], c.name)
+		assert.Equal(t, carina.SnapshotSourceKind, lv.Annotations[carina.VolumeDataSourceKind], c.name)
+	}
+}
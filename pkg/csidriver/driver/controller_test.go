@@ -31,8 +31,10 @@ func TestConvertRequestCapacity(t *testing.T) {
 		{requestBytes: -1, limitBytes: 0, result: 0, err: errors.New("required")},
 		{requestBytes: 41, limitBytes: -1, result: 0, err: errors.New("limit")},
 		{requestBytes: 15, limitBytes: 12, result: 0, err: errors.New("exceeds")},
-		{requestBytes: 15 << 30, limitBytes: 20 << 30, result: 15, err: nil},
-		{requestBytes: 0, limitBytes: 20, result: 1, err: nil},
+		{requestBytes: 15 << 30, limitBytes: 20 << 30, result: 15 << 30, err: nil},
+		{requestBytes: 3 << 29, limitBytes: 0, result: 3 << 29, err: nil},
+		{requestBytes: 1, limitBytes: 0, result: 4 << 20, err: nil},
+		{requestBytes: 0, limitBytes: 20, result: 1 << 30, err: nil},
 	}
 
 	a := assert.New(t)
@@ -0,0 +1,243 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package k8s
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+
+	"github.com/carina-io/carina"
+	carinav1 "github.com/carina-io/carina/api/v1"
+	"github.com/carina-io/carina/getter"
+	"github.com/carina-io/carina/utils/log"
+)
+
+// ErrSnapshotNotFound represents the specified snapshot is not found.
+var ErrSnapshotNotFound = errors.New("snapshot not found")
+
+// LogicSnapshotService represents service for LogicSnapshot.
+type LogicSnapshotService struct {
+	client.Client
+	getter *getter.RetryGetter
+	api    client.Reader
+}
+
+const indexFieldSnapshotID = "status.snapshotID"
+
+// +kubebuilder:rbac:groups=carina.storage.io,resources=logicsnapshots,verbs=get;list;watch;create;delete
+
+// NewLogicSnapshotService returns LogicSnapshotService.
+func NewLogicSnapshotService(mgr manager.Manager) (*LogicSnapshotService, error) {
+	ctx := context.Background()
+	if err := mgr.GetFieldIndexer().IndexField(ctx, &carinav1.LogicSnapshot{}, indexFieldSnapshotID,
+		func(o client.Object) []string {
+			return []string{o.(*carinav1.LogicSnapshot).Status.SnapshotID}
+		}); err != nil {
+		return nil, err
+	}
+
+	return &LogicSnapshotService{
+		Client: mgr.GetClient(),
+		getter: getter.NewRetryGetter(mgr),
+		api:    mgr.GetAPIReader(),
+	}, nil
+}
+
+// CreateSnapshot creates snapshot of the source volume on its node
+func (s *LogicSnapshotService) CreateSnapshot(ctx context.Context, node, deviceGroup, sourceVolumeID, name string, size resource.Quantity) (*carinav1.LogicSnapshot, error) {
+	log.Info("k8s.CreateSnapshot called name ", name, " node ", node, " deviceGroup ", deviceGroup, " source ", sourceVolumeID)
+
+	ls := &carinav1.LogicSnapshot{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "LogicSnapshot",
+			APIVersion: "carina.storage.io/v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       name,
+			Finalizers: []string{carina.LogicSnapshotFinalizer},
+		},
+		Spec: carinav1.LogicSnapshotSpec{
+			NodeName:       node,
+			DeviceGroup:    deviceGroup,
+			SourceVolumeID: sourceVolumeID,
+			Size:           size,
+		},
+	}
+
+	existingLS := new(carinav1.LogicSnapshot)
+	err := s.getter.Get(ctx, client.ObjectKey{Name: name}, existingLS)
+	if err != nil {
+		if !apierrors.IsNotFound(err) {
+			return nil, err
+		}
+
+		err := s.Create(ctx, ls)
+		if err != nil {
+			return nil, err
+		}
+		log.Info("created LogicSnapshot CRD name ", name)
+	} else {
+		// snapshot with same name was found; it must belong to the same source volume
+		if !existingLS.IsCompatibleWith(ls) {
+			return nil, status.Error(codes.AlreadyExists, "Incompatible LogicSnapshot already exists")
+		}
+	}
+
+	for {
+		log.Info("waiting for setting 'status.snapshotID' name ", name)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(100 * time.Millisecond):
+		}
+
+		var newLS carinav1.LogicSnapshot
+		err := s.getter.Get(ctx, client.ObjectKey{Name: name}, &newLS)
+		if err != nil {
+			log.Error(err, " failed to get LogicSnapshot name ", name)
+			return nil, err
+		}
+		if newLS.Status.SnapshotID != "" {
+			log.Info("create complete k8s.LogicSnapshot snapshot_id ", newLS.Status.SnapshotID)
+			return &newLS, nil
+		}
+		if newLS.Status.Code != codes.OK {
+			err := s.Delete(ctx, &newLS)
+			if err != nil {
+				// log this error but do not return this error, because newLS.Status.Message is more important
+				log.Error(err, " failed to delete LogicSnapshot")
+			}
+			return nil, status.Error(newLS.Status.Code, newLS.Status.Message)
+		}
+	}
+}
+
+// DeleteSnapshot deletes snapshot
+func (s *LogicSnapshotService) DeleteSnapshot(ctx context.Context, snapshotID string) error {
+	log.Info("k8s.DeleteSnapshot called snapshotID ", snapshotID)
+
+	ls, err := s.GetLogicSnapshotBySnapshotId(ctx, snapshotID)
+	if err != nil {
+		if err == ErrSnapshotNotFound {
+			log.Info("snapshot is not found snapshot_id ", snapshotID)
+			return nil
+		}
+		return err
+	}
+
+	if !ls.GetDeletionTimestamp().IsZero() {
+		return errors.New("snapshot is being deleted")
+	}
+
+	err = s.Delete(ctx, ls)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	// if the node doesn't exist, return directly
+	existingNode := new(corev1.Node)
+	err = s.getter.Get(ctx, client.ObjectKey{Name: ls.Spec.NodeName}, existingNode)
+	if err != nil {
+		return err
+	}
+
+	// wait until delete the target snapshot
+	for {
+		log.Info("waiting for delete LogicSnapshot name ", ls.Name)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(100 * time.Millisecond):
+		}
+
+		err := s.getter.Get(ctx, client.ObjectKey{Name: ls.Name}, new(carinav1.LogicSnapshot))
+		if err != nil {
+			if apierrors.IsNotFound(err) {
+				return nil
+			}
+			log.Error(err, " failed to get LogicSnapshot name ", ls.Name)
+			return err
+		}
+	}
+}
+
+// GetLogicSnapshotBySnapshotId returns LogicSnapshot by snapshot ID.
+// This ensures read-after-create consistency.
+func (s *LogicSnapshotService) GetLogicSnapshotBySnapshotId(ctx context.Context, snapshotID string) (*carinav1.LogicSnapshot, error) {
+	lsList := new(carinav1.LogicSnapshotList)
+	err := s.List(ctx, lsList, client.MatchingFields{indexFieldSnapshotID: snapshotID})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(lsList.Items) > 1 {
+		return nil, fmt.Errorf("multiple LogicSnapshot is found for SnapshotID %s", snapshotID)
+	} else if len(lsList.Items) != 0 {
+		return &lsList.Items[0], nil
+	}
+
+	// not found. try direct reader.
+	err = s.api.List(ctx, lsList, &client.ListOptions{Raw: &metav1.ListOptions{ResourceVersion: "0"}})
+	if err != nil {
+		return nil, err
+	}
+
+	var foundLS *carinav1.LogicSnapshot
+	for i, ls := range lsList.Items {
+		if ls.Status.SnapshotID == snapshotID {
+			if foundLS != nil {
+				return nil, fmt.Errorf("multiple LogicSnapshot is found for SnapshotID %s", snapshotID)
+			}
+			foundLS = &lsList.Items[i]
+		}
+	}
+	if foundLS == nil {
+		return nil, ErrSnapshotNotFound
+	}
+	return foundLS, nil
+}
+
+// ListLogicSnapshots returns all LogicSnapshots which have been created on nodes.
+func (s *LogicSnapshotService) ListLogicSnapshots(ctx context.Context) ([]carinav1.LogicSnapshot, error) {
+	lsList := new(carinav1.LogicSnapshotList)
+	if err := s.List(ctx, lsList); err != nil {
+		return nil, err
+	}
+
+	var snapshots []carinav1.LogicSnapshot
+	for _, ls := range lsList.Items {
+		if ls.Status.SnapshotID == "" {
+			continue
+		}
+		snapshots = append(snapshots, ls)
+	}
+	return snapshots, nil
+}
@@ -158,8 +158,8 @@ func NewLogicVolumeService(mgr manager.Manager) (*LogicVolumeService, error) {
 }
 
 // CreateVolume creates volume
-func (s *LogicVolumeService) CreateVolume(ctx context.Context, namespace, pvc, node, deviceGroup, pvName string, requestGb int64, owner metav1.OwnerReference, annotation map[string]string) (string, uint32, uint32, error) {
-	log.Info("k8s.CreateVolume called name ", pvName, " node ", node, " deviceGroup ", deviceGroup, " size_gb ", requestGb)
+func (s *LogicVolumeService) CreateVolume(ctx context.Context, namespace, pvc, node, deviceGroup, pvName string, requestBytes int64, owner metav1.OwnerReference, annotation map[string]string) (string, uint32, uint32, error) {
+	log.Info("k8s.CreateVolume called name ", pvName, " node ", node, " deviceGroup ", deviceGroup, " size ", requestBytes)
 
 	lv := &carinav1.LogicVolume{
 		TypeMeta: metav1.TypeMeta{
@@ -173,7 +173,7 @@ func (s *LogicVolumeService) CreateVolume(ctx context.Context, namespace, pvc, n
 		Spec: carinav1.LogicVolumeSpec{
 			NodeName:    node,
 			DeviceGroup: deviceGroup,
-			Size:        *resource.NewQuantity(requestGb<<30, resource.BinarySI),
+			Size:        *resource.NewQuantity(requestBytes, resource.BinarySI),
 			NameSpace:   namespace,
 			Pvc:         pvc,
 		},
@@ -290,8 +290,8 @@ func (s *LogicVolumeService) DeleteVolume(ctx context.Context, volumeID string)
 }
 
 // ExpandVolume expands volume
-func (s *LogicVolumeService) ExpandVolume(ctx context.Context, volumeID string, requestGb int64) error {
-	log.Info("k8s.ExpandVolume called volumeID ", volumeID, " requestGb ", requestGb)
+func (s *LogicVolumeService) ExpandVolume(ctx context.Context, volumeID string, requestBytes int64) error {
+	log.Info("k8s.ExpandVolume called volumeID ", volumeID, " requestBytes ", requestBytes)
 
 	lv, err := s.GetLogicVolumeByVolumeId(ctx, volumeID)
 	if err != nil {
@@ -305,7 +305,7 @@ func (s *LogicVolumeService) ExpandVolume(ctx context.Context, volumeID string,
 		return err
 	}
 
-	err = s.UpdateLogicVolumeSpecSize(ctx, volumeID, resource.NewQuantity(requestGb<<30, resource.BinarySI))
+	err = s.UpdateLogicVolumeSpecSize(ctx, volumeID, resource.NewQuantity(requestBytes, resource.BinarySI))
 	if err != nil {
 		return err
 	}
@@ -114,7 +114,16 @@ func (n NodeService) HaveSelectedNode(ctx context.Context, namespace, name strin
 	return node, nil
 }
 
-func (n NodeService) SelectDeviceGroup(ctx context.Context, requestGb int64, exclusivityDisk bool, nodeName, volumeType, scDeviceGroup string) (string, error) {
+// capacityKeyPrefix thin卷按thin pool超分后的容量选择磁盘组，与调度器一致
+func capacityKeyPrefix(thin bool) string {
+	if thin {
+		return carina.ThinCapacityKeyPrefix
+	}
+	return carina.DeviceCapacityKeyPrefix
+}
+
+// SelectDeviceGroup 在节点上选择满足容量的磁盘组，thin为true时使用thin pool的可分配容量
+func (n NodeService) SelectDeviceGroup(ctx context.Context, requestBytes int64, exclusivityDisk bool, nodeName, volumeType, scDeviceGroup string, thin bool) (string, error) {
 	if volumeType == carina.LvmVolumeType && scDeviceGroup != "" {
 		return scDeviceGroup, nil
 	}
@@ -134,14 +143,14 @@ func (n NodeService) SelectDeviceGroup(ctx context.Context, requestGb int64, exc
 	}
 
 	for groupDetail, allocatable := range nsr.Status.Allocatable {
-		if allocatable.Value() < requestGb {
+		if allocatable.Value() < requestBytes {
 			continue
 		}
 
-		if !strings.HasPrefix(groupDetail, carina.DeviceCapacityKeyPrefix) {
+		if !strings.HasPrefix(groupDetail, capacityKeyPrefix(thin)) {
 			continue
 		}
-		group := strings.TrimPrefix(groupDetail, carina.DeviceCapacityKeyPrefix)
+		group := strings.TrimPrefix(groupDetail, capacityKeyPrefix(thin))
 		isRawDevice := util.CheckRawDeviceGroup(strings.Split(group, "/")[0])
 
 		if volumeType == carina.RawVolumeType && isRawDevice {
@@ -204,7 +213,8 @@ func (n NodeService) SelectDeviceGroup(ctx context.Context, requestGb int64, exc
 	return selectDeviceGroup, nil
 }
 
-func (n NodeService) SelectNode(ctx context.Context, requestGb int64, volumeType, scDeviceGroup string, requirement *csi.TopologyRequirement, exclusivityDisk bool) (string, string, error) {
+// SelectNode 选择满足容量的节点以及磁盘组，thin为true时使用thin pool的可分配容量
+func (n NodeService) SelectNode(ctx context.Context, requestBytes int64, volumeType, scDeviceGroup string, requirement *csi.TopologyRequirement, exclusivityDisk, thin bool) (string, string, error) {
 	nodeList, err := n.getNodes(ctx, nil)
 	if err != nil {
 		return "", "", err
@@ -237,14 +247,14 @@ func (n NodeService) SelectNode(ctx context.Context, requestGb int64, volumeType
 		}
 
 		for groupDetail, allocatable := range nsr.Status.Allocatable {
-			if allocatable.Value() < requestGb {
+			if allocatable.Value() < requestBytes {
 				continue
 			}
 
-			if !strings.HasPrefix(groupDetail, carina.DeviceCapacityKeyPrefix) {
+			if !strings.HasPrefix(groupDetail, capacityKeyPrefix(thin)) {
 				continue
 			}
-			group := strings.TrimPrefix(groupDetail, carina.DeviceCapacityKeyPrefix)
+			group := strings.TrimPrefix(groupDetail, capacityKeyPrefix(thin))
 			if scDeviceGroup != "" && scDeviceGroup != strings.Split(group, "/")[0] {
 				continue
 			}
@@ -325,8 +335,22 @@ func (n NodeService) GetCapacityByNodeName(ctx context.Context, nodeName, lvDevi
 	return 0, errors.New("device group not found")
 }
 
-// GetTotalCapacity returns total capacity of all nodes.
-func (n NodeService) GetTotalCapacity(ctx context.Context, scDeviceGroup string, topology *csi.Topology, exclusivityDisk bool) (int64, error) {
+// GetThinCapacityByNodeName returns thin pool capacity of specified node by name, overcommit included.
+func (n NodeService) GetThinCapacityByNodeName(ctx context.Context, nodeName, lvDeviceGroup string) (int64, error) {
+	nsr := new(carinav1beta1.NodeStorageResource)
+	err := n.getter.Get(ctx, client.ObjectKey{Name: nodeName}, nsr)
+	if err != nil {
+		return 0, err
+	}
+
+	if allocatable, ok := nsr.Status.Allocatable[carina.ThinCapacityKeyPrefix+lvDeviceGroup]; ok {
+		return allocatable.Value(), nil
+	}
+	return 0, errors.New("device group not found")
+}
+
+// GetTotalCapacity returns total capacity of all nodes, thin pool capacity with overcommit if thin is true.
+func (n NodeService) GetTotalCapacity(ctx context.Context, scDeviceGroup string, topology *csi.Topology, exclusivityDisk, thin bool) (int64, error) {
 	var nodeLabels labels.Selector
 	if topology != nil && len(topology.GetSegments()) != 0 {
 		nodeLabels = labels.SelectorFromSet(topology.GetSegments())
@@ -365,10 +389,10 @@ func (n NodeService) GetTotalCapacity(ctx context.Context, scDeviceGroup string,
 		}
 
 		for groupDetail, allocatable := range nsr.Status.Capacity {
-			if !strings.HasPrefix(groupDetail, carina.DeviceCapacityKeyPrefix) {
+			if !strings.HasPrefix(groupDetail, capacityKeyPrefix(thin)) {
 				continue
 			}
-			group := strings.TrimPrefix(groupDetail, carina.DeviceCapacityKeyPrefix)
+			group := strings.TrimPrefix(groupDetail, capacityKeyPrefix(thin))
 			if scDeviceGroup != "" && scDeviceGroup != strings.Split(group, "/")[0] {
 				continue
 			}
@@ -402,7 +426,7 @@ func (n NodeService) GetTotalCapacity(ctx context.Context, scDeviceGroup string,
 	return capacity, nil
 }
 
-func (n NodeService) SelectMultiVolumeNode(ctx context.Context, backendDeviceGroup, cacheDeviceGroup string, backendRequestGb, cacheRequestGb int64, requirement *csi.TopologyRequirement) (string, error) {
+func (n NodeService) SelectMultiVolumeNode(ctx context.Context, backendDeviceGroup, cacheDeviceGroup string, backendRequestBytes, cacheRequestBytes int64, requirement *csi.TopologyRequirement) (string, error) {
 	nodeList, err := n.getNodes(ctx, nil)
 	if err != nil {
 		return "", err
@@ -428,7 +452,7 @@ func (n NodeService) SelectMultiVolumeNode(ctx context.Context, backendDeviceGro
 			if !strings.HasPrefix(groupDetail, carina.DeviceCapacityKeyPrefix) || !strings.Contains(groupDetail, cacheDeviceGroup) {
 				continue
 			}
-			if allocatable.Value() >= cacheRequestGb {
+			if allocatable.Value() >= cacheRequestBytes {
 				cacheFit = true
 				break
 			}
@@ -440,7 +464,7 @@ func (n NodeService) SelectMultiVolumeNode(ctx context.Context, backendDeviceGro
 			if !strings.HasPrefix(groupDetail, carina.DeviceCapacityKeyPrefix) || !strings.Contains(groupDetail, backendDeviceGroup) {
 				continue
 			}
-			if allocatable.Value() < backendRequestGb {
+			if allocatable.Value() < backendRequestBytes {
 				continue
 			}
 			preselectNode = append(preselectNode, groupPair{
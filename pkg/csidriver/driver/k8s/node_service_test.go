@@ -0,0 +1,118 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package k8s
+
+import (
+	"context"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+
+	"github.com/carina-io/carina"
+	carinav1beta1 "github.com/carina-io/carina/api/v1beta1"
+)
+
+// fakeManager 只提供NodeService用到的client
+type fakeManager struct {
+	manager.Manager
+	client client.Client
+}
+
+func (m *fakeManager) GetClient() client.Client {
+	return m.client
+}
+
+func (m *fakeManager) GetAPIReader() client.Reader {
+	return m.client
+}
+
+func newTestNodeService(t *testing.T, objs ...client.Object) *NodeService {
+	scheme := runtime.NewScheme()
+	assert.NoError(t, clientgoscheme.AddToScheme(scheme))
+	assert.NoError(t, carinav1beta1.AddToScheme(scheme))
+	cli := fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).Build()
+	return NewNodeService(&fakeManager{client: cli}, nil)
+}
+
+func TestThinCapacity(t *testing.T) {
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node1", Labels: map[string]string{corev1.LabelHostname: "node1"}},
+		Status:     corev1.NodeStatus{Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}}},
+	}
+	nsr := &carinav1beta1.NodeStorageResource{
+		ObjectMeta: metav1.ObjectMeta{Name: "node1"},
+		Status: carinav1beta1.NodeStorageResourceStatus{
+			Capacity: map[string]resource.Quantity{
+				carina.DeviceCapacityKeyPrefix + "carina-vg-ssd": resource.MustParse("60Gi"),
+				carina.ThinCapacityKeyPrefix + "carina-vg-ssd":   resource.MustParse("180Gi"),
+			},
+			Allocatable: map[string]resource.Quantity{
+				carina.DeviceCapacityKeyPrefix + "carina-vg-ssd": resource.MustParse("50Gi"),
+				carina.ThinCapacityKeyPrefix + "carina-vg-ssd":   resource.MustParse("150Gi"),
+			},
+		},
+	}
+	n := newTestNodeService(t, node, nsr)
+	requirement := &csi.TopologyRequirement{Requisite: []*csi.Topology{{Segments: map[string]string{corev1.LabelHostname: "node1"}}}}
+	ctx := context.Background()
+
+	cases := []struct {
+		name     string
+		request  int64
+		thin     bool
+		want     string
+		wantErr  error
+		capacity int64
+	}{
+		{name: "thick fits", request: 40 << 30, want: "carina-vg-ssd", capacity: 60 << 30},
+		{name: "thick exceeds allocatable", request: 100 << 30, wantErr: ErrNodeNotFound, capacity: 60 << 30},
+		// thin卷按超分后的容量计算
+		{name: "thin overcommit", request: 100 << 30, thin: true, want: "carina-vg-ssd", capacity: 180 << 30},
+		{name: "thin exceeds overcommit", request: 160 << 30, thin: true, wantErr: ErrNodeNotFound, capacity: 180 << 30},
+	}
+	for _, c := range cases {
+		group, err := n.SelectDeviceGroup(ctx, c.request, false, "node1", carina.LvmVolumeType, "", c.thin)
+		assert.Equal(t, c.wantErr, err, c.name)
+		assert.Equal(t, c.want, group, c.name)
+
+		nodeName, group, err := n.SelectNode(ctx, c.request, carina.LvmVolumeType, "", requirement, false, c.thin)
+		assert.Equal(t, c.wantErr, err, c.name)
+		assert.Equal(t, c.want, group, c.name)
+		if c.wantErr == nil {
+			assert.Equal(t, "node1", nodeName, c.name)
+		}
+
+		capacity, err := n.GetTotalCapacity(ctx, "", nil, false, c.thin)
+		assert.NoError(t, err, c.name)
+		assert.Equal(t, c.capacity, capacity, c.name)
+	}
+
+	capacity, err := n.GetThinCapacityByNodeName(ctx, "node1", "carina-vg-ssd")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(150<<30), capacity)
+	_, err = n.GetThinCapacityByNodeName(ctx, "node1", "carina-vg-hdd")
+	assert.Error(t, err)
+}
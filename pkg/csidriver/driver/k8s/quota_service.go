@@ -0,0 +1,116 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package k8s
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+
+	carinav1 "github.com/carina-io/carina/api/v1"
+	"github.com/carina-io/carina/pkg/quota"
+	"github.com/carina-io/carina/utils/log"
+)
+
+// QuotaService enforces CarinaStorageQuota when volumes are created or expanded.
+type QuotaService struct {
+	client.Client
+
+	// 已通过校验但LogicVolume尚未创建或扩容完成的用量，key为namespace/pvc
+	mu       sync.Mutex
+	reserved map[string]quota.GroupUsage
+}
+
+// +kubebuilder:rbac:groups=carina.storage.io,resources=carinastoragequotas,verbs=get;list;watch
+// +kubebuilder:rbac:groups=carina.storage.io,resources=logicvolumes,verbs=get;list;watch
+
+// NewQuotaService returns QuotaService.
+func NewQuotaService(mgr manager.Manager) *QuotaService {
+	return &QuotaService{
+		Client:   mgr.GetClient(),
+		reserved: map[string]quota.GroupUsage{},
+	}
+}
+
+// Reserve 校验pvc的卷占用charge后是否超出命名空间的配额，通过后预留该用量直至调用release，
+// 预留期间其他pvc的校验计入预留的用量，避免并发创建超出配额。pvc已有的LogicVolume不计入已用量，
+// charge需包含pvc全部卷的用量，因此重试CreateVolume以及扩容时不会重复计算
+func (s *QuotaService) Reserve(ctx context.Context, namespace, pvc string, charge quota.GroupUsage) (func(), error) {
+	quotaList := new(carinav1.CarinaStorageQuotaList)
+	if err := s.List(ctx, quotaList, client.InNamespace(namespace)); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if len(quotaList.Items) == 0 {
+		return func() {}, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	lvList := new(carinav1.LogicVolumeList)
+	if err := s.List(ctx, lvList); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	used := quota.NamespaceUsage(namespace, lvList.Items, func(lv *carinav1.LogicVolume) bool {
+		_, reserved := s.reserved[namespace+"/"+lv.Spec.Pvc]
+		return lv.Spec.Pvc == pvc || reserved
+	})
+	for key, usage := range s.reserved {
+		if key != namespace+"/"+pvc && strings.HasPrefix(key, namespace+"/") {
+			used.Merge(usage)
+		}
+	}
+
+	if err := quota.Check(quotaList.Items, used, charge); err != nil {
+		log.Warnf("reject volume of pvc %s/%s: %s", namespace, pvc, err.Error())
+		return nil, status.Error(codes.ResourceExhausted, err.Error())
+	}
+
+	key := namespace + "/" + pvc
+	s.reserved[key] = charge
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(s.reserved, key)
+	}, nil
+}
+
+// ReserveExpansion 校验卷扩容至size后是否超出配额，bcache卷的缓存卷按比例同时扩容
+func (s *QuotaService) ReserveExpansion(ctx context.Context, lv *carinav1.LogicVolume, size int64) (func(), error) {
+	lvList := new(carinav1.LogicVolumeList)
+	if err := s.List(ctx, lvList); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return s.Reserve(ctx, lv.Spec.NameSpace, lv.Spec.Pvc, quota.ExpansionCharge(lv, OwnedLogicVolumes(lv, lvList.Items), size))
+}
+
+// OwnedLogicVolumes 返回属于lv的LogicVolume，例如bcache卷的缓存卷
+func OwnedLogicVolumes(lv *carinav1.LogicVolume, lvs []carinav1.LogicVolume) []carinav1.LogicVolume {
+	var owned []carinav1.LogicVolume
+	for _, candidate := range lvs {
+		if metav1.IsControlledBy(&candidate, lv) {
+			owned = append(owned, candidate)
+		}
+	}
+	return owned
+}
@@ -107,6 +107,18 @@ func (s *nodeService) NodePublishVolume(ctx context.Context, req *csi.NodePublis
 	}
 	defer s.mutex.Release(volumeID)
 
+	// 文件系统卷已在NodeStageVolume中挂载到staging路径，这里只需bind mount
+	if isFsVol && req.GetStagingTargetPath() != "" {
+		return s.nodePublishStagedVolume(req)
+	}
+
+	if volumeContext[carina.VolumeEncryption] == "true" {
+		if isFsVol {
+			return nil, status.Errorf(codes.FailedPrecondition, "encrypted volume %s must be staged before publish", volumeID)
+		}
+		return s.nodePublishEncryptedBlockVolume(ctx, req)
+	}
+
 	cacheVolumeId := volumeContext[carina.VolumeCacheId]
 	if cacheVolumeId != "" {
 		return s.nodePublishBcacheVolume(ctx, req)
@@ -118,9 +130,13 @@ func (s *nodeService) NodePublishVolume(ctx context.Context, req *csi.NodePublis
 	if err != nil {
 		return nil, err
 	}
+	// 备份数据写入完成前卷不能被使用
+	if restore, ok := lvr.Annotations[carina.RestoreAnnotation]; ok {
+		return nil, status.Errorf(codes.Unavailable, "volume %s is being restored by %s", volumeID, restore)
+	}
 	switch lvr.Annotations[carina.VolumeManagerType] {
 	case carina.LvmVolumeType:
-		lv, err = s.getLvFromContext(lvr.Spec.DeviceGroup, volumeID)
+		lv, err = s.getLvFromContext(ctx, lvr.Spec.DeviceGroup, volumeID)
 		if err != nil {
 			return nil, err
 		}
@@ -138,11 +154,11 @@ func (s *nodeService) NodePublishVolume(ctx context.Context, req *csi.NodePublis
 			return nil, err
 		}
 	case carina.RawVolumeType:
-		partition, err := s.getPartitionFromContext(lvr.Spec.DeviceGroup, volumeID)
+		partition, err := s.getPartitionFromContext(ctx, lvr.Spec.DeviceGroup, volumeID)
 		if err != nil {
 			return nil, err
 		}
-		disk, err := s.dm.Partition.ScanDisk(lvr.Spec.DeviceGroup)
+		disk, err := s.dm.Partition.WithContext(ctx).ScanDisk(lvr.Spec.DeviceGroup)
 		if err != nil {
 			return nil, err
 		}
@@ -168,6 +184,292 @@ func (s *nodeService) NodePublishVolume(ctx context.Context, req *csi.NodePublis
 	return &csi.NodePublishVolumeResponse{}, nil
 }
 
+func (s *nodeService) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRequest) (*csi.NodeStageVolumeResponse, error) {
+	volumeID := req.GetVolumeId()
+	stagingPath := req.GetStagingTargetPath()
+
+	log.Info("NodeStageVolume called",
+		" volume_id ", volumeID,
+		" publish_context ", req.GetPublishContext(),
+		" staging_target_path ", stagingPath,
+		" volume_capability ", req.GetVolumeCapability(),
+		" num_secrets ", len(req.GetSecrets()),
+		" volume_context ", req.GetVolumeContext())
+
+	if len(volumeID) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "no volume_id is provided")
+	}
+	if len(stagingPath) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "no staging_target_path is provided")
+	}
+	if req.GetVolumeCapability() == nil {
+		return nil, status.Error(codes.InvalidArgument, "no volume_capability is provided")
+	}
+
+	// 块设备在NodePublishVolume中直接创建设备文件，无需staging
+	if req.GetVolumeCapability().GetBlock() != nil {
+		return &csi.NodeStageVolumeResponse{}, nil
+	}
+	mountOption := req.GetVolumeCapability().GetMount()
+	if mountOption == nil {
+		return nil, status.Errorf(codes.InvalidArgument, "no supported volume capability: %v", req.GetVolumeCapability())
+	}
+	if mountOption.FsType == "" {
+		mountOption.FsType = "ext4"
+	}
+	accessMode := req.GetVolumeCapability().GetAccessMode().GetMode()
+	if accessMode != csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER {
+		modeName := csi.VolumeCapability_AccessMode_Mode_name[int32(accessMode)]
+		return nil, status.Errorf(codes.FailedPrecondition, "unsupported access mode: %s", modeName)
+	}
+
+	if acquired := s.mutex.TryAcquire(volumeID); !acquired {
+		log.Warnf("An stage operation with the given volume %s already exists", volumeID)
+		return nil, status.Errorf(codes.Aborted, "an stage operation with the given volume %s already exists", volumeID)
+	}
+	defer s.mutex.Release(volumeID)
+
+	device, err := s.getStageDevice(ctx, volumeID, req.GetVolumeContext())
+	if err != nil {
+		return nil, err
+	}
+	if req.GetVolumeContext()[carina.VolumeEncryption] == "true" {
+		device, err = s.openEncryptedDevice(volumeID, device, req.GetSecrets())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = os.MkdirAll(stagingPath, 0755)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "mkdir failed: target=%s, error=%v", stagingPath, err)
+	}
+
+	fsType, err := filesystem.DetectFilesystem(device)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "filesystem check failed: volume=%s, error=%v", volumeID, err)
+	}
+
+	if fsType != "" && fsType != mountOption.FsType {
+		return nil, status.Errorf(codes.Internal, "target device is already formatted with different filesystem: volume=%s, current=%s, new:%s", volumeID, fsType, mountOption.FsType)
+	}
+
+	mountOptions := append([]string{}, mountOption.MountFlags...)
+	if mountOption.FsType == "xfs" {
+		mountOptions = append(mountOptions, "nouuid")
+	}
+
+	notMounted, err := s.mounter.IsLikelyNotMountPoint(stagingPath)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "mount check failed: target=%s, error=%v", stagingPath, err)
+	}
+
+	if notMounted {
+		log.Infof("mount %s %s %s %s", device, stagingPath, mountOption.FsType, strings.Join(mountOptions, ","))
+		if err := s.mounter.FormatAndMount(device, stagingPath, mountOption.FsType, mountOptions); err != nil {
+			return nil, status.Errorf(codes.Internal, "format and mount failed: volume=%s, device=%s, error=%v", volumeID, device, err)
+		}
+		if err := os.Chmod(stagingPath, 0777|os.ModeSetgid); err != nil {
+			return nil, status.Errorf(codes.Internal, "chmod 2777 failed: target=%s, error=%v", stagingPath, err)
+		}
+		// 已有文件系统的卷(克隆、bcache、离线扩容)需扩展文件系统至设备大小
+		if fsType != "" {
+			r := filesystem.NewResizeFs(&s.mounter)
+			if _, err := r.Resize(device, stagingPath); err != nil {
+				return nil, status.Errorf(codes.Internal, "failed to resize filesystem %s (mounted at: %s): %v", volumeID, stagingPath, err)
+			}
+		}
+	}
+
+	log.Info("NodeStageVolume(fs) succeeded",
+		" volume_id ", volumeID,
+		" staging_target_path ", stagingPath,
+		" fstype ", mountOption.FsType)
+
+	return &csi.NodeStageVolumeResponse{}, nil
+}
+
+func (s *nodeService) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstageVolumeRequest) (*csi.NodeUnstageVolumeResponse, error) {
+	volumeID := req.GetVolumeId()
+	stagingPath := req.GetStagingTargetPath()
+	log.Info("NodeUnstageVolume called",
+		" volume_id ", volumeID,
+		" staging_target_path ", stagingPath)
+
+	if len(volumeID) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "no volume_id is provided")
+	}
+	if len(stagingPath) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "no staging_target_path is provided")
+	}
+
+	if acquired := s.mutex.TryAcquire(volumeID); !acquired {
+		log.Warnf("An unstage operation with the given volume %s already exists", volumeID)
+		return nil, status.Errorf(codes.Aborted, "an unstage operation with the given volume %s already exists", volumeID)
+	}
+	defer s.mutex.Release(volumeID)
+
+	notMounted, err := s.mounter.IsLikelyNotMountPoint(stagingPath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, status.Errorf(codes.Internal, "mount check failed: target=%s, error=%v", stagingPath, err)
+	}
+	if err == nil && !notMounted {
+		if err := s.mounter.Unmount(stagingPath); err != nil {
+			return nil, status.Errorf(codes.Internal, "unmount failed for %s: error=%v", stagingPath, err)
+		}
+	}
+	if err := os.Remove(stagingPath); err != nil && !os.IsNotExist(err) {
+		return nil, status.Errorf(codes.Internal, "remove dir failed for %s: error=%v", stagingPath, err)
+	}
+
+	// 清理设备文件以及bcache设备
+	lvr, err := s.k8sLVService.GetLogicVolumeByVolumeId(ctx, volumeID)
+	if err != nil {
+		if err == k8s.ErrVolumeNotFound {
+			return &csi.NodeUnstageVolumeResponse{}, nil
+		}
+		return nil, err
+	}
+	if lvr.Annotations[carina.VolumeEncryption] == "true" {
+		if err := s.closeEncryptedDevice(volumeID); err != nil {
+			return nil, err
+		}
+	}
+	if bcacheDevice, err := s.getBcacheDevice(ctx, volumeID); err == nil && bcacheDevice != nil {
+		if mounted, _ := filesystem.IsDeviceMounted(bcacheDevice.BcachePath); !mounted {
+			if err := s.dm.VolumeManager.WithContext(ctx).DeleteBcache(bcacheDevice.DevicePath, ""); err != nil {
+				return nil, status.Errorf(codes.Internal, "remove device failed for %s: error=%v", bcacheDevice.BcachePath, err)
+			}
+		}
+	} else if lvr.Annotations[carina.VolumeManagerType] == carina.LvmVolumeType {
+		device := filepath.Join(DeviceDirectory, volumeID)
+		if mounted, _ := filesystem.IsDeviceMounted(device); !mounted {
+			if err := os.Remove(device); err != nil && !os.IsNotExist(err) {
+				return nil, status.Errorf(codes.Internal, "remove device failed for %s: error=%v", device, err)
+			}
+		}
+	}
+
+	log.Info("NodeUnstageVolume is succeeded",
+		" volume_id ", volumeID,
+		" staging_target_path ", stagingPath)
+	return &csi.NodeUnstageVolumeResponse{}, nil
+}
+
+// getStageDevice 返回卷对应的块设备路径，lvm卷以及raw分区会创建设备文件，bcache卷会创建bcache设备
+func (s *nodeService) getStageDevice(ctx context.Context, volumeID string, volumeContext map[string]string) (string, error) {
+	if volumeContext[carina.VolumeCacheId] != "" {
+		backendDevice := volumeContext[carina.VolumeDevicePath]
+		cacheDevice := volumeContext[carina.VolumeCacheDevicePath]
+		if backendDevice == "" || cacheDevice == "" {
+			return "", status.Errorf(codes.FailedPrecondition, "carina.storage.io/path %s carina.storage.io/cache/path %s, can not be empty", backendDevice, cacheDevice)
+		}
+		cacheDeviceInfo, err := s.dm.VolumeManager.WithContext(ctx).CreateBcache(backendDevice, cacheDevice, volumeContext[carina.VolumeCacheBlock], volumeContext[carina.VolumeCacheBucket], volumeContext[carina.VolumeCachePolicy])
+		if err != nil {
+			return "", err
+		}
+		return cacheDeviceInfo.BcachePath, nil
+	}
+
+	lvr, err := s.k8sLVService.GetLogicVolumeByVolumeId(ctx, volumeID)
+	if err != nil {
+		return "", err
+	}
+	if restore, ok := lvr.Annotations[carina.RestoreAnnotation]; ok {
+		return "", status.Errorf(codes.Unavailable, "volume %s is being restored by %s", volumeID, restore)
+	}
+	switch lvr.Annotations[carina.VolumeManagerType] {
+	case carina.LvmVolumeType:
+		lv, err := s.getLvFromContext(ctx, lvr.Spec.DeviceGroup, volumeID)
+		if err != nil {
+			return "", err
+		}
+		if lv == nil {
+			return "", status.Errorf(codes.NotFound, "failed to find LV: %s", volumeID)
+		}
+		device := filepath.Join(DeviceDirectory, volumeID)
+		if err := s.createDeviceIfNeeded(device, lv.LVKernelMajor, lv.LVKernelMinor); err != nil {
+			return "", err
+		}
+		return device, nil
+	case carina.RawVolumeType:
+		partition, err := s.getPartitionFromContext(ctx, lvr.Spec.DeviceGroup, volumeID)
+		if err != nil {
+			return "", err
+		}
+		if partition.Name == "" {
+			return "", status.Errorf(codes.NotFound, "failed to find partition: %s", utils.PartitionName(volumeID))
+		}
+		disk, err := s.dm.Partition.WithContext(ctx).ScanDisk(lvr.Spec.DeviceGroup)
+		if err != nil {
+			return "", err
+		}
+		device := linux.GetPartitionKname(disk.Path, partition.Number)
+		partinfo, err := linux.GetUdevInfo(device)
+		if err != nil {
+			return "", status.Errorf(codes.Internal, "failed to get partinfo %s", err)
+		}
+		major, _ := strconv.ParseUint(partinfo.Properties["MAJOR"], 10, 32)
+		minor, _ := strconv.ParseUint(partinfo.Properties["MINOR"], 10, 32)
+		if err := s.createDeviceIfNeeded(device, uint32(major), uint32(minor)); err != nil {
+			return "", err
+		}
+		return device, nil
+	default:
+		log.Errorf("Create LogicVolume: Create with no support volume type undefined")
+		return "", status.Errorf(codes.InvalidArgument, "Create with no support type ")
+	}
+}
+
+func (s *nodeService) nodePublishStagedVolume(req *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) {
+	stagingPath := req.GetStagingTargetPath()
+	target := req.GetTargetPath()
+
+	notMounted, err := s.mounter.IsLikelyNotMountPoint(stagingPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, status.Errorf(codes.FailedPrecondition, "volume %s is not staged at %s", req.GetVolumeId(), stagingPath)
+		}
+		return nil, status.Errorf(codes.Internal, "mount check failed: target=%s, error=%v", stagingPath, err)
+	}
+	if notMounted {
+		return nil, status.Errorf(codes.FailedPrecondition, "volume %s is not staged at %s", req.GetVolumeId(), stagingPath)
+	}
+
+	mountOptions := []string{"bind"}
+	if req.GetReadonly() {
+		mountOptions = append(mountOptions, "ro")
+	}
+	for _, m := range req.GetVolumeCapability().GetMount().GetMountFlags() {
+		if m == "rw" && req.GetReadonly() {
+			return nil, status.Error(codes.InvalidArgument, "mount option \"rw\" is specified even though read only mode is specified")
+		}
+	}
+
+	err = os.MkdirAll(target, 0755)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "mkdir failed: target=%s, error=%v", target, err)
+	}
+
+	notMounted, err = s.mounter.IsLikelyNotMountPoint(target)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "mount check failed: target=%s, error=%v", target, err)
+	}
+	if notMounted {
+		log.Infof("mount %s %s %s", stagingPath, target, strings.Join(mountOptions, ","))
+		if err := s.mounter.Mount(stagingPath, target, "", mountOptions); err != nil {
+			return nil, status.Errorf(codes.Internal, "bind mount failed: volume=%s, error=%v", req.GetVolumeId(), err)
+		}
+	}
+
+	log.Info("NodePublishVolume(fs) succeeded",
+		" volume_id ", req.GetVolumeId(),
+		" staging_target_path ", stagingPath,
+		" target_path ", target)
+
+	return &csi.NodePublishVolumeResponse{}, nil
+}
+
 func (s *nodeService) nodePublishLvmBlockVolume(req *csi.NodePublishVolumeRequest, lv *types.LvInfo) (*csi.NodePublishVolumeResponse, error) {
 	// Find lv and create a block device with it
 	var stat unix.Stat_t
@@ -317,6 +619,13 @@ func (s *nodeService) nodePublishLvmFilesystemVolume(req *csi.NodePublishVolumeR
 		if err := os.Chmod(req.GetTargetPath(), 0777|os.ModeSetgid); err != nil {
 			return nil, status.Errorf(codes.Internal, "chmod 2777 failed: target=%s, error=%v", req.GetTargetPath(), err)
 		}
+		// 从快照或已有卷创建的卷可能大于源卷，需扩展文件系统
+		if fsType != "" && req.GetVolumeContext()[carina.VolumeDataSourceKind] != "" {
+			r := filesystem.NewResizeFs(&s.mounter)
+			if _, err := r.Resize(device, req.GetTargetPath()); err != nil {
+				return nil, status.Errorf(codes.Internal, "failed to resize filesystem %s (mounted at: %s): %v", req.GetVolumeId(), req.GetTargetPath(), err)
+			}
+		}
 	}
 
 	log.Info("NodePublishVolume(fs) succeeded",
@@ -401,6 +710,13 @@ func (s *nodeService) nodePublishRawFilesystemVolume(req *csi.NodePublishVolumeR
 		if err := os.Chmod(req.GetTargetPath(), 0777|os.ModeSetgid); err != nil {
 			return nil, status.Errorf(codes.Internal, "chmod 2777 failed: target=%s, error=%v", req.GetTargetPath(), err)
 		}
+		// 从快照或已有卷创建的卷可能大于源卷，需扩展文件系统
+		if fsType != "" && req.GetVolumeContext()[carina.VolumeDataSourceKind] != "" {
+			r := filesystem.NewResizeFs(&s.mounter)
+			if _, err := r.Resize(device, req.GetTargetPath()); err != nil {
+				return nil, status.Errorf(codes.Internal, "failed to resize filesystem %s (mounted at: %s): %v", req.GetVolumeId(), req.GetTargetPath(), err)
+			}
+		}
 	}
 
 	log.Info("NodePublishVolume(fs) succeeded",
@@ -411,6 +727,103 @@ func (s *nodeService) nodePublishRawFilesystemVolume(req *csi.NodePublishVolumeR
 	return &csi.NodePublishVolumeResponse{}, nil
 }
 
+func (s *nodeService) nodePublishEncryptedBlockVolume(ctx context.Context, req *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) {
+	device, err := s.getStageDevice(ctx, req.GetVolumeId(), req.GetVolumeContext())
+	if err != nil {
+		return nil, err
+	}
+	mapper, err := s.openEncryptedDevice(req.GetVolumeId(), device, req.GetSecrets())
+	if err != nil {
+		return nil, err
+	}
+
+	var mapperStat unix.Stat_t
+	if err := filesystem.Stat(mapper, &mapperStat); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to stat %s: %v", mapper, err)
+	}
+
+	var stat unix.Stat_t
+	target := req.GetTargetPath()
+	err = filesystem.Stat(target, &stat)
+	switch err {
+	case nil:
+		if stat.Rdev == mapperStat.Rdev && stat.Mode&devicePermission == devicePermission {
+			return &csi.NodePublishVolumeResponse{}, nil
+		}
+		if err := os.Remove(target); err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to remove %s", target)
+		}
+	case unix.ENOENT:
+	default:
+		return nil, status.Errorf(codes.Internal, "failed to stat: %v", err)
+	}
+
+	err = os.MkdirAll(path.Dir(target), 0755)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "mkdir failed: target=%s, error=%v", path.Dir(target), err)
+	}
+
+	if err := filesystem.Mknod(target, devicePermission, int(mapperStat.Rdev)); err != nil {
+		return nil, status.Errorf(codes.Internal, "mknod failed for %s: error=%v", target, err)
+	}
+
+	log.Info("NodePublishVolume(block) succeeded",
+		" volume_id ", req.GetVolumeId(),
+		" target_path ", target,
+		" encrypted ", true)
+	return &csi.NodePublishVolumeResponse{}, nil
+}
+
+// openEncryptedDevice 加密卷首次使用时格式化为LUKS，然后打开映射并返回映射设备路径
+// secret中同时提供新旧密钥时，使用旧密钥解锁并替换为新密钥，完成密钥轮换
+func (s *nodeService) openEncryptedDevice(volumeID, device string, secrets map[string]string) (string, error) {
+	passphrase := secrets[carina.EncryptionPassphraseKey]
+	if passphrase == "" {
+		return "", status.Errorf(codes.InvalidArgument, "encrypted volume %s requires secret key %s", volumeID, carina.EncryptionPassphraseKey)
+	}
+	name := carina.LuksPrefix + volumeID
+
+	if !s.dm.Crypt.IsLuks(device) {
+		fsType, err := filesystem.DetectFilesystem(device)
+		if err != nil {
+			return "", status.Errorf(codes.Internal, "filesystem check failed: volume=%s, error=%v", volumeID, err)
+		}
+		// 避免误格式化已有数据的设备
+		if fsType != "" {
+			return "", status.Errorf(codes.FailedPrecondition, "device %s of volume %s already contains %s, refuse to encrypt", device, volumeID, fsType)
+		}
+		log.Infof("luksFormat device %s of volume %s", device, volumeID)
+		if err := s.dm.Crypt.Format(device, passphrase); err != nil {
+			return "", status.Errorf(codes.Internal, "luksFormat failed: volume=%s, device=%s, error=%v", volumeID, device, err)
+		}
+	}
+
+	if !s.dm.Crypt.CheckPassphrase(device, passphrase) {
+		previous := secrets[carina.EncryptionPreviousPassphraseKey]
+		if previous == "" || !s.dm.Crypt.CheckPassphrase(device, previous) {
+			return "", status.Errorf(codes.PermissionDenied, "passphrase can not unlock encrypted volume %s", volumeID)
+		}
+		log.Infof("rotate passphrase of encrypted volume %s", volumeID)
+		if err := s.dm.Crypt.ChangePassphrase(device, previous, passphrase); err != nil {
+			return "", status.Errorf(codes.Internal, "failed to rotate passphrase of volume %s: %v", volumeID, err)
+		}
+	}
+
+	if !s.dm.Crypt.IsOpen(name) {
+		if err := s.dm.Crypt.Open(device, name, passphrase); err != nil {
+			return "", status.Errorf(codes.Internal, "luksOpen failed: volume=%s, device=%s, error=%v", volumeID, device, err)
+		}
+	}
+	return s.dm.Crypt.MapperPath(name), nil
+}
+
+func (s *nodeService) closeEncryptedDevice(volumeID string) error {
+	if err := s.dm.Crypt.Close(carina.LuksPrefix + volumeID); err != nil {
+		return status.Errorf(codes.Internal, "luksClose failed: volume=%s, error=%v", volumeID, err)
+	}
+	return nil
+}
+
 func (s *nodeService) createDeviceIfNeeded(device string, major, minor uint32) error {
 	var stat unix.Stat_t
 	err := filesystem.Stat(device, &stat)
@@ -467,11 +880,11 @@ func (s *nodeService) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpu
 	case carina.LvmVolumeType:
 		device = filepath.Join(DeviceDirectory, volID)
 	case carina.RawVolumeType:
-		partition, err := s.getPartitionFromContext(lvr.Spec.DeviceGroup, volID)
+		partition, err := s.getPartitionFromContext(ctx, lvr.Spec.DeviceGroup, volID)
 		if err != nil {
 			return nil, err
 		}
-		disk, err := s.dm.Partition.ScanDisk(lvr.Spec.DeviceGroup)
+		disk, err := s.dm.Partition.WithContext(ctx).ScanDisk(lvr.Spec.DeviceGroup)
 		if err != nil {
 			return nil, err
 		}
@@ -482,17 +895,29 @@ func (s *nodeService) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpu
 		return nil, status.Errorf(codes.InvalidArgument, "Create with no support type ")
 	}
 
-	bcacheDevice, err := s.getBcacheDevice(volID)
+	bcacheDevice, err := s.getBcacheDevice(ctx, volID)
 	if err == nil && bcacheDevice != nil {
 		device = bcacheDevice.BcachePath
 		backendDevice = bcacheDevice.DevicePath
 		log.Infof("bcache volume cache device %s backend device %s", device, backendDevice)
 	}
 
+	encrypted := lvr.Annotations[carina.VolumeEncryption] == "true"
+	mapper := s.dm.Crypt.MapperPath(carina.LuksPrefix + volID)
+
 	info, err := os.Stat(target)
 	if os.IsNotExist(err) {
+		if encrypted {
+			// 文件系统卷的映射仍挂载在staging路径，由NodeUnstageVolume关闭
+			if mounted, _ := filesystem.IsDeviceMounted(mapper); mounted {
+				return &csi.NodeUnpublishVolumeResponse{}, nil
+			}
+			if err := s.closeEncryptedDevice(volID); err != nil {
+				return nil, err
+			}
+		}
 		if backendDevice != "" {
-			_ = s.dm.VolumeManager.DeleteBcache(backendDevice, "")
+			_ = s.dm.VolumeManager.WithContext(ctx).DeleteBcache(backendDevice, "")
 		}
 		// target_path does not exist, but device for mount-type PV may still exist.
 		_ = os.Remove(device)
@@ -501,10 +926,20 @@ func (s *nodeService) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpu
 		return nil, status.Errorf(codes.Internal, "stat failed for %s: %v", target, err)
 	}
 
+	if encrypted {
+		if info.IsDir() {
+			return s.nodeUnpublishEncryptedFilesystemVolume(req, mapper)
+		}
+		// 块设备卷在NodePublishVolume中打开映射，这里关闭后再清理底层设备
+		if err := s.closeEncryptedDevice(volID); err != nil {
+			return nil, err
+		}
+	}
+
 	// remove device file if target_path is device, unmount target_path otherwise
 	if info.IsDir() {
 		if backendDevice != "" {
-			unpublishResp, err := s.nodeUnpublishBFileSystemCacheVolume(req, device, backendDevice)
+			unpublishResp, err := s.nodeUnpublishBFileSystemCacheVolume(ctx, req, device, backendDevice)
 			if err != nil {
 				return unpublishResp, err
 			}
@@ -517,7 +952,7 @@ func (s *nodeService) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpu
 		return &csi.NodeUnpublishVolumeResponse{}, nil
 	}
 	if backendDevice != "" {
-		return s.nodeUnpublishBlockCacheVolume(req, device, backendDevice)
+		return s.nodeUnpublishBlockCacheVolume(ctx, req, device, backendDevice)
 	}
 	return s.nodeUnpublishBlockVolume(req, device)
 }
@@ -536,6 +971,13 @@ func (s *nodeService) nodeUnpublishFilesystemVolume(req *csi.NodeUnpublishVolume
 	if err := os.RemoveAll(target); err != nil {
 		return nil, status.Errorf(codes.Internal, "remove dir failed for %s: error=%v", target, err)
 	}
+	// 设备仍挂载在staging路径时，由NodeUnstageVolume清理
+	if staged, _ := filesystem.IsDeviceMounted(device); staged {
+		log.Info("NodeUnpublishVolume(fs) is succeeded",
+			" volume_id ", req.GetVolumeId(),
+			" target_path ", target)
+		return &csi.NodeUnpublishVolumeResponse{}, nil
+	}
 	err = os.Remove(device)
 	if err != nil && !os.IsNotExist(err) {
 		return nil, status.Errorf(codes.Internal, "remove device failed for %s: error=%v", device, err)
@@ -546,6 +988,26 @@ func (s *nodeService) nodeUnpublishFilesystemVolume(req *csi.NodeUnpublishVolume
 	return &csi.NodeUnpublishVolumeResponse{}, nil
 }
 
+func (s *nodeService) nodeUnpublishEncryptedFilesystemVolume(req *csi.NodeUnpublishVolumeRequest, mapper string) (*csi.NodeUnpublishVolumeResponse, error) {
+	target := req.GetTargetPath()
+	mounted, err := filesystem.IsMounted(mapper, target)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "mount check failed: target=%s, error=%v", target, err)
+	}
+	if mounted {
+		if err := s.mounter.Unmount(target); err != nil {
+			return nil, status.Errorf(codes.Internal, "unmount failed for %s: error=%v", target, err)
+		}
+	}
+	if err := os.RemoveAll(target); err != nil {
+		return nil, status.Errorf(codes.Internal, "remove dir failed for %s: error=%v", target, err)
+	}
+	log.Info("NodeUnpublishVolume(fs) is succeeded",
+		" volume_id ", req.GetVolumeId(),
+		" target_path ", target)
+	return &csi.NodeUnpublishVolumeResponse{}, nil
+}
+
 func (s *nodeService) nodeUnpublishBlockVolume(req *csi.NodeUnpublishVolumeRequest, device string) (*csi.NodeUnpublishVolumeResponse, error) {
 	if err := os.Remove(req.GetTargetPath()); err != nil {
 		return nil, status.Errorf(codes.Internal, "remove failed for %s: error=%v", req.GetTargetPath(), err)
@@ -557,7 +1019,7 @@ func (s *nodeService) nodeUnpublishBlockVolume(req *csi.NodeUnpublishVolumeReque
 	return &csi.NodeUnpublishVolumeResponse{}, nil
 }
 
-func (s *nodeService) nodeUnpublishBFileSystemCacheVolume(req *csi.NodeUnpublishVolumeRequest, device, backendDevice string) (*csi.NodeUnpublishVolumeResponse, error) {
+func (s *nodeService) nodeUnpublishBFileSystemCacheVolume(ctx context.Context, req *csi.NodeUnpublishVolumeRequest, device, backendDevice string) (*csi.NodeUnpublishVolumeResponse, error) {
 	target := req.GetTargetPath()
 	mounted, err := filesystem.IsMounted(device, target)
 	if err != nil {
@@ -571,8 +1033,15 @@ func (s *nodeService) nodeUnpublishBFileSystemCacheVolume(req *csi.NodeUnpublish
 	if err := os.RemoveAll(target); err != nil {
 		return nil, status.Errorf(codes.Internal, "remove dir failed for %s: error=%v", target, err)
 	}
+	// 设备仍挂载在staging路径时，由NodeUnstageVolume清理
+	if staged, _ := filesystem.IsDeviceMounted(device); staged {
+		log.Info("NodeUnpublishVolume(fs) is succeeded",
+			" volume_id ", req.GetVolumeId(),
+			" target_path ", target)
+		return &csi.NodeUnpublishVolumeResponse{}, nil
+	}
 	// delete bcache device
-	err = s.dm.VolumeManager.DeleteBcache(backendDevice, "")
+	err = s.dm.VolumeManager.WithContext(ctx).DeleteBcache(backendDevice, "")
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "remove device failed for %s: error=%v", device, err)
 	}
@@ -582,12 +1051,12 @@ func (s *nodeService) nodeUnpublishBFileSystemCacheVolume(req *csi.NodeUnpublish
 	return &csi.NodeUnpublishVolumeResponse{}, nil
 }
 
-func (s *nodeService) nodeUnpublishBlockCacheVolume(req *csi.NodeUnpublishVolumeRequest, device, backendDevice string) (*csi.NodeUnpublishVolumeResponse, error) {
+func (s *nodeService) nodeUnpublishBlockCacheVolume(ctx context.Context, req *csi.NodeUnpublishVolumeRequest, device, backendDevice string) (*csi.NodeUnpublishVolumeResponse, error) {
 	if err := os.Remove(req.GetTargetPath()); err != nil {
 		return nil, status.Errorf(codes.Internal, "remove failed for %s: error=%v", req.GetTargetPath(), err)
 	}
 	// delete bcache device
-	err := s.dm.VolumeManager.DeleteBcache(backendDevice, "")
+	err := s.dm.VolumeManager.WithContext(ctx).DeleteBcache(backendDevice, "")
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "remove device failed for %s: error=%v", device, err)
 	}
@@ -697,7 +1166,13 @@ func (s *nodeService) NodeExpandVolume(ctx context.Context, req *csi.NodeExpandV
 	}
 
 	isBlock := !info.IsDir()
+	luksName := carina.LuksPrefix + vid
 	if isBlock {
+		if s.dm.Crypt.IsOpen(luksName) {
+			if err := s.dm.Crypt.Resize(luksName, req.GetSecrets()[carina.EncryptionPassphraseKey]); err != nil {
+				return nil, status.Errorf(codes.Internal, "failed to resize encrypted device %s: %v", vid, err)
+			}
+		}
 		log.Info("NodeExpandVolume(block) is skipped",
 			" volume_id ", vid,
 			" target_path ", vpath,
@@ -712,7 +1187,7 @@ func (s *nodeService) NodeExpandVolume(ctx context.Context, req *csi.NodeExpandV
 	}
 	switch lvr.Annotations[carina.VolumeManagerType] {
 	case carina.LvmVolumeType:
-		lv, err := s.getLvFromContext(lvr.Spec.DeviceGroup, vid)
+		lv, err := s.getLvFromContext(ctx, lvr.Spec.DeviceGroup, vid)
 		if err != nil {
 			return nil, err
 		}
@@ -725,14 +1200,14 @@ func (s *nodeService) NodeExpandVolume(ctx context.Context, req *csi.NodeExpandV
 			return nil, err
 		}
 	case carina.RawVolumeType:
-		partition, err := s.getPartitionFromContext(lvr.Spec.DeviceGroup, vid)
+		partition, err := s.getPartitionFromContext(ctx, lvr.Spec.DeviceGroup, vid)
 		if err != nil {
 			return nil, err
 		}
 		if partition.Name == "" {
 			return nil, status.Errorf(codes.NotFound, "failed to find partition: %s", vid)
 		}
-		disk, err := s.dm.Partition.ScanDisk(lvr.Spec.DeviceGroup)
+		disk, err := s.dm.Partition.WithContext(ctx).ScanDisk(lvr.Spec.DeviceGroup)
 		if err != nil {
 			return nil, err
 		}
@@ -794,6 +1269,14 @@ func (s *nodeService) NodeExpandVolume(ctx context.Context, req *csi.NodeExpandV
 	}
 	defer s.mutex.Release(vid)
 
+	// 加密卷需要先调整映射大小，再扩展映射上的文件系统
+	if s.dm.Crypt.IsOpen(luksName) {
+		if err := s.dm.Crypt.Resize(luksName, req.GetSecrets()[carina.EncryptionPassphraseKey]); err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to resize encrypted device %s: %v", vid, err)
+		}
+		device = s.dm.Crypt.MapperPath(luksName)
+	}
+
 	r := filesystem.NewResizeFs(&s.mounter)
 	if _, err := r.Resize(device, vpath); err != nil {
 		return nil, status.Errorf(codes.Internal, "failed to resize filesystem %s (mounted at: %s): %v", vid, vpath, err)
@@ -809,6 +1292,7 @@ func (s *nodeService) NodeExpandVolume(ctx context.Context, req *csi.NodeExpandV
 
 func (s *nodeService) NodeGetCapabilities(context.Context, *csi.NodeGetCapabilitiesRequest) (*csi.NodeGetCapabilitiesResponse, error) {
 	capabilities := []csi.NodeServiceCapability_RPC_Type{
+		csi.NodeServiceCapability_RPC_STAGE_UNSTAGE_VOLUME,
 		csi.NodeServiceCapability_RPC_GET_VOLUME_STATS,
 		csi.NodeServiceCapability_RPC_EXPAND_VOLUME,
 	}
@@ -841,8 +1325,8 @@ func (s *nodeService) NodeGetInfo(ctx context.Context, req *csi.NodeGetInfoReque
 	}, nil
 }
 
-func (s *nodeService) getLvFromContext(deviceGroup, volumeID string) (*types.LvInfo, error) {
-	lvs, err := s.dm.VolumeManager.VolumeList(volumeID, deviceGroup)
+func (s *nodeService) getLvFromContext(ctx context.Context, deviceGroup, volumeID string) (*types.LvInfo, error) {
+	lvs, err := s.dm.VolumeManager.WithContext(ctx).VolumeList(volumeID, deviceGroup)
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "failed to list lv :%v", err)
 	}
@@ -855,11 +1339,11 @@ func (s *nodeService) getLvFromContext(deviceGroup, volumeID string) (*types.LvI
 
 	return nil, errors.New("not found")
 }
-func (s *nodeService) getPartitionFromContext(deviceGroup, volumeID string) (disko.Partition, error) {
-	return s.dm.Partition.GetPartition(utils.PartitionName(volumeID), deviceGroup)
+func (s *nodeService) getPartitionFromContext(ctx context.Context, deviceGroup, volumeID string) (disko.Partition, error) {
+	return s.dm.Partition.WithContext(ctx).GetPartition(utils.PartitionName(volumeID), deviceGroup)
 }
 
-func (s *nodeService) getBcacheDevice(volumeID string) (*types.BcacheDeviceInfo, error) {
+func (s *nodeService) getBcacheDevice(ctx context.Context, volumeID string) (*types.BcacheDeviceInfo, error) {
 	currentDiskSelector := configuration.DiskSelector()
 	var diskClass = []string{}
 	for _, v := range currentDiskSelector {
@@ -872,7 +1356,7 @@ func (s *nodeService) getBcacheDevice(volumeID string) (*types.BcacheDeviceInfo,
 		devicePath := filepath.Join("/dev", d, volumeID)
 		_, err := os.Stat(devicePath)
 		if err == nil {
-			info, err := s.dm.VolumeManager.BcacheDeviceInfo(devicePath)
+			info, err := s.dm.VolumeManager.WithContext(ctx).BcacheDeviceInfo(devicePath)
 			if err != nil {
 				return nil, err
 			}
@@ -896,7 +1380,7 @@ func (s *nodeService) nodePublishBcacheVolume(ctx context.Context, req *csi.Node
 		return nil, status.Errorf(codes.FailedPrecondition, "carina.storage.io/path %s carina.storage.io/cache/path %s, can not be empty", backendDevice, cacheDevice)
 	}
 
-	cacheDeviceInfo, err := s.dm.VolumeManager.CreateBcache(backendDevice, cacheDevice, block, bucket, cachePolicy)
+	cacheDeviceInfo, err := s.dm.VolumeManager.WithContext(ctx).CreateBcache(backendDevice, cacheDevice, block, bucket, cachePolicy)
 	if err != nil {
 		return nil, err
 	}
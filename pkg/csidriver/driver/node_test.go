@@ -0,0 +1,354 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	mountutil "k8s.io/mount-utils"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/carina-io/carina"
+	carinav1 "github.com/carina-io/carina/api/v1"
+	deviceManager "github.com/carina-io/carina/pkg/devicemanager"
+	"github.com/carina-io/carina/pkg/devicemanager/crypt"
+	"github.com/carina-io/carina/utils/mutx"
+)
+
+// newTestNodeService 使用fake mounter，不执行真实的挂载
+func newTestNodeService(t *testing.T, mounter *mountutil.FakeMounter, objs ...client.Object) *nodeService {
+	cs, _ := newTestControllerService(t, objs...)
+	return &nodeService{
+		k8sLVService: cs.lvService,
+		mutex:        mutx.NewGlobalLocks(),
+		mounter:      mountutil.SafeFormatAndMount{Interface: mounter},
+	}
+}
+
+func testLogicVolume(annotations map[string]string) *carinav1.LogicVolume {
+	return &carinav1.LogicVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: "pvc-1", Annotations: annotations},
+		Spec:       carinav1.LogicVolumeSpec{NodeName: "node1", DeviceGroup: "carina-vg-ssd"},
+		Status:     carinav1.LogicVolumeStatus{VolumeID: "volume-pvc-1"},
+	}
+}
+
+func fsCapability(mode csi.VolumeCapability_AccessMode_Mode) *csi.VolumeCapability {
+	return &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{FsType: "xfs"}},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: mode},
+	}
+}
+
+func TestNodeStageVolume(t *testing.T) {
+	blockCapability := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+	}
+	cases := []struct {
+		name       string
+		volumeID   string
+		capability *csi.VolumeCapability
+		// busy 同一卷的其他操作正在进行
+		busy     bool
+		lv       *carinav1.LogicVolume
+		wantCode codes.Code
+	}{
+		{name: "no volume id", capability: fsCapability(csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER), wantCode: codes.InvalidArgument},
+		{name: "no capability", volumeID: "volume-pvc-1", wantCode: codes.InvalidArgument},
+		// 块设备在NodePublishVolume中处理
+		{name: "block", volumeID: "volume-pvc-1", capability: blockCapability},
+		{name: "multi node", volumeID: "volume-pvc-1", capability: fsCapability(csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER), wantCode: codes.FailedPrecondition},
+		{name: "busy", volumeID: "volume-pvc-1", capability: fsCapability(csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER), busy: true, wantCode: codes.Aborted},
+		{
+			name:       "restoring",
+			volumeID:   "volume-pvc-1",
+			capability: fsCapability(csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER),
+			lv:         testLogicVolume(map[string]string{carina.VolumeManagerType: carina.LvmVolumeType, carina.RestoreAnnotation: "backup-1"}),
+			wantCode:   codes.Unavailable,
+		},
+	}
+	for _, c := range cases {
+		var objs []client.Object
+		if c.lv != nil {
+			objs = append(objs, c.lv)
+		}
+		mounter := mountutil.NewFakeMounter(nil)
+		s := newTestNodeService(t, mounter, objs...)
+		if c.busy {
+			s.mutex.TryAcquire(c.volumeID)
+		}
+		stagingPath := filepath.Join(t.TempDir(), "globalmount")
+
+		_, err := s.NodeStageVolume(context.Background(), &csi.NodeStageVolumeRequest{
+			VolumeId:          c.volumeID,
+			StagingTargetPath: stagingPath,
+			VolumeCapability:  c.capability,
+		})
+		assert.Equal(t, c.wantCode, status.Code(err), c.name)
+		assert.Empty(t, mounter.GetLog(), c.name)
+	}
+}
+
+func TestNodeUnstageVolume(t *testing.T) {
+	cases := []struct {
+		name    string
+		mounted bool
+		// removed staging目录已删除，即重试的请求
+		removed     bool
+		busy        bool
+		lv          *carinav1.LogicVolume
+		wantUnmount bool
+		wantCode    codes.Code
+	}{
+		{name: "mounted", mounted: true, lv: testLogicVolume(map[string]string{carina.VolumeManagerType: carina.LvmVolumeType}), wantUnmount: true},
+		{name: "not mounted", lv: testLogicVolume(map[string]string{carina.VolumeManagerType: carina.LvmVolumeType})},
+		{name: "retry", removed: true, lv: testLogicVolume(map[string]string{carina.VolumeManagerType: carina.LvmVolumeType})},
+		// LogicVolume已删除时只清理挂载点
+		{name: "volume deleted", mounted: true, wantUnmount: true},
+		{name: "busy", mounted: true, busy: true, wantCode: codes.Aborted},
+	}
+	for _, c := range cases {
+		var objs []client.Object
+		if c.lv != nil {
+			objs = append(objs, c.lv)
+		}
+		stagingPath := filepath.Join(t.TempDir(), "globalmount")
+		var mountPoints []mountutil.MountPoint
+		if !c.removed {
+			assert.NoError(t, os.MkdirAll(stagingPath, 0755), c.name)
+		}
+		if c.mounted {
+			mountPoints = append(mountPoints, mountutil.MountPoint{Device: "/dev/carina/volume-pvc-1", Path: stagingPath, Type: "xfs"})
+		}
+		mounter := mountutil.NewFakeMounter(mountPoints)
+		s := newTestNodeService(t, mounter, objs...)
+		if c.busy {
+			s.mutex.TryAcquire("volume-pvc-1")
+		}
+
+		_, err := s.NodeUnstageVolume(context.Background(), &csi.NodeUnstageVolumeRequest{VolumeId: "volume-pvc-1", StagingTargetPath: stagingPath})
+		assert.Equal(t, c.wantCode, status.Code(err), c.name)
+		if c.wantUnmount {
+			assert.Equal(t, []mountutil.FakeAction{{Action: mountutil.FakeActionUnmount, Target: stagingPath}}, mounter.GetLog(), c.name)
+		} else {
+			assert.Empty(t, mounter.GetLog(), c.name)
+		}
+		if c.wantCode == codes.OK {
+			assert.NoDirExists(t, stagingPath, c.name)
+		}
+	}
+}
+
+func TestNodePublishStagedVolume(t *testing.T) {
+	cases := []struct {
+		name       string
+		staged     bool
+		readonly   bool
+		mountFlags []string
+		wantMount  []string
+		wantCode   codes.Code
+	}{
+		{name: "staged", staged: true, wantMount: []string{"bind"}},
+		{name: "readonly", staged: true, readonly: true, wantMount: []string{"bind", "ro"}},
+		{name: "not staged", wantCode: codes.FailedPrecondition},
+		{name: "rw with readonly", staged: true, readonly: true, mountFlags: []string{"rw"}, wantCode: codes.InvalidArgument},
+	}
+	for _, c := range cases {
+		dir := t.TempDir()
+		stagingPath := filepath.Join(dir, "globalmount")
+		targetPath := filepath.Join(dir, "mount")
+		assert.NoError(t, os.MkdirAll(stagingPath, 0755), c.name)
+		var mountPoints []mountutil.MountPoint
+		if c.staged {
+			mountPoints = append(mountPoints, mountutil.MountPoint{Device: "/dev/carina/volume-pvc-1", Path: stagingPath, Type: "xfs"})
+		}
+		mounter := mountutil.NewFakeMounter(mountPoints)
+		s := newTestNodeService(t, mounter)
+		capability := fsCapability(csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER)
+		capability.GetMount().MountFlags = c.mountFlags
+
+		_, err := s.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+			VolumeId:          "volume-pvc-1",
+			StagingTargetPath: stagingPath,
+			TargetPath:        targetPath,
+			VolumeCapability:  capability,
+			Readonly:          c.readonly,
+		})
+		assert.Equal(t, c.wantCode, status.Code(err), c.name)
+		if c.wantMount == nil {
+			assert.Empty(t, mounter.GetLog(), c.name)
+			continue
+		}
+		// fake mounter将bind mount的源路径解析为staging路径挂载的设备
+		points, _ := mounter.List()
+		assert.Equal(t, mountutil.MountPoint{Device: "/dev/carina/volume-pvc-1", Path: targetPath, Opts: c.wantMount}, points[len(points)-1], c.name)
+	}
+}
+
+// fakeCrypt 按设备记录当前密钥，只实现已格式化为LUKS的设备
+type fakeCrypt struct {
+	crypt.LocalCrypt
+	passphrases map[string]string
+	opened      map[string]bool
+	openErr     error
+	closeErr    error
+	calls       []string
+}
+
+func (f *fakeCrypt) IsLuks(device string) bool {
+	_, ok := f.passphrases[device]
+	return ok
+}
+
+func (f *fakeCrypt) CheckPassphrase(device, passphrase string) bool {
+	return f.passphrases[device] == passphrase
+}
+
+func (f *fakeCrypt) ChangePassphrase(device, oldPassphrase, newPassphrase string) error {
+	f.calls = append(f.calls, "luksChangeKey "+device)
+	f.passphrases[device] = newPassphrase
+	return nil
+}
+
+func (f *fakeCrypt) Open(device, name, passphrase string) error {
+	f.calls = append(f.calls, "luksOpen "+device+" "+name)
+	if f.openErr != nil {
+		return f.openErr
+	}
+	f.opened[name] = true
+	return nil
+}
+
+func (f *fakeCrypt) Close(name string) error {
+	if !f.opened[name] {
+		return nil
+	}
+	f.calls = append(f.calls, "luksClose "+name)
+	if f.closeErr != nil {
+		return f.closeErr
+	}
+	delete(f.opened, name)
+	return nil
+}
+
+func (f *fakeCrypt) IsOpen(name string) bool {
+	return f.opened[name]
+}
+
+func (f *fakeCrypt) MapperPath(name string) string {
+	return "/dev/mapper/" + name
+}
+
+func TestOpenEncryptedDevice(t *testing.T) {
+	const device = "/dev/carina/volume-pvc-1"
+	const mapper = "luks-volume-pvc-1"
+	cases := []struct {
+		name    string
+		secrets map[string]string
+		opened  bool
+		openErr error
+		// wantPassphrase 设备最终的密钥
+		wantPassphrase string
+		wantCalls      []string
+		wantCode       codes.Code
+	}{
+		{
+			name:           "open",
+			secrets:        map[string]string{carina.EncryptionPassphraseKey: "p1"},
+			wantPassphrase: "p1",
+			wantCalls:      []string{"luksOpen " + device + " " + mapper},
+		},
+		// 重试时映射已存在
+		{name: "opened", secrets: map[string]string{carina.EncryptionPassphraseKey: "p1"}, opened: true, wantPassphrase: "p1"},
+		{name: "no passphrase", secrets: map[string]string{}, wantPassphrase: "p1", wantCode: codes.InvalidArgument},
+		{
+			name:           "rotate",
+			secrets:        map[string]string{carina.EncryptionPassphraseKey: "p2", carina.EncryptionPreviousPassphraseKey: "p1"},
+			wantPassphrase: "p2",
+			wantCalls:      []string{"luksChangeKey " + device, "luksOpen " + device + " " + mapper},
+		},
+		// 轮换已完成，旧密钥不再可用
+		{
+			name:           "rotated",
+			secrets:        map[string]string{carina.EncryptionPassphraseKey: "p1", carina.EncryptionPreviousPassphraseKey: "p0"},
+			wantPassphrase: "p1",
+			wantCalls:      []string{"luksOpen " + device + " " + mapper},
+		},
+		{name: "wrong passphrase", secrets: map[string]string{carina.EncryptionPassphraseKey: "p2"}, wantPassphrase: "p1", wantCode: codes.PermissionDenied},
+		{
+			name:           "wrong previous passphrase",
+			secrets:        map[string]string{carina.EncryptionPassphraseKey: "p3", carina.EncryptionPreviousPassphraseKey: "p2"},
+			wantPassphrase: "p1",
+			wantCode:       codes.PermissionDenied,
+		},
+		{
+			name:           "open failed",
+			secrets:        map[string]string{carina.EncryptionPassphraseKey: "p1"},
+			openErr:        errors.New("device busy"),
+			wantPassphrase: "p1",
+			wantCalls:      []string{"luksOpen " + device + " " + mapper},
+			wantCode:       codes.Internal,
+		},
+	}
+	for _, c := range cases {
+		fc := &fakeCrypt{passphrases: map[string]string{device: "p1"}, opened: map[string]bool{}, openErr: c.openErr}
+		if c.opened {
+			fc.opened[mapper] = true
+		}
+		s := &nodeService{dm: &deviceManager.DeviceManager{Crypt: fc}}
+
+		path, err := s.openEncryptedDevice("volume-pvc-1", device, c.secrets)
+		assert.Equal(t, c.wantCode, status.Code(err), c.name)
+		if c.wantCode == codes.OK {
+			assert.Equal(t, "/dev/mapper/"+mapper, path, c.name)
+			assert.True(t, fc.opened[mapper], c.name)
+		}
+		assert.Equal(t, c.wantPassphrase, fc.passphrases[device], c.name)
+		assert.Equal(t, c.wantCalls, fc.calls, c.name)
+	}
+}
+
+func TestCloseEncryptedDevice(t *testing.T) {
+	cases := []struct {
+		name     string
+		opened   bool
+		closeErr error
+		wantCode codes.Code
+	}{
+		{name: "close", opened: true},
+		{name: "closed"},
+		{name: "close failed", opened: true, closeErr: errors.New("device busy"), wantCode: codes.Internal},
+	}
+	for _, c := range cases {
+		fc := &fakeCrypt{opened: map[string]bool{"luks-volume-pvc-1": c.opened}, closeErr: c.closeErr}
+		s := &nodeService{dm: &deviceManager.DeviceManager{Crypt: fc}}
+
+		err := s.closeEncryptedDevice("volume-pvc-1")
+		assert.Equal(t, c.wantCode, status.Code(err), c.name)
+		assert.Equal(t, c.closeErr != nil, fc.opened["luks-volume-pvc-1"], c.name)
+	}
+}
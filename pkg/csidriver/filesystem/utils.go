@@ -95,6 +95,29 @@ func IsMounted(device, target string) (bool, error) {
 	return false, nil
 }
 
+// IsDeviceMounted returns true if device is mounted on any path, e.g. the staging path.
+func IsDeviceMounted(device string) (bool, error) {
+	data, err := os.ReadFile("/proc/mounts")
+	if err != nil {
+		return false, fmt.Errorf("could not read /proc/mounts: %v", err)
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || !strings.HasPrefix(fields[0], "/dev/") {
+			continue
+		}
+		same, err := isSameDevice(device, fields[0])
+		if err != nil {
+			continue
+		}
+		if same {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
 func getOneStringByRegex(str, rule string) (string, error) {
 	if !strings.Contains(str, "/pods/") {
 		return "non-csi", nil
@@ -17,6 +17,7 @@
 package bcache
 
 import (
+	"context"
 	"fmt"
 	"github.com/carina-io/carina/pkg/devicemanager/types"
 	"github.com/carina-io/carina/utils/exec"
@@ -26,6 +27,10 @@ type BcacheImplement struct {
 	Executor exec.Executor
 }
 
+func (bi *BcacheImplement) WithContext(ctx context.Context) Bcache {
+	return &BcacheImplement{Executor: bi.Executor.WithContext(ctx)}
+}
+
 func (bi *BcacheImplement) CreateBcache(dev, cacheDev string, block, bucket string) error {
 	_ = bi.Executor.ExecuteCommand("wipefs", "-af", dev)
 	_ = bi.Executor.ExecuteCommand("wipefs", "-af", cacheDev)
@@ -17,6 +17,8 @@
 package bcache
 
 import (
+	"context"
+
 	"github.com/carina-io/carina/pkg/devicemanager/types"
 )
 
@@ -31,4 +33,7 @@ type Bcache interface {
 	ShowDevice(dev string) (*types.BcacheDeviceInfo, error)
 
 	SetCacheMode(bcache string, cachePolicy string) error
+
+	// WithContext 返回绑定ctx的Bcache，ctx结束时正在执行的命令连同其进程组一起被终止
+	WithContext(ctx context.Context) Bcache
 }
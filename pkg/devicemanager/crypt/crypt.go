@@ -0,0 +1,130 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package crypt
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/carina-io/carina/utils/exec"
+	"github.com/carina-io/carina/utils/log"
+)
+
+const mapperDirectory = "/dev/mapper"
+
+type LuksImplement struct {
+	Executor exec.Executor
+}
+
+func (li *LuksImplement) IsLuks(device string) bool {
+	return li.Executor.ExecuteCommand("cryptsetup", "isLuks", device) == nil
+}
+
+func (li *LuksImplement) Format(device, passphrase string) error {
+	keyFile, err := writeKeyFile(passphrase)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(keyFile)
+
+	_ = li.Executor.ExecuteCommand("wipefs", "-af", device)
+	return li.Executor.ExecuteCommand("cryptsetup", "luksFormat", "--batch-mode", "--type", "luks2", "--key-file", keyFile, device)
+}
+
+func (li *LuksImplement) CheckPassphrase(device, passphrase string) bool {
+	keyFile, err := writeKeyFile(passphrase)
+	if err != nil {
+		log.Errorf("write key file failed %s", err.Error())
+		return false
+	}
+	defer os.Remove(keyFile)
+
+	return li.Executor.ExecuteCommand("cryptsetup", "luksOpen", "--test-passphrase", "--key-file", keyFile, device) == nil
+}
+
+func (li *LuksImplement) ChangePassphrase(device, oldPassphrase, newPassphrase string) error {
+	oldKeyFile, err := writeKeyFile(oldPassphrase)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(oldKeyFile)
+	newKeyFile, err := writeKeyFile(newPassphrase)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(newKeyFile)
+
+	return li.Executor.ExecuteCommand("cryptsetup", "luksChangeKey", "--batch-mode", "--key-file", oldKeyFile, device, newKeyFile)
+}
+
+func (li *LuksImplement) Open(device, name, passphrase string) error {
+	keyFile, err := writeKeyFile(passphrase)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(keyFile)
+
+	return li.Executor.ExecuteCommand("cryptsetup", "luksOpen", "--key-file", keyFile, device, name)
+}
+
+func (li *LuksImplement) Close(name string) error {
+	if !li.IsOpen(name) {
+		return nil
+	}
+	return li.Executor.ExecuteCommand("cryptsetup", "luksClose", name)
+}
+
+func (li *LuksImplement) IsOpen(name string) bool {
+	_, err := os.Stat(li.MapperPath(name))
+	return err == nil
+}
+
+func (li *LuksImplement) Resize(name, passphrase string) error {
+	if passphrase == "" {
+		return li.Executor.ExecuteCommand("cryptsetup", "resize", name)
+	}
+	keyFile, err := writeKeyFile(passphrase)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(keyFile)
+
+	return li.Executor.ExecuteCommand("cryptsetup", "resize", "--key-file", keyFile, name)
+}
+
+func (li *LuksImplement) MapperPath(name string) string {
+	return filepath.Join(mapperDirectory, name)
+}
+
+// writeKeyFile 密钥通过临时文件传递给cryptsetup，避免出现在命令行参数以及日志中
+func writeKeyFile(passphrase string) (string, error) {
+	f, err := os.CreateTemp("", "carina-luks-")
+	if err != nil {
+		return "", fmt.Errorf("failed to create key file: %v", err)
+	}
+	defer f.Close()
+	if err := f.Chmod(0600); err != nil {
+		_ = os.Remove(f.Name())
+		return "", err
+	}
+	if _, err := f.WriteString(passphrase); err != nil {
+		_ = os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
@@ -0,0 +1,148 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package crypt
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/carina-io/carina/utils/exec"
+)
+
+// fakeExecutor 记录执行的命令，并在执行时读取--key-file以及新密钥文件的内容
+type fakeExecutor struct {
+	exec.Executor
+	commands []string
+	keys     []string
+	keyFiles []string
+	errs     map[string]error
+}
+
+func (f *fakeExecutor) ExecuteCommand(command string, arg ...string) error {
+	line := strings.Join(append([]string{command}, arg...), " ")
+	f.commands = append(f.commands, line)
+	for i, a := range arg {
+		// luksChangeKey的最后一个参数为新密钥文件
+		if (i > 0 && arg[i-1] == "--key-file") || (len(arg) > 0 && arg[0] == "luksChangeKey" && i == len(arg)-1) {
+			data, err := os.ReadFile(a)
+			if err != nil {
+				return err
+			}
+			f.keys = append(f.keys, string(data))
+			f.keyFiles = append(f.keyFiles, a)
+		}
+	}
+	for action, err := range f.errs {
+		if strings.HasPrefix(line, action) {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestLuks(t *testing.T) {
+	cases := []struct {
+		name     string
+		run      func(li *LuksImplement) error
+		errs     map[string]error
+		wantCmds []string
+		wantKeys []string
+		wantErr  bool
+	}{
+		{
+			name:     "format",
+			run:      func(li *LuksImplement) error { return li.Format("/dev/sdb", "p1") },
+			wantCmds: []string{"wipefs -af /dev/sdb", "cryptsetup luksFormat --batch-mode --type luks2 --key-file"},
+			wantKeys: []string{"p1"},
+		},
+		{
+			name:     "open",
+			run:      func(li *LuksImplement) error { return li.Open("/dev/sdb", "luks-volume-pvc-1", "p1") },
+			wantCmds: []string{"cryptsetup luksOpen --key-file"},
+			wantKeys: []string{"p1"},
+		},
+		{
+			name:     "open failed",
+			run:      func(li *LuksImplement) error { return li.Open("/dev/sdb", "luks-volume-pvc-1", "p1") },
+			errs:     map[string]error{"cryptsetup luksOpen": errors.New("No key available with this passphrase")},
+			wantCmds: []string{"cryptsetup luksOpen --key-file"},
+			wantKeys: []string{"p1"},
+			wantErr:  true,
+		},
+		{
+			name: "check passphrase",
+			run: func(li *LuksImplement) error {
+				if !li.CheckPassphrase("/dev/sdb", "p1") {
+					return errors.New("check passphrase failed")
+				}
+				return nil
+			},
+			wantCmds: []string{"cryptsetup luksOpen --test-passphrase --key-file"},
+			wantKeys: []string{"p1"},
+		},
+		{
+			name:     "rotate",
+			run:      func(li *LuksImplement) error { return li.ChangePassphrase("/dev/sdb", "p1", "p2") },
+			wantCmds: []string{"cryptsetup luksChangeKey --batch-mode --key-file"},
+			wantKeys: []string{"p1", "p2"},
+		},
+		{
+			name:     "rotate failed",
+			run:      func(li *LuksImplement) error { return li.ChangePassphrase("/dev/sdb", "p1", "p2") },
+			errs:     map[string]error{"cryptsetup luksChangeKey": errors.New("No key available with this passphrase")},
+			wantCmds: []string{"cryptsetup luksChangeKey --batch-mode --key-file"},
+			wantKeys: []string{"p1", "p2"},
+			wantErr:  true,
+		},
+		{
+			name:     "resize",
+			run:      func(li *LuksImplement) error { return li.Resize("luks-volume-pvc-1", "") },
+			wantCmds: []string{"cryptsetup resize luks-volume-pvc-1"},
+		},
+		// 映射不存在时无需关闭
+		{
+			name: "close closed",
+			run:  func(li *LuksImplement) error { return li.Close("luks-volume-pvc-1") },
+		},
+	}
+	for _, c := range cases {
+		executor := &fakeExecutor{errs: c.errs}
+		li := &LuksImplement{Executor: executor}
+
+		err := c.run(li)
+		assert.Equal(t, c.wantErr, err != nil, c.name)
+		assert.Equal(t, len(c.wantCmds), len(executor.commands), c.name)
+		for i, command := range executor.commands {
+			if i < len(c.wantCmds) {
+				assert.True(t, strings.HasPrefix(command, c.wantCmds[i]), "%s: %s", c.name, command)
+			}
+			// 密钥不出现在命令行参数中
+			for _, key := range executor.keys {
+				assert.NotContains(t, strings.Fields(command), key, c.name)
+			}
+		}
+		assert.Equal(t, c.wantKeys, executor.keys, c.name)
+		// 密钥文件用完即删除
+		for _, f := range executor.keyFiles {
+			assert.NoFileExists(t, f, c.name)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package crypt
+
+// LocalCrypt dm-crypt(LUKS) 加密设备操作
+type LocalCrypt interface {
+	// IsLuks 设备是否已经格式化为LUKS
+	IsLuks(device string) bool
+	// Format 将设备格式化为LUKS，设备原有数据将被清除
+	Format(device, passphrase string) error
+	// CheckPassphrase 校验密钥能否解锁设备
+	CheckPassphrase(device, passphrase string) bool
+	// ChangePassphrase 密钥轮换，使用新密钥替换旧密钥所在的keyslot
+	ChangePassphrase(device, oldPassphrase, newPassphrase string) error
+	// Open 打开加密设备，映射到/dev/mapper/<name>
+	Open(device, name, passphrase string) error
+	// Close 关闭映射
+	Close(name string) error
+	// IsOpen 映射是否存在
+	IsOpen(name string) bool
+	// Resize 扩容后调整映射大小
+	Resize(name, passphrase string) error
+	// MapperPath 映射设备路径
+	MapperPath(name string) string
+}
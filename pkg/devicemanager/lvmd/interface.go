@@ -17,6 +17,8 @@
 package lvmd
 
 import (
+	"context"
+
 	"github.com/carina-io/carina/api"
 	"github.com/carina-io/carina/pkg/devicemanager/types"
 )
@@ -32,6 +34,13 @@ type Lvm2 interface {
 	// PVScan 扫描pv加入cache,在服务启动时执行
 	PVScan(dev string) error
 	PVDisplay(dev string) (*api.PVInfo, error)
+	// PVAddTag pv增加标签
+	PVAddTag(dev, tag string) error
+	// PVChange 设置pv是否可分配
+	PVChange(dev string, allocatable bool) error
+	// PVMove 后台迁移pv数据，PVMoveProgress 查询迁移进度
+	PVMove(dev string) error
+	PVMoveProgress(dev, vg string) (float64, bool, error)
 
 	VGCheck(vg string) error
 	VGCreate(vg string, tags, pvs []string) error
@@ -45,25 +54,47 @@ type Lvm2 interface {
 	VGReduce(vg, pv string) error
 
 	// 快照占用的是池子剩余的容量
-	CreateThinPool(lv, vg string, size uint64) error
-	ResizeThinPool(lv, vg string, size uint64) error
+	// pvs 不为空时只在指定的pv上分配空间
+	CreateThinPool(lv, vg string, size uint64, pvs ...string) error
+	ResizeThinPool(lv, vg string, size uint64, pvs ...string) error
+	// ResizeThinPoolMetadata 扩容thin pool的元数据卷，元数据写满后pool变为只读
+	ResizeThinPoolMetadata(lv, vg string, size uint64, pvs ...string) error
 	DeleteThinPool(lv, vg string) error
-	LVCreateFromPool(lv, thin, vg string, size uint64) error
-	LVCreateFromVG(lv, vg string, size uint64, tags []string, stripe uint, stripeSize string) error
+	LVCreateFromPool(lv, thin, vg string, size uint64, tags []string) error
+	// LVCreateFromVG layout为nil时创建线性卷，否则按条带以及raid布局创建
+	LVCreateFromVG(lv, vg string, size uint64, tags []string, layout *types.LvLayout, pvs ...string) error
+	// LVAddTag lv增加标签
+	LVAddTag(lv, vg string, tags ...string) error
 	LVRemove(lv, vg string) error
-	LVResize(lv, vg string, size uint64) error
+	LVResize(lv, vg string, size uint64, pvs ...string) error
 	LVDisplay(lv, vg string) (*types.LvInfo, error)
 	// LVS 这个方法会频繁调用
 	LVS(lvName string) ([]types.LvInfo, error)
 
+	// LVCreateCache 在pvs上创建缓存卷cache并挂载到lv，mode为writethrough/writeback时使用dm-cache，writecache时使用dm-writecache
+	LVCreateCache(lv, cache, vg string, size uint64, mode string, pvs []string) error
+	// CheckCacheVol 检查lvm版本是否支持LVCreateCache，不支持时返回错误
+	CheckCacheVol() error
+	// LVUncache 刷写脏数据后卸载并删除lv的缓存卷
+	LVUncache(lv, vg string) error
+	// LVCacheStats vg中所有lvmcache卷的缓存统计
+	LVCacheStats(vg string) ([]types.LvCacheStats, error)
+	// LVRaidStatus vg中所有raid卷的同步以及健康状态
+	LVRaidStatus(vg string) ([]types.LvRaidStatus, error)
+
 	// CreateSnapshot 快照占用Pool空间，要有足够对池空间才能创建快照，不然会导致数据损坏
-	CreateSnapshot(snap, lv, vg string) error
+	CreateSnapshot(snap, lv, vg string, size uint64) error
 	DeleteSnapshot(snap, vg string) error
 	// RestoreSnapshot 恢复快照会导致此快照消失
 	RestoreSnapshot(snap, vg string) error
+	// ThinDelta pool中两个thin设备(thin_id)之间变化的数据范围
+	ThinDelta(pool, vg string, from, to uint64) ([]types.BlockRange, error)
 
 	// StartLvm2 启动必要的lvm2服务
 	StartLvm2() error
 	// RemoveUnknownDevice 清理unknown设备
 	RemoveUnknownDevice(vg string) error
+
+	// WithContext 返回绑定ctx的Lvm2，ctx结束时正在执行的命令连同其进程组一起被终止
+	WithContext(ctx context.Context) Lvm2
 }
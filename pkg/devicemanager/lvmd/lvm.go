@@ -17,10 +17,13 @@
 package lvmd
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/carina-io/carina/api"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/carina-io/carina/pkg/devicemanager/types"
@@ -33,6 +36,10 @@ type Lvm2Implement struct {
 	Executor exec.Executor
 }
 
+func (lv2 *Lvm2Implement) WithContext(ctx context.Context) Lvm2 {
+	return &Lvm2Implement{Executor: lv2.Executor.WithContext(ctx)}
+}
+
 func (lv2 *Lvm2Implement) PVCheck(dev string) (string, error) {
 	return lv2.Executor.ExecuteCommandWithCombinedOutput("pvck", dev)
 }
@@ -59,18 +66,70 @@ func (lv2 *Lvm2Implement) PVWipefs(dev string) error {
 	return lv2.Executor.ExecuteCommand("wipefs -a", dev)
 }
 
+// PVAddTag pv增加标签，用于记录磁盘的稳定标识
+func (lv2 *Lvm2Implement) PVAddTag(dev, tag string) error {
+	return lv2.Executor.ExecuteCommand("pvchange", "--addtag", tag, dev)
+}
+
+// PVChange 设置pv是否允许分配新的extent，维护中的磁盘不再分配新卷
+func (lv2 *Lvm2Implement) PVChange(dev string, allocatable bool) error {
+	flag := "y"
+	if !allocatable {
+		flag = "n"
+	}
+	return lv2.Executor.ExecuteCommand("pvchange", "-x", flag, dev)
+}
+
+// PVMove 后台迁移pv上的extent至同一vg的其他pv，由lvmpolld完成迁移
+func (lv2 *Lvm2Implement) PVMove(dev string) error {
+	return lv2.Executor.ExecuteCommand("pvmove", "-b", dev)
+}
+
+// PVMoveProgress 查询vg中迁移指定pv的pvmove进度，move_pv为pvmove临时lv的源pv
+// lvs -a -o lv_name,move_pv,copy_percent --reportformat json v1
+// {"report": [{"lv": [{"lv_name":"[pvmove0]", "move_pv":"/dev/sdb", "copy_percent":"45.00"}]}]}
+func (lv2 *Lvm2Implement) PVMoveProgress(dev, vg string) (float64, bool, error) {
+	args := append([]string{"-a", "-o", "lv_name,move_pv,copy_percent"}, reportArgs...)
+	output, err := lv2.Executor.ExecuteCommandWithOutput("lvs", append(args, vg)...)
+	if err != nil {
+		return 0, false, err
+	}
+	report, err := decodeReport(output)
+	if err != nil {
+		return 0, false, err
+	}
+	for _, r := range report.Report {
+		for _, lv := range r.LV {
+			if strings.HasPrefix(strings.Trim(lv.LVName, "[]"), "pvmove") && lv.MovePV == dev {
+				return float64(lv.CopyPercent), true, nil
+			}
+		}
+	}
+	return 0, false, nil
+}
+
+// pvDeviceIDUnsupported lvm版本不支持deviceid字段时置为true
+var pvDeviceIDUnsupported atomic.Bool
+
 // PVS 示例输出
-// pvs --noheadings --separator=, --units=b --nosuffix --unbuffered --nameprefixes
-// LVM2_PV_NAME='/dev/loop2',LVM2_VG_NAME='lvmvg',LVM2_PV_FMT='lvm2',LVM2_PV_ATTR='a--',LVM2_PV_SIZE='16101933056',LVM2_PV_FREE='16101933056'
+// pvs -o pv_name,vg_name,pv_fmt,pv_attr,pv_size,pv_free,pv_tags,pv_uuid,deviceid,deviceidtype --reportformat json --units=b --nosuffix
+// {"report": [{"pv": [{"pv_name":"/dev/loop2", "vg_name":"lvmvg", "pv_fmt":"lvm2", "pv_attr":"a--", "pv_size":"16101933056", "pv_free":"16101933056", "pv_tags":"t1", ...}]}]}
 func (lv2 *Lvm2Implement) PVS() ([]api.PVInfo, error) {
+	fields := pvFields
+	if !pvDeviceIDUnsupported.Load() {
+		fields += "," + pvDeviceIDFields
+	}
 
-	args := []string{"--noheadings", "--separator=,", "--units=b", "--nosuffix", "--unbuffered", "--nameprefixes"}
-
-	pvsInfo, err := lv2.Executor.ExecuteCommandWithOutput("pvs", args...)
+	pvsInfo, err := lv2.Executor.ExecuteCommandWithOutput("pvs", append([]string{"-o", fields}, reportArgs...)...)
+	if err != nil && fields != pvFields && strings.Contains(pvsInfo, "Unrecognised field") {
+		log.Warnf("lvm doesn't support %s, ignore pv device id", pvDeviceIDFields)
+		pvDeviceIDUnsupported.Store(true)
+		return lv2.PVS()
+	}
 	if err != nil {
 		return nil, err
 	}
-	return parsePvs(pvsInfo), nil
+	return parsePvs(pvsInfo)
 }
 
 // PVDisplay
@@ -140,19 +199,15 @@ func (lv2 *Lvm2Implement) VGRemove(vg string) error {
 }
 
 // VGS 示例
-// vgs --noheadings --separator=, --units=b --nosuffix --unbuffered --nameprefixes
-// LVM2_VG_NAME='lvmvg',LVM2_PV_COUNT='1',LVM2_LV_COUNT='0',LVM2_SNAP_COUNT='0',LVM2_VG_ATTR='wz--n-',LVM2_VG_SIZE='16101933056',LVM2_VG_FREE='16101933056'
-// LVM2_VG_NAME='v1',LVM2_PV_COUNT='2',LVM2_LV_COUNT='0',LVM2_SNAP_COUNT='0',LVM2_VG_ATTR='wz--n-',LVM2_VG_SIZE='32203866112',LVM2_VG_FREE='32203866112'
+// vgs -o vg_name,pv_count,lv_count,vg_attr,vg_size,vg_free,vg_extent_size,vg_tags,vg_missing_pv_count --reportformat json --units=b --nosuffix
+// {"report": [{"vg": [{"vg_name":"v1", "pv_count":"2", "lv_count":"0", "vg_attr":"wz--n-", "vg_size":"32203866112", "vg_free":"32203866112", ...}]}]}
 func (lv2 *Lvm2Implement) VGS() ([]api.VgGroup, error) {
-	flieds := []string{"-o", "VG_NAME,PV_COUNT,LV_COUNT,VG_ATTR,VG_SIZE,VG_FREE"}
-	args := []string{"--noheadings", "--separator=,", "--units=b", "--nosuffix", "--unbuffered", "--nameprefixes"}
-
-	vgsInfo, err := lv2.Executor.ExecuteCommandWithOutput("vgs", append(flieds, args...)...)
+	vgsInfo, err := lv2.Executor.ExecuteCommandWithOutput("vgs", append([]string{"-o", vgFields}, reportArgs...)...)
 	if err != nil {
 		return nil, err
 	}
 
-	return parseVgs(vgsInfo), nil
+	return parseVgs(vgsInfo)
 }
 
 func (lv2 *Lvm2Implement) VGDisplay(vg string) (*api.VgGroup, error) {
@@ -233,13 +288,21 @@ func (lv2 *Lvm2Implement) VGReduce(vg, pv string) error {
 }
 
 // CreateThinPool lvcreate -T v1/t5 --size 2g
-func (lv2 *Lvm2Implement) CreateThinPool(lv, vg string, size uint64) error {
-	return lv2.Executor.ExecuteCommand("lvcreate", "-T", fmt.Sprintf("%s/%s", vg, lv), "--size", fmt.Sprintf("%vg", size>>30))
+func (lv2 *Lvm2Implement) CreateThinPool(lv, vg string, size uint64, pvs ...string) error {
+	args := []string{"-T", fmt.Sprintf("%s/%s", vg, lv), "--size", fmt.Sprintf("%vb", size)}
+	return lv2.Executor.ExecuteCommand("lvcreate", append(args, pvs...)...)
 }
 
 // ResizeThinPool lvresize -f -L 6g v1/t5
-func (lv2 *Lvm2Implement) ResizeThinPool(lv, vg string, size uint64) error {
-	return lv2.Executor.ExecuteCommand("lvresize", "-f", "-L", fmt.Sprintf("%vg", size>>30), fmt.Sprintf("%s/%s", vg, lv))
+func (lv2 *Lvm2Implement) ResizeThinPool(lv, vg string, size uint64, pvs ...string) error {
+	args := []string{"-f", "-L", fmt.Sprintf("%vb", size), fmt.Sprintf("%s/%s", vg, lv)}
+	return lv2.Executor.ExecuteCommand("lvresize", append(args, pvs...)...)
+}
+
+// ResizeThinPoolMetadata lvextend --poolmetadatasize 256m v1/t5
+func (lv2 *Lvm2Implement) ResizeThinPoolMetadata(lv, vg string, size uint64, pvs ...string) error {
+	args := []string{"--poolmetadatasize", fmt.Sprintf("%vb", size), fmt.Sprintf("%s/%s", vg, lv)}
+	return lv2.Executor.ExecuteCommand("lvextend", append(args, pvs...)...)
 }
 
 // DeleteThinPool lvremove v1/t3
@@ -248,39 +311,148 @@ func (lv2 *Lvm2Implement) DeleteThinPool(lv, vg string) error {
 	return lv2.LVRemove(lv, vg)
 }
 
-func (lv2 *Lvm2Implement) LVCreateFromPool(lv, thin, vg string, size uint64) error {
-	return lv2.Executor.ExecuteCommand("lvcreate", "-T", fmt.Sprintf("%s/%s", vg, thin), "-n", lv, "-V", fmt.Sprintf("%vg", size>>30))
+// LVCreateFromPool lvcreate -T v1/t5 -n m2 -V 2g --addtag t1
+func (lv2 *Lvm2Implement) LVCreateFromPool(lv, thin, vg string, size uint64, tags []string) error {
+	args := []string{"-T", fmt.Sprintf("%s/%s", vg, thin), "-n", lv, "-V", fmt.Sprintf("%vb", size)}
+	return lv2.Executor.ExecuteCommand("lvcreate", append(args, tagArgs(tags)...)...)
 }
 
 // LVCreateFromVG LVCreate creates logical volume in this volume group.
 // name is a name of creating volume. size is volume size in bytes. volTags is a
-// list of tags to add to the volume.
-func (lv2 *Lvm2Implement) LVCreateFromVG(lv, vg string, size uint64, tags []string, stripe uint, stripeSize string) error {
-	args := []string{"-n", lv, "-L", fmt.Sprintf("%vg", size>>30), "-W", "y", "-y"}
-	for _, tag := range tags {
-		if tag != "" {
-			args = append(args, "--add-tag="+tag)
+// list of tags to add to the volume. layout is nil for linear volume.
+// lvcreate -n m2 -L 2g -W y -y --type raid10 -m 1 -i 2 -I 64k v1
+func (lv2 *Lvm2Implement) LVCreateFromVG(lv, vg string, size uint64, tags []string, layout *types.LvLayout, pvs ...string) error {
+	args := []string{"-n", lv, "-L", fmt.Sprintf("%vb", size), "-W", "y", "-y"}
+	args = append(args, tagArgs(tags)...)
+	if layout != nil {
+		if layout.RaidLevel != "" {
+			args = append(args, "--type", layout.RaidLevel)
 		}
-	}
-	if stripe != 0 {
-		args = append(args, "-i", fmt.Sprintf("%d", stripe))
+		if layout.Mirrors != 0 {
+			args = append(args, "-m", fmt.Sprintf("%d", layout.Mirrors))
+		}
+		if layout.Stripes != 0 {
+			args = append(args, "-i", fmt.Sprintf("%d", layout.Stripes))
 
-		if stripeSize != "" {
-			args = append(args, "-I", stripeSize)
+			if layout.StripeSize != "" {
+				args = append(args, "-I", layout.StripeSize)
+			}
 		}
 	}
 	args = append(args, vg)
+	args = append(args, pvs...)
 
 	return lv2.Executor.ExecuteCommand("lvcreate", args...)
 }
 
+// LVAddTag lvchange --addtag t1 --addtag t2 v1/m2
+func (lv2 *Lvm2Implement) LVAddTag(lv, vg string, tags ...string) error {
+	args := tagArgs(tags)
+	if len(args) == 0 {
+		return nil
+	}
+	return lv2.Executor.ExecuteCommand("lvchange", append(args, fmt.Sprintf("%s/%s", vg, lv))...)
+}
+
+func tagArgs(tags []string) []string {
+	args := []string{}
+	for _, tag := range tags {
+		if tag != "" {
+			args = append(args, "--addtag", tag)
+		}
+	}
+	return args
+}
+
 func (lv2 *Lvm2Implement) LVRemove(lv, vg string) error {
 	return lv2.Executor.ExecuteCommand("lvremove", "-f", fmt.Sprintf("%s/%s", vg, lv))
 }
 
 // LVResize lvresize -L 2g v1/m2
-func (lv2 *Lvm2Implement) LVResize(lv, vg string, size uint64) error {
-	return lv2.Executor.ExecuteCommand("lvresize", "-L", fmt.Sprintf("%vg", size>>30), fmt.Sprintf("%s/%s", vg, lv))
+func (lv2 *Lvm2Implement) LVResize(lv, vg string, size uint64, pvs ...string) error {
+	args := []string{"-L", fmt.Sprintf("%vb", size), fmt.Sprintf("%s/%s", vg, lv)}
+	return lv2.Executor.ExecuteCommand("lvresize", append(args, pvs...)...)
+}
+
+// LVCreateCache
+// lvcreate -n lvmcache-m2 -L 1g -W y -y v1 /dev/nvme0n1
+// lvconvert -y --type cache --cachevol lvmcache-m2 --cachemode writeback v1/m2
+// lvconvert -y --type writecache --cachevol lvmcache-m2 v1/m2
+func (lv2 *Lvm2Implement) LVCreateCache(lv, cache, vg string, size uint64, mode string, pvs []string) error {
+	if err := lv2.CheckCacheVol(); err != nil {
+		return err
+	}
+	if err := lv2.LVCreateFromVG(cache, vg, size, nil, nil, pvs...); err != nil {
+		return err
+	}
+	args := []string{"-y", "--type", "cache", "--cachevol", cache, "--cachemode", mode, fmt.Sprintf("%s/%s", vg, lv)}
+	if mode == "writecache" {
+		args = []string{"-y", "--type", "writecache", "--cachevol", cache, fmt.Sprintf("%s/%s", vg, lv)}
+	}
+	if err := lv2.Executor.ExecuteCommand("lvconvert", args...); err != nil {
+		_ = lv2.LVRemove(cache, vg)
+		return err
+	}
+	return nil
+}
+
+// CheckCacheVol lvm version
+// --cachevol以及--type writecache需要lvm2 2.03及以上版本，2.02只支持--cachepool
+func (lv2 *Lvm2Implement) CheckCacheVol() error {
+	output, err := lv2.Executor.ExecuteCommandWithOutput("lvm", "version")
+	if err != nil {
+		return err
+	}
+	major, minor, err := parseLvmVersion(output)
+	if err != nil {
+		return err
+	}
+	if major < 2 || major == 2 && minor < 3 {
+		return fmt.Errorf("lvmcache requires lvm2 2.03 or later, current version %d.%02d", major, minor)
+	}
+	return nil
+}
+
+// LVUncache lvconvert -y --uncache v1/m2
+func (lv2 *Lvm2Implement) LVUncache(lv, vg string) error {
+	return lv2.Executor.ExecuteCommand("lvconvert", "-y", "--uncache", fmt.Sprintf("%s/%s", vg, lv))
+}
+
+// lvWritecacheUnsupported lvm版本不支持writecache字段时置为true
+var lvWritecacheUnsupported atomic.Bool
+
+// LVCacheStats
+// lvs -o lv_name,vg_name,segtype,cache_mode,cache_total_blocks,... -S 'segtype=cache||segtype=writecache' --reportformat json v1
+func (lv2 *Lvm2Implement) LVCacheStats(vg string) ([]types.LvCacheStats, error) {
+	fields := lvFields + "," + lvCacheFields
+	writecache := !lvWritecacheUnsupported.Load()
+	if writecache {
+		fields += "," + lvWritecacheFields
+	}
+	args := append([]string{"-o", fields, "-S", "segtype=cache||segtype=writecache"}, reportArgs...)
+
+	lvsInfo, err := lv2.Executor.ExecuteCommandWithOutput("lvs", append(args, vg)...)
+	if err != nil && writecache && strings.Contains(lvsInfo, "Unrecognised field") {
+		log.Warnf("lvm doesn't support %s, ignore writecache stats", lvWritecacheFields)
+		lvWritecacheUnsupported.Store(true)
+		return lv2.LVCacheStats(vg)
+	}
+	if err != nil {
+		return nil, errors.New(lvsInfo)
+	}
+	return parseLvCacheStats(lvsInfo)
+}
+
+// LVRaidStatus
+// lvs -o lv_name,vg_name,segtype,sync_percent,lv_health_status,raid_sync_action -S 'segtype=~^raid' --reportformat json v1
+func (lv2 *Lvm2Implement) LVRaidStatus(vg string) ([]types.LvRaidStatus, error) {
+	args := append([]string{"-o", lvFields, "-S", "segtype=~^raid"}, reportArgs...)
+
+	lvsInfo, err := lv2.Executor.ExecuteCommandWithOutput("lvs", append(args, vg)...)
+	if err != nil {
+		return nil, errors.New(lvsInfo)
+	}
+	return parseLvRaidStatus(lvsInfo)
 }
 
 // LVDisplay lvdisplay v1/m2
@@ -297,35 +469,45 @@ func (lv2 *Lvm2Implement) LVDisplay(lv, vg string) (*types.LvInfo, error) {
 
 // LVS
 /*
-# lvs -o lv_name,lv_path,lv_size,lv_kernel_major,lv_kernel_minor,origin,origin_size,pool_lv,thin_count,lv_tags --noheadings --separator=, --units=b --nosuffix --unbuffered --nameprefixes
-  LVM2_LV_NAME='t1',LVM2_LV_PATH='/dev/v1/t1',LVM2_LV_SIZE='1073741824',LVM2_LV_KERNEL_MAJOR='252',LVM2_LV_KERNEL_MINOR='0',LVM2_ORIGIN='',LVM2_ORIGIN_SIZE='',LVM2_POOL_LV='',LVM2_THIN_COUNT='',LVM2_LV_TAGS='t1'
-  LVM2_LV_NAME='t5',LVM2_LV_PATH='',LVM2_LV_SIZE='6979321856',LVM2_LV_KERNEL_MAJOR='252',LVM2_LV_KERNEL_MINOR='3',LVM2_ORIGIN='',LVM2_ORIGIN_SIZE='',LVM2_POOL_LV='',LVM2_THIN_COUNT='1',LVM2_LV_TAGS=''
-  LVM2_LV_NAME='m2',LVM2_LV_PATH='/dev/v1/m2',LVM2_LV_SIZE='2147483648',LVM2_LV_KERNEL_MAJOR='252',LVM2_LV_KERNEL_MINOR='5',LVM2_ORIGIN='',LVM2_ORIGIN_SIZE='',LVM2_POOL_LV='t5',LVM2_THIN_COUNT='',LVM2_LV_TAGS=''
-
+# lvs -o lv_name,vg_name,lv_path,lv_size,...,segtype,lv_health_status,sync_percent --reportformat json --units=b --nosuffix
+  {
+      "report": [
+          {
+              "lv": [
+                  {"lv_name":"thin-t5", "vg_name":"v1", "lv_path":"", "lv_size":"6979321856", "pool_lv":"", "thin_count":"1", "segtype":"thin-pool", "metadata_percent":"10.55", ...},
+                  {"lv_name":"volume-m2", "vg_name":"v1", "lv_path":"/dev/v1/volume-m2", "lv_size":"2147483648", "pool_lv":"thin-t5", "segtype":"thin", ...}
+              ]
+          }
+      ]
+  }
 */
 func (lv2 *Lvm2Implement) LVS(lvName string) ([]types.LvInfo, error) {
-	fields := []string{"-o", "lv_name,vg_name,lv_path,lv_size,data_percent,lv_attr,lv_kernel_major,lv_kernel_minor,origin,origin_size,pool_lv,thin_count,lv_tags,lv_active"}
-	args := []string{"--noheadings", "--separator=,", "--units=b", "--nosuffix", "--unbuffered", "--nameprefixes"}
-
+	args := append([]string{"-o", lvFields}, reportArgs...)
 	if lvName != "" {
 		args = append(args, lvName)
 	}
 
-	lvsInfo, err := lv2.Executor.ExecuteCommandWithOutput("lvs", append(fields, args...)...)
+	lvsInfo, err := lv2.Executor.ExecuteCommandWithOutput("lvs", args...)
 	if err != nil && strings.Contains(lvsInfo, "Failed to find logical volume") {
 		return []types.LvInfo{}, nil
 	}
 	if err != nil {
 		return nil, errors.New(lvsInfo)
 	}
-	return parseLvs(lvsInfo), nil
+	return parseLvs(lvsInfo)
 }
 
 // CreateSnapshot lvcreate -s v1/m2 -n snaph-m1 -ay -Ky
-func (lv2 *Lvm2Implement) CreateSnapshot(snap, lv, vg string) error {
+// size为0时创建thin快照，否则创建指定大小的COW快照 lvcreate -s v1/m2 -n snaph-m1 -L 2g -ay -Ky
+func (lv2 *Lvm2Implement) CreateSnapshot(snap, lv, vg string, size uint64) error {
 	// Pool容量时lv卷的三倍，则能创建两个快照
 	// TODO: 需要检查pool>lvm卷,若是相等则不支持创建快照操作
-	return lv2.Executor.ExecuteCommand("lvcreate", "-s", fmt.Sprintf("%s/%s", vg, lv), "-n", snap, "-ay", "-Ky")
+	args := []string{"-s", fmt.Sprintf("%s/%s", vg, lv), "-n", snap}
+	if size > 0 {
+		args = append(args, "-L", fmt.Sprintf("%vb", size))
+	}
+	args = append(args, "-ay", "-Ky")
+	return lv2.Executor.ExecuteCommand("lvcreate", args...)
 }
 
 // DeleteSnapshot
@@ -347,6 +529,33 @@ func (lv2 *Lvm2Implement) RestoreSnapshot(snap, vg string) error {
 	return lv2.Executor.ExecuteCommand("lvconvert", "--merge", fmt.Sprintf("%s/%s", vg, snap))
 }
 
+// ThinDelta thin_delta读取pool元数据比较两个thin设备的映射，读取前保留元数据快照，结束后释放
+// dmsetup message carina--vg--hdd-thin--pool-tpool 0 reserve_metadata_snap
+// thin_delta -m --snap1 1 --snap2 2 /dev/mapper/carina--vg--hdd-thin--pool_tmeta
+func (lv2 *Lvm2Implement) ThinDelta(pool, vg string, from, to uint64) ([]types.BlockRange, error) {
+	tpool := dmName(vg, pool) + "-tpool"
+	if err := lv2.Executor.ExecuteCommand("dmsetup", "message", tpool, "0", "reserve_metadata_snap"); err != nil {
+		// 上次比较异常退出时元数据快照未释放，释放后重试
+		_ = lv2.Executor.ExecuteCommand("dmsetup", "message", tpool, "0", "release_metadata_snap")
+		if err := lv2.Executor.ExecuteCommand("dmsetup", "message", tpool, "0", "reserve_metadata_snap"); err != nil {
+			return nil, err
+		}
+	}
+	defer func() {
+		// ctx结束后仍需释放元数据快照，否则pool无法再次保留
+		executor := lv2.Executor.WithContext(context.Background())
+		if err := executor.ExecuteCommand("dmsetup", "message", tpool, "0", "release_metadata_snap"); err != nil {
+			log.Warnf("release metadata snapshot of %s/%s failed %s", vg, pool, err.Error())
+		}
+	}()
+
+	output, err := lv2.Executor.ExecuteCommandWithOutput("thin_delta", "-m", "--snap1", strconv.FormatUint(from, 10), "--snap2", strconv.FormatUint(to, 10), "/dev/mapper/"+dmName(vg, pool)+"_tmeta")
+	if err != nil {
+		return nil, fmt.Errorf("thin_delta failed %s %w", output, err)
+	}
+	return parseThinDelta(output)
+}
+
 func (lv2 *Lvm2Implement) StartLvm2() error {
 	//err := lv2.Executor.ExecuteCommandResidentBinary(3*time.Second, "lvmetad")
 	//if err != nil {
@@ -0,0 +1,193 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package lvmd
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/carina-io/carina/utils/exec"
+)
+
+// fakeExecutor 记录执行的命令，output按命令返回输出，errs按命令返回错误，返回"Unrecognised field"的字段由unrecognised模拟旧版本lvm
+type fakeExecutor struct {
+	exec.Executor
+	commands     []string
+	output       map[string]string
+	errs         map[string]error
+	unrecognised []string
+}
+
+func (f *fakeExecutor) ExecuteCommand(command string, arg ...string) error {
+	_, err := f.ExecuteCommandWithOutput(command, arg...)
+	return err
+}
+
+func (f *fakeExecutor) ExecuteCommandWithOutput(command string, arg ...string) (string, error) {
+	line := strings.Join(append([]string{command}, arg...), " ")
+	f.commands = append(f.commands, line)
+	for _, field := range f.unrecognised {
+		if strings.Contains(line, field) {
+			return "  Unrecognised field: " + field, errors.New("exit status 5")
+		}
+	}
+	return f.output[command], f.errs[command]
+}
+
+func TestLVSLvm202(t *testing.T) {
+	executor := &fakeExecutor{
+		output:       map[string]string{"lvs": `{"report": [{"lv": [{"lv_name":"volume-m1", "vg_name":"carina-vg-hdd", "lv_size":"1073741824", "segtype":"linear"}]}]}`},
+		unrecognised: []string{"writecache_total_blocks"},
+	}
+	lv2 := &Lvm2Implement{Executor: executor}
+
+	lvs, err := lv2.LVS("")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(lvs))
+	_, err = lv2.LVDisplay("volume-m1", "carina-vg-hdd")
+	assert.NoError(t, err)
+	_, err = lv2.LVRaidStatus("carina-vg-hdd")
+	assert.NoError(t, err)
+	for _, command := range executor.commands {
+		assert.NotContains(t, command, "cache_", command)
+	}
+
+	// 不支持writecache字段时去掉后重试，之后不再查询
+	executor.commands = nil
+	_, err = lv2.LVCacheStats("carina-vg-hdd")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(executor.commands))
+	assert.Contains(t, executor.commands[1], lvCacheFields)
+	assert.NotContains(t, executor.commands[1], "writecache_")
+	_, err = lv2.LVCacheStats("carina-vg-hdd")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(executor.commands))
+	lvWritecacheUnsupported.Store(false)
+}
+
+const (
+	lvm203Version = `  LVM version:     2.03.14(2) (2021-10-20)
+  Library version: 1.02.181 (2021-10-20)
+  Driver version:  4.45.0`
+	lvm202Version = `  LVM version:     2.02.187(2)-RHEL7 (2020-03-24)
+  Library version: 1.02.170-RHEL7 (2020-03-24)
+  Driver version:  4.37.1`
+)
+
+func TestLVCreateCache(t *testing.T) {
+	cases := []struct {
+		mode    string
+		version string
+		failed  error
+		want    []string
+		wantErr string
+	}{
+		{
+			mode:    "writethrough",
+			version: lvm203Version,
+			want: []string{
+				"lvm version",
+				"lvcreate -n lvmcache-volume-m2 -L 1073741824b -W y -y carina-vg-hdd /dev/nvme0n1",
+				"lvconvert -y --type cache --cachevol lvmcache-volume-m2 --cachemode writethrough carina-vg-hdd/volume-m2",
+			},
+		},
+		{
+			mode:    "writeback",
+			version: lvm203Version,
+			want: []string{
+				"lvm version",
+				"lvcreate -n lvmcache-volume-m2 -L 1073741824b -W y -y carina-vg-hdd /dev/nvme0n1",
+				"lvconvert -y --type cache --cachevol lvmcache-volume-m2 --cachemode writeback carina-vg-hdd/volume-m2",
+			},
+		},
+		{
+			mode:    "writecache",
+			version: lvm203Version,
+			want: []string{
+				"lvm version",
+				"lvcreate -n lvmcache-volume-m2 -L 1073741824b -W y -y carina-vg-hdd /dev/nvme0n1",
+				"lvconvert -y --type writecache --cachevol lvmcache-volume-m2 carina-vg-hdd/volume-m2",
+			},
+		},
+		// 挂载失败时删除缓存卷
+		{
+			mode:    "writeback",
+			version: lvm203Version,
+			failed:  errors.New("exit status 5"),
+			want: []string{
+				"lvm version",
+				"lvcreate -n lvmcache-volume-m2 -L 1073741824b -W y -y carina-vg-hdd /dev/nvme0n1",
+				"lvconvert -y --type cache --cachevol lvmcache-volume-m2 --cachemode writeback carina-vg-hdd/volume-m2",
+				"lvremove -f carina-vg-hdd/lvmcache-volume-m2",
+			},
+			wantErr: "exit status 5",
+		},
+		// lvm2 2.02不支持--cachevol，不创建缓存卷
+		{
+			mode:    "writecache",
+			version: lvm202Version,
+			want:    []string{"lvm version"},
+			wantErr: "lvmcache requires lvm2 2.03 or later, current version 2.02",
+		},
+	}
+	for _, c := range cases {
+		executor := &fakeExecutor{
+			output: map[string]string{"lvm": c.version},
+			errs:   map[string]error{"lvconvert": c.failed},
+		}
+		lv2 := &Lvm2Implement{Executor: executor}
+
+		err := lv2.LVCreateCache("volume-m2", "lvmcache-volume-m2", "carina-vg-hdd", 1<<30, c.mode, []string{"/dev/nvme0n1"})
+		if c.wantErr != "" {
+			assert.EqualError(t, err, c.wantErr, c.mode)
+		} else {
+			assert.NoError(t, err, c.mode)
+		}
+		assert.Equal(t, c.want, executor.commands, c.mode)
+	}
+}
+
+func TestPVMoveProgress(t *testing.T) {
+	// vg中同时迁移两块磁盘，pvmove临时lv的move_pv为源pv
+	output := `{"report": [{"lv": [
+		{"lv_name":"volume-m1", "move_pv":"", "copy_percent":""},
+		{"lv_name":"[pvmove0]", "move_pv":"/dev/sdb", "copy_percent":"45.00"},
+		{"lv_name":"[pvmove1]", "move_pv":"/dev/sdc", "copy_percent":"10.50"}
+	]}]}`
+	cases := []struct {
+		dev        string
+		wantMoving bool
+		wantPct    float64
+	}{
+		{dev: "/dev/sdb", wantMoving: true, wantPct: 45},
+		{dev: "/dev/sdc", wantMoving: true, wantPct: 10.5},
+		{dev: "/dev/sdd"},
+	}
+	for _, c := range cases {
+		executor := &fakeExecutor{output: map[string]string{"lvs": output}}
+		lv2 := &Lvm2Implement{Executor: executor}
+
+		percent, moving, err := lv2.PVMoveProgress(c.dev, "carina-vg-hdd")
+		assert.NoError(t, err, c.dev)
+		assert.Equal(t, c.wantMoving, moving, c.dev)
+		assert.Equal(t, c.wantPct, percent, c.dev)
+		assert.Contains(t, executor.commands[0], "move_pv", c.dev)
+	}
+}
@@ -17,155 +17,388 @@
 package lvmd
 
 import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+
 	"github.com/carina-io/carina"
 	"github.com/carina-io/carina/api"
 	"github.com/carina-io/carina/pkg/devicemanager/types"
-	"github.com/carina-io/carina/utils/log"
-	"strconv"
-	"strings"
 )
 
-func parseVgs(vgsString string) []api.VgGroup {
-	// LVM2_VG_NAME='lvmvg',LVM2_PV_COUNT='1',LVM2_LV_COUNT='0',LVM2_VG_ATTR='wz--n-',LVM2_VG_SIZE='16101933056',LVM2_VG_FREE='16101933056'
-	// LVM2_VG_NAME='v1',LVM2_PV_COUNT='2',LVM2_LV_COUNT='0',LVM2_VG_ATTR='wz--n-',LVM2_VG_SIZE='32203866112',LVM2_VG_FREE='32203866112'
-	// LVM2_VG_NAME='v1',LVM2_PV_COUNT='1',LVM2_LV_COUNT='0',LVM2_VG_ATTR='wz--n-',LVM2_VG_SIZE='16101933056',LVM2_VG_FREE='16101933056'
+// reportArgs lvs/vgs/pvs 均以json格式输出，容量单位为字节
+var reportArgs = []string{"--reportformat", "json", "--units=b", "--nosuffix"}
+
+// lvmReport lvm --reportformat json 的输出，所有字段的值均为字符串
+// {"report": [{"lv": [{"lv_name":"volume-m2", "vg_name":"v1", "lv_size":"2147483648"}]}]}
+type lvmReport struct {
+	Report []struct {
+		PV []pvReport `json:"pv"`
+		VG []vgReport `json:"vg"`
+		LV []lvReport `json:"lv"`
+	} `json:"report"`
+}
+
+// reportUint lvm报告中的整数，空字符串以及-1等无效值解析为0
+type reportUint uint64
+
+func (u *reportUint) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	n, _ := strconv.ParseUint(s, 10, 64)
+	*u = reportUint(n)
+	return nil
+}
+
+// reportFloat lvm报告中的百分比，空字符串解析为0
+type reportFloat float64
+
+func (f *reportFloat) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	n, _ := strconv.ParseFloat(s, 64)
+	*f = reportFloat(n)
+	return nil
+}
+
+type vgReport struct {
+	VGName         string     `json:"vg_name"`
+	PVCount        reportUint `json:"pv_count"`
+	LVCount        reportUint `json:"lv_count"`
+	VGAttr         string     `json:"vg_attr"`
+	VGSize         reportUint `json:"vg_size"`
+	VGFree         reportUint `json:"vg_free"`
+	VGExtentSize   reportUint `json:"vg_extent_size"`
+	VGTags         string     `json:"vg_tags"`
+	MissingPVCount reportUint `json:"vg_missing_pv_count"`
+}
+
+const vgFields = "vg_name,pv_count,lv_count,vg_attr,vg_size,vg_free,vg_extent_size,vg_tags,vg_missing_pv_count"
+
+type pvReport struct {
+	PVName       string     `json:"pv_name"`
+	VGName       string     `json:"vg_name"`
+	PVFmt        string     `json:"pv_fmt"`
+	PVAttr       string     `json:"pv_attr"`
+	PVSize       reportUint `json:"pv_size"`
+	PVFree       reportUint `json:"pv_free"`
+	PVTags       string     `json:"pv_tags"`
+	PVUUID       string     `json:"pv_uuid"`
+	DeviceID     string     `json:"deviceid"`
+	DeviceIDType string     `json:"deviceidtype"`
+}
+
+const pvFields = "pv_name,vg_name,pv_fmt,pv_attr,pv_size,pv_free,pv_tags,pv_uuid"
+
+// pvDeviceIDFields lvm 2.03.12 之后支持的字段，低版本不支持时不再获取
+const pvDeviceIDFields = "deviceid,deviceidtype"
+
+type lvReport struct {
+	LVName          string      `json:"lv_name"`
+	VGName          string      `json:"vg_name"`
+	LVPath          string      `json:"lv_path"`
+	LVSize          reportUint  `json:"lv_size"`
+	LVKernelMajor   reportUint  `json:"lv_kernel_major"`
+	LVKernelMinor   reportUint  `json:"lv_kernel_minor"`
+	Origin          string      `json:"origin"`
+	OriginSize      reportUint  `json:"origin_size"`
+	PoolLV          string      `json:"pool_lv"`
+	ThinCount       reportUint  `json:"thin_count"`
+	ThinID          reportUint  `json:"thin_id"`
+	LVTags          string      `json:"lv_tags"`
+	DataPercent     reportFloat `json:"data_percent"`
+	MetadataPercent reportFloat `json:"metadata_percent"`
+	MetadataSize    reportUint  `json:"lv_metadata_size"`
+	LVAttr          string      `json:"lv_attr"`
+	LVActive        string      `json:"lv_active"`
+	SegType         string      `json:"segtype"`
+	HealthStatus    string      `json:"lv_health_status"`
+	SyncPercent     string      `json:"sync_percent"`
+	SyncAction      string      `json:"raid_sync_action"`
+	CopyPercent     reportFloat `json:"copy_percent"`
+	MovePV          string      `json:"move_pv"`
+
+	CacheMode                 string     `json:"cache_mode"`
+	CacheTotalBlocks          reportUint `json:"cache_total_blocks"`
+	CacheUsedBlocks           reportUint `json:"cache_used_blocks"`
+	CacheDirtyBlocks          reportUint `json:"cache_dirty_blocks"`
+	CacheReadHits             reportUint `json:"cache_read_hits"`
+	CacheReadMisses           reportUint `json:"cache_read_misses"`
+	CacheWriteHits            reportUint `json:"cache_write_hits"`
+	CacheWriteMisses          reportUint `json:"cache_write_misses"`
+	WritecacheTotalBlocks     reportUint `json:"writecache_total_blocks"`
+	WritecacheFreeBlocks      reportUint `json:"writecache_free_blocks"`
+	WritecacheWritebackBlocks reportUint `json:"writecache_writeback_blocks"`
+}
+
+var lvFields = strings.Join([]string{
+	"lv_name,vg_name,lv_path,lv_size,lv_kernel_major,lv_kernel_minor,origin,origin_size,pool_lv,thin_count,thin_id,lv_tags",
+	"data_percent,metadata_percent,lv_metadata_size,lv_attr,lv_active,segtype,lv_health_status,sync_percent,raid_sync_action",
+}, ",")
+
+// lvCacheFields lvmcache卷的缓存统计，只在LVCacheStats中查询
+const lvCacheFields = "cache_mode,cache_total_blocks,cache_used_blocks,cache_dirty_blocks,cache_read_hits,cache_read_misses,cache_write_hits,cache_write_misses"
+
+// lvWritecacheFields dm-writecache的统计，lvm2 2.03之前没有这些字段
+const lvWritecacheFields = "writecache_total_blocks,writecache_free_blocks,writecache_writeback_blocks"
+
+func decodeReport(output string) (*lvmReport, error) {
+	report := &lvmReport{}
+	if strings.TrimSpace(output) == "" {
+		return report, nil
+	}
+	if err := json.Unmarshal([]byte(output), report); err != nil {
+		return nil, fmt.Errorf("decode lvm report failed: %w", err)
+	}
+	return report, nil
+}
+
+func parseVgs(output string) ([]api.VgGroup, error) {
+	report, err := decodeReport(output)
+	if err != nil {
+		return nil, err
+	}
 	resp := []api.VgGroup{}
+	for _, r := range report.Report {
+		for _, vg := range r.VG {
+			resp = append(resp, api.VgGroup{
+				VGName:         vg.VGName,
+				PVCount:        uint64(vg.PVCount),
+				LVCount:        uint64(vg.LVCount),
+				VGAttr:         vg.VGAttr,
+				VGSize:         uint64(vg.VGSize),
+				VGFree:         uint64(vg.VGFree),
+				VGExtentSize:   uint64(vg.VGExtentSize),
+				VGTags:         vg.VGTags,
+				MissingPVCount: uint64(vg.MissingPVCount),
+				PVS:            []*api.PVInfo{},
+			})
+		}
+	}
+	return resp, nil
+}
+
+func parsePvs(output string) ([]api.PVInfo, error) {
+	report, err := decodeReport(output)
+	if err != nil {
+		return nil, err
+	}
+	resp := []api.PVInfo{}
+	for _, r := range report.Report {
+		for _, pv := range r.PV {
+			resp = append(resp, api.PVInfo{
+				PVName:       pv.PVName,
+				VGName:       pv.VGName,
+				PVFmt:        pv.PVFmt,
+				PVAttr:       pv.PVAttr,
+				PVSize:       uint64(pv.PVSize),
+				PVFree:       uint64(pv.PVFree),
+				PVTags:       pv.PVTags,
+				PVUUID:       pv.PVUUID,
+				DeviceID:     pv.DeviceID,
+				DeviceIDType: pv.DeviceIDType,
+			})
+		}
+	}
+	return resp, nil
+}
 
-	if vgsString == "" {
-		return resp
-	}
-
-	vgsString = strings.ReplaceAll(vgsString, "'", "")
-	vgsString = strings.ReplaceAll(vgsString, " ", "")
-
-	vgsList := strings.Split(vgsString, "\n")
-	for _, vgs := range vgsList {
-		tmp := api.VgGroup{}
-		vg := strings.Split(vgs, ",")
-		for _, v := range vg {
-			k := strings.Split(v, "=")
-
-			switch k[0] {
-			case "LVM2_VG_NAME":
-				tmp.VGName = k[1]
-			case "LVM2_PV_COUNT":
-				tmp.PVCount, _ = strconv.ParseUint(k[1], 10, 64)
-			case "LVM2_LV_COUNT":
-				tmp.LVCount, _ = strconv.ParseUint(k[1], 10, 64)
-			case "LVM2_VG_ATTR":
-				tmp.VGAttr = k[1]
-			case "LVM2_VG_SIZE":
-				tmp.VGSize, _ = strconv.ParseUint(k[1], 10, 64)
-			case "LVM2_VG_FREE":
-				tmp.VGFree, _ = strconv.ParseUint(k[1], 10, 64)
-			default:
-				log.Warnf("undefined filed %s-%s", k[0], k[1])
+func parseLvs(output string) ([]types.LvInfo, error) {
+	report, err := decodeReport(output)
+	if err != nil {
+		return nil, err
+	}
+	resp := []types.LvInfo{}
+	for _, r := range report.Report {
+		for _, lv := range r.LV {
+			if !carinaLvName(lv.LVName) {
+				continue
 			}
+			resp = append(resp, lv.lvInfo())
 		}
-		tmp.PVS = []*api.PVInfo{}
-		resp = append(resp, tmp)
 	}
-	return resp
+	return resp, nil
 }
 
-func parseLvs(lvsString string) []types.LvInfo {
-	// LVM2_LV_NAME='t1',LVM2_LV_PATH='/dev/v1/t1',LVM2_LV_SIZE='1073741824',LVM2_LV_KERNEL_MAJOR='252',LVM2_LV_KERNEL_MINOR='0',LVM2_ORIGIN='',LVM2_ORIGIN_SIZE='',LVM2_POOL_LV='',LVM2_THIN_COUNT='',LVM2_LV_TAGS='t1'
-	// LVM2_LV_NAME='t5',LVM2_LV_PATH='',LVM2_LV_SIZE='6979321856',LVM2_LV_KERNEL_MAJOR='252',LVM2_LV_KERNEL_MINOR='3',LVM2_ORIGIN='',LVM2_ORIGIN_SIZE='',LVM2_POOL_LV='',LVM2_THIN_COUNT='1',LVM2_LV_TAGS=''
-	// LVM2_LV_NAME='m2',LVM2_LV_PATH='/dev/v1/m2',LVM2_LV_SIZE='2147483648',LVM2_LV_KERNEL_MAJOR='252',LVM2_LV_KERNEL_MINOR='5',LVM2_ORIGIN='',LVM2_ORIGIN_SIZE='',LVM2_POOL_LV='t5',LVM2_THIN_COUNT='',LVM2_LV_TAGS=''
+// carinaLvName 是否为carina创建的lv，克隆时的临时快照也需要查询以便清理
+func carinaLvName(name string) bool {
+	for _, prefix := range []string{carina.VolumePrefix, carina.ThinPrefix, carina.SnapshotPrefix, carina.ClonePrefix} {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
 
-	resp := []types.LvInfo{}
-	if lvsString == "" {
-		return resp
-	}
-
-	lvsString = strings.ReplaceAll(lvsString, "'", "")
-	lvsString = strings.ReplaceAll(lvsString, " ", "")
-
-	lvsList := strings.Split(lvsString, "\n")
-	for _, lvs := range lvsList {
-		tmp := types.LvInfo{}
-		lv := strings.Split(lvs, ",")
-		for _, v := range lv {
-			k := strings.Split(v, "=")
-
-			switch k[0] {
-			case "LVM2_LV_NAME":
-				tmp.LVName = k[1]
-			case "LVM2_VG_NAME":
-				tmp.VGName = k[1]
-			case "LVM2_LV_PATH":
-				tmp.LVPath = k[1]
-			case "LVM2_LV_SIZE":
-				tmp.LVSize, _ = strconv.ParseUint(k[1], 10, 64)
-			case "LVM2_LV_KERNEL_MAJOR":
-				t, _ := strconv.ParseUint(k[1], 10, 32)
-				tmp.LVKernelMajor = uint32(t)
-			case "LVM2_LV_KERNEL_MINOR":
-				t, _ := strconv.ParseUint(k[1], 10, 32)
-				tmp.LVKernelMinor = uint32(t)
-			case "LVM2_ORIGIN":
-				tmp.Origin = k[1]
-			case "LVM2_ORIGIN_SIZE":
-				tmp.OriginSize, _ = strconv.ParseUint(k[1], 10, 64)
-			case "LVM2_POOL_LV":
-				tmp.PoolLV = k[1]
-			case "LVM2_THIN_COUNT":
-				tmp.ThinCount, _ = strconv.ParseUint(k[1], 10, 64)
-			case "LVM2_LV_TAGS":
-				tmp.LVTags = k[1]
-			case "LVM2_DATA_PERCENT":
-				tmp.DataPercent, _ = strconv.ParseFloat(k[1], 64)
-			case "LVM2_LV_ATTR":
-				tmp.LVAttr = k[1]
-			case "LVM2_LV_ACTIVE":
-				tmp.LVActive = k[1]
-			default:
-				log.Warnf("undefined field %s=%s", k[0], k[1])
+func parseLvCacheStats(output string) ([]types.LvCacheStats, error) {
+	report, err := decodeReport(output)
+	if err != nil {
+		return nil, err
+	}
+	resp := []types.LvCacheStats{}
+	for _, r := range report.Report {
+		for _, lv := range r.LV {
+			if stats := lv.cacheStats(); stats != nil {
+				resp = append(resp, *stats)
 			}
 		}
-		if strings.HasPrefix(tmp.LVName, carina.VolumePrefix) || strings.HasPrefix(tmp.LVName, carina.ThinPrefix) {
-			resp = append(resp, tmp)
+	}
+	return resp, nil
+}
+
+func parseLvRaidStatus(output string) ([]types.LvRaidStatus, error) {
+	report, err := decodeReport(output)
+	if err != nil {
+		return nil, err
+	}
+	resp := []types.LvRaidStatus{}
+	for _, r := range report.Report {
+		for _, lv := range r.LV {
+			resp = append(resp, types.LvRaidStatus{
+				LVName:       lv.LVName,
+				VGName:       lv.VGName,
+				SegType:      lv.SegType,
+				SyncPercent:  lv.SyncPercent,
+				HealthStatus: lv.HealthStatus,
+				SyncAction:   lv.SyncAction,
+			})
 		}
 	}
-	return resp
+	return resp, nil
 }
 
-func parsePvs(pvsString string) []api.PVInfo {
-	// LVM2_PV_NAME='/dev/loop2',LVM2_VG_NAME='lvmvg',LVM2_PV_FMT='lvm2',LVM2_PV_ATTR='a--',LVM2_PV_SIZE='16101933056',LVM2_PV_FREE='16101933056'
-	resp := []api.PVInfo{}
+func (lv *lvReport) lvInfo() types.LvInfo {
+	syncPercent, _ := strconv.ParseFloat(lv.SyncPercent, 64)
+	return types.LvInfo{
+		LVName:          lv.LVName,
+		VGName:          lv.VGName,
+		LVPath:          lv.LVPath,
+		LVSize:          uint64(lv.LVSize),
+		LVKernelMajor:   uint32(lv.LVKernelMajor),
+		LVKernelMinor:   uint32(lv.LVKernelMinor),
+		Origin:          lv.Origin,
+		OriginSize:      uint64(lv.OriginSize),
+		PoolLV:          lv.PoolLV,
+		ThinCount:       uint64(lv.ThinCount),
+		ThinID:          uint64(lv.ThinID),
+		LVTags:          lv.LVTags,
+		DataPercent:     float64(lv.DataPercent),
+		MetadataPercent: float64(lv.MetadataPercent),
+		MetadataSize:    uint64(lv.MetadataSize),
+		LVAttr:          lv.LVAttr,
+		LVActive:        lv.LVActive,
+		SegType:         lv.SegType,
+		HealthStatus:    lv.HealthStatus,
+		SyncPercent:     syncPercent,
+	}
+}
 
-	if pvsString == "" {
-		return resp
-	}
-
-	pvsString = strings.ReplaceAll(pvsString, "'", "")
-	pvsString = strings.ReplaceAll(pvsString, " ", "")
-
-	pvsList := strings.Split(pvsString, "\n")
-	for _, pvs := range pvsList {
-		tmp := api.PVInfo{}
-		pv := strings.Split(pvs, ",")
-		for _, v := range pv {
-			k := strings.Split(v, "=")
-
-			switch k[0] {
-			case "LVM2_PV_NAME":
-				tmp.PVName = k[1]
-			case "LVM2_VG_NAME":
-				tmp.VGName = k[1]
-			case "LVM2_PV_FMT":
-				tmp.PVFmt = k[1]
-			case "LVM2_PV_ATTR":
-				tmp.PVAttr = k[1]
-			case "LVM2_PV_SIZE":
-				tmp.PVSize, _ = strconv.ParseUint(k[1], 10, 64)
-			case "LVM2_PV_FREE":
-				tmp.PVFree, _ = strconv.ParseUint(k[1], 10, 64)
-			default:
-				log.Warnf("undefined field %s-%s", k[0], k[1])
-			}
+// cacheStats lvmcache卷的缓存统计，非cache以及writecache卷返回nil
+func (lv *lvReport) cacheStats() *types.LvCacheStats {
+	stats := &types.LvCacheStats{
+		LVName:  lv.LVName,
+		VGName:  lv.VGName,
+		SegType: lv.SegType,
+	}
+	switch lv.SegType {
+	case "cache":
+		stats.CacheMode = lv.CacheMode
+		stats.TotalBlocks = uint64(lv.CacheTotalBlocks)
+		stats.UsedBlocks = uint64(lv.CacheUsedBlocks)
+		stats.DirtyBlocks = uint64(lv.CacheDirtyBlocks)
+		stats.ReadHits = uint64(lv.CacheReadHits)
+		stats.ReadMisses = uint64(lv.CacheReadMisses)
+		stats.WriteHits = uint64(lv.CacheWriteHits)
+		stats.WriteMisses = uint64(lv.CacheWriteMisses)
+	case "writecache":
+		// dm-writecache没有命中统计，使用块数和待回写块数
+		stats.CacheMode = "writecache"
+		stats.TotalBlocks = uint64(lv.WritecacheTotalBlocks)
+		if lv.WritecacheTotalBlocks > lv.WritecacheFreeBlocks {
+			stats.UsedBlocks = uint64(lv.WritecacheTotalBlocks - lv.WritecacheFreeBlocks)
 		}
-		resp = append(resp, tmp)
+		stats.DirtyBlocks = uint64(lv.WritecacheWritebackBlocks)
+	default:
+		return nil
 	}
-	return resp
+	return stats
+}
+
+// thinDelta thin_delta的输出，begin以及length的单位为pool的数据块，data_block_size的单位为扇区
+type thinDelta struct {
+	DataBlockSize uint64 `xml:"data_block_size,attr"`
+	Diff          struct {
+		Ranges []struct {
+			XMLName xml.Name
+			Begin   uint64 `xml:"begin,attr"`
+			Length  uint64 `xml:"length,attr"`
+		} `xml:",any"`
+	} `xml:"diff"`
+}
+
+// parseThinDelta 解析两个thin设备之间变化的数据范围，新设备中取消映射的数据块同样视为变化
+func parseThinDelta(output string) ([]types.BlockRange, error) {
+	delta := &thinDelta{}
+	if err := xml.Unmarshal([]byte(output), delta); err != nil {
+		return nil, fmt.Errorf("decode thin delta failed: %w", err)
+	}
+	if delta.DataBlockSize == 0 {
+		return nil, fmt.Errorf("decode thin delta failed: data block size is 0")
+	}
+	blockSize := delta.DataBlockSize * 512
+	resp := []types.BlockRange{}
+	for _, r := range delta.Diff.Ranges {
+		switch r.XMLName.Local {
+		case "different", "left_only", "right_only":
+		default:
+			continue
+		}
+		offset, length := r.Begin*blockSize, r.Length*blockSize
+		// 合并相邻的范围
+		if n := len(resp); n > 0 && resp[n-1].Offset+resp[n-1].Length == offset {
+			resp[n-1].Length += length
+			continue
+		}
+		resp = append(resp, types.BlockRange{Offset: offset, Length: length})
+	}
+	return resp, nil
+}
+
+// parseLvmVersion 解析lvm version输出中的LVM版本，如"  LVM version:     2.03.14(2) (2021-10-20)"返回2和3
+func parseLvmVersion(output string) (int, int, error) {
+	for _, line := range strings.Split(output, "\n") {
+		version := strings.TrimSpace(line)
+		if !strings.HasPrefix(version, "LVM version:") {
+			continue
+		}
+		fields := strings.Fields(strings.TrimPrefix(version, "LVM version:"))
+		if len(fields) == 0 {
+			break
+		}
+		parts := strings.SplitN(fields[0], ".", 3)
+		if len(parts) < 2 {
+			break
+		}
+		major, err := strconv.Atoi(parts[0])
+		if err != nil {
+			break
+		}
+		minor, err := strconv.Atoi(parts[1])
+		if err != nil {
+			break
+		}
+		return major, minor, nil
+	}
+	return 0, 0, fmt.Errorf("decode lvm version failed: %s", strings.TrimSpace(output))
+}
+
+// dmName lvm设备在device mapper中的名称，vg以及lv名称中的-需要转义为--
+func dmName(vg, lv string) string {
+	return strings.ReplaceAll(vg, "-", "--") + "-" + strings.ReplaceAll(lv, "-", "--")
 }
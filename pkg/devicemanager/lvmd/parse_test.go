@@ -0,0 +1,186 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package lvmd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/carina-io/carina/pkg/devicemanager/types"
+)
+
+func TestParseLvs(t *testing.T) {
+	output := `  {
+      "report": [
+          {
+              "lv": [
+                  {"lv_name":"thin-t5", "vg_name":"carina-vg-hdd", "lv_path":"", "lv_size":"6979321856", "lv_kernel_major":"252", "lv_kernel_minor":"3", "origin":"", "origin_size":"", "pool_lv":"", "thin_count":"1", "lv_tags":"", "data_percent":"12.50", "metadata_percent":"10.55", "lv_metadata_size":"8388608", "lv_attr":"twi-aotz--", "lv_active":"active", "segtype":"thin-pool", "lv_health_status":"", "sync_percent":"", "raid_sync_action":""},
+                  {"lv_name":"volume-m2", "vg_name":"carina-vg-hdd", "lv_path":"/dev/carina-vg-hdd/volume-m2", "lv_size":"2147483648", "lv_kernel_major":"-1", "lv_kernel_minor":"-1", "origin":"", "origin_size":"", "pool_lv":"", "thin_count":"", "lv_tags":"owner=a,b\"c", "data_percent":"", "metadata_percent":"", "lv_attr":"rwi-a-r-p-", "lv_active":"active", "segtype":"raid1", "lv_health_status":"partial", "sync_percent":"35.20", "raid_sync_action":"recover"},
+                  {"lv_name":"volume-m3", "vg_name":"carina-vg-hdd", "lv_path":"/dev/carina-vg-hdd/volume-m3", "lv_size":"1073741824", "segtype":"cache", "cache_mode":"writeback", "cache_total_blocks":"16384", "cache_used_blocks":"120", "cache_dirty_blocks":"3", "cache_read_hits":"10", "cache_read_misses":"20", "cache_write_hits":"30", "cache_write_misses":"40"},
+                  {"lv_name":"clone-volume-m4", "vg_name":"carina-vg-hdd", "lv_path":"/dev/carina-vg-hdd/clone-volume-m4", "lv_size":"2147483648", "origin":"volume-m2", "lv_attr":"swi-a-s---", "segtype":"linear"},
+                  {"lv_name":"lvol0_pmspare", "vg_name":"carina-vg-hdd", "lv_size":"4194304"}
+              ]
+          }
+      ]
+  }
+`
+	lvs, err := parseLvs(output)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, len(lvs))
+
+	assert.Equal(t, "thin-pool", lvs[0].SegType)
+	assert.Equal(t, uint64(6979321856), lvs[0].LVSize)
+	assert.Equal(t, uint64(1), lvs[0].ThinCount)
+	assert.Equal(t, uint32(252), lvs[0].LVKernelMajor)
+	assert.Equal(t, 10.55, lvs[0].MetadataPercent)
+	assert.Equal(t, uint64(8388608), lvs[0].MetadataSize)
+
+	// 标签中的逗号以及引号不影响解析
+	assert.Equal(t, "owner=a,b\"c", lvs[1].LVTags)
+	assert.Equal(t, uint32(0), lvs[1].LVKernelMajor)
+	assert.Equal(t, "partial", lvs[1].HealthStatus)
+	assert.Equal(t, 35.2, lvs[1].SyncPercent)
+
+	assert.Equal(t, "cache", lvs[2].SegType)
+	// 克隆时的临时快照
+	assert.Equal(t, "volume-m2", lvs[3].Origin)
+
+	raids, err := parseLvRaidStatus(output)
+	assert.NoError(t, err)
+	assert.Equal(t, "35.20", raids[1].SyncPercent)
+	assert.Equal(t, "recover", raids[1].SyncAction)
+
+	stats, err := parseLvCacheStats(output)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(stats))
+	assert.Equal(t, "writeback", stats[0].CacheMode)
+	assert.Equal(t, uint64(120), stats[0].UsedBlocks)
+	assert.Equal(t, uint64(40), stats[0].WriteMisses)
+
+	_, err = parseLvs("Failed to find logical volume")
+	assert.Error(t, err)
+}
+
+// lvm2 2.02没有writecache字段，报告中不包含writecache_*
+func TestParseLvsLvm202(t *testing.T) {
+	output := `  {
+      "report": [
+          {
+              "lv": [
+                  {"lv_name":"volume-m1", "vg_name":"carina-vg-hdd", "lv_path":"/dev/carina-vg-hdd/volume-m1", "lv_size":"1073741824", "lv_kernel_major":"253", "lv_kernel_minor":"4", "origin":"", "origin_size":"", "pool_lv":"", "thin_count":"", "thin_id":"", "lv_tags":"", "data_percent":"", "metadata_percent":"", "lv_attr":"-wi-ao----", "lv_active":"active", "segtype":"linear", "lv_health_status":"", "sync_percent":"", "raid_sync_action":""},
+                  {"lv_name":"volume-m3", "vg_name":"carina-vg-hdd", "lv_path":"/dev/carina-vg-hdd/volume-m3", "lv_size":"1073741824", "lv_kernel_major":"253", "lv_kernel_minor":"7", "origin":"", "origin_size":"", "pool_lv":"[lvmcache-volume-m3]", "thin_count":"", "thin_id":"", "lv_tags":"", "data_percent":"0.73", "metadata_percent":"0.78", "lv_attr":"Cwi-aoC---", "lv_active":"active", "segtype":"cache", "lv_health_status":"", "sync_percent":"0.00", "raid_sync_action":"", "cache_mode":"writethrough", "cache_total_blocks":"4096", "cache_used_blocks":"30", "cache_dirty_blocks":"0", "cache_read_hits":"5", "cache_read_misses":"6", "cache_write_hits":"7", "cache_write_misses":"8"}
+              ]
+          }
+      ]
+  }
+`
+	lvs, err := parseLvs(output)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(lvs))
+	assert.Equal(t, uint32(253), lvs[0].LVKernelMajor)
+	assert.Equal(t, "linear", lvs[0].SegType)
+
+	stats, err := parseLvCacheStats(output)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(stats))
+	assert.Equal(t, "writethrough", stats[0].CacheMode)
+	assert.Equal(t, uint64(4096), stats[0].TotalBlocks)
+	assert.Equal(t, uint64(30), stats[0].UsedBlocks)
+}
+
+func TestParseLvCacheStats(t *testing.T) {
+	output := `  {
+      "report": [
+          {
+              "lv": [
+                  {"lv_name":"volume-m1", "vg_name":"carina-vg-hdd", "lv_size":"1073741824", "segtype":"linear", "cache_mode":"", "cache_total_blocks":"", "writecache_total_blocks":"", "writecache_free_blocks":"", "writecache_writeback_blocks":""},
+                  {"lv_name":"volume-m3", "vg_name":"carina-vg-hdd", "lv_size":"1073741824", "segtype":"cache", "cache_mode":"writeback", "cache_total_blocks":"16384", "cache_used_blocks":"120", "cache_dirty_blocks":"3", "cache_read_hits":"10", "cache_read_misses":"20", "cache_write_hits":"30", "cache_write_misses":"40", "writecache_total_blocks":"", "writecache_free_blocks":"", "writecache_writeback_blocks":""},
+                  {"lv_name":"volume-m4", "vg_name":"carina-vg-hdd", "lv_size":"1073741824", "segtype":"writecache", "cache_mode":"", "cache_total_blocks":"", "writecache_total_blocks":"262144", "writecache_free_blocks":"262000", "writecache_writeback_blocks":"16"}
+              ]
+          }
+      ]
+  }
+`
+	stats, err := parseLvCacheStats(output)
+	assert.NoError(t, err)
+	assert.Equal(t, []types.LvCacheStats{
+		{LVName: "volume-m3", VGName: "carina-vg-hdd", SegType: "cache", CacheMode: "writeback", TotalBlocks: 16384, UsedBlocks: 120, DirtyBlocks: 3, ReadHits: 10, ReadMisses: 20, WriteHits: 30, WriteMisses: 40},
+		{LVName: "volume-m4", VGName: "carina-vg-hdd", SegType: "writecache", CacheMode: "writecache", TotalBlocks: 262144, UsedBlocks: 144, DirtyBlocks: 16},
+	}, stats)
+}
+
+func TestParseLvmVersion(t *testing.T) {
+	major, minor, err := parseLvmVersion(lvm203Version)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{2, 3}, []int{major, minor})
+
+	major, minor, err = parseLvmVersion(lvm202Version)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{2, 2}, []int{major, minor})
+
+	_, _, err = parseLvmVersion("  WARNING: Failed to connect to lvmetad.")
+	assert.Error(t, err)
+	_, _, err = parseLvmVersion("  LVM version:     unknown")
+	assert.Error(t, err)
+	_, _, err = parseLvmVersion("  LVM version:")
+	assert.Error(t, err)
+}
+
+func TestParseVgsAndPvs(t *testing.T) {
+	vgs, err := parseVgs(`{"report": [{"vg": [{"vg_name":"carina-vg-hdd", "pv_count":"2", "lv_count":"1", "vg_attr":"wz-pn-", "vg_size":"32203866112", "vg_free":"16101933056", "vg_extent_size":"4194304", "vg_tags":"", "vg_missing_pv_count":"1"}]}]}`)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(vgs))
+	assert.Equal(t, uint64(32203866112), vgs[0].VGSize)
+	assert.Equal(t, uint64(4194304), vgs[0].VGExtentSize)
+	assert.Equal(t, uint64(1), vgs[0].MissingPVCount)
+	assert.NotNil(t, vgs[0].PVS)
+
+	pvs, err := parsePvs(`{"report": [{"pv": [{"pv_name":"/dev/sdb", "vg_name":"carina-vg-hdd", "pv_fmt":"lvm2", "pv_attr":"a--", "pv_size":"16101933056", "pv_free":"16101933056", "pv_tags":"carina.storage.io/cache-group:ssd,carina.storage.io/disk-id:wwn-0x5000:sdb", "pv_uuid":"OiNoxD-Y1sw-FSzi-mqPN-07EW-C77P-TNdtc6", "deviceid":"naa.5000c500a1b2c3d4", "deviceidtype":"wwid"}]}]}`)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(pvs))
+	assert.Equal(t, "carina.storage.io/cache-group:ssd,carina.storage.io/disk-id:wwn-0x5000:sdb", pvs[0].PVTags)
+	assert.Equal(t, "OiNoxD-Y1sw-FSzi-mqPN-07EW-C77P-TNdtc6", pvs[0].PVUUID)
+	assert.Equal(t, "naa.5000c500a1b2c3d4", pvs[0].DeviceID)
+	assert.Equal(t, "wwid", pvs[0].DeviceIDType)
+
+	empty, err := parsePvs("")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(empty))
+}
+
+func TestParseThinDelta(t *testing.T) {
+	output := `<superblock uuid="" time="2" transaction="3" data_block_size="128" nr_data_blocks="4096">
+  <diff left="1" right="2">
+    <different begin="0" length="2"/>
+    <right_only begin="2" length="1"/>
+    <left_only begin="10" length="4"/>
+  </diff>
+</superblock>`
+	ranges, err := parseThinDelta(output)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(ranges))
+	assert.Equal(t, uint64(0), ranges[0].Offset)
+	assert.Equal(t, uint64(3*64*1024), ranges[0].Length)
+	assert.Equal(t, uint64(10*64*1024), ranges[1].Offset)
+	assert.Equal(t, uint64(4*64*1024), ranges[1].Length)
+
+	_, err = parseThinDelta("thin_delta: no such device")
+	assert.Error(t, err)
+
+	assert.Equal(t, "carina--vg--hdd-thin--pool", dmName("carina-vg-hdd", "thin-pool"))
+}
@@ -19,16 +19,28 @@ package deviceManager
 import (
 	"context"
 	"github.com/carina-io/carina/pkg/devicemanager/bcache"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/carina-io/carina"
+	"github.com/carina-io/carina/api"
+	carinav1 "github.com/carina-io/carina/api/v1"
+	carinav1beta1 "github.com/carina-io/carina/api/v1beta1"
 	"github.com/carina-io/carina/pkg/configuration"
+	"github.com/carina-io/carina/pkg/devicemanager/crypt"
 	"github.com/carina-io/carina/pkg/devicemanager/lvmd"
 	"github.com/carina-io/carina/pkg/devicemanager/partition"
+	"github.com/carina-io/carina/pkg/devicemanager/raid"
+	"github.com/carina-io/carina/pkg/devicemanager/smart"
+	"github.com/carina-io/carina/pkg/devicemanager/types"
 	"github.com/carina-io/carina/pkg/devicemanager/volume"
 	"github.com/carina-io/carina/utils/exec"
 	"github.com/carina-io/carina/utils/log"
 	"github.com/carina-io/carina/utils/mutx"
 	corev1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
 	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
@@ -36,11 +48,17 @@ import (
 type Trigger string
 
 const (
-	Dummy                 Trigger = "dummy"
-	ConfigModify          Trigger = "configModify"
-	LVMCheck              Trigger = "lvmCheck"
-	CleanupOrphan         Trigger = "cleanupOrphan"
-	LogicVolumeController Trigger = "logicVolumeController"
+	Dummy                   Trigger = "dummy"
+	ConfigModify            Trigger = "configModify"
+	LVMCheck                Trigger = "lvmCheck"
+	CleanupOrphan           Trigger = "cleanupOrphan"
+	LogicVolumeController   Trigger = "logicVolumeController"
+	LogicSnapshotController Trigger = "logicSnapshotController"
+	ThinPoolExtend          Trigger = "thinPoolExtend"
+	DiskMaintenance         Trigger = "diskMaintenance"
+	RaidCheck               Trigger = "raidCheck"
+	DiskHealthCheck         Trigger = "diskHealthCheck"
+	BackupController        Trigger = "backupController"
 )
 
 type VolumeEvent struct {
@@ -55,9 +73,23 @@ type DeviceManager struct {
 	// Volume 操作
 	VolumeManager volume.LocalVolume
 	//磁盘以及分区操作
-	Partition     partition.LocalPartition
-	NodeName      string
+	Partition partition.LocalPartition
+	// LUKS加密设备操作
+	Crypt crypt.LocalCrypt
+	// mdadm软件RAID操作
+	Raid raid.LocalRaid
+	// 磁盘SMART信息
+	Smart    smart.LocalSmart
+	NodeName string
+	// ClusterID 写入卷归属标签的集群标识，为空时不打标签也不清理孤儿卷
+	ClusterID     string
 	noticeUpdates []chan *VolumeEvent
+	// 维护中磁盘的迁移进度
+	maintenanceLock sync.Mutex
+	maintenances    map[string]api.DiskMaintenance
+	// 磁盘健康状态
+	healthLock sync.Mutex
+	diskHealth []api.DiskHealth
 }
 
 func NewDeviceManager(nodeName string, cache cache.Cache, client client.Client) *DeviceManager {
@@ -67,14 +99,29 @@ func NewDeviceManager(nodeName string, cache cache.Cache, client client.Client)
 	dm := DeviceManager{
 		Cache:         cache,
 		Client:        client,
-		VolumeManager: &volume.LocalVolumeImplement{Mutex: mutex, Lv: &lvmd.Lvm2Implement{Executor: executor}, Bcache: &bcache.BcacheImplement{Executor: executor}},
+		VolumeManager: &volume.LocalVolumeImplement{Locks: mutx.NewQueuedLocks(volume.MaxLockWaiters), Lv: &lvmd.Lvm2Implement{Executor: executor}, Bcache: &bcache.BcacheImplement{Executor: executor}, Executor: executor, Reserved: volume.NewReservedSpace()},
 		Partition:     &partition.LocalPartitionImplement{Mutex: mutex, CacheParttionNum: make(map[string]uint), Executor: executor},
+		Crypt:         &crypt.LuksImplement{Executor: executor},
+		Raid:          &raid.MdadmImplement{Executor: executor},
+		Smart:         &smart.SmartctlImplement{Executor: executor},
 		NodeName:      nodeName,
 		noticeUpdates: []chan *VolumeEvent{},
+		maintenances:  map[string]api.DiskMaintenance{},
 	}
 	return &dm
 }
 
+// VolumeOwner LogicVolume对应卷的归属信息
+func (dm *DeviceManager) VolumeOwner(lv *carinav1.LogicVolume) *types.VolumeOwner {
+	return &types.VolumeOwner{
+		ClusterID:    dm.ClusterID,
+		PVName:       lv.PVName(),
+		PVCNamespace: lv.Spec.NameSpace,
+		PVCName:      lv.Spec.Pvc,
+		CreatedAt:    lv.CreationTimestamp.Time,
+	}
+}
+
 func (dm *DeviceManager) GetNodeDiskSelectGroup() map[string]configuration.DiskSelectorItem {
 	diskClass := map[string]configuration.DiskSelectorItem{}
 	currentDiskSelector := configuration.DiskSelector()
@@ -96,6 +143,69 @@ func (dm *DeviceManager) GetNodeDiskSelectGroup() map[string]configuration.DiskS
 	return diskClass
 }
 
+// GetCordonedDisks 从NodeStorageResource注解中获取维护中的磁盘
+func (dm *DeviceManager) GetCordonedDisks() []string {
+	nsr := &carinav1beta1.NodeStorageResource{}
+	err := dm.Cache.Get(context.Background(), client.ObjectKey{Name: dm.NodeName}, nsr)
+	if err != nil {
+		if !apierrs.IsNotFound(err) {
+			log.Errorf("get nodeStorageResource %s error %s", dm.NodeName, err.Error())
+		}
+		return nil
+	}
+	disks := []string{}
+	for _, d := range strings.Split(nsr.Annotations[carina.DiskCordonAnnotation], ",") {
+		d = strings.TrimSpace(d)
+		if d == "" {
+			continue
+		}
+		if !strings.HasPrefix(d, "/dev/") {
+			d = "/dev/" + d
+		}
+		disks = append(disks, d)
+	}
+	return disks
+}
+
+func (dm *DeviceManager) SetDiskMaintenance(m api.DiskMaintenance) {
+	dm.maintenanceLock.Lock()
+	defer dm.maintenanceLock.Unlock()
+	dm.maintenances[m.Disk] = m
+}
+
+func (dm *DeviceManager) RemoveDiskMaintenance(disk string) {
+	dm.maintenanceLock.Lock()
+	defer dm.maintenanceLock.Unlock()
+	delete(dm.maintenances, disk)
+}
+
+func (dm *DeviceManager) GetDiskMaintenances() []api.DiskMaintenance {
+	dm.maintenanceLock.Lock()
+	defer dm.maintenanceLock.Unlock()
+	resp := []api.DiskMaintenance{}
+	for _, m := range dm.maintenances {
+		resp = append(resp, m)
+	}
+	sort.Slice(resp, func(i, j int) bool {
+		return resp[i].Disk < resp[j].Disk
+	})
+	return resp
+}
+
+func (dm *DeviceManager) SetDiskHealth(health []api.DiskHealth) {
+	dm.healthLock.Lock()
+	defer dm.healthLock.Unlock()
+	dm.diskHealth = health
+}
+
+func (dm *DeviceManager) GetDiskHealth() []api.DiskHealth {
+	dm.healthLock.Lock()
+	defer dm.healthLock.Unlock()
+	resp := make([]api.DiskHealth, len(dm.diskHealth))
+	copy(resp, dm.diskHealth)
+	return resp
+}
+
 func (dm *DeviceManager) NoticeUpdateCapacity(trigger Trigger, done chan struct{}) {
 	for _, notice := range dm.noticeUpdates {
 		select {
@@ -17,6 +17,7 @@
 package partition
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
@@ -28,6 +29,7 @@ import (
 	"github.com/anuvu/disko/linux"
 	"github.com/anuvu/disko/partid"
 	"github.com/carina-io/carina"
+	"github.com/carina-io/carina/api"
 	"github.com/carina-io/carina/pkg/devicemanager/types"
 	"github.com/carina-io/carina/utils/exec"
 	"github.com/carina-io/carina/utils/log"
@@ -45,11 +47,13 @@ type LocalPartition interface {
 	ScanAllDisks(filter disko.DiskFilter) (disko.DiskSet, error)
 	ScanAllDisk(paths []string) (disko.DiskSet, error)
 	ScanDisk(groups string) (disko.Disk, error)
-	CreatePartition(name, groups string, size uint64) error
+	// CreatePartition owner不为空时分区GUID由集群标识以及pv名称生成，用于判断分区归属
+	CreatePartition(name, groups string, size uint64, owner *types.VolumeOwner) error
 	GetPartition(name, groups string) (disko.Partition, error)
 	UpdatePartition(name, groups string, size uint64) error
 	DeletePartition(name, groups string) error
 	DeletePartitionByPartNumber(disk disko.Disk, number uint) error
+	CopyPartition(name, sourceName, groups string) error
 	UpdatePartitionCache(name string, number uint) error
 	Wipe(name, groups string) error
 	UdevSettle() error
@@ -58,6 +62,11 @@ type LocalPartition interface {
 	ListDevicesDetail(device string) ([]*types.LocalDisk, error)
 	GetDiskUsed(device string) (uint64, error)
 	GetDevice(deviceNumber string) (*types.LocalDisk, error)
+	// GetUdevInfo 获取设备的udev属性以及符号链接
+	GetUdevInfo(device string) (*api.UdevInfo, error)
+
+	// WithContext 返回绑定ctx的LocalPartition，ctx结束时正在执行的命令连同其进程组一起被终止
+	WithContext(ctx context.Context) LocalPartition
 }
 
 const DISKMUTEX = "DiskMutex"
@@ -78,6 +87,14 @@ func NewLocalPartitionImplement() *LocalPartitionImplement {
 		Executor:         executor}
 }
 
+func (ld *LocalPartitionImplement) WithContext(ctx context.Context) LocalPartition {
+	return &LocalPartitionImplement{
+		Mutex:            ld.Mutex,
+		CacheParttionNum: ld.CacheParttionNum,
+		Executor:         ld.Executor.WithContext(ctx),
+	}
+}
+
 func (ld *LocalPartitionImplement) ScanAllDisk(paths []string) (disko.DiskSet, error) {
 	matchAll = func(d disko.Disk) bool {
 		return true
@@ -138,7 +155,7 @@ func parseUdevInfo(output string) map[string]string {
 	return result
 }
 
-func (ld *LocalPartitionImplement) CreatePartition(name, groups string, size uint64) error {
+func (ld *LocalPartitionImplement) CreatePartition(name, groups string, size uint64, owner *types.VolumeOwner) error {
 	partition, _ := ld.GetPartition(name, groups)
 	if partition.Name == name {
 		return nil
@@ -149,6 +166,7 @@ func (ld *LocalPartitionImplement) CreatePartition(name, groups string, size uin
 		return nil
 	}
 	diskPath := strings.Split(groups, "/")[1]
+	size = alignPartition(size)
 	log.Info("create partition: group:", groups, " path:", diskPath, "size", size)
 	if !ld.Mutex.TryAcquire(DISKMUTEX) {
 		log.Info("wait other task release mutex, please retry...")
@@ -193,6 +211,9 @@ func (ld *LocalPartitionImplement) CreatePartition(name, groups string, size uin
 		Name:   partitionName,
 		Number: partitionNum,
 	}
+	if owner != nil && owner.ClusterID != "" {
+		part.ID = types.PartitionID(owner.ClusterID, owner.PVName)
+	}
 	log.Info("create partition", part)
 	err = mysys.CreatePartition(disk, part)
 	if err != nil {
@@ -205,6 +226,7 @@ func (ld *LocalPartitionImplement) CreatePartition(name, groups string, size uin
 }
 
 func (ld *LocalPartitionImplement) UpdatePartition(name, groups string, size uint64) error {
+	size = alignPartition(size)
 	partition, _ := ld.GetPartition(name, groups)
 	if partition.Last-partition.Start >= size {
 		return nil
@@ -262,9 +284,9 @@ func (ld *LocalPartitionImplement) UpdatePartition(name, groups string, size uin
 				return err
 			}
 		}
-		_, err = ld.Executor.ExecuteCommandWithOutput("parted", "-s", disk.Path, "resizepart", fmt.Sprintf("%d", p.Number), fmt.Sprintf("%vg", last>>30))
+		_, err = ld.Executor.ExecuteCommandWithOutput("parted", "-s", disk.Path, "resizepart", fmt.Sprintf("%d", p.Number), fmt.Sprintf("%dB", last))
 		if err != nil {
-			log.Error("exec parted ", disk.Path, " resizepart ", fmt.Sprintf("%d", p.Number), fmt.Sprintf("%vg", last>>30), " failed:"+err.Error())
+			log.Error("exec parted ", disk.Path, " resizepart ", fmt.Sprintf("%d", p.Number), fmt.Sprintf("%dB", last), " failed:"+err.Error())
 			return err
 		}
 		if isMount {
@@ -282,6 +304,11 @@ func (ld *LocalPartitionImplement) UpdatePartition(name, groups string, size uin
 	return ld.PartProbe()
 }
 
+// alignPartition 分区大小按1MiB向上对齐
+func alignPartition(size uint64) uint64 {
+	return (size + carina.PartitionAlignment - 1) / carina.PartitionAlignment * carina.PartitionAlignment
+}
+
 func (ld *LocalPartitionImplement) DeletePartition(name, groups string) error {
 	if !ld.Mutex.TryAcquire(DISKMUTEX) {
 		log.Info("wait other task release mutex, please retry...")
@@ -345,6 +372,35 @@ func (ld *LocalPartitionImplement) DeletePartitionByPartNumber(disk disko.Disk,
 
 }
 
+// CopyPartition 复制同一磁盘上源分区的数据到目标分区
+func (ld *LocalPartitionImplement) CopyPartition(name, sourceName, groups string) error {
+	disk, err := ld.ScanDisk(groups)
+	if err != nil {
+		log.Error("scanDisk group ", groups, " failed "+err.Error())
+		return err
+	}
+	var source, target disko.Partition
+	for _, part := range disk.Partitions {
+		switch part.Name {
+		case sourceName:
+			source = part
+		case name:
+			target = part
+		}
+	}
+	if source.Name == "" {
+		return fmt.Errorf("source partition %s not found on %s", sourceName, disk.Path)
+	}
+	if target.Name == "" {
+		return fmt.Errorf("partition %s not found on %s", name, disk.Path)
+	}
+	if target.Size() < source.Size() {
+		return fmt.Errorf("partition %s is smaller than source partition %s", name, sourceName)
+	}
+
+	return ld.Executor.ExecuteCommand("dd", "if="+linux.GetPartitionKname(disk.Path, source.Number), "of="+linux.GetPartitionKname(disk.Path, target.Number), "bs=4M", "oflag=direct", "conv=fsync")
+}
+
 func (ld *LocalPartitionImplement) UpdatePartitionCache(name string, number uint) error {
 	log.Info("update CachePartitionNum success", number, ld.CacheParttionNum)
 	if _, ok := ld.CacheParttionNum[name]; !ok {
@@ -511,3 +567,16 @@ func (ld *LocalPartitionImplement) GetDevice(deviceNumber string) (*types.LocalD
 	}
 	return nil, nil
 }
+
+func (ld *LocalPartitionImplement) GetUdevInfo(device string) (*api.UdevInfo, error) {
+	info, err := linux.GetUdevInfo(device)
+	if err != nil {
+		return nil, err
+	}
+	return &api.UdevInfo{
+		Name:       info.Name,
+		SysPath:    info.SysPath,
+		Symlinks:   info.Symlinks,
+		Properties: info.Properties,
+	}, nil
+}
@@ -148,7 +148,7 @@ func TestCreatePartition(t *testing.T) {
 	//name: 54cd2f39cf95 group: carina-raw-loop size: 13958643712
 	size := 4747316223
 	lvName := "pvc-58ad162c-1815-476b-9b3d-4735f652842e"
-	err := localparttion.CreatePartition(utils.PartitionName(lvName), "carina-raw-loop/loop2", uint64(size))
+	err := localparttion.CreatePartition(utils.PartitionName(lvName), "carina-raw-loop/loop2", uint64(size), nil)
 	assert.NoError(t, err)
 	disk, err := mysys.ScanDisk("/dev/loop2")
 	assert.NoError(t, err)
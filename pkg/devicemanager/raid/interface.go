@@ -0,0 +1,37 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package raid
+
+import (
+	"github.com/carina-io/carina/api"
+)
+
+// LocalRaid mdadm软件RAID操作
+type LocalRaid interface {
+	// CreateRaid 使用devices创建md阵列，阵列名称与磁盘组名称相同
+	CreateRaid(name, level string, devices []string) error
+	// GetRaid 按名称查询md阵列，不存在时返回nil
+	GetRaid(name string) (*api.Raid, error)
+	// ListRaids 列出本机所有md阵列
+	ListRaids() ([]api.Raid, error)
+	// AddDevice 向阵列添加磁盘，阵列降级时将自动使用该磁盘重建
+	AddDevice(path, device string) error
+	// RemoveFailedDevices 移除阵列中故障以及已经拔出的成员
+	RemoveFailedDevices(path string) error
+	// Assemble 组装已存在但未启动的阵列
+	Assemble() error
+}
@@ -0,0 +1,93 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package raid
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/carina-io/carina/api"
+	"github.com/carina-io/carina/utils/exec"
+	"github.com/carina-io/carina/utils/log"
+)
+
+const mdDirectory = "/dev/md"
+
+type MdadmImplement struct {
+	Executor exec.Executor
+}
+
+func (mi *MdadmImplement) CreateRaid(name, level string, devices []string) error {
+	for _, d := range devices {
+		_ = mi.Executor.ExecuteCommand("wipefs", "-af", d)
+	}
+	args := []string{"--create", filepath.Join(mdDirectory, name), "--run", "--metadata=1.2", "--level=" + level,
+		fmt.Sprintf("--raid-devices=%d", len(devices)), "--name=" + name}
+	args = append(args, devices...)
+	return mi.Executor.ExecuteCommand("mdadm", args...)
+}
+
+func (mi *MdadmImplement) GetRaid(name string) (*api.Raid, error) {
+	raids, err := mi.ListRaids()
+	if err != nil {
+		return nil, err
+	}
+	for i := range raids {
+		if raids[i].Name == name {
+			return &raids[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// ListRaids mdadm --detail --scan
+// ARRAY /dev/md/carina-raid metadata=1.2 name=node1:carina-raid UUID=0f5c2a47:7b1c4b44:9c1a3e2d:4e7f6a10
+func (mi *MdadmImplement) ListRaids() ([]api.Raid, error) {
+	scan, err := mi.Executor.ExecuteCommandWithOutput("mdadm", "--detail", "--scan")
+	if err != nil {
+		return nil, err
+	}
+	resp := []api.Raid{}
+	for _, path := range parseScan(scan) {
+		detail, err := mi.Executor.ExecuteCommandWithOutput("mdadm", "--detail", path)
+		if err != nil {
+			log.Warnf("get detail of raid %s failed %v", path, err)
+			continue
+		}
+		raid := parseDetail(detail)
+		raid.Path = path
+		// pv名称为内核设备名，例如/dev/md127
+		if p, err := filepath.EvalSymlinks(path); err == nil {
+			raid.Path = p
+		}
+		resp = append(resp, *raid)
+	}
+	return resp, nil
+}
+
+func (mi *MdadmImplement) AddDevice(path, device string) error {
+	_ = mi.Executor.ExecuteCommand("wipefs", "-af", device)
+	return mi.Executor.ExecuteCommand("mdadm", "--manage", path, "--add", device)
+}
+
+func (mi *MdadmImplement) RemoveFailedDevices(path string) error {
+	return mi.Executor.ExecuteCommand("mdadm", "--manage", path, "--remove", "failed", "--remove", "detached")
+}
+
+func (mi *MdadmImplement) Assemble() error {
+	return mi.Executor.ExecuteCommand("mdadm", "--assemble", "--scan")
+}
@@ -0,0 +1,134 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package raid
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/carina-io/carina/api"
+)
+
+func parseScan(scan string) []string {
+	resp := []string{}
+	for _, line := range strings.Split(scan, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != "ARRAY" {
+			continue
+		}
+		resp = append(resp, fields[1])
+	}
+	return resp
+}
+
+/*
+/dev/md127:
+           Version : 1.2
+        Raid Level : raid1
+        Array Size : 10476544 (9.99 GiB 10.73 GB)
+      Raid Devices : 2
+     Total Devices : 3
+             State : clean, degraded, recovering
+    Active Devices : 1
+   Working Devices : 2
+    Failed Devices : 1
+     Spare Devices : 1
+    Rebuild Status : 12% complete
+              Name : node1:carina-raid  (local to host node1)
+
+    Number   Major   Minor   RaidDevice State
+       0       8       16        0      active sync   /dev/sdb
+       2       8       48        1      spare rebuilding   /dev/sdd
+
+       1       8       32        -      faulty   /dev/sdc
+*/
+
+func parseDetail(detail string) *api.Raid {
+	resp := &api.Raid{}
+	members := false
+	for _, line := range strings.Split(detail, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if members {
+			if m := parseMember(line); m != nil {
+				resp.Members = append(resp.Members, *m)
+			}
+			continue
+		}
+		if strings.HasPrefix(line, "Number") {
+			members = true
+			continue
+		}
+		k := strings.SplitN(line, " : ", 2)
+		if len(k) != 2 {
+			continue
+		}
+		key, value := strings.TrimSpace(k[0]), strings.TrimSpace(k[1])
+		fields := strings.Fields(value)
+		if len(fields) == 0 {
+			continue
+		}
+		switch key {
+		case "Raid Level":
+			resp.Level = value
+		case "Array Size":
+			// 单位KiB
+			size, _ := strconv.ParseUint(fields[0], 10, 64)
+			resp.Size = size << 10
+		case "State":
+			resp.State = value
+		case "Raid Devices":
+			resp.RaidDevices, _ = strconv.Atoi(value)
+		case "Active Devices":
+			resp.ActiveDevices, _ = strconv.Atoi(value)
+		case "Failed Devices":
+			resp.FailedDevices, _ = strconv.Atoi(value)
+		case "Spare Devices":
+			resp.SpareDevices, _ = strconv.Atoi(value)
+		case "Rebuild Status", "Resync Status", "Reshape Status":
+			resp.RebuildProgress = fields[0]
+		case "Name":
+			name := fields[0]
+			if i := strings.LastIndex(name, ":"); i >= 0 {
+				name = name[i+1:]
+			}
+			resp.Name = name
+		}
+	}
+	resp.Degraded = strings.Contains(resp.State, "degraded") || resp.ActiveDevices < resp.RaidDevices
+	return resp
+}
+
+// parseMember 解析成员设备，已移除的成员没有设备路径
+// 0       8       16        0      active sync   /dev/sdb
+// -       0        0        1      removed
+func parseMember(line string) *api.RaidMember {
+	fields := strings.Fields(line)
+	if len(fields) < 5 {
+		return nil
+	}
+	if _, err := strconv.Atoi(fields[1]); err != nil {
+		return nil
+	}
+	device := fields[len(fields)-1]
+	if strings.HasPrefix(device, "/dev/") {
+		return &api.RaidMember{Device: device, State: strings.Join(fields[4:len(fields)-1], " ")}
+	}
+	return &api.RaidMember{State: strings.Join(fields[4:], " ")}
+}
@@ -0,0 +1,75 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package raid
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDetail(t *testing.T) {
+	detail := `/dev/md127:
+           Version : 1.2
+     Creation Time : Mon Oct 10 10:00:00 2022
+        Raid Level : raid1
+        Array Size : 10476544 (9.99 GiB 10.73 GB)
+     Used Dev Size : 10476544 (9.99 GiB 10.73 GB)
+      Raid Devices : 2
+     Total Devices : 3
+       Persistence : Superblock is persistent
+
+             State : clean, degraded, recovering
+    Active Devices : 1
+   Working Devices : 2
+    Failed Devices : 1
+     Spare Devices : 1
+
+Consistency Policy : resync
+
+    Rebuild Status : 12% complete
+
+              Name : node1:carina-raid-hdd  (local to host node1)
+              UUID : 0f5c2a47:7b1c4b44:9c1a3e2d:4e7f6a10
+            Events : 27
+
+    Number   Major   Minor   RaidDevice State
+       0       8       16        0      active sync   /dev/sdb
+       2       8       48        1      spare rebuilding   /dev/sdd
+
+       1       8       32        -      faulty   /dev/sdc
+`
+	raid := parseDetail(detail)
+	assert.Equal(t, "carina-raid-hdd", raid.Name)
+	assert.Equal(t, "raid1", raid.Level)
+	assert.Equal(t, uint64(10476544<<10), raid.Size)
+	assert.Equal(t, 2, raid.RaidDevices)
+	assert.Equal(t, 1, raid.ActiveDevices)
+	assert.Equal(t, 1, raid.FailedDevices)
+	assert.Equal(t, 1, raid.SpareDevices)
+	assert.True(t, raid.Degraded)
+	assert.Equal(t, "12%", raid.RebuildProgress)
+	assert.Len(t, raid.Members, 3)
+	assert.Equal(t, "/dev/sdd", raid.Members[1].Device)
+	assert.Equal(t, "spare rebuilding", raid.Members[1].State)
+	assert.Equal(t, "faulty", raid.Members[2].State)
+}
+
+func TestParseScan(t *testing.T) {
+	scan := "ARRAY /dev/md/carina-raid-hdd metadata=1.2 name=node1:carina-raid-hdd UUID=0f5c2a47:7b1c4b44:9c1a3e2d:4e7f6a10\n"
+	assert.Equal(t, []string{"/dev/md/carina-raid-hdd"}, parseScan(scan))
+}
@@ -0,0 +1,128 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package smart
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/carina-io/carina/api"
+)
+
+// smartctl退出码，命令行解析失败以及设备打开失败时没有SMART数据
+const (
+	exitCommandLineError = 1 << 0
+	exitDeviceOpenFailed = 1 << 1
+)
+
+// ATA SMART属性ID
+const (
+	ataReallocatedSectorCount = 5
+	ataWearLevelingCount      = 177
+	ataReportedUncorrect      = 187
+	ataCurrentPendingSector   = 197
+	ataPercentLifetimeRemain  = 202
+	ataSSDLifeLeft            = 231
+	ataMediaWearoutIndicator  = 233
+)
+
+type smartctlOutput struct {
+	Smartctl struct {
+		ExitStatus int `json:"exit_status"`
+		Messages   []struct {
+			String string `json:"string"`
+		} `json:"messages"`
+	} `json:"smartctl"`
+	Device struct {
+		Protocol string `json:"protocol"`
+	} `json:"device"`
+	ModelName    string `json:"model_name"`
+	SerialNumber string `json:"serial_number"`
+	SmartStatus  *struct {
+		Passed bool `json:"passed"`
+	} `json:"smart_status"`
+	Temperature struct {
+		Current int64 `json:"current"`
+	} `json:"temperature"`
+	AtaSmartAttributes struct {
+		Table []struct {
+			ID    int   `json:"id"`
+			Value int64 `json:"value"`
+			Raw   struct {
+				Value int64 `json:"value"`
+			} `json:"raw"`
+		} `json:"table"`
+	} `json:"ata_smart_attributes"`
+	NvmeSmartHealthInformationLog *struct {
+		Temperature    int64 `json:"temperature"`
+		PercentageUsed int64 `json:"percentage_used"`
+		MediaErrors    int64 `json:"media_errors"`
+	} `json:"nvme_smart_health_information_log"`
+	ScsiGrownDefectList *int64 `json:"scsi_grown_defect_list"`
+}
+
+func parseSmartctl(device, out string) (*api.DiskHealth, error) {
+	o := smartctlOutput{}
+	if err := json.Unmarshal([]byte(out), &o); err != nil {
+		return nil, fmt.Errorf("parse smartctl output of %s failed %v", device, err)
+	}
+	if o.Smartctl.ExitStatus&(exitCommandLineError|exitDeviceOpenFailed) != 0 || o.SmartStatus == nil {
+		msg := ""
+		if len(o.Smartctl.Messages) > 0 {
+			msg = o.Smartctl.Messages[0].String
+		}
+		return nil, fmt.Errorf("smart is unavailable on %s %s", device, msg)
+	}
+
+	resp := &api.DiskHealth{
+		Disk:        device,
+		Model:       o.ModelName,
+		Serial:      o.SerialNumber,
+		Protocol:    o.Device.Protocol,
+		Passed:      o.SmartStatus.Passed,
+		Temperature: o.Temperature.Current,
+	}
+
+	for _, attr := range o.AtaSmartAttributes.Table {
+		switch attr.ID {
+		case ataReallocatedSectorCount:
+			resp.ReallocatedSectors = attr.Raw.Value
+		case ataCurrentPendingSector:
+			resp.PendingSectors = attr.Raw.Value
+		case ataReportedUncorrect:
+			resp.MediaErrors = attr.Raw.Value
+		case ataWearLevelingCount, ataSSDLifeLeft, ataMediaWearoutIndicator, ataPercentLifetimeRemain:
+			// 归一化值为剩余寿命百分比
+			if used := 100 - attr.Value; used > resp.PercentageUsed {
+				resp.PercentageUsed = used
+			}
+		}
+	}
+
+	if nvme := o.NvmeSmartHealthInformationLog; nvme != nil {
+		resp.MediaErrors = nvme.MediaErrors
+		resp.PercentageUsed = nvme.PercentageUsed
+		if resp.Temperature == 0 {
+			resp.Temperature = nvme.Temperature
+		}
+	}
+
+	if o.ScsiGrownDefectList != nil {
+		resp.ReallocatedSectors = *o.ScsiGrownDefectList
+	}
+	return resp, nil
+}
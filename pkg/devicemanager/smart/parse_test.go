@@ -0,0 +1,70 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package smart
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSmartctl(t *testing.T) {
+	ata := `{
+  "smartctl": {"exit_status": 0},
+  "device": {"name": "/dev/sdb", "type": "sat", "protocol": "ATA"},
+  "model_name": "ST4000NM0035",
+  "serial_number": "ZC1A2B3C",
+  "smart_status": {"passed": true},
+  "temperature": {"current": 38},
+  "ata_smart_attributes": {"table": [
+    {"id": 5, "name": "Reallocated_Sector_Ct", "value": 100, "raw": {"value": 8}},
+    {"id": 187, "name": "Reported_Uncorrect", "value": 100, "raw": {"value": 2}},
+    {"id": 197, "name": "Current_Pending_Sector", "value": 100, "raw": {"value": 1}},
+    {"id": 177, "name": "Wear_Leveling_Count", "value": 93, "raw": {"value": 120}}
+  ]}
+}`
+	h, err := parseSmartctl("/dev/sdb", ata)
+	assert.NoError(t, err)
+	assert.Equal(t, "ST4000NM0035", h.Model)
+	assert.Equal(t, "ATA", h.Protocol)
+	assert.True(t, h.Passed)
+	assert.Equal(t, int64(38), h.Temperature)
+	assert.Equal(t, int64(8), h.ReallocatedSectors)
+	assert.Equal(t, int64(1), h.PendingSectors)
+	assert.Equal(t, int64(2), h.MediaErrors)
+	assert.Equal(t, int64(7), h.PercentageUsed)
+
+	nvme := `{
+  "smartctl": {"exit_status": 4},
+  "device": {"name": "/dev/nvme0n1", "type": "nvme", "protocol": "NVMe"},
+  "smart_status": {"passed": false},
+  "temperature": {"current": 45},
+  "nvme_smart_health_information_log": {"critical_warning": 4, "temperature": 45, "percentage_used": 97, "media_errors": 3}
+}`
+	h, err = parseSmartctl("/dev/nvme0n1", nvme)
+	assert.NoError(t, err)
+	assert.False(t, h.Passed)
+	assert.Equal(t, int64(97), h.PercentageUsed)
+	assert.Equal(t, int64(3), h.MediaErrors)
+
+	loop := `{
+  "smartctl": {"exit_status": 2, "messages": [{"string": "/dev/loop0: Unable to detect device type", "severity": "error"}]},
+  "device": {"name": "/dev/loop0"}
+}`
+	_, err = parseSmartctl("/dev/loop0", loop)
+	assert.Error(t, err)
+}
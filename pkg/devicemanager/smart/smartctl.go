@@ -0,0 +1,33 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package smart
+
+import (
+	"github.com/carina-io/carina/api"
+	"github.com/carina-io/carina/utils/exec"
+)
+
+type SmartctlImplement struct {
+	Executor exec.Executor
+}
+
+// GetDiskHealth smartctl --json --all /dev/sda
+// smartctl的退出码为位掩码，磁盘存在告警时退出码也不为0，因此以输出内容为准
+func (si *SmartctlImplement) GetDiskHealth(device string) (*api.DiskHealth, error) {
+	out, _ := si.Executor.ExecuteCommandWithCombinedOutput("smartctl", "--json", "--all", device)
+	return parseSmartctl(device, out)
+}
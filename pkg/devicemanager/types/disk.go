@@ -33,6 +33,8 @@ const (
 	Lvm2FsType = "LVM2_member"
 	// MultiPath is for multipath devices
 	MultiPath = "mpath"
+	// RaidType is for md raid devices, e.g. raid1
+	RaidType = "raid"
 )
 
 type LocalDisk struct {
@@ -0,0 +1,100 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package types
+
+import (
+	"crypto/sha256"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/carina-io/carina"
+)
+
+// VolumeOwner 卷的归属信息，创建lv时写入lvm标签，孤儿卷清理只处理属于本集群的卷，并据此重建LogicVolume
+type VolumeOwner struct {
+	ClusterID    string
+	PVName       string
+	PVCNamespace string
+	PVCName      string
+	CreatedAt    time.Time
+}
+
+// Tags 归属信息对应的lvm标签，没有集群标识时不打标签
+func (o *VolumeOwner) Tags() []string {
+	if o == nil || o.ClusterID == "" {
+		return nil
+	}
+	tags := []string{carina.OwnerClusterTagPrefix + o.ClusterID, carina.OwnerPVTagPrefix + o.PVName}
+	if o.PVCName != "" {
+		tags = append(tags, carina.OwnerPVCTagPrefix+o.PVCNamespace+"/"+o.PVCName)
+	}
+	if !o.CreatedAt.IsZero() {
+		tags = append(tags, carina.OwnerCreatedTagPrefix+strconv.FormatInt(o.CreatedAt.Unix(), 10))
+	}
+	return tags
+}
+
+// ParseVolumeOwner 从逗号分隔的lvm标签解析归属信息，没有集群标签时返回nil
+func ParseVolumeOwner(tags string) *VolumeOwner {
+	owner := &VolumeOwner{}
+	for _, tag := range strings.Split(tags, ",") {
+		switch {
+		case strings.HasPrefix(tag, carina.OwnerClusterTagPrefix):
+			owner.ClusterID = strings.TrimPrefix(tag, carina.OwnerClusterTagPrefix)
+		case strings.HasPrefix(tag, carina.OwnerPVTagPrefix):
+			owner.PVName = strings.TrimPrefix(tag, carina.OwnerPVTagPrefix)
+		case strings.HasPrefix(tag, carina.OwnerPVCTagPrefix):
+			pvc := strings.SplitN(strings.TrimPrefix(tag, carina.OwnerPVCTagPrefix), "/", 2)
+			if len(pvc) == 2 {
+				owner.PVCNamespace, owner.PVCName = pvc[0], pvc[1]
+			}
+		case strings.HasPrefix(tag, carina.OwnerCreatedTagPrefix):
+			if sec, err := strconv.ParseInt(strings.TrimPrefix(tag, carina.OwnerCreatedTagPrefix), 10, 64); err == nil {
+				owner.CreatedAt = time.Unix(sec, 0)
+			}
+		}
+	}
+	if owner.ClusterID == "" {
+		return nil
+	}
+	return owner
+}
+
+// Owner lv的归属信息，没有归属标签的卷返回nil
+func (lv LvInfo) Owner() *VolumeOwner {
+	return ParseVolumeOwner(lv.LVTags)
+}
+
+// PartitionID 裸盘分区的GUID，gpt分区名称长度有限，不能记录完整的归属信息，
+// 因此前8字节取集群标识的摘要，后8字节取pv名称的摘要
+func PartitionID(clusterID, pvName string) [16]byte {
+	var id [16]byte
+	cluster, pv := sha256.Sum256([]byte(clusterID)), sha256.Sum256([]byte(pvName))
+	copy(id[:8], cluster[:8])
+	copy(id[8:], pv[:8])
+	return id
+}
+
+// PartitionOwned 分区GUID是否由本集群生成
+func PartitionOwned(id [16]byte, clusterID string) bool {
+	if clusterID == "" {
+		return false
+	}
+	expected := PartitionID(clusterID, "")
+	return string(id[:8]) == string(expected[:8])
+}
@@ -0,0 +1,53 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package types
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVolumeOwnerTags(t *testing.T) {
+	owner := &VolumeOwner{
+		ClusterID:    "3f7c2b9e-cluster",
+		PVName:       "pvc-319c46a0",
+		PVCNamespace: "default",
+		PVCName:      "data-mysql-0",
+		CreatedAt:    time.Unix(1700000000, 0),
+	}
+	parsed := ParseVolumeOwner("other," + strings.Join(owner.Tags(), ","))
+	assert.Equal(t, owner.ClusterID, parsed.ClusterID)
+	assert.Equal(t, owner.PVName, parsed.PVName)
+	assert.Equal(t, owner.PVCNamespace, parsed.PVCNamespace)
+	assert.Equal(t, owner.PVCName, parsed.PVCName)
+	assert.True(t, owner.CreatedAt.Equal(parsed.CreatedAt))
+
+	assert.Nil(t, (&VolumeOwner{PVName: "pvc-319c46a0"}).Tags())
+	assert.Nil(t, ParseVolumeOwner(""))
+	assert.Nil(t, ParseVolumeOwner("carina.storage.io/pv:pvc-319c46a0"))
+}
+
+func TestPartitionOwned(t *testing.T) {
+	id := PartitionID("cluster-a", "pvc-319c46a0")
+	assert.True(t, PartitionOwned(id, "cluster-a"))
+	assert.False(t, PartitionOwned(id, "cluster-b"))
+	assert.False(t, PartitionOwned(id, ""))
+	assert.NotEqual(t, id, PartitionID("cluster-a", "pvc-48d1e7f2"))
+}
@@ -16,6 +16,15 @@
 
 package types
 
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/carina-io/carina"
+)
+
 // LvInfo lv详细信息
 type LvInfo struct {
 	LVName        string  `json:"lvName"`
@@ -28,8 +37,186 @@ type LvInfo struct {
 	OriginSize    uint64  `json:"originSize"`
 	PoolLV        string  `json:"poolLv"`
 	ThinCount     uint64  `json:"thinCount"`
+	ThinID        uint64  `json:"thinId"`
 	LVTags        string  `json:"lvTags"`
 	DataPercent   float64 `json:"dataPercent"`
 	LVAttr        string  `json:"lvAttr"`
 	LVActive      string  `json:"lvActive"`
+	// MetadataPercent thin pool元数据使用率
+	MetadataPercent float64 `json:"metadataPercent"`
+	// MetadataSize thin pool元数据卷容量
+	MetadataSize uint64 `json:"metadataSize"`
+	// SegType linear、striped、thin、thin-pool、raid1、cache等
+	SegType      string  `json:"segType"`
+	HealthStatus string  `json:"healthStatus"`
+	SyncPercent  float64 `json:"syncPercent"`
+}
+
+// LvCacheStats lvmcache卷的缓存统计，单位为缓存块
+type LvCacheStats struct {
+	LVName      string `json:"lvName"`
+	VGName      string `json:"vgName"`
+	SegType     string `json:"segType"`
+	CacheMode   string `json:"cacheMode"`
+	TotalBlocks uint64 `json:"totalBlocks"`
+	UsedBlocks  uint64 `json:"usedBlocks"`
+	DirtyBlocks uint64 `json:"dirtyBlocks"`
+	ReadHits    uint64 `json:"readHits"`
+	ReadMisses  uint64 `json:"readMisses"`
+	WriteHits   uint64 `json:"writeHits"`
+	WriteMisses uint64 `json:"writeMisses"`
+}
+
+// BlockRange 卷中的一段连续数据，单位为字节
+type BlockRange struct {
+	Offset uint64 `json:"offset"`
+	Length uint64 `json:"length"`
+}
+
+// LvLayout lv的条带以及raid布局，为空时创建线性卷
+type LvLayout struct {
+	RaidLevel  string `json:"raidLevel"`
+	Mirrors    uint   `json:"mirrors"`
+	Stripes    uint   `json:"stripes"`
+	StripeSize string `json:"stripeSize"`
+}
+
+var stripeSizeRegexp = regexp.MustCompile(`^[1-9][0-9]*[kKmM]?$`)
+
+// NewLvLayout 从storageclass参数或LogicVolume注解解析lv布局，未配置时返回nil
+func NewLvLayout(params map[string]string) (*LvLayout, error) {
+	raidLevel := strings.ToLower(params[carina.VolumeRaidLevel])
+	mirrors, stripes, stripeSize := params[carina.VolumeMirrors], params[carina.VolumeStripes], params[carina.VolumeStripeSize]
+	if raidLevel == "" && mirrors == "" && stripes == "" && stripeSize == "" {
+		return nil, nil
+	}
+
+	layout := &LvLayout{RaidLevel: raidLevel, StripeSize: stripeSize}
+	if mirrors != "" {
+		n, err := strconv.ParseUint(mirrors, 10, 32)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("%s %s, should be a positive integer", carina.VolumeMirrors, mirrors)
+		}
+		layout.Mirrors = uint(n)
+	}
+	if stripes != "" {
+		n, err := strconv.ParseUint(stripes, 10, 32)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("%s %s, should be a positive integer", carina.VolumeStripes, stripes)
+		}
+		layout.Stripes = uint(n)
+	}
+	if stripeSize != "" && !stripeSizeRegexp.MatchString(stripeSize) {
+		return nil, fmt.Errorf("%s %s, should be a size such as 64k", carina.VolumeStripeSize, stripeSize)
+	}
+
+	switch raidLevel {
+	case "raid1":
+		if layout.Stripes > 1 || stripeSize != "" {
+			return nil, fmt.Errorf("raid1 doesn't support %s and %s, use raid10 instead", carina.VolumeStripes, carina.VolumeStripeSize)
+		}
+		layout.Stripes = 0
+		if layout.Mirrors == 0 {
+			layout.Mirrors = 1
+		}
+	case "raid10":
+		if layout.Mirrors == 0 {
+			layout.Mirrors = 1
+		}
+		if layout.Stripes == 0 {
+			layout.Stripes = 2
+		}
+		if layout.Stripes < 2 {
+			return nil, fmt.Errorf("%s of raid10 should not be less than 2", carina.VolumeStripes)
+		}
+	case "raid5":
+		if layout.Mirrors != 0 {
+			return nil, fmt.Errorf("raid5 doesn't support %s", carina.VolumeMirrors)
+		}
+		if layout.Stripes == 0 {
+			layout.Stripes = 2
+		}
+		if layout.Stripes < 2 {
+			return nil, fmt.Errorf("%s of raid5 should not be less than 2", carina.VolumeStripes)
+		}
+	case "":
+		if layout.Mirrors != 0 {
+			return nil, fmt.Errorf("%s requires %s raid1 or raid10", carina.VolumeMirrors, carina.VolumeRaidLevel)
+		}
+		if layout.Stripes < 2 {
+			return nil, fmt.Errorf("%s of striped volume should not be less than 2", carina.VolumeStripes)
+		}
+	default:
+		return nil, fmt.Errorf("%s %s, should be raid1, raid5 or raid10", carina.VolumeRaidLevel, raidLevel)
+	}
+	return layout, nil
+}
+
+// Annotations lv布局对应的LogicVolume注解
+func (l *LvLayout) Annotations() map[string]string {
+	annotations := map[string]string{}
+	if l == nil {
+		return annotations
+	}
+	if l.RaidLevel != "" {
+		annotations[carina.VolumeRaidLevel] = l.RaidLevel
+	}
+	if l.Mirrors > 0 {
+		annotations[carina.VolumeMirrors] = strconv.FormatUint(uint64(l.Mirrors), 10)
+	}
+	if l.Stripes > 0 {
+		annotations[carina.VolumeStripes] = strconv.FormatUint(uint64(l.Stripes), 10)
+	}
+	if l.StripeSize != "" {
+		annotations[carina.VolumeStripeSize] = l.StripeSize
+	}
+	return annotations
+}
+
+// Redundancy 分配的物理空间与卷容量的比例，返回分子以及分母
+// raid1/raid10每个副本占用一份空间，raid5每Stripes个数据条带占用一个校验条带
+func (l *LvLayout) Redundancy() (uint64, uint64) {
+	if l == nil {
+		return 1, 1
+	}
+	switch l.RaidLevel {
+	case "raid1", "raid10":
+		return uint64(l.Mirrors) + 1, 1
+	case "raid5":
+		return uint64(l.Stripes) + 1, uint64(l.Stripes)
+	}
+	return 1, 1
+}
+
+// AllocSize 容量为size的卷实际分配的物理空间
+func (l *LvLayout) AllocSize(size uint64) uint64 {
+	num, den := l.Redundancy()
+	return (size*num + den - 1) / den
+}
+
+// MinPVs 创建卷所需的最少pv数量，每个条带以及副本需要位于不同的pv
+func (l *LvLayout) MinPVs() int {
+	if l == nil {
+		return 1
+	}
+	switch l.RaidLevel {
+	case "raid1":
+		return int(l.Mirrors) + 1
+	case "raid10":
+		return int(l.Stripes) * (int(l.Mirrors) + 1)
+	case "raid5":
+		return int(l.Stripes) + 1
+	}
+	return int(l.Stripes)
+}
+
+// LvRaidStatus lvm raid卷的同步以及健康状态
+type LvRaidStatus struct {
+	LVName      string `json:"lvName"`
+	VGName      string `json:"vgName"`
+	SegType     string `json:"segType"`
+	SyncPercent string `json:"syncPercent"`
+	// HealthStatus 为空表示正常，partial表示有镜像或条带所在的pv丢失
+	HealthStatus string `json:"healthStatus"`
+	SyncAction   string `json:"syncAction"`
 }
@@ -17,6 +17,8 @@
 package volume
 
 import (
+	"context"
+
 	"github.com/carina-io/carina/api"
 	"github.com/carina-io/carina/pkg/devicemanager/lvmd"
 	"github.com/carina-io/carina/pkg/devicemanager/types"
@@ -25,25 +27,56 @@ import (
 // LocalVolume 本接口负责对外提供方法
 // 处理业务逻辑并调用lvm接口
 type LocalVolume interface {
-	CreateVolume(lvName, vgName string, size, ratio uint64) error
+	// CreateVolume layout为nil时创建线性卷，否则创建条带或raid卷，owner记录在卷的lvm标签中
+	CreateVolume(lvName, vgName string, size, ratio uint64, layout *types.LvLayout, owner *types.VolumeOwner) error
 	DeleteVolume(lvName, vgName string) error
-	ResizeVolume(lvName, vgName string, size, ratio uint64) error
+	CreateVolumeFromSource(lvName, vgName, sourceName string, size, ratio uint64, owner *types.VolumeOwner) error
+	// TagVolume 为没有归属标签的已有卷补充标签
+	TagVolume(lvName, vgName string, owner *types.VolumeOwner) error
+
+	// CreateThinVolume thin卷，从device group共享的thin pool中分配
+	CreateThinVolume(lvName, vgName string, size uint64, overcommit float64, owner *types.VolumeOwner) error
+	ResizeThinVolume(lvName, vgName string, size uint64, overcommit float64) error
+	ThinPoolUsage(vgName string) (poolSize, virtualSize uint64, err error)
+	ExtendThinPool(vgName string) (bool, error)
+	ResizeVolume(lvName, vgName string, size, ratio uint64, layout *types.LvLayout) error
 	VolumeList(lvName, vgName string) ([]types.LvInfo, error)
 	VolumeInfo(lvName, vgName string) (*types.LvInfo, error)
 
+	// CreateSnapshot 快照
+	CreateSnapshot(snapName, lvName, vgName string) error
+	DeleteSnapshot(snapName, vgName string) error
+	// SnapshotDelta 同一thin卷的两个thin快照之间变化的数据范围，用于增量备份
+	SnapshotDelta(fromSnap, toSnap, vgName string) ([]types.BlockRange, error)
+
 	// GetCurrentVgStruct 额外的方法
 	GetCurrentVgStruct() ([]api.VgGroup, error)
 	GetCurrentPvStruct() ([]api.PVInfo, error)
 	AddNewDiskToVg(disk, vgName string) error
 	RemoveDiskInVg(disk, vgName string) error
+	// CordonDisk 维护模式，禁止或恢复在该磁盘上分配新的extent
+	CordonDisk(disk string, cordon bool) error
+	// DrainDiskInVg 将磁盘上的数据迁移至同一vg的其他磁盘，迁移完成后移出vg，返回迁移进度以及是否完成
+	DrainDiskInVg(disk, vgName string) (float64, bool, error)
 
 	HealthCheck()
 	RefreshLvmCache()
 
+	// CreateCacheVolume lvmcache卷，后端磁盘组的vg中包含缓存磁盘组的pv，mode为writethrough/writeback/writecache
+	CreateCacheVolume(lvName, vgName, cacheGroup string, size, cacheSize uint64, mode string, owner *types.VolumeOwner) error
+	ResizeCacheVolume(lvName, vgName, cacheGroup string, size, cacheSize uint64, mode string) error
+	CacheVolumeStats(vgName string) ([]types.LvCacheStats, error)
+	// RaidVolumeStatus vg中raid卷的同步以及健康状态
+	RaidVolumeStatus(vgName string) ([]types.LvRaidStatus, error)
+
 	// CreateBcache bcache
 	CreateBcache(dev, cacheDev string, block, bucket string, cacheMode string) (*types.BcacheDeviceInfo, error)
 	DeleteBcache(dev, cacheDev string) error
 	BcacheDeviceInfo(dev string) (*types.BcacheDeviceInfo, error)
 
 	GetLv() lvmd.Lvm2
+
+	// WithContext 返回绑定ctx的LocalVolume，等待vg以及lv的锁和执行的命令都受ctx控制，
+	// 用于将csi请求的超时以及controller的取消传递到子进程
+	WithContext(ctx context.Context) LocalVolume
 }
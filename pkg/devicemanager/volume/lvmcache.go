@@ -0,0 +1,225 @@
+/*
+  Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package volume
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/carina-io/carina"
+	"github.com/carina-io/carina/pkg/devicemanager/types"
+	"github.com/carina-io/carina/utils/log"
+)
+
+// tieredPVs 配置了缓存磁盘组的vg同时包含后端磁盘组和缓存磁盘组的pv，
+// 缓存磁盘组的pv带有carina.storage.io/cache-group标签
+type tieredPVs struct {
+	backing     []string
+	backingSize uint64
+	backingFree uint64
+	cache       map[string][]string
+	cacheFree   map[string]uint64
+}
+
+// allocatable 普通卷只能在后端磁盘组的pv上分配，vg不包含缓存磁盘时不限制
+func (t *tieredPVs) allocatable() []string {
+	if len(t.cache) == 0 {
+		return nil
+	}
+	return t.backing
+}
+
+// count 普通卷可使用的pv数量
+func (t *tieredPVs) count() int {
+	return len(t.backing)
+}
+
+// free 普通卷可用的剩余空间
+func (t *tieredPVs) free(vgFree uint64) uint64 {
+	if len(t.cache) == 0 {
+		return vgFree
+	}
+	return t.backingFree
+}
+
+// size 普通卷可使用的容量，用于计算保留空间
+func (t *tieredPVs) size(vgSize uint64) uint64 {
+	if len(t.cache) == 0 {
+		return vgSize
+	}
+	return t.backingSize
+}
+
+// CacheGroup 从pv标签中解析所属的缓存磁盘组，不是缓存磁盘时返回空
+func CacheGroup(tags string) string {
+	for _, tag := range strings.Split(tags, ",") {
+		if strings.HasPrefix(tag, carina.CacheGroupTagPrefix) {
+			return strings.TrimPrefix(tag, carina.CacheGroupTagPrefix)
+		}
+	}
+	return ""
+}
+
+func (v *LocalVolumeImplement) getTieredPVs(vgName string) (*tieredPVs, error) {
+	pvs, err := v.Lv.PVS()
+	if err != nil {
+		log.Errorf("get pv info failed %s", err.Error())
+		return nil, err
+	}
+	t := &tieredPVs{
+		cache:     map[string][]string{},
+		cacheFree: map[string]uint64{},
+	}
+	for _, pv := range pvs {
+		if pv.VGName != vgName {
+			continue
+		}
+		group := CacheGroup(pv.PVTags)
+		if group == "" {
+			t.backing = append(t.backing, pv.PVName)
+			t.backingSize += pv.PVSize
+			t.backingFree += pv.PVFree
+			continue
+		}
+		t.cache[group] = append(t.cache[group], pv.PVName)
+		t.cacheFree[group] += pv.PVFree
+	}
+	return t, nil
+}
+
+// CreateCacheVolume 在后端磁盘组的pv上创建卷，再使用同一vg中缓存磁盘组的pv创建缓存卷挂载到该卷
+func (v *LocalVolumeImplement) CreateCacheVolume(lvName, vgName, cacheGroup string, size, cacheSize uint64, mode string, owner *types.VolumeOwner) error {
+	unlock, err := v.lock(lvKey(vgName, carina.VolumePrefix+lvName), vgName)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// 创建任何lv之前检查lvm版本，避免留下未挂载缓存的卷
+	if err := v.Lv.CheckCacheVol(); err != nil {
+		log.Errorf("create cache volume %s/%s failed %s", vgName, lvName, err.Error())
+		return err
+	}
+
+	name := carina.VolumePrefix + lvName
+	tiered, err := v.getTieredPVs(vgName)
+	if err != nil {
+		return err
+	}
+	if len(tiered.cache[cacheGroup]) == 0 {
+		log.Errorf("device group %s doesn't contain disks of cache group %s", vgName, cacheGroup)
+		return errors.New("cache group " + cacheGroup + " not found in device group " + vgName)
+	}
+
+	lvInfo, _ := v.Lv.LVDisplay(name, vgName)
+	if lvInfo != nil && lvInfo.VGName == vgName {
+		if isCachedLV(lvInfo) {
+			log.Infof("%s/%s cache volume exists", vgName, name)
+			return nil
+		}
+		// 上次挂载缓存失败，重新创建缓存卷
+		if tiered.cacheFree[cacheGroup] < cacheSize {
+			log.Warnf("cache group %s of %s don't have enough space", cacheGroup, vgName)
+			return errors.New(carina.ResourceExhausted)
+		}
+		return v.Lv.LVCreateCache(name, carina.LvmCachePrefix+name, vgName, cacheSize, mode, tiered.cache[cacheGroup])
+	}
+
+	reserved := v.Reserved.Bytes(vgName, tiered.backingSize)
+	if !hasSpace(tiered.backingFree, size, reserved) || tiered.cacheFree[cacheGroup] < cacheSize {
+		log.Warnf("%s or cache group %s don't have enough space, reserved %d", vgName, cacheGroup, reserved)
+		return errors.New(carina.ResourceExhausted)
+	}
+
+	if err := v.Lv.LVCreateFromVG(name, vgName, size, owner.Tags(), nil, tiered.backing...); err != nil {
+		return err
+	}
+	if err := v.Lv.LVCreateCache(name, carina.LvmCachePrefix+name, vgName, cacheSize, mode, tiered.cache[cacheGroup]); err != nil {
+		log.Errorf("create cache of %s/%s failed %s", vgName, name, err.Error())
+		_ = v.Lv.LVRemove(name, vgName)
+		return err
+	}
+	return nil
+}
+
+// ResizeCacheVolume dm-writecache不支持扩容，dm-cache扩容后缓存大小不变，
+// 因此先刷写脏数据并卸载缓存，扩容后按新的容量重建缓存
+func (v *LocalVolumeImplement) ResizeCacheVolume(lvName, vgName, cacheGroup string, size, cacheSize uint64, mode string) error {
+	unlock, err := v.lock(lvKey(vgName, carina.VolumePrefix+lvName), vgName)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	name := carina.VolumePrefix + lvName
+	lvInfo, err := v.Lv.LVDisplay(name, vgName)
+	if err != nil {
+		log.Errorf("get volume info failed %s/%s %s", vgName, name, err.Error())
+		return err
+	}
+	if lvInfo.LVSize >= size && isCachedLV(lvInfo) {
+		log.Infof("%s/%s have expend", vgName, lvName)
+		return nil
+	}
+	// 卸载缓存之前检查lvm版本，避免无法重建缓存
+	if err := v.Lv.CheckCacheVol(); err != nil {
+		log.Errorf("resize cache volume %s/%s failed %s", vgName, lvName, err.Error())
+		return err
+	}
+
+	tiered, err := v.getTieredPVs(vgName)
+	if err != nil {
+		return err
+	}
+	reserved := v.Reserved.Bytes(vgName, tiered.backingSize)
+	if lvInfo.LVSize < size && !hasSpace(tiered.backingFree, size-lvInfo.LVSize, reserved) {
+		log.Warnf("%s don't have enough space, reserved %d", vgName, reserved)
+		return errors.New(carina.ResourceExhausted)
+	}
+
+	if isCachedLV(lvInfo) {
+		if err := v.Lv.LVUncache(name, vgName); err != nil {
+			log.Errorf("uncache %s/%s failed %s", vgName, name, err.Error())
+			return err
+		}
+		// 卸载后缓存卷的空间已释放
+		tiered, err = v.getTieredPVs(vgName)
+		if err != nil {
+			return err
+		}
+	}
+	if lvInfo.LVSize < size {
+		if err := v.Lv.LVResize(name, vgName, size, tiered.backing...); err != nil {
+			return err
+		}
+	}
+	if tiered.cacheFree[cacheGroup] < cacheSize {
+		log.Warnf("cache group %s of %s don't have enough space", cacheGroup, vgName)
+		return errors.New(carina.ResourceExhausted)
+	}
+	return v.Lv.LVCreateCache(name, carina.LvmCachePrefix+name, vgName, cacheSize, mode, tiered.cache[cacheGroup])
+}
+
+// CacheVolumeStats vg中lvmcache卷的缓存命中以及脏数据统计
+func (v *LocalVolumeImplement) CacheVolumeStats(vgName string) ([]types.LvCacheStats, error) {
+	return v.Lv.LVCacheStats(vgName)
+}
+
+// isCachedLV lv_attr第一位为C表示已挂载cache或writecache
+func isCachedLV(lvInfo *types.LvInfo) bool {
+	return strings.HasPrefix(lvInfo.LVAttr, "C")
+}
@@ -0,0 +1,111 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package volume
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/carina-io/carina"
+	"github.com/carina-io/carina/api"
+	"github.com/carina-io/carina/pkg/devicemanager/types"
+	"github.com/carina-io/carina/utils/mutx"
+)
+
+func (f *fakeLvm) PVS() ([]api.PVInfo, error) {
+	return f.pvs, nil
+}
+
+func (f *fakeLvm) CheckCacheVol() error {
+	return f.cacheVolErr
+}
+
+func (f *fakeLvm) LVCreateFromVG(lv, vg string, size uint64, tags []string, layout *types.LvLayout, pvs ...string) error {
+	f.calls = append(f.calls, fmt.Sprintf("create %s %s", lv, strings.Join(pvs, ",")))
+	f.lvs[lv] = &types.LvInfo{LVName: lv, VGName: vg, LVSize: size, LVAttr: "-wi-a-----"}
+	if f.vg != nil {
+		f.vg.VGFree -= size
+	}
+	return nil
+}
+
+func (f *fakeLvm) LVCreateCache(lv, cache, vg string, size uint64, mode string, pvs []string) error {
+	f.calls = append(f.calls, fmt.Sprintf("cache %s %s %s %s", lv, cache, mode, strings.Join(pvs, ",")))
+	return f.cacheErr
+}
+
+func (f *fakeLvm) LVRemove(lv, vg string) error {
+	f.calls = append(f.calls, "remove "+lv)
+	delete(f.lvs, lv)
+	return nil
+}
+
+func TestCreateCacheVolume(t *testing.T) {
+	pvs := []api.PVInfo{
+		{PVName: "/dev/sdb", VGName: "carina-vg-hdd", PVSize: 100 << 30, PVFree: 100 << 30},
+		{PVName: "/dev/nvme0n1", VGName: "carina-vg-hdd", PVSize: 20 << 30, PVFree: 20 << 30, PVTags: carina.CacheGroupTagPrefix + "carina-vg-ssd"},
+	}
+	cases := []struct {
+		name        string
+		cacheVolErr error
+		cacheErr    error
+		wantCalls   []string
+		wantErr     string
+	}{
+		{
+			name: "create",
+			wantCalls: []string{
+				"create volume-pvc-1 /dev/sdb",
+				"cache volume-pvc-1 lvmcache-volume-pvc-1 writeback /dev/nvme0n1",
+			},
+		},
+		// lvm版本不支持时不创建任何lv
+		{
+			name:        "lvm 2.02",
+			cacheVolErr: errors.New("lvmcache requires lvm2 2.03 or later, current version 2.02"),
+			wantErr:     "lvmcache requires lvm2 2.03 or later, current version 2.02",
+		},
+		// 挂载缓存失败时删除已创建的卷
+		{
+			name:     "attach failed",
+			cacheErr: errors.New("exit status 5"),
+			wantCalls: []string{
+				"create volume-pvc-1 /dev/sdb",
+				"cache volume-pvc-1 lvmcache-volume-pvc-1 writeback /dev/nvme0n1",
+				"remove volume-pvc-1",
+			},
+			wantErr: "exit status 5",
+		},
+	}
+	for _, c := range cases {
+		lv := &fakeLvm{lvs: map[string]*types.LvInfo{}, pvs: pvs, cacheVolErr: c.cacheVolErr, cacheErr: c.cacheErr}
+		v := &LocalVolumeImplement{Lv: lv, Locks: mutx.NewQueuedLocks(MaxLockWaiters)}
+
+		err := v.CreateCacheVolume("pvc-1", "carina-vg-hdd", "carina-vg-ssd", 10<<30, 2<<30, "writeback", nil)
+		if c.wantErr != "" {
+			assert.EqualError(t, err, c.wantErr, c.name)
+			assert.Empty(t, lv.lvs, c.name)
+		} else {
+			assert.NoError(t, err, c.name)
+		}
+		assert.Equal(t, c.wantCalls, lv.calls, c.name)
+	}
+}
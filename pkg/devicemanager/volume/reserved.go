@@ -0,0 +1,75 @@
+/*
+  Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package volume
+
+import (
+	"sync"
+
+	"github.com/carina-io/carina"
+	"github.com/carina-io/carina/pkg/configuration"
+	"github.com/carina-io/carina/utils/log"
+)
+
+// ReservedSpace 各vg的保留空间配置，配置文件变更时通过configuration.RegisterListenerChan刷新
+type ReservedSpace struct {
+	lock      sync.RWMutex
+	selectors map[string]configuration.DiskSelectorItem
+}
+
+func NewReservedSpace() *ReservedSpace {
+	r := &ReservedSpace{}
+	r.reload()
+
+	configModifyChan := make(chan struct{}, 1)
+	configuration.RegisterListenerChan(configModifyChan)
+	go func() {
+		for range configModifyChan {
+			log.Info("config modify reload reserved space...")
+			r.reload()
+		}
+	}()
+	return r
+}
+
+func (r *ReservedSpace) reload() {
+	selectors := map[string]configuration.DiskSelectorItem{}
+	for _, ds := range configuration.DiskSelector() {
+		selectors[ds.Name] = ds
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.selectors = selectors
+}
+
+// Bytes vg的保留空间，size为vg中普通卷可使用的容量
+func (r *ReservedSpace) Bytes(vgName string, size uint64) uint64 {
+	if r == nil {
+		return carina.DefaultReservedSpace
+	}
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.selectors[vgName].ReservedBytes(size)
+}
+
+// hasSpace 剩余空间分配size后不低于保留空间，保留空间的十分之一(最多1Gi)作为容差避免边界情况
+func hasSpace(free, size, reserved uint64) bool {
+	edge := reserved / 10
+	if edge > carina.DefaultEdgeSpace {
+		edge = carina.DefaultEdgeSpace
+	}
+	return free >= size+reserved-edge
+}
@@ -29,6 +29,7 @@ import (
 	"github.com/carina-io/carina/pkg/devicemanager/bcache"
 	"github.com/carina-io/carina/pkg/devicemanager/lvmd"
 	"github.com/carina-io/carina/pkg/devicemanager/types"
+	"github.com/carina-io/carina/utils/exec"
 	"github.com/carina-io/carina/utils/log"
 	"github.com/carina-io/carina/utils/mutx"
 	"google.golang.org/grpc/codes"
@@ -36,21 +37,35 @@ import (
 )
 
 const (
-	VOLUMEMUTEX = "VolumeMutex"
+	// thin pool数据或元数据使用率超过该值时自动扩容
+	thinPoolExtendThreshold = 80
+	// thinPoolMaxMetadataSize lvm允许的thin pool元数据卷上限15.81g
+	thinPoolMaxMetadataSize = 16192 << 20
+	// MaxLockWaiters 同一vg或lv排队等待的操作数量上限
+	MaxLockWaiters = 64
+	// lockTimeout 等待vg或lv锁的最长时间
+	lockTimeout = 2 * time.Minute
 )
 
+// LocalVolumeImplement 同一vg中分配空间的操作按vg加锁，不同vg的操作可以并行，
+// 单个lv的操作按lv加锁，先获取lv的锁再获取vg的锁
 type LocalVolumeImplement struct {
-	Lv     lvmd.Lvm2
-	Bcache bcache.Bcache
-	Mutex  *mutx.GlobalLocks
+	Lv       lvmd.Lvm2
+	Bcache   bcache.Bcache
+	Locks    *mutx.QueuedLocks
+	Executor exec.Executor
+	// Reserved 各vg的保留空间，为空时保留10Gi
+	Reserved *ReservedSpace
+	// ctx 由WithContext设置，为空时只受lockTimeout限制
+	ctx context.Context
 }
 
-func (v *LocalVolumeImplement) CreateVolume(lvName, vgName string, size, ratio uint64) error {
-	if !v.Mutex.TryAcquire(VOLUMEMUTEX) {
-		log.Info("wait other task release mutex, please retry...")
-		return errors.New("get global mutex failed")
+func (v *LocalVolumeImplement) CreateVolume(lvName, vgName string, size, ratio uint64, layout *types.LvLayout, owner *types.VolumeOwner) error {
+	unlock, err := v.lock(lvKey(vgName, carina.VolumePrefix+lvName), vgName)
+	if err != nil {
+		return err
 	}
-	defer v.Mutex.Release(VOLUMEMUTEX)
+	defer unlock()
 
 	vgInfo, err := v.Lv.VGDisplay(vgName)
 	if err != nil {
@@ -61,11 +76,22 @@ func (v *LocalVolumeImplement) CreateVolume(lvName, vgName string, size, ratio u
 		log.Error("cannot find device group info")
 		return errors.New("cannot find device group info")
 	}
+	size = alignExtent(size, vgInfo)
 
-	if vgInfo.VGFree-size < carina.DefaultReservedSpace-carina.DefaultEdgeSpace { ////avoid edge conditions
-		log.Warnf("%s don't have enough space, reserved 10g", vgName)
+	tiered, err := v.getTieredPVs(vgName)
+	if err != nil {
+		return err
+	}
+	// raid卷的每个副本以及校验条带都占用空间
+	reserved := v.Reserved.Bytes(vgName, tiered.size(vgInfo.VGSize))
+	if !hasSpace(tiered.free(vgInfo.VGFree), layout.AllocSize(size), reserved) {
+		log.Warnf("%s don't have enough space, reserved %d", vgName, reserved)
 		return errors.New(carina.ResourceExhausted)
 	}
+	if tiered.count() < layout.MinPVs() {
+		log.Warnf("%s has %d disks, %d disks are required by layout %v", vgName, tiered.count(), layout.MinPVs(), layout)
+		return fmt.Errorf("device group %s doesn't have enough disks for %s", vgName, layout.RaidLevel)
+	}
 
 	name := carina.VolumePrefix + lvName
 
@@ -76,21 +102,384 @@ func (v *LocalVolumeImplement) CreateVolume(lvName, vgName string, size, ratio u
 	}
 
 	// 创建volume卷
-	return v.Lv.LVCreateFromVG(name, vgName, size, []string{}, 0, "")
+	return v.Lv.LVCreateFromVG(name, vgName, size, owner.Tags(), layout, tiered.allocatable()...)
 }
 
-func (v *LocalVolumeImplement) DeleteVolume(lvName, vgName string) error {
-	if !v.Mutex.TryAcquire(VOLUMEMUTEX) {
-		log.Info("wait other task release mutex, please retry...")
-		return errors.New("get global mutex failed")
+// CreateVolumeFromSource 从快照或已有卷创建volume
+// thin源卷通过thin快照克隆，普通卷先创建新卷再复制数据，复制完成后给新卷打上标签，
+// 未打标签的卷在重试时重新复制
+func (v *LocalVolumeImplement) CreateVolumeFromSource(lvName, vgName, sourceName string, size, ratio uint64, owner *types.VolumeOwner) error {
+	name := carina.VolumePrefix + lvName
+
+	sourceInfo, err := v.Lv.LVDisplay(sourceName, vgName)
+	if err != nil {
+		log.Errorf("get source volume info failed %s/%s %s", vgName, sourceName, err.Error())
+		return err
+	}
+
+	if sourceInfo.PoolLV != "" {
+		return v.createThinClone(name, sourceName, vgName, size, sourceInfo.LVSize, owner)
+	}
+
+	lvInfo, _ := v.Lv.LVDisplay(name, vgName)
+	if lvInfo != nil && lvInfo.VGName == vgName {
+		if hasTag(lvInfo.LVTags, carina.CloneCompletedTag) {
+			log.Infof("%s/%s volume exists", vgName, name)
+			return nil
+		}
+		log.Warnf("%s/%s volume exists but data copy is not completed, copy again", vgName, name)
+	} else if err := v.CreateVolume(lvName, vgName, size, ratio, nil, owner); err != nil {
+		return err
+	}
+
+	// 数据复制耗时较长，只持有源卷和新卷的锁，源卷在复制期间不能扩容或删除，不阻塞同一vg的其他操作
+	unlock, err := v.lock(lvKey(vgName, sourceName), lvKey(vgName, name))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// 快照本身是某一时间点的数据，普通卷从临时快照复制，保证数据一致
+	from := sourceName
+	if sourceInfo.Origin == "" {
+		from = carina.ClonePrefix + name
+		if err := v.createCloneSnapshot(from, sourceName, vgName, sourceInfo.LVSize); err != nil {
+			return err
+		}
+		defer func() {
+			if err := v.Lv.DeleteSnapshot(from, vgName); err != nil {
+				log.Warnf("delete temporary snapshot %s/%s failed %s", vgName, from, err.Error())
+			}
+		}()
+	}
+
+	err = v.Executor.ExecuteCommand("dd", fmt.Sprintf("if=/dev/%s/%s", vgName, from), fmt.Sprintf("of=/dev/%s/%s", vgName, name), "bs=4M", "oflag=direct", "conv=fsync")
+	if err != nil {
+		log.Errorf("copy data from %s/%s to %s/%s failed %s", vgName, from, vgName, name, err.Error())
+		return err
+	}
+	return v.Lv.LVAddTag(name, vgName, carina.CloneCompletedTag)
+}
+
+// createCloneSnapshot 创建克隆用的临时快照，删除上次复制中断时遗留的快照，快照按源卷大小分配保证不会失效
+func (v *LocalVolumeImplement) createCloneSnapshot(snap, origin, vgName string, size uint64) error {
+	unlock, err := v.lock(vgName)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if snapInfo, _ := v.Lv.LVDisplay(snap, vgName); snapInfo != nil && snapInfo.VGName == vgName {
+		if err := v.Lv.DeleteSnapshot(snap, vgName); err != nil {
+			return err
+		}
 	}
-	defer v.Mutex.Release(VOLUMEMUTEX)
 
+	vgInfo, err := v.Lv.VGDisplay(vgName)
+	if err != nil {
+		log.Errorf("get device group info failed %s %s", vgName, err.Error())
+		return err
+	}
+	if reserved := v.Reserved.Bytes(vgName, vgInfo.VGSize); !hasSpace(vgInfo.VGFree, size, reserved) {
+		log.Warnf("%s don't have enough space for temporary snapshot, reserved %d", vgName, reserved)
+		return errors.New(carina.ResourceExhausted)
+	}
+	return v.Lv.CreateSnapshot(snap, origin, vgName, size)
+}
+
+// hasTag lv_tags中是否包含tag
+func hasTag(tags, tag string) bool {
+	for _, t := range strings.Split(tags, ",") {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+func (v *LocalVolumeImplement) createThinClone(name, sourceName, vgName string, size, sourceSize uint64, owner *types.VolumeOwner) error {
+	unlock, err := v.lock(lvKey(vgName, name), vgName)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	lvInfo, _ := v.Lv.LVDisplay(name, vgName)
+	if lvInfo != nil && lvInfo.VGName == vgName {
+		log.Infof("%s/%s volume exists", vgName, name)
+		return nil
+	}
+
+	if err := v.Lv.CreateSnapshot(name, sourceName, vgName, 0); err != nil {
+		return err
+	}
+	// 快照不继承源卷的标签
+	if err := v.Lv.LVAddTag(name, vgName, owner.Tags()...); err != nil {
+		return err
+	}
+	if size > sourceSize {
+		return v.Lv.LVResize(name, vgName, size)
+	}
+	return nil
+}
+
+// CreateThinVolume 在device group的thin pool中创建thin卷，pool不存在时创建
+// thin卷虚拟容量总和不能超过 (pool容量 + vg剩余容量) * overcommit
+func (v *LocalVolumeImplement) CreateThinVolume(lvName, vgName string, size uint64, overcommit float64, owner *types.VolumeOwner) error {
+	unlock, err := v.lock(lvKey(vgName, carina.VolumePrefix+lvName), vgName)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	name := carina.VolumePrefix + lvName
+
+	lvInfo, _ := v.Lv.LVDisplay(name, vgName)
+	if lvInfo != nil && lvInfo.VGName == vgName {
+		log.Infof("%s/%s volume exists", vgName, name)
+		return nil
+	}
+
+	vgInfo, err := v.Lv.VGDisplay(vgName)
+	if err != nil {
+		log.Errorf("get device group info failed %s %s", vgName, err.Error())
+		return err
+	}
+	if vgInfo == nil {
+		log.Error("cannot find device group info")
+		return errors.New("cannot find device group info")
+	}
+	size = alignExtent(size, vgInfo)
+
+	poolSize, virtualSize, err := v.ThinPoolUsage(vgName)
+	if err != nil {
+		return err
+	}
+	reserved := v.Reserved.Bytes(vgName, vgInfo.VGSize)
+	if !thinAllocatable(vgInfo.VGFree, reserved, poolSize, virtualSize+size, overcommit) {
+		log.Warnf("%s thin pool don't have enough space, overcommit %v", vgName, overcommit)
+		return errors.New(carina.ResourceExhausted)
+	}
+
+	if poolSize == 0 {
+		// 初始pool容量按超分比例折算，不足1g按1g
+		initSize := alignExtent(uint64(float64(size)/overcommit), vgInfo)
+		if initSize < 1<<30 {
+			initSize = 1 << 30
+		}
+		if !hasSpace(vgInfo.VGFree, initSize, reserved) {
+			log.Warnf("%s don't have enough space, reserved %d", vgName, reserved)
+			return errors.New(carina.ResourceExhausted)
+		}
+		tiered, err := v.getTieredPVs(vgName)
+		if err != nil {
+			return err
+		}
+		if err := v.Lv.CreateThinPool(carina.ThinPoolName, vgName, initSize, tiered.allocatable()...); err != nil {
+			log.Errorf("create thin pool failed %s/%s %s", vgName, carina.ThinPoolName, err.Error())
+			return err
+		}
+	}
+
+	return v.Lv.LVCreateFromPool(name, carina.ThinPoolName, vgName, size, owner.Tags())
+}
+
+func (v *LocalVolumeImplement) ResizeThinVolume(lvName, vgName string, size uint64, overcommit float64) error {
+	unlock, err := v.lock(lvKey(vgName, carina.VolumePrefix+lvName), vgName)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	name := carina.VolumePrefix + lvName
+
+	lvInfo, err := v.Lv.LVDisplay(name, vgName)
+	if err != nil {
+		log.Errorf("get volume info failed %s/%s %s", vgName, name, err.Error())
+		return err
+	}
+	if lvInfo.LVSize >= size {
+		log.Infof("%s/%s have expend", vgName, lvName)
+		return nil
+	}
+
+	vgInfo, err := v.Lv.VGDisplay(vgName)
+	if err != nil {
+		log.Errorf("get device group info failed %s %s", vgName, err.Error())
+		return err
+	}
+	size = alignExtent(size, vgInfo)
+	poolSize, virtualSize, err := v.ThinPoolUsage(vgName)
+	if err != nil {
+		return err
+	}
+	if !thinAllocatable(vgInfo.VGFree, v.Reserved.Bytes(vgName, vgInfo.VGSize), poolSize, virtualSize+size-lvInfo.LVSize, overcommit) {
+		log.Warnf("%s thin pool don't have enough space, overcommit %v", vgName, overcommit)
+		return errors.New(carina.ResourceExhausted)
+	}
+
+	return v.Lv.LVResize(name, vgName, size)
+}
+
+// ThinPoolUsage 返回thin pool容量以及pool内thin卷虚拟容量总和，pool不存在时均为0
+func (v *LocalVolumeImplement) ThinPoolUsage(vgName string) (poolSize, virtualSize uint64, err error) {
+	lvs, err := v.Lv.LVS(vgName)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, lv := range lvs {
+		if lv.LVName == carina.ThinPoolName {
+			poolSize = lv.LVSize
+		}
+		if lv.PoolLV == carina.ThinPoolName {
+			virtualSize += lv.LVSize
+		}
+	}
+	return poolSize, virtualSize, nil
+}
+
+// ExtendThinPool thin pool数据或元数据使用率超过阈值时扩容，数据每次扩容pool容量的20%，至少1g，
+// 元数据每次扩容元数据容量的20%，至少128m，不超过lvm允许的上限
+func (v *LocalVolumeImplement) ExtendThinPool(vgName string) (bool, error) {
+	unlock, err := v.lock(vgName)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	poolInfo, _ := v.Lv.LVDisplay(carina.ThinPoolName, vgName)
+	if poolInfo == nil {
+		return false, nil
+	}
+	extendData := poolInfo.DataPercent >= thinPoolExtendThreshold
+	extendMetadata := poolInfo.MetadataPercent >= thinPoolExtendThreshold && poolInfo.MetadataSize < thinPoolMaxMetadataSize
+	if poolInfo.MetadataPercent >= thinPoolExtendThreshold && !extendMetadata {
+		log.Warnf("thin pool %s/%s metadata usage %v%%, metadata size %d reaches the limit", vgName, carina.ThinPoolName, poolInfo.MetadataPercent, poolInfo.MetadataSize)
+	}
+	if !extendData && !extendMetadata {
+		return false, nil
+	}
+
+	vgInfo, err := v.Lv.VGDisplay(vgName)
+	if err != nil {
+		log.Errorf("get device group info failed %s %s", vgName, err.Error())
+		return false, err
+	}
+
+	var step, metadataStep uint64
+	if extendData {
+		step = alignExtent(poolInfo.LVSize/5, vgInfo)
+		if step < 1<<30 {
+			step = 1 << 30
+		}
+	}
+	if extendMetadata {
+		metadataStep = alignExtent(poolInfo.MetadataSize/5, vgInfo)
+		if metadataStep < 128<<20 {
+			metadataStep = 128 << 20
+		}
+		if poolInfo.MetadataSize+metadataStep > thinPoolMaxMetadataSize {
+			metadataStep = thinPoolMaxMetadataSize - poolInfo.MetadataSize
+		}
+	}
+	if !hasSpace(vgInfo.VGFree, step+metadataStep, v.Reserved.Bytes(vgName, vgInfo.VGSize)) {
+		log.Warnf("thin pool %s/%s data usage %v%%, metadata usage %v%%, %s don't have enough space to extend", vgName, carina.ThinPoolName, poolInfo.DataPercent, poolInfo.MetadataPercent, vgName)
+		return false, errors.New(carina.ResourceExhausted)
+	}
+
+	tiered, err := v.getTieredPVs(vgName)
+	if err != nil {
+		return false, err
+	}
+	// 先扩容元数据，元数据写满会导致pool只读
+	if extendMetadata {
+		log.Infof("thin pool %s/%s metadata usage %v%%, extend metadata %d to %d", vgName, carina.ThinPoolName, poolInfo.MetadataPercent, poolInfo.MetadataSize, poolInfo.MetadataSize+metadataStep)
+		if err := v.Lv.ResizeThinPoolMetadata(carina.ThinPoolName, vgName, poolInfo.MetadataSize+metadataStep, tiered.allocatable()...); err != nil {
+			return false, err
+		}
+	}
+	if extendData {
+		log.Infof("thin pool %s/%s data usage %v%%, extend %d to %d", vgName, carina.ThinPoolName, poolInfo.DataPercent, poolInfo.LVSize, poolInfo.LVSize+step)
+		if err := v.Lv.ResizeThinPool(carina.ThinPoolName, vgName, poolInfo.LVSize+step, tiered.allocatable()...); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func (v *LocalVolumeImplement) WithContext(ctx context.Context) LocalVolume {
+	return &LocalVolumeImplement{
+		Lv:       v.Lv.WithContext(ctx),
+		Bcache:   v.Bcache.WithContext(ctx),
+		Locks:    v.Locks,
+		Executor: v.Executor.WithContext(ctx),
+		Reserved: v.Reserved,
+		ctx:      ctx,
+	}
+}
+
+func (v *LocalVolumeImplement) context() context.Context {
+	if v.ctx == nil {
+		return context.Background()
+	}
+	return v.ctx
+}
+
+// lock 按顺序获取传入的锁，锁被占用时按FIFO顺序排队等待，超过lockTimeout或ctx结束返回错误
+func (v *LocalVolumeImplement) lock(keys ...string) (func(), error) {
+	ctx, cancel := context.WithTimeout(v.context(), lockTimeout)
+	defer cancel()
+	if err := v.Locks.AcquireAll(ctx, keys...); err != nil {
+		log.Warnf("%s, please retry...", err.Error())
+		return nil, err
+	}
+	return func() { v.Locks.ReleaseAll(keys...) }, nil
+}
+
+// SnapshotName 快照对应的lv名称，external-snapshotter生成的名称已带前缀时不再重复添加
+func SnapshotName(name string) string {
+	if strings.HasPrefix(name, carina.SnapshotPrefix) {
+		return name
+	}
+	return carina.SnapshotPrefix + name
+}
+
+// lvKey lv锁的key
+func lvKey(vgName, name string) string {
+	return vgName + "/" + name
+}
+
+// alignExtent 卷大小按vg的PE大小向上对齐，未获取到PE大小时按lvm默认值
+func alignExtent(size uint64, vgInfo *api.VgGroup) uint64 {
+	extent := uint64(carina.VolumeAlignment)
+	if vgInfo != nil && vgInfo.VGExtentSize > 0 {
+		extent = vgInfo.VGExtentSize
+	}
+	return (size + extent - 1) / extent * extent
+}
+
+// thinAllocatable pool可扩容至 pool容量 + vg剩余容量(除去保留空间)，thin卷虚拟容量按超分比例计算
+func thinAllocatable(vgFree, reserved, poolSize, virtualSize uint64, overcommit float64) bool {
+	physical := poolSize
+	if vgFree > reserved {
+		physical += vgFree - reserved
+	}
+	return float64(virtualSize) <= float64(physical)*overcommit
+}
+
+func (v *LocalVolumeImplement) DeleteVolume(lvName, vgName string) error {
 	name := lvName
 	if !strings.HasPrefix(lvName, carina.VolumePrefix) {
 		name = carina.VolumePrefix + lvName
 	}
 
+	// 可能同时删除thin pool，需要持有vg的锁
+	unlock, err := v.lock(lvKey(vgName, name), vgName)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
 	lvInfo, err := v.Lv.LVDisplay(name, vgName)
 	if err != nil && strings.Contains(err.Error(), "not found") {
 		log.Warnf("volume %s/%s not exist", vgName, lvName)
@@ -108,20 +497,34 @@ func (v *LocalVolumeImplement) DeleteVolume(lvName, vgName string) error {
 	}
 
 	// backward compatible
+	if lvInfo.PoolLV == "" {
+		return nil
+	}
 	thinInfo, _ := v.Lv.LVDisplay(lvInfo.PoolLV, vgName)
 	if thinInfo == nil {
 		log.Error("cannot find thin info")
 		return nil
 	}
+	// pool内仍有快照或克隆卷时保留pool
+	lvs, err := v.Lv.LVS(vgName)
+	if err != nil {
+		return err
+	}
+	for _, l := range lvs {
+		if l.PoolLV == lvInfo.PoolLV && l.LVName != name {
+			log.Infof("thin pool %s/%s still in use by %s", vgName, lvInfo.PoolLV, l.LVName)
+			return nil
+		}
+	}
 	return v.Lv.DeleteThinPool(lvInfo.PoolLV, vgName)
 }
 
-func (v *LocalVolumeImplement) ResizeVolume(lvName, vgName string, size, ratio uint64) error {
-	if !v.Mutex.TryAcquire(VOLUMEMUTEX) {
-		log.Info("wait other task release mutex, please retry...")
-		return errors.New("get global mutex failed")
+func (v *LocalVolumeImplement) ResizeVolume(lvName, vgName string, size, ratio uint64, layout *types.LvLayout) error {
+	unlock, err := v.lock(lvKey(vgName, carina.VolumePrefix+lvName), vgName)
+	if err != nil {
+		return err
 	}
-	defer v.Mutex.Release(VOLUMEMUTEX)
+	defer unlock()
 
 	// vg 检查
 	vgInfo, err := v.Lv.VGDisplay(vgName)
@@ -133,6 +536,7 @@ func (v *LocalVolumeImplement) ResizeVolume(lvName, vgName string, size, ratio u
 		log.Error("cannot find device group info")
 		return errors.New("cannot find device group info")
 	}
+	size = alignExtent(size, vgInfo)
 
 	name := carina.VolumePrefix + lvName
 
@@ -151,20 +555,40 @@ func (v *LocalVolumeImplement) ResizeVolume(lvName, vgName string, size, ratio u
 		return nil
 	}
 
-	if vgInfo.VGFree-(size-lvInfo.LVSize) < carina.DefaultReservedSpace-carina.DefaultEdgeSpace { //avoid edge conditions
-		log.Warnf("%s don't have enough space, reserved 10g", vgName)
+	tiered, err := v.getTieredPVs(vgName)
+	if err != nil {
+		return err
+	}
+	reserved := v.Reserved.Bytes(vgName, tiered.size(vgInfo.VGSize))
+	if size > lvInfo.LVSize && !hasSpace(tiered.free(vgInfo.VGFree), layout.AllocSize(size-lvInfo.LVSize), reserved) {
+		log.Warnf("%s don't have enough space, reserved %d", vgName, reserved)
 		return errors.New(carina.ResourceExhausted)
 	}
 
 	// backward compatible
 	thinInfo, _ := v.Lv.LVDisplay(lvInfo.PoolLV, vgName)
 	if thinInfo != nil && thinInfo.LVSize < size {
-		if err := v.Lv.ResizeThinPool(lvInfo.PoolLV, vgName, size*ratio); err != nil {
+		if err := v.Lv.ResizeThinPool(lvInfo.PoolLV, vgName, size*ratio, tiered.allocatable()...); err != nil {
 			return err
 		}
 	}
 
-	return v.Lv.LVResize(name, vgName, size)
+	return v.Lv.LVResize(name, vgName, size, tiered.allocatable()...)
+}
+
+func (v *LocalVolumeImplement) TagVolume(lvName, vgName string, owner *types.VolumeOwner) error {
+	name := lvName
+	if !strings.HasPrefix(lvName, carina.VolumePrefix) {
+		name = carina.VolumePrefix + lvName
+	}
+
+	unlock, err := v.lock(lvKey(vgName, name))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return v.Lv.LVAddTag(name, vgName, owner.Tags()...)
 }
 
 func (v *LocalVolumeImplement) VolumeList(lvName, vgName string) ([]types.LvInfo, error) {
@@ -175,6 +599,11 @@ func (v *LocalVolumeImplement) VolumeList(lvName, vgName string) ([]types.LvInfo
 	return v.Lv.LVS(name)
 }
 
+// RaidVolumeStatus vg中raid卷的同步以及健康状态
+func (v *LocalVolumeImplement) RaidVolumeStatus(vgName string) ([]types.LvRaidStatus, error) {
+	return v.Lv.LVRaidStatus(vgName)
+}
+
 func (v *LocalVolumeImplement) VolumeInfo(lvName, vgName string) (*types.LvInfo, error) {
 	lvs, err := v.VolumeList(lvName, vgName)
 	if err != nil {
@@ -190,6 +619,102 @@ func (v *LocalVolumeImplement) VolumeInfo(lvName, vgName string) (*types.LvInfo,
 	return nil, errors.New("not found")
 }
 
+func (v *LocalVolumeImplement) CreateSnapshot(snapName, lvName, vgName string) error {
+	origin := lvName
+	if !strings.HasPrefix(lvName, carina.VolumePrefix) {
+		origin = carina.VolumePrefix + lvName
+	}
+	name := SnapshotName(snapName)
+
+	unlock, err := v.lock(lvKey(vgName, name), vgName)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	vgInfo, err := v.Lv.VGDisplay(vgName)
+	if err != nil {
+		log.Errorf("get device group info failed %s %s", vgName, err.Error())
+		return err
+	}
+	if vgInfo == nil {
+		log.Error("cannot find device group info")
+		return errors.New("cannot find device group info")
+	}
+
+	snapInfo, _ := v.Lv.LVDisplay(name, vgName)
+	if snapInfo != nil && snapInfo.VGName == vgName {
+		log.Infof("%s/%s snapshot exists", vgName, name)
+		return nil
+	}
+
+	lvInfo, err := v.Lv.LVDisplay(origin, vgName)
+	if err != nil {
+		log.Errorf("get volume info failed %s/%s %s", vgName, origin, err.Error())
+		return err
+	}
+
+	// thin卷的快照占用pool空间，普通卷的快照按原卷大小创建，保证快照不会因写满而失效
+	var size uint64
+	if lvInfo.PoolLV == "" {
+		size = lvInfo.LVSize
+		if reserved := v.Reserved.Bytes(vgName, vgInfo.VGSize); !hasSpace(vgInfo.VGFree, size, reserved) {
+			log.Warnf("%s don't have enough space, reserved %d", vgName, reserved)
+			return errors.New(carina.ResourceExhausted)
+		}
+	}
+
+	return v.Lv.CreateSnapshot(name, origin, vgName, size)
+}
+
+func (v *LocalVolumeImplement) DeleteSnapshot(snapName, vgName string) error {
+	name := SnapshotName(snapName)
+
+	// 删除快照只释放空间，不阻塞同一vg的其他操作
+	unlock, err := v.lock(lvKey(vgName, name))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	_, err = v.Lv.LVDisplay(name, vgName)
+	if err != nil && strings.Contains(err.Error(), "not found") {
+		log.Warnf("snapshot %s/%s not exist", vgName, name)
+		return nil
+	}
+	if err != nil {
+		log.Errorf("get snapshot failed %s/%s %s", vgName, name, err.Error())
+		return err
+	}
+
+	return v.Lv.DeleteSnapshot(name, vgName)
+}
+
+func (v *LocalVolumeImplement) SnapshotDelta(fromSnap, toSnap, vgName string) ([]types.BlockRange, error) {
+	var infos []*types.LvInfo
+	for _, snapName := range []string{fromSnap, toSnap} {
+		name := SnapshotName(snapName)
+		info, err := v.Lv.LVDisplay(name, vgName)
+		if err != nil {
+			log.Errorf("get snapshot failed %s/%s %s", vgName, name, err.Error())
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+	from, to := infos[0], infos[1]
+	if from.PoolLV == "" || from.PoolLV != to.PoolLV {
+		return nil, fmt.Errorf("%s and %s are not thin snapshots in the same pool", fromSnap, toSnap)
+	}
+
+	// 同一pool只能保留一个元数据快照
+	unlock, err := v.lock(lvKey(vgName, to.PoolLV))
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	return v.Lv.ThinDelta(to.PoolLV, vgName, from.ThinID, to.ThinID)
+}
+
 func (v *LocalVolumeImplement) GetCurrentVgStruct() ([]api.VgGroup, error) {
 
 	resp := []api.VgGroup{}
@@ -229,11 +754,11 @@ func (v *LocalVolumeImplement) GetCurrentPvStruct() ([]api.PVInfo, error) {
 
 func (v *LocalVolumeImplement) AddNewDiskToVg(disk, vgName string) error {
 	vgName = strings.ToLower(vgName)
-	if !v.Mutex.TryAcquire(VOLUMEMUTEX) {
-		log.Info("wait other task release mutex, please retry...")
-		return errors.New("get global mutex failed")
+	unlock, err := v.lock(vgName)
+	if err != nil {
+		return err
 	}
-	defer v.Mutex.Release(VOLUMEMUTEX)
+	defer unlock()
 	// 确保PV存在
 	pvInfo, err := v.Lv.PVDisplay(disk)
 	if err != nil && !strings.Contains(err.Error(), "not found") {
@@ -275,11 +800,11 @@ func (v *LocalVolumeImplement) AddNewDiskToVg(disk, vgName string) error {
 	return nil
 }
 func (v *LocalVolumeImplement) RemoveDiskInVg(disk, vgName string) error {
-	if !v.Mutex.TryAcquire(VOLUMEMUTEX) {
-		log.Info("wait other task release mutex, please retry...")
-		return errors.New("get global mutex failed")
+	unlock, err := v.lock(vgName)
+	if err != nil {
+		return err
 	}
-	defer v.Mutex.Release(VOLUMEMUTEX)
+	defer unlock()
 
 	// 确保PV存在
 	pvInfo, err := v.Lv.PVDisplay(disk)
@@ -330,7 +855,7 @@ func (v *LocalVolumeImplement) RemoveDiskInVg(disk, vgName string) error {
 			}
 		} else {
 			// 移除该Pv，剩余空间不足，则不允许移除
-			if vgInfo.VGFree-carina.DefaultReservedSpace+carina.DefaultEdgeSpace < pvInfo.PVSize {
+			if !hasSpace(vgInfo.VGFree, pvInfo.PVSize, v.Reserved.Bytes(vgName, vgInfo.VGSize-pvInfo.PVSize)) {
 				log.Warnf("cannot remove the disk %s because there will not enough space", disk)
 				return errors.New(carina.ResourceExhausted)
 			}
@@ -344,25 +869,108 @@ func (v *LocalVolumeImplement) RemoveDiskInVg(disk, vgName string) error {
 	return nil
 }
 
-func (v *LocalVolumeImplement) HealthCheck() {
-	if !v.Mutex.TryAcquire(VOLUMEMUTEX) {
-		log.Info("wait other task release mutex, please retry...")
-		return
+func (v *LocalVolumeImplement) CordonDisk(disk string, cordon bool) error {
+	pvInfo, err := v.Lv.PVDisplay(disk)
+	if err != nil {
+		// 磁盘已经移出vg
+		if strings.Contains(err.Error(), "not found") {
+			return nil
+		}
+		return err
 	}
-	defer v.Mutex.Release(VOLUMEMUTEX)
+	// 迁移完成后vgreduce保留的pv不属于任何vg，无需恢复分配
+	if pvInfo.VGName == "" {
+		return nil
+	}
+	unlock, err := v.lock(pvInfo.VGName)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	// pv_attr第一位为a时表示可分配
+	if strings.HasPrefix(pvInfo.PVAttr, "a") != cordon {
+		return nil
+	}
+	return v.Lv.PVChange(disk, !cordon)
+}
 
-	ctx, cf := context.WithTimeout(context.TODO(), 25*time.Second)
-	defer cf()
+func (v *LocalVolumeImplement) DrainDiskInVg(disk, vgName string) (float64, bool, error) {
+	pvInfo, err := v.Lv.PVDisplay(disk)
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			return 100, true, nil
+		}
+		return 0, false, err
+	}
+	if pvInfo.VGName == "" {
+		return 100, true, nil
+	}
+	if pvInfo.VGName != vgName {
+		return 0, false, fmt.Errorf("pv %s have bind vg %s not %s", pvInfo.PVName, pvInfo.VGName, vgName)
+	}
+
+	// pvmove由lvmpolld在后台执行，不持有全局锁，避免长时间阻塞卷操作
+	percent, moving, err := v.Lv.PVMoveProgress(disk, vgName)
+	if err != nil {
+		return 0, false, err
+	}
+	if moving {
+		return percent, false, nil
+	}
 
-	for {
-		select {
-		case <-ctx.Done():
-			log.Info("volume health check timeout.")
-		default:
-			_ = v.Lv.RemoveUnknownDevice(carina.DeviceVGHDD)
-			_ = v.Lv.RemoveUnknownDevice(carina.DeviceVGSSD)
-			return
+	used := pvInfo.PVSize - pvInfo.PVFree
+	if used > 0 {
+		vgInfo, err := v.Lv.VGDisplay(vgName)
+		if err != nil {
+			return 0, false, err
+		}
+		if vgInfo.PVCount == 1 || vgInfo.VGFree-pvInfo.PVFree < used {
+			log.Warnf("cannot drain the disk %s because there is not enough space in vg %s", disk, vgName)
+			return 0, false, errors.New(carina.ResourceExhausted)
+		}
+		log.Infof("start to move extents of pv %s in vg %s, used %d", disk, vgName, used)
+		if err := v.Lv.PVMove(disk); err != nil {
+			return 0, false, err
+		}
+		return 0, false, nil
+	}
+
+	unlock, err := v.lock(vgName)
+	if err != nil {
+		return 100, false, nil
+	}
+	defer unlock()
+	vgInfo, err := v.Lv.VGDisplay(vgName)
+	if err != nil {
+		return 100, false, err
+	}
+	// vg中最后一块磁盘，且已经没有数据，直接删除vg
+	if vgInfo.PVCount == 1 {
+		if err := v.Lv.VGRemove(vgName); err != nil {
+			log.Errorf("vg remove %s failed %s", vgName, err.Error())
+			return 100, false, err
+		}
+		if err := v.Lv.PVRemove(disk); err != nil {
+			log.Errorf("pv remove %s failed %s", disk, err.Error())
+			return 100, false, err
+		}
+		return 100, true, nil
+	}
+	if err := v.Lv.VGReduce(vgName, disk); err != nil {
+		log.Errorf("vgreduce %s from vg %s failed %s", disk, vgName, err.Error())
+		return 100, false, err
+	}
+	return 100, true, nil
+}
+
+func (v *LocalVolumeImplement) HealthCheck() {
+	for _, vgName := range []string{carina.DeviceVGHDD, carina.DeviceVGSSD} {
+		unlock, err := v.lock(vgName)
+		if err != nil {
+			continue
 		}
+		_ = v.Lv.RemoveUnknownDevice(vgName)
+		unlock()
 	}
 }
 
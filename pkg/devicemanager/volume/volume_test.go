@@ -0,0 +1,437 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package volume
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/carina-io/carina"
+	"github.com/carina-io/carina/api"
+	"github.com/carina-io/carina/pkg/devicemanager/lvmd"
+	"github.com/carina-io/carina/pkg/devicemanager/types"
+	"github.com/carina-io/carina/utils/exec"
+	"github.com/carina-io/carina/utils/mutx"
+)
+
+// fakeLvm 只实现快照、克隆以及lvmcache卷用到的lvm操作，lv按名称保存在内存中
+type fakeLvm struct {
+	lvmd.Lvm2
+	lvs map[string]*types.LvInfo
+	pvs []api.PVInfo
+	// vg 为空时VGDisplay只返回vg名称
+	vg *api.VgGroup
+	// cacheVolErr 模拟lvm版本不支持--cachevol，cacheErr 模拟挂载缓存失败
+	cacheVolErr error
+	cacheErr    error
+	// moving 正在进行pvmove的源pv及其进度
+	moving map[string]float64
+	calls  []string
+}
+
+func (f *fakeLvm) VGDisplay(vg string) (*api.VgGroup, error) {
+	if f.vg != nil {
+		return f.vg, nil
+	}
+	return &api.VgGroup{VGName: vg}, nil
+}
+
+func (f *fakeLvm) LVDisplay(lv, vg string) (*types.LvInfo, error) {
+	info, ok := f.lvs[lv]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	return info, nil
+}
+
+func (f *fakeLvm) CreateSnapshot(snap, lv, vg string, size uint64) error {
+	origin, ok := f.lvs[lv]
+	if !ok {
+		return errors.New("not found")
+	}
+	f.calls = append(f.calls, "snapshot "+snap)
+	f.lvs[snap] = &types.LvInfo{LVName: snap, VGName: vg, PoolLV: origin.PoolLV, LVSize: origin.LVSize, Origin: lv}
+	return nil
+}
+
+func (f *fakeLvm) DeleteSnapshot(snap, vg string) error {
+	f.calls = append(f.calls, "remove "+snap)
+	delete(f.lvs, snap)
+	return nil
+}
+
+func (f *fakeLvm) LVAddTag(lv, vg string, tags ...string) error {
+	if info, ok := f.lvs[lv]; ok {
+		info.LVTags = strings.Join(append(strings.Split(info.LVTags, ","), tags...), ",")
+	}
+	return nil
+}
+
+// fakeExecutor 记录执行的命令，errs按命令名称返回错误
+type fakeExecutor struct {
+	exec.Executor
+	commands []string
+	errs     map[string]error
+}
+
+func (f *fakeExecutor) ExecuteCommand(command string, arg ...string) error {
+	f.commands = append(f.commands, command+" "+strings.Join(arg, " "))
+	return f.errs[command]
+}
+
+func TestSnapshotName(t *testing.T) {
+	assert.Equal(t, "snapshot-abc", SnapshotName("abc"))
+	assert.Equal(t, "snapshot-2f3c", SnapshotName("snapshot-2f3c"))
+}
+
+func TestCreateVolumeFromSnapshot(t *testing.T) {
+	// external-snapshotter生成的LogicSnapshot名称已带snapshot-前缀
+	for _, snapName := range []string{"snapshot-2f3c", "backup-2f3c"} {
+		lv := &fakeLvm{lvs: map[string]*types.LvInfo{
+			"volume-pvc-1": {LVName: "volume-pvc-1", VGName: "carina-vg-ssd", PoolLV: "thin-pool", LVSize: 1 << 30},
+		}}
+		v := &LocalVolumeImplement{Lv: lv, Locks: mutx.NewQueuedLocks(MaxLockWaiters), Reserved: NewReservedSpace()}
+
+		assert.NoError(t, v.CreateSnapshot(snapName, "pvc-1", "carina-vg-ssd"))
+		// LogicSnapshot控制器记录的SnapshotID即为恢复时的源卷名称
+		snapshotID := SnapshotName(snapName)
+		assert.Contains(t, lv.lvs, snapshotID)
+
+		assert.NoError(t, v.CreateVolumeFromSource("pvc-2", "carina-vg-ssd", snapshotID, 1<<30, 0, nil))
+		assert.Contains(t, lv.lvs, "volume-pvc-2")
+	}
+}
+
+func TestCreateVolumeFromThickSource(t *testing.T) {
+	const vg = "carina-vg-hdd"
+	copyFromSnapshot := fmt.Sprintf("dd if=/dev/%s/clone-volume-pvc-2 of=/dev/%s/volume-pvc-2 bs=4M oflag=direct conv=fsync", vg, vg)
+	cases := []struct {
+		name string
+		// target 上次请求遗留的目标卷
+		target    *types.LvInfo
+		source    *types.LvInfo
+		vgFree    uint64
+		ddErr     error
+		wantCalls []string
+		wantCopy  []string
+		wantTag   bool
+		wantErr   string
+	}{
+		{
+			name:      "clone volume",
+			source:    &types.LvInfo{LVName: "volume-pvc-1", LVSize: 10 << 30},
+			vgFree:    100 << 30,
+			wantCalls: []string{"create volume-pvc-2 ", "snapshot clone-volume-pvc-2", "remove clone-volume-pvc-2"},
+			wantCopy:  []string{copyFromSnapshot},
+			wantTag:   true,
+		},
+		// 快照数据不会变化，直接复制
+		{
+			name:      "clone snapshot",
+			source:    &types.LvInfo{LVName: "snapshot-1", LVSize: 10 << 30, Origin: "volume-pvc-1"},
+			vgFree:    100 << 30,
+			wantCalls: []string{"create volume-pvc-2 "},
+			wantCopy:  []string{fmt.Sprintf("dd if=/dev/%s/snapshot-1 of=/dev/%s/volume-pvc-2 bs=4M oflag=direct conv=fsync", vg, vg)},
+			wantTag:   true,
+		},
+		// 复制失败时保留目标卷，不打标签，由重试重新复制
+		{
+			name:      "copy failed",
+			source:    &types.LvInfo{LVName: "volume-pvc-1", LVSize: 10 << 30},
+			vgFree:    100 << 30,
+			ddErr:     errors.New("input/output error"),
+			wantCalls: []string{"create volume-pvc-2 ", "snapshot clone-volume-pvc-2", "remove clone-volume-pvc-2"},
+			wantCopy:  []string{copyFromSnapshot},
+			wantErr:   "input/output error",
+		},
+		{
+			name:      "retry interrupted copy",
+			target:    &types.LvInfo{LVName: "volume-pvc-2", LVSize: 10 << 30},
+			source:    &types.LvInfo{LVName: "volume-pvc-1", LVSize: 10 << 30},
+			vgFree:    100 << 30,
+			wantCalls: []string{"snapshot clone-volume-pvc-2", "remove clone-volume-pvc-2"},
+			wantCopy:  []string{copyFromSnapshot},
+			wantTag:   true,
+		},
+		{
+			name:    "copy completed",
+			target:  &types.LvInfo{LVName: "volume-pvc-2", LVSize: 10 << 30, LVTags: carina.CloneCompletedTag},
+			source:  &types.LvInfo{LVName: "volume-pvc-1", LVSize: 10 << 30},
+			vgFree:  100 << 30,
+			wantTag: true,
+		},
+		// 新卷已创建，剩余空间不足以创建临时快照
+		{
+			name:      "no space for snapshot",
+			source:    &types.LvInfo{LVName: "volume-pvc-1", LVSize: 10 << 30},
+			vgFree:    25 << 30,
+			wantCalls: []string{"create volume-pvc-2 "},
+			wantErr:   carina.ResourceExhausted,
+		},
+	}
+	for _, c := range cases {
+		lv := &fakeLvm{
+			lvs: map[string]*types.LvInfo{},
+			pvs: []api.PVInfo{{PVName: "/dev/sdb", VGName: vg, PVSize: 200 << 30, PVFree: c.vgFree}},
+			vg:  &api.VgGroup{VGName: vg, VGSize: 200 << 30, VGFree: c.vgFree, PVS: []*api.PVInfo{{PVName: "/dev/sdb"}}},
+		}
+		c.source.VGName = vg
+		lv.lvs[c.source.LVName] = c.source
+		if c.target != nil {
+			c.target.VGName = vg
+			lv.lvs[c.target.LVName] = c.target
+		}
+		executor := &fakeExecutor{errs: map[string]error{"dd": c.ddErr}}
+		v := &LocalVolumeImplement{Lv: lv, Executor: executor, Locks: mutx.NewQueuedLocks(MaxLockWaiters), Reserved: NewReservedSpace()}
+
+		err := v.CreateVolumeFromSource("pvc-2", vg, c.source.LVName, 10<<30, 0, nil)
+		if c.wantErr != "" {
+			assert.EqualError(t, err, c.wantErr, c.name)
+		} else {
+			assert.NoError(t, err, c.name)
+		}
+		assert.Equal(t, c.wantCalls, lv.calls, c.name)
+		assert.Equal(t, c.wantCopy, executor.commands, c.name)
+		assert.NotContains(t, lv.lvs, "clone-volume-pvc-2", c.name)
+		if target, ok := lv.lvs["volume-pvc-2"]; ok {
+			assert.Equal(t, c.wantTag, hasTag(target.LVTags, carina.CloneCompletedTag), c.name)
+		}
+		// 源卷和新卷的锁已释放
+		unlock, err := v.lock(lvKey(vg, c.source.LVName), lvKey(vg, "volume-pvc-2"), vg)
+		assert.NoError(t, err, c.name)
+		unlock()
+	}
+}
+
+func (f *fakeLvm) ResizeThinPool(lv, vg string, size uint64, pvs ...string) error {
+	f.calls = append(f.calls, fmt.Sprintf("resize %s %d", lv, size))
+	return nil
+}
+
+func (f *fakeLvm) ResizeThinPoolMetadata(lv, vg string, size uint64, pvs ...string) error {
+	f.calls = append(f.calls, fmt.Sprintf("resize metadata %s %d", lv, size))
+	return nil
+}
+
+func TestExtendThinPool(t *testing.T) {
+	cases := []struct {
+		name      string
+		pool      *types.LvInfo
+		vgFree    uint64
+		want      bool
+		wantCalls []string
+		wantErr   string
+	}{
+		{name: "no pool", vgFree: 100 << 30},
+		{name: "below threshold", pool: &types.LvInfo{LVSize: 10 << 30, DataPercent: 50, MetadataPercent: 50, MetadataSize: 1 << 30}, vgFree: 100 << 30},
+		{
+			name:      "data",
+			pool:      &types.LvInfo{LVSize: 10 << 30, DataPercent: 85, MetadataPercent: 10, MetadataSize: 1 << 30},
+			vgFree:    100 << 30,
+			want:      true,
+			wantCalls: []string{fmt.Sprintf("resize thin-pool %d", 12<<30)},
+		},
+		// 数据使用率低但元数据即将写满
+		{
+			name:      "metadata",
+			pool:      &types.LvInfo{LVSize: 10 << 30, DataPercent: 10, MetadataPercent: 90, MetadataSize: 64 << 20},
+			vgFree:    100 << 30,
+			want:      true,
+			wantCalls: []string{fmt.Sprintf("resize metadata thin-pool %d", 192<<20)},
+		},
+		{
+			name:   "data and metadata",
+			pool:   &types.LvInfo{LVSize: 10 << 30, DataPercent: 90, MetadataPercent: 90, MetadataSize: 1 << 30},
+			vgFree: 100 << 30,
+			want:   true,
+			wantCalls: []string{
+				fmt.Sprintf("resize metadata thin-pool %d", 1<<30+208<<20),
+				fmt.Sprintf("resize thin-pool %d", 12<<30),
+			},
+		},
+		{
+			name:      "metadata limited",
+			pool:      &types.LvInfo{LVSize: 100 << 30, DataPercent: 10, MetadataPercent: 90, MetadataSize: 16000 << 20},
+			vgFree:    100 << 30,
+			want:      true,
+			wantCalls: []string{fmt.Sprintf("resize metadata thin-pool %d", thinPoolMaxMetadataSize)},
+		},
+		{name: "metadata reaches the limit", pool: &types.LvInfo{LVSize: 100 << 30, MetadataPercent: 90, MetadataSize: thinPoolMaxMetadataSize}, vgFree: 100 << 30},
+		{
+			name:    "no space",
+			pool:    &types.LvInfo{LVSize: 10 << 30, DataPercent: 90, MetadataPercent: 90, MetadataSize: 1 << 30},
+			vgFree:  10 << 30,
+			wantErr: carina.ResourceExhausted,
+		},
+	}
+	for _, c := range cases {
+		lv := &fakeLvm{
+			lvs: map[string]*types.LvInfo{},
+			pvs: []api.PVInfo{{PVName: "/dev/sdb", VGName: "carina-vg-hdd", PVSize: 200 << 30, PVFree: c.vgFree}},
+			vg:  &api.VgGroup{VGName: "carina-vg-hdd", VGSize: 200 << 30, VGFree: c.vgFree},
+		}
+		if c.pool != nil {
+			c.pool.LVName, c.pool.VGName = carina.ThinPoolName, "carina-vg-hdd"
+			lv.lvs[carina.ThinPoolName] = c.pool
+		}
+		v := &LocalVolumeImplement{Lv: lv, Locks: mutx.NewQueuedLocks(MaxLockWaiters)}
+
+		ok, err := v.ExtendThinPool("carina-vg-hdd")
+		if c.wantErr != "" {
+			assert.EqualError(t, err, c.wantErr, c.name)
+		} else {
+			assert.NoError(t, err, c.name)
+		}
+		assert.Equal(t, c.want, ok, c.name)
+		assert.Equal(t, c.wantCalls, lv.calls, c.name)
+	}
+}
+
+func (f *fakeLvm) PVDisplay(dev string) (*api.PVInfo, error) {
+	for i := range f.pvs {
+		if f.pvs[i].PVName == dev {
+			return &f.pvs[i], nil
+		}
+	}
+	return nil, errors.New("not found")
+}
+
+func (f *fakeLvm) PVChange(dev string, allocatable bool) error {
+	f.calls = append(f.calls, fmt.Sprintf("pvchange %s %t", dev, allocatable))
+	return nil
+}
+
+func TestCordonDisk(t *testing.T) {
+	cases := []struct {
+		name      string
+		pv        *api.PVInfo
+		cordon    bool
+		wantCalls []string
+	}{
+		{name: "cordon", pv: &api.PVInfo{VGName: "carina-vg-hdd", PVAttr: "a--"}, cordon: true, wantCalls: []string{"pvchange /dev/sdb false"}},
+		{name: "cordoned", pv: &api.PVInfo{VGName: "carina-vg-hdd", PVAttr: "---"}, cordon: true},
+		{name: "uncordon", pv: &api.PVInfo{VGName: "carina-vg-hdd", PVAttr: "---"}, wantCalls: []string{"pvchange /dev/sdb true"}},
+		// 迁移完成后vgreduce保留的pv不属于任何vg
+		{name: "uncordon drained", pv: &api.PVInfo{PVAttr: "---"}},
+		{name: "uncordon removed"},
+	}
+	for _, c := range cases {
+		lv := &fakeLvm{lvs: map[string]*types.LvInfo{}}
+		if c.pv != nil {
+			c.pv.PVName = "/dev/sdb"
+			lv.pvs = []api.PVInfo{*c.pv}
+		}
+		v := &LocalVolumeImplement{Lv: lv, Locks: mutx.NewQueuedLocks(MaxLockWaiters)}
+
+		assert.NoError(t, v.CordonDisk("/dev/sdb", c.cordon), c.name)
+		assert.Equal(t, c.wantCalls, lv.calls, c.name)
+	}
+}
+
+func (f *fakeLvm) PVMove(dev string) error {
+	f.calls = append(f.calls, "pvmove "+dev)
+	return nil
+}
+
+func (f *fakeLvm) PVMoveProgress(dev, vg string) (float64, bool, error) {
+	percent, ok := f.moving[dev]
+	return percent, ok, nil
+}
+
+func (f *fakeLvm) VGReduce(vg, pv string) error {
+	f.calls = append(f.calls, "vgreduce "+vg+" "+pv)
+	return nil
+}
+
+func (f *fakeLvm) VGRemove(vg string) error {
+	f.calls = append(f.calls, "vgremove "+vg)
+	return nil
+}
+
+func (f *fakeLvm) PVRemove(dev string) error {
+	f.calls = append(f.calls, "pvremove "+dev)
+	return nil
+}
+
+func TestDrainDiskInVg(t *testing.T) {
+	const vg = "carina-vg-hdd"
+	cases := []struct {
+		name        string
+		pv          *api.PVInfo
+		pvCount     uint64
+		vgFree      uint64
+		moving      map[string]float64
+		wantPercent float64
+		wantDone    bool
+		wantCalls   []string
+		wantErr     string
+	}{
+		{name: "start", pv: &api.PVInfo{VGName: vg, PVSize: 100 << 30, PVFree: 60 << 30}, pvCount: 2, vgFree: 160 << 30, wantCalls: []string{"pvmove /dev/sdb"}},
+		{name: "moving", pv: &api.PVInfo{VGName: vg, PVSize: 100 << 30, PVFree: 60 << 30}, pvCount: 2, vgFree: 160 << 30, moving: map[string]float64{"/dev/sdb": 45}, wantPercent: 45},
+		// 同一vg中其他磁盘的迁移不影响本磁盘
+		{
+			name:      "other disk moving",
+			pv:        &api.PVInfo{VGName: vg, PVSize: 100 << 30, PVFree: 60 << 30},
+			pvCount:   3,
+			vgFree:    260 << 30,
+			moving:    map[string]float64{"/dev/sdc": 30},
+			wantCalls: []string{"pvmove /dev/sdb"},
+		},
+		{name: "no space", pv: &api.PVInfo{VGName: vg, PVSize: 100 << 30, PVFree: 50 << 30}, pvCount: 2, vgFree: 60 << 30, wantErr: carina.ResourceExhausted},
+		{name: "last disk with data", pv: &api.PVInfo{VGName: vg, PVSize: 100 << 30, PVFree: 60 << 30}, pvCount: 1, vgFree: 60 << 30, wantErr: carina.ResourceExhausted},
+		{name: "moved", pv: &api.PVInfo{VGName: vg, PVSize: 100 << 30, PVFree: 100 << 30}, pvCount: 2, vgFree: 160 << 30, wantPercent: 100, wantDone: true, wantCalls: []string{"vgreduce carina-vg-hdd /dev/sdb"}},
+		{
+			name:        "moved last disk",
+			pv:          &api.PVInfo{VGName: vg, PVSize: 100 << 30, PVFree: 100 << 30},
+			pvCount:     1,
+			vgFree:      100 << 30,
+			wantPercent: 100,
+			wantDone:    true,
+			wantCalls:   []string{"vgremove carina-vg-hdd", "pvremove /dev/sdb"},
+		},
+		// 已经vgreduce或pvremove
+		{name: "reduced", pv: &api.PVInfo{PVSize: 100 << 30, PVFree: 100 << 30}, wantPercent: 100, wantDone: true},
+		{name: "removed", wantPercent: 100, wantDone: true},
+		{name: "other vg", pv: &api.PVInfo{VGName: "carina-vg-ssd", PVSize: 100 << 30}, wantErr: "pv /dev/sdb have bind vg carina-vg-ssd not carina-vg-hdd"},
+	}
+	for _, c := range cases {
+		lv := &fakeLvm{
+			lvs:    map[string]*types.LvInfo{},
+			vg:     &api.VgGroup{VGName: vg, PVCount: c.pvCount, VGFree: c.vgFree},
+			moving: c.moving,
+		}
+		if c.pv != nil {
+			c.pv.PVName = "/dev/sdb"
+			lv.pvs = []api.PVInfo{*c.pv}
+		}
+		v := &LocalVolumeImplement{Lv: lv, Locks: mutx.NewQueuedLocks(MaxLockWaiters)}
+
+		percent, done, err := v.DrainDiskInVg("/dev/sdb", vg)
+		if c.wantErr != "" {
+			assert.EqualError(t, err, c.wantErr, c.name)
+		} else {
+			assert.NoError(t, err, c.name)
+		}
+		assert.Equal(t, c.wantPercent, percent, c.name)
+		assert.Equal(t, c.wantDone, done, c.name)
+		assert.Equal(t, c.wantCalls, lv.calls, c.name)
+	}
+}
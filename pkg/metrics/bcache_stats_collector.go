@@ -0,0 +1,255 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/carina-io/carina/pkg/csidriver/driver/k8s"
+	"github.com/carina-io/carina/utils/log"
+)
+
+const (
+	bcacheSubSystem string = "bcache"
+	// need mount /sys/block when container deploy carina node
+	sysBlockPath = "/sys/block"
+)
+
+var (
+	bcacheLabels = []string{"namespace", "pvc", "pv", "device_group", "device"}
+
+	bcacheInfoDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, bcacheSubSystem, "info"),
+		"The cache mode and state of the bcache device.",
+		append(append([]string{}, bcacheLabels...), "mode", "state"),
+		constLabels,
+	)
+	bcacheHitsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, bcacheSubSystem, "hits_total"),
+		"The total number of cache hits of the bcache device.",
+		bcacheLabels,
+		constLabels,
+	)
+	bcacheMissesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, bcacheSubSystem, "misses_total"),
+		"The total number of cache misses of the bcache device.",
+		bcacheLabels,
+		constLabels,
+	)
+	bcacheBypassHitsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, bcacheSubSystem, "bypass_hits_total"),
+		"The total number of cache hits of the I/O bypassing the cache.",
+		bcacheLabels,
+		constLabels,
+	)
+	bcacheBypassMissesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, bcacheSubSystem, "bypass_misses_total"),
+		"The total number of cache misses of the I/O bypassing the cache.",
+		bcacheLabels,
+		constLabels,
+	)
+	bcacheHitRatioDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, bcacheSubSystem, "hit_ratio"),
+		"The ratio of cache hits to all cache lookups of the bcache device.",
+		bcacheLabels,
+		constLabels,
+	)
+	bcacheDirtyBytesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, bcacheSubSystem, "dirty_bytes"),
+		"The bytes of dirty data not yet written back to the backing volume.",
+		bcacheLabels,
+		constLabels,
+	)
+)
+
+// bcacheStats /sys/block/bcache*/bcache下的统计，计数为设备注册以来的总数
+type bcacheStats struct {
+	name         string
+	backing      string
+	mode         string
+	state        string
+	hits         uint64
+	misses       uint64
+	bypassHits   uint64
+	bypassMisses uint64
+	hitRatio     float64
+	dirtyBytes   uint64
+}
+
+type bcacheStatsCollector struct {
+	descs     []typedFactorDesc
+	lvService *k8s.LogicVolumeService
+	sysPath   string
+}
+
+func newBcacheStatsCollector(lvService *k8s.LogicVolumeService) (Collector, error) {
+	return &bcacheStatsCollector{
+		descs: []typedFactorDesc{
+			{desc: bcacheHitsDesc, valueType: prometheus.CounterValue},
+			{desc: bcacheMissesDesc, valueType: prometheus.CounterValue},
+			{desc: bcacheBypassHitsDesc, valueType: prometheus.CounterValue},
+			{desc: bcacheBypassMissesDesc, valueType: prometheus.CounterValue},
+			{desc: bcacheHitRatioDesc, valueType: prometheus.GaugeValue},
+			{desc: bcacheDirtyBytesDesc, valueType: prometheus.GaugeValue},
+		},
+		lvService: lvService,
+		sysPath:   sysBlockPath,
+	}, nil
+}
+
+func (c *bcacheStatsCollector) Name() string {
+	return "bcache"
+}
+
+func (c *bcacheStatsCollector) Update(ch chan<- prometheus.Metric) error {
+	stats, err := readBcacheStats(c.sysPath)
+	if err != nil {
+		return err
+	}
+	if len(stats) == 0 {
+		return ErrNoData
+	}
+	logicVolumes, err := c.lvService.GetLogicVolumesByNodeName(context.Background(), nodeName, false)
+	if err != nil {
+		return err
+	}
+	// bcache设备的后端设备为pvc对应的lvm卷
+	devices := map[string]int{}
+	for i, lv := range logicVolumes {
+		devices[fmt.Sprintf("%d:%d", lv.Status.DeviceMajor, lv.Status.DeviceMinor)] = i
+	}
+
+	for _, s := range stats {
+		i, ok := devices[s.backing]
+		if !ok {
+			continue
+		}
+		lv := logicVolumes[i]
+		labels := []string{lv.Spec.NameSpace, lv.Spec.Pvc, lv.PVName(), lv.Spec.DeviceGroup, s.name}
+		ch <- prometheus.MustNewConstMetric(bcacheInfoDesc, prometheus.GaugeValue, 1, append(labels, s.mode, s.state)...)
+		// need keep order with desc
+		for j, val := range []float64{
+			float64(s.hits),
+			float64(s.misses),
+			float64(s.bypassHits),
+			float64(s.bypassMisses),
+			s.hitRatio,
+			float64(s.dirtyBytes),
+		} {
+			if j >= len(c.descs) {
+				break
+			}
+			ch <- c.descs[j].mustNewConstMetric(val, labels...)
+		}
+	}
+	return nil
+}
+
+// readBcacheStats 读取sysPath下全部bcache设备的统计，单个设备读取失败时跳过
+func readBcacheStats(sysPath string) ([]bcacheStats, error) {
+	dirs, err := filepath.Glob(filepath.Join(sysPath, "bcache*"))
+	if err != nil {
+		return nil, err
+	}
+	var resp []bcacheStats
+	for _, dir := range dirs {
+		s, err := readBcacheDevice(dir)
+		if err != nil {
+			log.Warnf("read bcache stats of %s failed %v", dir, err)
+			continue
+		}
+		resp = append(resp, *s)
+	}
+	return resp, nil
+}
+
+func readBcacheDevice(dir string) (*bcacheStats, error) {
+	s := &bcacheStats{name: filepath.Base(dir)}
+	slaves, err := os.ReadDir(filepath.Join(dir, "slaves"))
+	if err != nil {
+		return nil, err
+	}
+	if len(slaves) != 1 {
+		return nil, fmt.Errorf("bcache device %s has %d backing devices", s.name, len(slaves))
+	}
+	if s.backing, err = readSysfsString(filepath.Join(dir, "slaves", slaves[0].Name(), "dev")); err != nil {
+		return nil, err
+	}
+
+	mode, err := readSysfsString(filepath.Join(dir, "bcache", "cache_mode"))
+	if err != nil {
+		return nil, err
+	}
+	s.mode = parseBcacheCacheMode(mode)
+	if s.state, err = readSysfsString(filepath.Join(dir, "bcache", "state")); err != nil {
+		return nil, err
+	}
+	dirty, err := readSysfsString(filepath.Join(dir, "bcache", "dirty_data"))
+	if err != nil {
+		return nil, err
+	}
+	if s.dirtyBytes, err = parseBcacheSize(dirty); err != nil {
+		return nil, err
+	}
+
+	for file, val := range map[string]*uint64{
+		"cache_hits":          &s.hits,
+		"cache_misses":        &s.misses,
+		"cache_bypass_hits":   &s.bypassHits,
+		"cache_bypass_misses": &s.bypassMisses,
+	} {
+		v, err := readSysfsString(filepath.Join(dir, "bcache", "stats_total", file))
+		if err != nil {
+			return nil, err
+		}
+		if *val, err = strconv.ParseUint(v, 10, 64); err != nil {
+			return nil, err
+		}
+	}
+	if s.hits+s.misses > 0 {
+		s.hitRatio = float64(s.hits) / float64(s.hits+s.misses)
+	}
+	return s, nil
+}
+
+func readSysfsString(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// parseBcacheCacheMode cache_mode中方括号内为当前模式，例如 writethrough [writeback] writearound none
+func parseBcacheCacheMode(modes string) string {
+	for _, mode := range strings.Fields(modes) {
+		if strings.HasPrefix(mode, "[") && strings.HasSuffix(mode, "]") {
+			return strings.Trim(mode, "[]")
+		}
+	}
+	return modes
+}
+
+// parseBcacheSize 解析bcache以1024为进制输出的容量，例如 512、1.5k、2.0M
+func parseBcacheSize(size string) (uint64, error) {
+	units := "kMGTPEZY"
+	multiplier := 1.0
+	if n := len(size); n > 0 {
+		if i := strings.IndexByte(units, size[n-1]); i >= 0 {
+			for j := 0; j <= i; j++ {
+				multiplier *= 1024
+			}
+			size = size[:n-1]
+		}
+	}
+	val, err := strconv.ParseFloat(size, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid bcache size %s", size)
+	}
+	return uint64(val * multiplier), nil
+}
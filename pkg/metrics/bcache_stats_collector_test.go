@@ -0,0 +1,64 @@
+package metrics
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeSysfs(t *testing.T, path, content string) {
+	assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	assert.NoError(t, os.WriteFile(path, []byte(content+"\n"), 0644))
+}
+
+func TestReadBcacheStats(t *testing.T) {
+	sysPath := t.TempDir()
+	dir := filepath.Join(sysPath, "bcache0")
+	writeSysfs(t, filepath.Join(dir, "slaves", "dm-3", "dev"), "253:3")
+	writeSysfs(t, filepath.Join(dir, "bcache", "cache_mode"), "writethrough [writeback] writearound none")
+	writeSysfs(t, filepath.Join(dir, "bcache", "state"), "dirty")
+	writeSysfs(t, filepath.Join(dir, "bcache", "dirty_data"), "1.5M")
+	writeSysfs(t, filepath.Join(dir, "bcache", "stats_total", "cache_hits"), "300")
+	writeSysfs(t, filepath.Join(dir, "bcache", "stats_total", "cache_misses"), "100")
+	writeSysfs(t, filepath.Join(dir, "bcache", "stats_total", "cache_bypass_hits"), "5")
+	writeSysfs(t, filepath.Join(dir, "bcache", "stats_total", "cache_bypass_misses"), "7")
+	// 没有后端设备的bcache设备跳过
+	assert.NoError(t, os.MkdirAll(filepath.Join(sysPath, "bcache1", "slaves"), 0755))
+
+	stats, err := readBcacheStats(sysPath)
+	assert.NoError(t, err)
+	assert.Equal(t, []bcacheStats{{
+		name:         "bcache0",
+		backing:      "253:3",
+		mode:         "writeback",
+		state:        "dirty",
+		hits:         300,
+		misses:       100,
+		bypassHits:   5,
+		bypassMisses: 7,
+		hitRatio:     0.75,
+		dirtyBytes:   1572864,
+	}}, stats)
+}
+
+func TestParseBcacheSize(t *testing.T) {
+	table := []struct {
+		size     string
+		expected uint64
+	}{
+		{size: "0", expected: 0},
+		{size: "512", expected: 512},
+		{size: "2.0k", expected: 2048},
+		{size: "1.5M", expected: 1572864},
+		{size: "3G", expected: 3 << 30},
+	}
+	for _, e := range table {
+		size, err := parseBcacheSize(e.size)
+		assert.NoError(t, err)
+		assert.Equal(t, e.expected, size, e.size)
+	}
+	_, err := parseBcacheSize("abc")
+	assert.Error(t, err)
+}
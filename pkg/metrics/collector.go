@@ -84,8 +84,33 @@ func NewCarinaCollector(dm *deviceManager.DeviceManager, lvService *k8s.LogicVol
 	if err != nil {
 		return nil, err
 	}
+	diskHealthCollector, err := newDiskHealthCollector(dm)
+	if err != nil {
+		return nil, err
+	}
 	collectors[vgStatsCollector.Name()] = vgStatsCollector
 	collectors[volumeStatsCollector.Name()] = volumeStatsCollector
+	lvmCacheStatsCollector, err := newLvmCacheStatsCollector(dm)
+	if err != nil {
+		return nil, err
+	}
+	collectors[diskHealthCollector.Name()] = diskHealthCollector
+	collectors[lvmCacheStatsCollector.Name()] = lvmCacheStatsCollector
+	thinPoolStatsCollector, err := newThinPoolStatsCollector(dm, lvService)
+	if err != nil {
+		return nil, err
+	}
+	bcacheStatsCollector, err := newBcacheStatsCollector(lvService)
+	if err != nil {
+		return nil, err
+	}
+	rawPartitionStatsCollector, err := newRawPartitionStatsCollector(dm, lvService)
+	if err != nil {
+		return nil, err
+	}
+	collectors[thinPoolStatsCollector.Name()] = thinPoolStatsCollector
+	collectors[bcacheStatsCollector.Name()] = bcacheStatsCollector
+	collectors[rawPartitionStatsCollector.Name()] = rawPartitionStatsCollector
 
 	return &CarinaCollector{collectors: collectors, dm: dm}, nil
 }
@@ -0,0 +1,101 @@
+package metrics
+
+import (
+	deviceManager "github.com/carina-io/carina/pkg/devicemanager"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	diskHealthSubSystem string = "disk_health"
+)
+
+var (
+	diskHealthLabels    = []string{"device", "device_group"}
+	diskSmartPassedDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, diskHealthSubSystem, "smart_passed"),
+		"Whether the SMART overall-health self-assessment passed.",
+		diskHealthLabels,
+		constLabels,
+	)
+	diskTemperatureDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, diskHealthSubSystem, "temperature_celsius"),
+		"The current temperature of the disk.",
+		diskHealthLabels,
+		constLabels,
+	)
+	diskReallocatedSectorsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, diskHealthSubSystem, "reallocated_sectors"),
+		"The number of reallocated sectors of the disk.",
+		diskHealthLabels,
+		constLabels,
+	)
+	diskPendingSectorsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, diskHealthSubSystem, "pending_sectors"),
+		"The number of sectors waiting to be remapped.",
+		diskHealthLabels,
+		constLabels,
+	)
+	diskMediaErrorsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, diskHealthSubSystem, "media_errors"),
+		"The number of uncorrectable media errors of the disk.",
+		diskHealthLabels,
+		constLabels,
+	)
+	diskPercentageUsedDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, diskHealthSubSystem, "percentage_used"),
+		"The estimated percentage of the SSD endurance used.",
+		diskHealthLabels,
+		constLabels,
+	)
+)
+
+type diskHealthCollector struct {
+	descs []typedFactorDesc
+	dm    *deviceManager.DeviceManager
+}
+
+func newDiskHealthCollector(dm *deviceManager.DeviceManager) (Collector, error) {
+	return &diskHealthCollector{
+		descs: []typedFactorDesc{
+			{desc: diskSmartPassedDesc, valueType: prometheus.GaugeValue},
+			{desc: diskTemperatureDesc, valueType: prometheus.GaugeValue},
+			{desc: diskReallocatedSectorsDesc, valueType: prometheus.GaugeValue},
+			{desc: diskPendingSectorsDesc, valueType: prometheus.GaugeValue},
+			{desc: diskMediaErrorsDesc, valueType: prometheus.GaugeValue},
+			{desc: diskPercentageUsedDesc, valueType: prometheus.GaugeValue},
+		},
+		dm: dm,
+	}, nil
+}
+
+func (d *diskHealthCollector) Name() string {
+	return "disk_health"
+}
+
+func (d *diskHealthCollector) Update(ch chan<- prometheus.Metric) error {
+	health := d.dm.GetDiskHealth()
+	if len(health) == 0 {
+		return ErrNoData
+	}
+	for _, h := range health {
+		passed := float64(0)
+		if h.Passed {
+			passed = 1
+		}
+		// need keep order with desc
+		for i, val := range []float64{
+			passed,
+			float64(h.Temperature),
+			float64(h.ReallocatedSectors),
+			float64(h.PendingSectors),
+			float64(h.MediaErrors),
+			float64(h.PercentageUsed),
+		} {
+			if i >= len(d.descs) {
+				break
+			}
+			ch <- d.descs[i].mustNewConstMetric(val, h.Disk, h.Group)
+		}
+	}
+	return nil
+}
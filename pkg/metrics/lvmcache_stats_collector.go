@@ -0,0 +1,122 @@
+package metrics
+
+import (
+	"strings"
+
+	"github.com/carina-io/carina"
+	deviceManager "github.com/carina-io/carina/pkg/devicemanager"
+	"github.com/carina-io/carina/utils/log"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	volumeCacheSubSystem string = "volume_cache"
+)
+
+var (
+	volumeCacheLabels          = []string{"volume", "device_group", "mode"}
+	volumeCacheTotalBlocksDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, volumeCacheSubSystem, "total_blocks"),
+		"The number of cache blocks of the lvmcache volume.",
+		volumeCacheLabels,
+		constLabels,
+	)
+	volumeCacheUsedBlocksDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, volumeCacheSubSystem, "used_blocks"),
+		"The number of used cache blocks of the lvmcache volume.",
+		volumeCacheLabels,
+		constLabels,
+	)
+	volumeCacheDirtyBlocksDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, volumeCacheSubSystem, "dirty_blocks"),
+		"The number of dirty cache blocks not yet written back to the origin volume.",
+		volumeCacheLabels,
+		constLabels,
+	)
+	volumeCacheReadHitsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, volumeCacheSubSystem, "read_hits"),
+		"The number of read hits of the lvmcache volume.",
+		volumeCacheLabels,
+		constLabels,
+	)
+	volumeCacheReadMissesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, volumeCacheSubSystem, "read_misses"),
+		"The number of read misses of the lvmcache volume.",
+		volumeCacheLabels,
+		constLabels,
+	)
+	volumeCacheWriteHitsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, volumeCacheSubSystem, "write_hits"),
+		"The number of write hits of the lvmcache volume.",
+		volumeCacheLabels,
+		constLabels,
+	)
+	volumeCacheWriteMissesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, volumeCacheSubSystem, "write_misses"),
+		"The number of write misses of the lvmcache volume.",
+		volumeCacheLabels,
+		constLabels,
+	)
+)
+
+type lvmCacheStatsCollector struct {
+	descs []typedFactorDesc
+	dm    *deviceManager.DeviceManager
+}
+
+func newLvmCacheStatsCollector(dm *deviceManager.DeviceManager) (Collector, error) {
+	return &lvmCacheStatsCollector{
+		descs: []typedFactorDesc{
+			{desc: volumeCacheTotalBlocksDesc, valueType: prometheus.GaugeValue},
+			{desc: volumeCacheUsedBlocksDesc, valueType: prometheus.GaugeValue},
+			{desc: volumeCacheDirtyBlocksDesc, valueType: prometheus.GaugeValue},
+			{desc: volumeCacheReadHitsDesc, valueType: prometheus.CounterValue},
+			{desc: volumeCacheReadMissesDesc, valueType: prometheus.CounterValue},
+			{desc: volumeCacheWriteHitsDesc, valueType: prometheus.CounterValue},
+			{desc: volumeCacheWriteMissesDesc, valueType: prometheus.CounterValue},
+		},
+		dm: dm,
+	}, nil
+}
+
+func (c *lvmCacheStatsCollector) Name() string {
+	return "volume_cache"
+}
+
+func (c *lvmCacheStatsCollector) Update(ch chan<- prometheus.Metric) error {
+	found := false
+	// 只有配置了缓存磁盘组的磁盘组才会有lvmcache卷
+	for _, ds := range c.dm.GetNodeDiskSelectGroup() {
+		if ds.CacheGroup == "" {
+			continue
+		}
+		stats, err := c.dm.VolumeManager.CacheVolumeStats(ds.Name)
+		if err != nil {
+			log.Warnf("get cache stats of %s failed %v", ds.Name, err)
+			continue
+		}
+		for _, s := range stats {
+			found = true
+			volume := strings.TrimPrefix(s.LVName, carina.VolumePrefix)
+			// need keep order with desc
+			for i, val := range []float64{
+				float64(s.TotalBlocks),
+				float64(s.UsedBlocks),
+				float64(s.DirtyBlocks),
+				float64(s.ReadHits),
+				float64(s.ReadMisses),
+				float64(s.WriteHits),
+				float64(s.WriteMisses),
+			} {
+				if i >= len(c.descs) {
+					break
+				}
+				ch <- c.descs[i].mustNewConstMetric(val, volume, s.VGName, s.CacheMode)
+			}
+		}
+	}
+	if !found {
+		return ErrNoData
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+package metrics
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/carina-io/carina"
+	carinav1beta1 "github.com/carina-io/carina/api/v1beta1"
+	"github.com/carina-io/carina/pkg/csidriver/driver/k8s"
+	deviceManager "github.com/carina-io/carina/pkg/devicemanager"
+)
+
+const (
+	rawDiskSubSystem      string = "raw_disk"
+	rawPartitionSubSystem string = "raw_partition"
+)
+
+var (
+	rawDiskLabels      = []string{"device_group", "disk"}
+	rawPartitionLabels = []string{"namespace", "pvc", "pv", "device_group", "disk"}
+
+	rawDiskCapacityBytesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, rawDiskSubSystem, "capacity_bytes"),
+		"The size of the raw disk.",
+		rawDiskLabels,
+		constLabels,
+	)
+	rawDiskAllocatableBytesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, rawDiskSubSystem, "allocatable_bytes"),
+		"The largest free space of the raw disk available for a new partition.",
+		rawDiskLabels,
+		constLabels,
+	)
+	rawDiskAllocatedBytesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, rawDiskSubSystem, "allocated_bytes"),
+		"The total size of the partitions of volumes on the raw disk.",
+		rawDiskLabels,
+		constLabels,
+	)
+	rawDiskPartitionsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, rawDiskSubSystem, "partitions"),
+		"The number of partitions of volumes on the raw disk.",
+		rawDiskLabels,
+		constLabels,
+	)
+	rawDiskExclusiveDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, rawDiskSubSystem, "exclusive"),
+		"Whether the raw disk is used exclusively by one volume.",
+		rawDiskLabels,
+		constLabels,
+	)
+	rawPartitionSizeBytesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, rawPartitionSubSystem, "size_bytes"),
+		"The size of the partition of the volume.",
+		rawPartitionLabels,
+		constLabels,
+	)
+	rawPartitionExclusiveDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, rawPartitionSubSystem, "exclusive"),
+		"Whether the volume uses the raw disk exclusively.",
+		rawPartitionLabels,
+		constLabels,
+	)
+)
+
+// rawDiskUsage 裸盘上卷分区的用量
+type rawDiskUsage struct {
+	capacity    float64
+	allocatable float64
+	allocated   float64
+	partitions  float64
+	exclusive   float64
+}
+
+type rawPartitionStatsCollector struct {
+	descs     []typedFactorDesc
+	dm        *deviceManager.DeviceManager
+	lvService *k8s.LogicVolumeService
+}
+
+func newRawPartitionStatsCollector(dm *deviceManager.DeviceManager, lvService *k8s.LogicVolumeService) (Collector, error) {
+	return &rawPartitionStatsCollector{
+		descs: []typedFactorDesc{
+			{desc: rawDiskCapacityBytesDesc, valueType: prometheus.GaugeValue},
+			{desc: rawDiskAllocatableBytesDesc, valueType: prometheus.GaugeValue},
+			{desc: rawDiskAllocatedBytesDesc, valueType: prometheus.GaugeValue},
+			{desc: rawDiskPartitionsDesc, valueType: prometheus.GaugeValue},
+			{desc: rawDiskExclusiveDesc, valueType: prometheus.GaugeValue},
+		},
+		dm:        dm,
+		lvService: lvService,
+	}, nil
+}
+
+func (c *rawPartitionStatsCollector) Name() string {
+	return "raw_partition"
+}
+
+func (c *rawPartitionStatsCollector) Update(ch chan<- prometheus.Metric) error {
+	diskSelectGroup := c.dm.GetNodeDiskSelectGroup()
+	disks := map[string]*rawDiskUsage{}
+
+	// 裸盘的容量以及可分配容量由carina-node扫描后记录在NodeStorageResource中，key为 carina.storage.io/<磁盘组>/<磁盘>
+	nsr := &carinav1beta1.NodeStorageResource{}
+	if err := c.dm.Cache.Get(context.Background(), client.ObjectKey{Name: nodeName}, nsr); client.IgnoreNotFound(err) != nil {
+		return err
+	}
+	for key, capacity := range nsr.Status.Capacity {
+		if !strings.HasPrefix(key, carina.DeviceCapacityKeyPrefix) {
+			continue
+		}
+		group, disk, ok := splitRawDeviceGroup(strings.TrimPrefix(key, carina.DeviceCapacityKeyPrefix))
+		if !ok {
+			continue
+		}
+		if ds, ok := diskSelectGroup[group]; !ok || strings.ToLower(ds.Policy) != "raw" {
+			continue
+		}
+		allocatable := nsr.Status.Allocatable[key]
+		disks[group+"/"+disk] = &rawDiskUsage{capacity: float64(capacity.Value()), allocatable: float64(allocatable.Value())}
+	}
+
+	logicVolumes, err := c.lvService.GetLogicVolumesByNodeName(context.Background(), nodeName, false)
+	if err != nil {
+		return err
+	}
+	for _, lv := range logicVolumes {
+		if lv.Annotations[carina.VolumeManagerType] != carina.RawVolumeType {
+			continue
+		}
+		group, disk, ok := splitRawDeviceGroup(lv.Spec.DeviceGroup)
+		if !ok {
+			continue
+		}
+		size := lv.Spec.Size.Value()
+		if lv.Status.CurrentSize != nil {
+			size = lv.Status.CurrentSize.Value()
+		}
+		exclusive := float64(0)
+		if lv.Annotations[carina.ExclusivityDisk] == "true" {
+			exclusive = 1
+		}
+		labels := []string{lv.Spec.NameSpace, lv.Spec.Pvc, lv.PVName(), group, disk}
+		ch <- prometheus.MustNewConstMetric(rawPartitionSizeBytesDesc, prometheus.GaugeValue, float64(size), labels...)
+		ch <- prometheus.MustNewConstMetric(rawPartitionExclusiveDesc, prometheus.GaugeValue, exclusive, labels...)
+
+		usage, ok := disks[lv.Spec.DeviceGroup]
+		if !ok {
+			usage = &rawDiskUsage{}
+			disks[lv.Spec.DeviceGroup] = usage
+		}
+		usage.allocated += float64(size)
+		usage.partitions++
+		if exclusive > usage.exclusive {
+			usage.exclusive = exclusive
+		}
+	}
+
+	if len(disks) == 0 {
+		return ErrNoData
+	}
+	keys := make([]string, 0, len(disks))
+	for key := range disks {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		group, disk, _ := splitRawDeviceGroup(key)
+		usage := disks[key]
+		// need keep order with desc
+		for i, val := range []float64{
+			usage.capacity,
+			usage.allocatable,
+			usage.allocated,
+			usage.partitions,
+			usage.exclusive,
+		} {
+			if i >= len(c.descs) {
+				break
+			}
+			ch <- c.descs[i].mustNewConstMetric(val, group, disk)
+		}
+	}
+	return nil
+}
+
+// splitRawDeviceGroup 裸盘卷的磁盘组为 <磁盘组>/<磁盘>
+func splitRawDeviceGroup(deviceGroup string) (string, string, bool) {
+	parts := strings.SplitN(deviceGroup, "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
@@ -0,0 +1,146 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/carina-io/carina"
+	carinav1 "github.com/carina-io/carina/api/v1"
+	"github.com/carina-io/carina/pkg/csidriver/driver/k8s"
+	deviceManager "github.com/carina-io/carina/pkg/devicemanager"
+)
+
+const (
+	thinPoolSubSystem   string = "thin_pool"
+	thinVolumeSubSystem string = "thin_volume"
+)
+
+var (
+	thinPoolLabels = []string{"device_group"}
+
+	thinPoolSizeBytesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, thinPoolSubSystem, "size_bytes"),
+		"The size of the thin pool in bytes.",
+		thinPoolLabels,
+		constLabels,
+	)
+	thinPoolVirtualBytesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, thinPoolSubSystem, "virtual_bytes"),
+		"The total virtual size of the thin volumes in the thin pool.",
+		thinPoolLabels,
+		constLabels,
+	)
+	thinPoolDataPercentDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, thinPoolSubSystem, "data_percent"),
+		"The percentage of the thin pool data space used.",
+		thinPoolLabels,
+		constLabels,
+	)
+	thinPoolMetadataPercentDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, thinPoolSubSystem, "metadata_percent"),
+		"The percentage of the thin pool metadata space used.",
+		thinPoolLabels,
+		constLabels,
+	)
+	thinVolumeDataPercentDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, thinVolumeSubSystem, "data_percent"),
+		"The percentage of the thin volume allocated in the thin pool.",
+		deviceStatLabels,
+		constLabels,
+	)
+)
+
+type thinPoolStatsCollector struct {
+	descs     []typedFactorDesc
+	dm        *deviceManager.DeviceManager
+	lvService *k8s.LogicVolumeService
+}
+
+func newThinPoolStatsCollector(dm *deviceManager.DeviceManager, lvService *k8s.LogicVolumeService) (Collector, error) {
+	return &thinPoolStatsCollector{
+		descs: []typedFactorDesc{
+			{desc: thinPoolSizeBytesDesc, valueType: prometheus.GaugeValue},
+			{desc: thinPoolVirtualBytesDesc, valueType: prometheus.GaugeValue},
+			{desc: thinPoolDataPercentDesc, valueType: prometheus.GaugeValue},
+			{desc: thinPoolMetadataPercentDesc, valueType: prometheus.GaugeValue},
+		},
+		dm:        dm,
+		lvService: lvService,
+	}, nil
+}
+
+func (c *thinPoolStatsCollector) Name() string {
+	return "thin_pool"
+}
+
+func (c *thinPoolStatsCollector) Update(ch chan<- prometheus.Metric) error {
+	diskSelectGroup := c.dm.GetNodeDiskSelectGroup()
+	lvs, err := c.dm.VolumeManager.VolumeList("", "")
+	if err != nil {
+		return errors.New("couldn't get logical volumes:" + err.Error())
+	}
+	logicVolumes, err := logicVolumesByName(c.lvService)
+	if err != nil {
+		return err
+	}
+
+	virtualSize := map[string]uint64{}
+	for _, lv := range lvs {
+		if lv.PoolLV == carina.ThinPoolName {
+			virtualSize[lv.VGName] += lv.LVSize
+		}
+	}
+
+	found := false
+	for _, lv := range lvs {
+		if _, ok := diskSelectGroup[lv.VGName]; !ok {
+			continue
+		}
+		if lv.LVName == carina.ThinPoolName {
+			found = true
+			// need keep order with desc
+			for i, val := range []float64{
+				float64(lv.LVSize),
+				float64(virtualSize[lv.VGName]),
+				lv.DataPercent,
+				lv.MetadataPercent,
+			} {
+				if i >= len(c.descs) {
+					break
+				}
+				ch <- c.descs[i].mustNewConstMetric(val, lv.VGName)
+			}
+			continue
+		}
+		// 只统计pvc的thin卷，快照同样位于thin pool中
+		if lv.PoolLV != carina.ThinPoolName || !strings.HasPrefix(lv.LVName, carina.VolumePrefix) {
+			continue
+		}
+		logicVolume, ok := logicVolumes[lv.LVName]
+		if !ok {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(thinVolumeDataPercentDesc, prometheus.GaugeValue, lv.DataPercent,
+			logicVolume.Spec.NameSpace, logicVolume.Spec.Pvc, logicVolume.PVName(), lv.VGName)
+	}
+	if !found {
+		return ErrNoData
+	}
+	return nil
+}
+
+// logicVolumesByName 本节点的LogicVolume，key为lvm卷名称
+func logicVolumesByName(lvService *k8s.LogicVolumeService) (map[string]*carinav1.LogicVolume, error) {
+	logicVolumes, err := lvService.GetLogicVolumesByNodeName(context.Background(), nodeName, false)
+	if err != nil {
+		return nil, err
+	}
+	resp := make(map[string]*carinav1.LogicVolume, len(logicVolumes))
+	for _, lv := range logicVolumes {
+		resp[carina.VolumePrefix+lv.Name] = lv
+	}
+	return resp, nil
+}
@@ -0,0 +1,105 @@
+/*
+ Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package migration
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"time"
+
+	"github.com/carina-io/carina/utils/log"
+)
+
+// Server 目标节点接收迁移数据的tls服务，每个节点启动时生成新的证书
+type Server struct {
+	addr        string
+	endpoint    string
+	cert        tls.Certificate
+	fingerprint string
+	authorize   Authorize
+}
+
+// NewServer addr为监听地址，advertiseHost为源节点连接使用的地址
+func NewServer(addr, advertiseHost string, authorize Authorize) (*Server, error) {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	cert, fingerprint, err := NewServerCertificate(advertiseHost)
+	if err != nil {
+		return nil, err
+	}
+	return &Server{
+		addr:        addr,
+		endpoint:    net.JoinHostPort(advertiseHost, port),
+		cert:        cert,
+		fingerprint: fingerprint,
+		authorize:   authorize,
+	}, nil
+}
+
+// Endpoint 源节点连接的地址
+func (s *Server) Endpoint() string {
+	return s.endpoint
+}
+
+// Fingerprint 服务证书的sha256指纹
+func (s *Server) Fingerprint() string {
+	return s.fingerprint
+}
+
+// Start implements controller-runtime's manager.Runnable.
+func (s *Server) Start(ctx context.Context) error {
+	lis, err := tls.Listen("tcp", s.addr, &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{s.cert},
+	})
+	if err != nil {
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		lis.Close()
+	}()
+
+	log.Infof("migration server listening on %s, advertise %s", s.addr, s.endpoint)
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			log.Warnf("accept migration connection failed %s", err.Error())
+			time.Sleep(time.Second)
+			continue
+		}
+		go s.serve(conn)
+	}
+}
+
+func (s *Server) serve(conn net.Conn) {
+	defer conn.Close()
+	if err := Receive(conn, s.authorize); err != nil {
+		log.Errorf("receive migration data from %s failed %s", conn.RemoteAddr(), err.Error())
+	}
+}
+
+// NeedLeaderElection implements controller-runtime's manager.LeaderElectionRunnable.
+func (s *Server) NeedLeaderElection() bool {
+	return false
+}
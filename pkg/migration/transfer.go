@@ -0,0 +1,284 @@
+/*
+ Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package migration
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// 卷迁移的数据传输，源节点按块读取设备发送至目标节点，目标节点写入相同的偏移，
+// 每个连接先发送一行json请求头，目标节点校验通过后返回一行json结果，源节点随后发送数据帧，
+// 以结束帧收尾，目标节点数据落盘后再返回一行json结果
+
+const (
+	// ChunkSize 每个数据帧的最大长度，也是增量同步比较的粒度
+	ChunkSize = 4 << 20
+
+	frameHeaderSize = 16
+	maxHeaderSize   = 4 << 10
+
+	frameData uint32 = 0
+	// frameZero 全零的数据块只发送帧头
+	frameZero uint32 = 1
+	frameEnd  uint32 = 2
+)
+
+// Header 源节点发送的请求头
+type Header struct {
+	Migration string `json:"migration"`
+	Token     string `json:"token"`
+	// Volume 目标卷的volume id
+	Volume string `json:"volume"`
+	Size   int64  `json:"size"`
+	// Final 应用停止后的增量同步
+	Final bool `json:"final"`
+}
+
+// Result 目标节点返回的结果
+type Result struct {
+	Bytes int64  `json:"bytes"`
+	Error string `json:"error,omitempty"`
+}
+
+// Digests 已发送数据块的摘要，增量同步时跳过未变化的数据块
+type Digests [][sha256.Size]byte
+
+// Authorize 校验请求头，返回需要写入的设备
+type Authorize func(hdr *Header) (string, error)
+
+// Send 读取device的前hdr.Size字节发送至conn，返回发送的字节数，
+// digests记录本次发送的数据块摘要，其中已有的摘要与数据块一致时跳过该数据块，
+// 发送失败时目标节点写入了哪些数据块无法确定，digests会被清空
+func Send(ctx context.Context, conn io.ReadWriter, device string, hdr Header, digests *Digests, progress func(int64)) (sent int64, err error) {
+	defer func() {
+		if err != nil {
+			*digests = nil
+		}
+	}()
+
+	f, err := os.Open(device)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	line, err := json.Marshal(hdr)
+	if err != nil {
+		return 0, err
+	}
+	w := bufio.NewWriterSize(conn, ChunkSize+frameHeaderSize)
+	if _, err := w.Write(append(line, '\n')); err != nil {
+		return 0, err
+	}
+	if err := w.Flush(); err != nil {
+		return 0, err
+	}
+	r := bufio.NewReader(conn)
+	if _, err := readResult(r); err != nil {
+		return 0, err
+	}
+
+	buf := make([]byte, ChunkSize)
+	zero := make([]byte, ChunkSize)
+	for i, offset := 0, int64(0); offset < hdr.Size; i, offset = i+1, offset+ChunkSize {
+		if err := ctx.Err(); err != nil {
+			return sent, err
+		}
+		length := int64(ChunkSize)
+		if hdr.Size-offset < length {
+			length = hdr.Size - offset
+		}
+		chunk := buf[:length]
+		if _, err := f.ReadAt(chunk, offset); err != nil {
+			return sent, fmt.Errorf("read %s at %d: %w", device, offset, err)
+		}
+
+		sum := sha256.Sum256(chunk)
+		if i < len(*digests) && (*digests)[i] == sum {
+			continue
+		}
+		if i < len(*digests) {
+			(*digests)[i] = sum
+		} else {
+			*digests = append(*digests, sum)
+		}
+
+		flag := frameData
+		if bytes.Equal(chunk, zero[:length]) {
+			flag = frameZero
+		}
+		if err := writeFrame(w, offset, uint32(length), flag); err != nil {
+			return sent, err
+		}
+		if flag == frameData {
+			if _, err := w.Write(chunk); err != nil {
+				return sent, err
+			}
+		}
+		sent += length
+		if progress != nil {
+			progress(sent)
+		}
+	}
+	if err := writeFrame(w, 0, 0, frameEnd); err != nil {
+		return sent, err
+	}
+	if err := w.Flush(); err != nil {
+		return sent, err
+	}
+
+	if _, err := readResult(r); err != nil {
+		return sent, err
+	}
+	return sent, nil
+}
+
+// Receive 接收一次传输写入authorize返回的设备，数据落盘后向源节点返回结果
+func Receive(conn io.ReadWriter, authorize Authorize) error {
+	r := bufio.NewReaderSize(conn, ChunkSize+frameHeaderSize)
+	hdr, err := readHeader(r)
+	if err != nil {
+		return err
+	}
+
+	device, err := authorize(hdr)
+	if werr := writeResult(conn, 0, err); err != nil || werr != nil {
+		return firstError(err, werr)
+	}
+	written, err := receiveFrames(r, device, hdr.Size)
+	return firstError(err, writeResult(conn, written, err))
+}
+
+func firstError(errs ...error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeResult(w io.Writer, written int64, err error) error {
+	result := Result{Bytes: written}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	line, _ := json.Marshal(result)
+	_, werr := w.Write(append(line, '\n'))
+	return werr
+}
+
+func readResult(r *bufio.Reader) (*Result, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, fmt.Errorf("read result: %w", err)
+	}
+	result := &Result{}
+	if err := json.Unmarshal(line, result); err != nil {
+		return nil, fmt.Errorf("decode result: %w", err)
+	}
+	if result.Error != "" {
+		return result, errors.New(result.Error)
+	}
+	return result, nil
+}
+
+func readHeader(r *bufio.Reader) (*Header, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, fmt.Errorf("read header: %w", err)
+	}
+	hdr := &Header{}
+	if err := json.Unmarshal(line, hdr); err != nil {
+		return nil, fmt.Errorf("decode header: %w", err)
+	}
+	return hdr, nil
+}
+
+// readLine 读取一行json，限制长度避免未认证的连接占用内存
+func readLine(r *bufio.Reader) ([]byte, error) {
+	var line []byte
+	for len(line) < maxHeaderSize {
+		part, isPrefix, err := r.ReadLine()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, part...)
+		if !isPrefix {
+			return line, nil
+		}
+	}
+	return nil, errors.New("line too long")
+}
+
+func receiveFrames(r io.Reader, device string, size int64) (int64, error) {
+	f, err := os.OpenFile(device, os.O_WRONLY, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	var written int64
+	head := make([]byte, frameHeaderSize)
+	buf := make([]byte, ChunkSize)
+	zero := make([]byte, ChunkSize)
+	for {
+		if _, err := io.ReadFull(r, head); err != nil {
+			return written, fmt.Errorf("read frame: %w", err)
+		}
+		offset := int64(binary.BigEndian.Uint64(head[0:8]))
+		length := int64(binary.BigEndian.Uint32(head[8:12]))
+		flag := binary.BigEndian.Uint32(head[12:16])
+		if flag == frameEnd {
+			break
+		}
+		if length > ChunkSize || offset < 0 || offset+length > size {
+			return written, fmt.Errorf("invalid frame offset %d length %d", offset, length)
+		}
+
+		data := zero[:length]
+		if flag == frameData {
+			data = buf[:length]
+			if _, err := io.ReadFull(r, data); err != nil {
+				return written, fmt.Errorf("read frame: %w", err)
+			}
+		}
+		if _, err := f.WriteAt(data, offset); err != nil {
+			return written, fmt.Errorf("write %s at %d: %w", device, offset, err)
+		}
+		written += length
+	}
+	return written, f.Sync()
+}
+
+func writeFrame(w io.Writer, offset int64, length, flag uint32) error {
+	head := make([]byte, frameHeaderSize)
+	binary.BigEndian.PutUint64(head[0:8], uint64(offset))
+	binary.BigEndian.PutUint32(head[8:12], length)
+	binary.BigEndian.PutUint32(head[12:16], flag)
+	_, err := w.Write(head)
+	return err
+}
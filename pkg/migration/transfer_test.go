@@ -0,0 +1,95 @@
+/*
+ Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package migration
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSendReceive(t *testing.T) {
+	dir := t.TempDir()
+	source, target := filepath.Join(dir, "source"), filepath.Join(dir, "target")
+	size := int64(2*ChunkSize + 4096)
+	data := make([]byte, size)
+	_, _ = rand.Read(data[:ChunkSize])
+	// 第二个数据块全为零，最后不足一个块
+	_, _ = rand.Read(data[2*ChunkSize:])
+	assert.NoError(t, os.WriteFile(source, data, 0600))
+	assert.NoError(t, os.WriteFile(target, bytes.Repeat([]byte{0xff}, int(size)), 0600))
+
+	cert, fingerprint, err := NewServerCertificate("127.0.0.1")
+	assert.NoError(t, err)
+	lis, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	assert.NoError(t, err)
+	defer lis.Close()
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			_ = Receive(conn, func(hdr *Header) (string, error) {
+				if hdr.Token != "secret" {
+					return "", errors.New("unauthorized")
+				}
+				return target, nil
+			})
+			conn.Close()
+		}
+	}()
+
+	send := func(token string, digests *Digests) (int64, error) {
+		conn, err := Dial(context.Background(), lis.Addr().String(), fingerprint)
+		if err != nil {
+			return 0, err
+		}
+		defer conn.Close()
+		return Send(context.Background(), conn, source, Header{Migration: "m", Token: token, Volume: "volume-t", Size: size}, digests, nil)
+	}
+
+	var digests Digests
+	_, err = send("wrong", &digests)
+	assert.EqualError(t, err, "unauthorized")
+	assert.Nil(t, digests)
+
+	sent, err := send("secret", &digests)
+	assert.NoError(t, err)
+	assert.Equal(t, size, sent)
+	got, _ := os.ReadFile(target)
+	assert.True(t, bytes.Equal(data, got))
+
+	// 增量同步只发送变化的数据块
+	data[2*ChunkSize+1] ^= 0xff
+	assert.NoError(t, os.WriteFile(source, data, 0600))
+	sent, err = send("secret", &digests)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4096), sent)
+	got, _ = os.ReadFile(target)
+	assert.True(t, bytes.Equal(data, got))
+
+	_, err = Dial(context.Background(), lis.Addr().String(), "0000")
+	assert.Error(t, err)
+}
@@ -0,0 +1,194 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package quota
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"github.com/carina-io/carina"
+	carinav1 "github.com/carina-io/carina/api/v1"
+	"github.com/carina-io/carina/pkg/devicemanager/types"
+)
+
+// Usage 一个磁盘组的用量
+type Usage struct {
+	Storage        int64
+	Volumes        int64
+	ExclusiveDisks int64
+}
+
+// Add 累加用量
+func (u Usage) Add(other Usage) Usage {
+	return Usage{
+		Storage:        u.Storage + other.Storage,
+		Volumes:        u.Volumes + other.Volumes,
+		ExclusiveDisks: u.ExclusiveDisks + other.ExclusiveDisks,
+	}
+}
+
+// ToStatus 转换为CarinaStorageQuota status中的用量
+func (u Usage) ToStatus() carinav1.DeviceGroupUsage {
+	return carinav1.DeviceGroupUsage{
+		Storage:        *resource.NewQuantity(u.Storage, resource.BinarySI),
+		Volumes:        u.Volumes,
+		ExclusiveDisks: u.ExclusiveDisks,
+	}
+}
+
+// GroupUsage 各磁盘组的用量，磁盘组名称为小写
+type GroupUsage map[string]Usage
+
+// Add 累加磁盘组的用量，裸盘卷的磁盘组为<磁盘组>/<磁盘>，按磁盘组计算
+func (g GroupUsage) Add(group string, u Usage) {
+	group = DeviceGroupName(group)
+	g[group] = g[group].Add(u)
+}
+
+// DeviceGroupName 配额中的磁盘组名称，去掉裸盘卷磁盘组中的磁盘
+func DeviceGroupName(deviceGroup string) string {
+	return strings.ToLower(strings.SplitN(deviceGroup, "/", 2)[0])
+}
+
+// Merge 累加其他磁盘组用量
+func (g GroupUsage) Merge(other GroupUsage) {
+	for group, u := range other {
+		g.Add(group, u)
+	}
+}
+
+// VolumeUsage LogicVolume占用的磁盘组容量
+// raid卷按副本以及校验条带计算；lvmcache卷的缓存位于后端磁盘组的vg中，按比例计入缓存磁盘组；
+// bcache卷的缓存是独立的LogicVolume，随其自身计算，且不计入卷数量
+func VolumeUsage(lv *carinav1.LogicVolume) GroupUsage {
+	return ResizedVolumeUsage(lv, lv.Spec.Size.Value())
+}
+
+// ResizedVolumeUsage 卷扩容至size后占用的磁盘组容量，扩容同时扩大bcache以及lvmcache的缓存
+func ResizedVolumeUsage(lv *carinav1.LogicVolume, size int64) GroupUsage {
+	usage := GroupUsage{}
+	u := Usage{Storage: size}
+	if layout, err := types.NewLvLayout(lv.Annotations); err == nil {
+		u.Storage = int64(layout.AllocSize(uint64(size)))
+	}
+	if len(lv.OwnerReferences) == 0 {
+		u.Volumes = 1
+	}
+	if lv.Annotations[carina.VolumeManagerType] == carina.RawVolumeType && lv.Annotations[carina.ExclusivityDisk] == "true" {
+		u.ExclusiveDisks = 1
+	}
+	usage.Add(lv.Spec.DeviceGroup, u)
+
+	if lv.Annotations[carina.VolumeCacheBackend] == carina.LvmCacheBackend {
+		usage.Add(lv.Annotations[carina.VolumeCacheDiskType], Usage{Storage: CacheSize(size, lv.Annotations[carina.VolumeCacheDiskRatio])})
+	}
+	return usage
+}
+
+// ExpansionCharge 卷扩容至size后的用量，owned为bcache卷的缓存卷，按相同比例扩容；
+// 扩容不增加卷数量，不校验卷数量以及独占磁盘数量
+func ExpansionCharge(lv *carinav1.LogicVolume, owned []carinav1.LogicVolume, size int64) GroupUsage {
+	charge := ResizedVolumeUsage(lv, size)
+	for i := range owned {
+		charge.Merge(ResizedVolumeUsage(&owned[i], CacheSize(size, lv.Annotations[carina.VolumeCacheDiskRatio])))
+	}
+	for group, u := range charge {
+		charge[group] = Usage{Storage: u.Storage}
+	}
+	return charge
+}
+
+// CacheSize 缓存容量，与CreateVolume按比例计算缓存容量的方式一致
+func CacheSize(size int64, cacheDiskRatio string) int64 {
+	ratio, err := strconv.ParseInt(cacheDiskRatio, 10, 64)
+	if err != nil || ratio < 1 || ratio >= 100 {
+		return 0
+	}
+	return (size*ratio/100 + carina.VolumeAlignment - 1) / carina.VolumeAlignment * carina.VolumeAlignment
+}
+
+// NamespaceUsage 命名空间下所有LogicVolume的用量，skip返回true的卷不计入
+func NamespaceUsage(namespace string, lvs []carinav1.LogicVolume, skip func(lv *carinav1.LogicVolume) bool) GroupUsage {
+	usage := GroupUsage{}
+	for i := range lvs {
+		lv := &lvs[i]
+		if lv.Spec.NameSpace != namespace || (skip != nil && skip(lv)) {
+			continue
+		}
+		usage.Merge(VolumeUsage(lv))
+	}
+	return usage
+}
+
+// Exceeded 返回加上charge后超出配额的项，只校验charge中增加的部分，配额调低后已有的超额用量不影响其他操作
+func Exceeded(q *carinav1.CarinaStorageQuota, used, charge GroupUsage) []string {
+	var exceeded []string
+	groups := make([]string, 0, len(q.Spec.Hard))
+	for group := range q.Spec.Hard {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+
+	for _, group := range groups {
+		hard := q.Spec.Hard[group]
+		c, u := charge[DeviceGroupName(group)], used[DeviceGroupName(group)]
+		if hard.Storage != nil && c.Storage > 0 && u.Storage+c.Storage > hard.Storage.Value() {
+			exceeded = append(exceeded, fmt.Sprintf("device group %s storage requested %s, used %s, limited %s",
+				group, quantity(c.Storage), quantity(u.Storage), hard.Storage.String()))
+		}
+		if hard.Volumes != nil && c.Volumes > 0 && u.Volumes+c.Volumes > *hard.Volumes {
+			exceeded = append(exceeded, fmt.Sprintf("device group %s volumes requested %d, used %d, limited %d",
+				group, c.Volumes, u.Volumes, *hard.Volumes))
+		}
+		if hard.ExclusiveDisks != nil && c.ExclusiveDisks > 0 && u.ExclusiveDisks+c.ExclusiveDisks > *hard.ExclusiveDisks {
+			exceeded = append(exceeded, fmt.Sprintf("device group %s exclusive disks requested %d, used %d, limited %d",
+				group, c.ExclusiveDisks, u.ExclusiveDisks, *hard.ExclusiveDisks))
+		}
+	}
+	return exceeded
+}
+
+// Check 校验命名空间下的所有配额，返回的错误中包含全部超出的配额
+func Check(quotas []carinav1.CarinaStorageQuota, used, charge GroupUsage) error {
+	var messages []string
+	for i := range quotas {
+		for _, e := range Exceeded(&quotas[i], used, charge) {
+			messages = append(messages, fmt.Sprintf("exceeded quota %s: %s", quotas[i].Name, e))
+		}
+	}
+	if len(messages) > 0 {
+		return fmt.Errorf("%s", strings.Join(messages, "; "))
+	}
+	return nil
+}
+
+// Status 配额中各磁盘组的用量
+func Status(q *carinav1.CarinaStorageQuota, used GroupUsage) map[string]carinav1.DeviceGroupUsage {
+	status := make(map[string]carinav1.DeviceGroupUsage, len(q.Spec.Hard))
+	for group := range q.Spec.Hard {
+		status[group] = used[DeviceGroupName(group)].ToStatus()
+	}
+	return status
+}
+
+func quantity(bytes int64) string {
+	return resource.NewQuantity(bytes, resource.BinarySI).String()
+}
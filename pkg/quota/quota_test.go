@@ -0,0 +1,121 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package quota
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/carina-io/carina"
+	carinav1 "github.com/carina-io/carina/api/v1"
+)
+
+const gi = int64(1 << 30)
+
+func newLogicVolume(name, group string, size int64, annotations map[string]string) carinav1.LogicVolume {
+	return carinav1.LogicVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Annotations: annotations},
+		Spec: carinav1.LogicVolumeSpec{
+			Size:        *resource.NewQuantity(size, resource.BinarySI),
+			DeviceGroup: group,
+			Pvc:         name,
+			NameSpace:   "default",
+		},
+	}
+}
+
+func TestVolumeUsage(t *testing.T) {
+	table := []struct {
+		lv       carinav1.LogicVolume
+		expected GroupUsage
+	}{
+		{
+			lv:       newLogicVolume("lvm", "carina-vg-hdd", 10*gi, nil),
+			expected: GroupUsage{"carina-vg-hdd": {Storage: 10 * gi, Volumes: 1}},
+		},
+		{
+			lv:       newLogicVolume("raid1", "carina-vg-hdd", 10*gi, map[string]string{carina.VolumeRaidLevel: "raid1"}),
+			expected: GroupUsage{"carina-vg-hdd": {Storage: 20 * gi, Volumes: 1}},
+		},
+		{
+			lv:       newLogicVolume("raw", "carina-raw-ssd/sdb", 10*gi, map[string]string{carina.VolumeManagerType: carina.RawVolumeType, carina.ExclusivityDisk: "true"}),
+			expected: GroupUsage{"carina-raw-ssd": {Storage: 10 * gi, Volumes: 1, ExclusiveDisks: 1}},
+		},
+		{
+			lv: newLogicVolume("lvmcache", "Carina-VG-HDD", 10*gi, map[string]string{
+				carina.VolumeCacheBackend:   carina.LvmCacheBackend,
+				carina.VolumeCacheDiskType:  "carina-vg-ssd",
+				carina.VolumeCacheDiskRatio: "10",
+			}),
+			expected: GroupUsage{"carina-vg-hdd": {Storage: 10 * gi, Volumes: 1}, "carina-vg-ssd": {Storage: gi}},
+		},
+	}
+
+	for _, e := range table {
+		assert.Equal(t, e.expected, VolumeUsage(&e.lv), e.lv.Name)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	storage := resource.MustParse("20Gi")
+	volumes := int64(2)
+	q := carinav1.CarinaStorageQuota{
+		ObjectMeta: metav1.ObjectMeta{Name: "quota", Namespace: "default"},
+		Spec: carinav1.CarinaStorageQuotaSpec{Hard: map[string]carinav1.DeviceGroupQuota{
+			"carina-vg-hdd": {Storage: &storage, Volumes: &volumes},
+		}},
+	}
+	lvs := []carinav1.LogicVolume{
+		newLogicVolume("a", "carina-vg-hdd", 10*gi, nil),
+		newLogicVolume("b", "carina-vg-ssd", 10*gi, nil),
+	}
+	used := NamespaceUsage("default", lvs, nil)
+
+	assert.NoError(t, Check([]carinav1.CarinaStorageQuota{q}, used, GroupUsage{"carina-vg-hdd": {Storage: 10 * gi, Volumes: 1}}))
+	assert.NoError(t, Check([]carinav1.CarinaStorageQuota{q}, used, GroupUsage{"carina-vg-ssd": {Storage: 100 * gi, Volumes: 1}}))
+	assert.Error(t, Check([]carinav1.CarinaStorageQuota{q}, used, GroupUsage{"carina-vg-hdd": {Storage: 11 * gi, Volumes: 1}}))
+
+	// 超额后只拒绝增加用量的操作
+	used.Add("carina-vg-hdd", Usage{Storage: 20 * gi, Volumes: 1})
+	assert.NoError(t, Check([]carinav1.CarinaStorageQuota{q}, used, GroupUsage{"carina-vg-hdd": {}}))
+	err := Check([]carinav1.CarinaStorageQuota{q}, used, GroupUsage{"carina-vg-hdd": {Storage: gi}})
+	assert.EqualError(t, err, "exceeded quota quota: device group carina-vg-hdd storage requested 1Gi, used 30Gi, limited 20Gi")
+}
+
+func TestCheckRawDeviceGroup(t *testing.T) {
+	exclusiveDisks := int64(1)
+	q := carinav1.CarinaStorageQuota{
+		ObjectMeta: metav1.ObjectMeta{Name: "quota", Namespace: "default"},
+		Spec: carinav1.CarinaStorageQuotaSpec{Hard: map[string]carinav1.DeviceGroupQuota{
+			"carina-raw-ssd": {ExclusiveDisks: &exclusiveDisks},
+		}},
+	}
+	// 裸盘卷的磁盘组为<磁盘组>/<磁盘>，按磁盘组计算
+	lvs := []carinav1.LogicVolume{
+		newLogicVolume("a", "carina-raw-ssd/sdb", 10*gi, map[string]string{carina.VolumeManagerType: carina.RawVolumeType, carina.ExclusivityDisk: "true"}),
+	}
+	used := NamespaceUsage("default", lvs, nil)
+	assert.Equal(t, int64(1), Status(&q, used)["carina-raw-ssd"].ExclusiveDisks)
+
+	charge := GroupUsage{}
+	charge.Add("carina-raw-ssd/sdc", Usage{Storage: 10 * gi, Volumes: 1, ExclusiveDisks: 1})
+	assert.EqualError(t, Check([]carinav1.CarinaStorageQuota{q}, used, charge),
+		"exceeded quota quota: device group carina-raw-ssd exclusive disks requested 1, used 1, limited 1")
+}
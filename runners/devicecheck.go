@@ -18,16 +18,18 @@ package runners
 
 import (
 	"context"
-	"regexp"
 	"strings"
 	"time"
 
 	"k8s.io/apimachinery/pkg/api/equality"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 
+	"github.com/carina-io/carina"
+	"github.com/carina-io/carina/api"
 	"github.com/carina-io/carina/pkg/configuration"
 	deviceManager "github.com/carina-io/carina/pkg/devicemanager"
 	"github.com/carina-io/carina/pkg/devicemanager/types"
+	"github.com/carina-io/carina/pkg/devicemanager/volume"
 	"github.com/carina-io/carina/utils"
 	"github.com/carina-io/carina/utils/log"
 )
@@ -106,6 +108,7 @@ func (dc *deviceCheck) addAndRemoveDevice() {
 	}
 	changeBefore := actuallyVg
 	log.Debug("ActuallyVg: ", actuallyVg)
+	cordoned := dc.dm.GetCordonedDisks()
 	newDisk, err := dc.discoverDisk(diskClass)
 	if err != nil {
 		log.Error("find new device failed: " + err.Error())
@@ -140,16 +143,39 @@ func (dc *deviceCheck) addAndRemoveDevice() {
 	}
 	log.Debug("ActuallyVgMap ", actuallyVgMap)
 
+	// 缓存磁盘组的磁盘加入使用它的后端磁盘组的vg
+	selectors := []configuration.DiskSelectorItem{}
+	for _, ds := range diskClass {
+		selectors = append(selectors, ds)
+	}
+	cacheBackends := configuration.CacheBackends(selectors)
+
 	// 执行新增磁盘
-	for vg, pvs := range newDisk {
+	for group, pvs := range newDisk {
+		vg, cacheGroup := group, ""
+		if backend, ok := cacheBackends[group]; ok {
+			vg, cacheGroup = backend, group
+		}
 		log.Infof("vg:%s, pvs:%s ", vg, pvs)
 		for _, pv := range pvs {
 			//过滤已经在磁盘组的磁盘
 			if v, ok := actuallyVgMap[vg]; ok && utils.ContainsString(v, pv) {
 				continue
 			}
+			// 维护中的磁盘不再加入磁盘组
+			if utils.ContainsString(cordoned, pv) {
+				log.Infof("skip cordoned disk %s", pv)
+				continue
+			}
 			if err = dc.dm.VolumeManager.AddNewDiskToVg(pv, vg); err != nil {
 				log.Errorf("add new disk failed vg: %s, disk: %s, error: %v", vg, pv, err)
+				continue
+			}
+			dc.tagDiskID(pv, "")
+			if cacheGroup != "" {
+				if err = dc.dm.VolumeManager.GetLv().PVAddTag(pv, carina.CacheGroupTagPrefix+cacheGroup); err != nil {
+					log.Warnf("add cache group tag to pv %s failed %v", pv, err)
+				}
 			}
 		}
 	}
@@ -168,20 +194,40 @@ func (dc *deviceCheck) addAndRemoveDevice() {
 		if _, ok := diskClass[v.VGName]; !ok {
 			continue
 		}
+		// raid磁盘组的pv为md阵列，由raidCheck管理
+		if strings.ToLower(diskClass[v.VGName].Policy) == "raid" {
+			continue
+		}
 
-		diskSelector, err := regexp.Compile(strings.Join(diskClass[v.VGName].Re, "|"))
+		diskSelector, err := newDiskMatcher(diskClass[v.VGName])
 		if err != nil {
-			log.Warnf("disk regex %s error %v ", strings.Join(diskClass[v.VGName].Re, "|"), err)
+			log.Warnf("disk selector %s error %v ", v.VGName, err)
 			return
 		}
 		log.Debug("diskSelector  ", diskSelector)
+		var cacheSelector *diskMatcher
+		if cache, ok := diskClass[diskClass[v.VGName].CacheGroup]; ok {
+			if cacheSelector, err = newDiskMatcher(cache); err != nil {
+				log.Warnf("disk selector %s error %v ", cache.Name, err)
+				return
+			}
+		}
 		for _, pv := range v.PVS {
 			if strings.Contains(pv.PVName, "unknown") {
 				_ = dc.dm.VolumeManager.GetLv().RemoveUnknownDevice(pv.VGName)
 				continue
 			}
-			//同一个vg里，如果正则不匹配就将磁盘移出vg
-			if !diskSelector.MatchString(pv.PVName) {
+			// 维护中的磁盘由diskMaintenance迁移数据后移出
+			if utils.ContainsString(cordoned, pv.PVName) {
+				continue
+			}
+			// 缓存磁盘按照缓存磁盘组的匹配条件判断
+			matcher := diskSelector
+			if volume.CacheGroup(pv.PVTags) != "" && cacheSelector != nil {
+				matcher = cacheSelector
+			}
+			//同一个vg里，如果不匹配就将磁盘移出vg
+			if !dc.pvMatched(matcher, pv) {
 				log.Infof("try to remove pv %s from vg %s", pv.PVName, v.VGName)
 				if err := dc.dm.VolumeManager.RemoveDiskInVg(pv.PVName, v.VGName); err != nil {
 					log.Errorf("remove pv %s error %v", pv.PVName, err)
@@ -223,52 +269,18 @@ func (dc *deviceCheck) discoverDisk(diskClass map[string]configuration.DiskSelec
 	hasMatchedDisk := map[string]int8{}
 
 	for _, ds := range diskClass {
-		if strings.ToLower(ds.Policy) == "raw" {
-			// 目前不支持raw磁盘模式
+		if utils.ContainsString([]string{"raw", "raid"}, strings.ToLower(ds.Policy)) {
+			// raw磁盘模式不使用vg，raid磁盘模式由raidCheck管理
 			continue
 		}
-		diskSelector, err := regexp.Compile(strings.Join(ds.Re, "|"))
+		diskSelector, err := newDiskMatcher(ds)
 		if err != nil {
-			log.Warnf("disk regex %s error %v ", strings.Join(ds.Re, "|"), err)
+			log.Warnf("disk selector %s error %v ", ds.Name, err)
 			continue
 		}
 		// 过滤出空块设备
 		for _, d := range localDisk {
-			// 如果是其他磁盘Parent直接跳过
-			if d.HavePartitions {
-				continue
-			}
-
-			if strings.Contains(d.Name, "cache") {
-				continue
-			}
-
-			// 过滤不支持的磁盘类型
-			diskTypeCheck := true
-			for _, t := range []string{types.LVMType, types.CryptType, types.MultiPath, types.RomType} {
-				if strings.Contains(d.Type, t) {
-					diskTypeCheck = false
-					break
-				}
-			}
-			if !diskTypeCheck {
-				log.Infof("mismatched disk:%s, disktype:%s", d.Name, d.Type)
-				continue
-			}
-
-			if !diskSelector.MatchString(d.Name) {
-				log.Infof("mismatched disk:%s, regex:%s", d.Name, diskSelector.String())
-				continue
-			}
-
-			// 判断设备是否已经存在数据
-			dused, err := dc.dm.Partition.GetDiskUsed(d.Name)
-			if err != nil {
-				log.Warnf("get disk %s used failed %v", d.Name, err)
-				continue
-			}
-			if dused > 0 {
-				log.Warnf("block device don't empty " + d.Name)
+			if !eligibleDisk(dc.dm, diskSelector, ds.Name, d) {
 				continue
 			}
 			name = ds.Name
@@ -295,12 +307,12 @@ func (dc *deviceCheck) discoverPv(diskClass map[string]configuration.DiskSelecto
 		return nil, err
 	}
 	for _, ds := range diskClass {
-		if strings.ToLower(ds.Policy) == "raw" {
+		if utils.ContainsString([]string{"raw", "raid"}, strings.ToLower(ds.Policy)) {
 			continue
 		}
-		diskSelector, err := regexp.Compile(strings.Join(ds.Re, "|"))
+		diskSelector, err := newDiskMatcher(ds)
 		if err != nil {
-			log.Warnf("disk regex %s error %v ", strings.Join(ds.Re, "|"), err)
+			log.Warnf("disk selector %s error %v ", ds.Name, err)
 			return resp, err
 		}
 
@@ -315,10 +327,6 @@ func (dc *deviceCheck) discoverPv(diskClass map[string]configuration.DiskSelecto
 			if pv.VGName != "" {
 				continue
 			}
-			if !diskSelector.MatchString(pv.PVName) {
-				log.Infof("mismatched pv:%s, regex:%s", pv.PVName, diskSelector.String())
-				continue
-			}
 			disk, err := dc.dm.Partition.ListDevicesDetailWithoutFilter(pv.PVName)
 			if err != nil {
 				log.Errorf("get device failed %s", err.Error())
@@ -328,6 +336,10 @@ func (dc *deviceCheck) discoverPv(diskClass map[string]configuration.DiskSelecto
 				log.Error("get disk count not equal 1")
 				continue
 			}
+			if !diskSelector.match(pv.PVName, disk[0], udevInfo(dc.dm, diskSelector, pv.PVName)) {
+				log.Infof("mismatched pv:%s, selector:%s", pv.PVName, ds.Name)
+				continue
+			}
 			name = ds.Name
 			log.Infof("eligible %s pv %s", ds.Name, disk[0].Name)
 			if !utils.ContainsString(resp[name], disk[0].Name) {
@@ -338,6 +350,103 @@ func (dc *deviceCheck) discoverPv(diskClass map[string]configuration.DiskSelecto
 	return resp, nil
 }
 
+// eligibleDisk 判断磁盘是否满足磁盘组匹配条件并且为空块设备
+func eligibleDisk(dm *deviceManager.DeviceManager, m *diskMatcher, group string, d *types.LocalDisk) bool {
+	// 如果是其他磁盘Parent直接跳过
+	if d.HavePartitions {
+		return false
+	}
+
+	if strings.Contains(d.Name, "cache") {
+		return false
+	}
+
+	// 过滤不支持的磁盘类型
+	for _, t := range []string{types.LVMType, types.CryptType, types.MultiPath, types.RomType, types.RaidType} {
+		if strings.Contains(d.Type, t) {
+			log.Infof("mismatched disk:%s, disktype:%s", d.Name, d.Type)
+			return false
+		}
+	}
+
+	if !m.match(d.Name, d, udevInfo(dm, m, d.Name)) {
+		log.Infof("mismatched disk:%s, selector:%s", d.Name, group)
+		return false
+	}
+
+	// 判断设备是否已经存在数据
+	dused, err := dm.Partition.GetDiskUsed(d.Name)
+	if err != nil {
+		log.Warnf("get disk %s used failed %v", d.Name, err)
+		return false
+	}
+	if dused > 0 {
+		log.Warnf("block device don't empty " + d.Name)
+		return false
+	}
+	return true
+}
+
+// udevInfo 仅在配置了udev匹配条件时查询设备udev属性
+func udevInfo(dm *deviceManager.DeviceManager, m *diskMatcher, device string) *api.UdevInfo {
+	if !m.needUdev {
+		return nil
+	}
+	info, err := dm.Partition.GetUdevInfo(device)
+	if err != nil {
+		log.Warnf("get udev info of %s failed %v", device, err)
+		return nil
+	}
+	return info
+}
+
+// pvMatched 判断卷组中的pv是否仍满足匹配条件
+// 设备名变化(如重启或热插拔导致sdb变为sdc)时，若pv标签中记录的稳定标识与当前设备一致，则使用加入卷组时的设备名匹配
+func (dc *deviceCheck) pvMatched(m *diskMatcher, pv *api.PVInfo) bool {
+	var disk *types.LocalDisk
+	if disks, err := dc.dm.Partition.ListDevicesDetailWithoutFilter(pv.PVName); err == nil && len(disks) == 1 {
+		disk = disks[0]
+	}
+	udev, err := dc.dm.Partition.GetUdevInfo(pv.PVName)
+	if err != nil {
+		log.Warnf("get udev info of %s failed %v", pv.PVName, err)
+		udev = nil
+	}
+	id := stableDiskID(udev)
+	tagID, tagName := parseDiskIDTag(pv.PVTags)
+
+	if m.match(pv.PVName, disk, udev) {
+		// 兼容升级前加入卷组的磁盘，补充记录稳定标识
+		if tagID == "" && id != "" {
+			dc.tagDiskID(pv.PVName, id)
+		}
+		return true
+	}
+	if tagID != "" && tagID == id && tagName != pv.PVName && m.match(tagName, disk, udev) {
+		log.Infof("pv %s was renamed from %s, disk id %s, keep it in vg %s", pv.PVName, tagName, id, pv.VGName)
+		return true
+	}
+	return false
+}
+
+// tagDiskID 在pv标签中记录磁盘稳定标识以及当前设备名
+func (dc *deviceCheck) tagDiskID(device, id string) {
+	if id == "" {
+		udev, err := dc.dm.Partition.GetUdevInfo(device)
+		if err != nil {
+			log.Warnf("get udev info of %s failed %v", device, err)
+			return
+		}
+		id = stableDiskID(udev)
+	}
+	if id == "" {
+		return
+	}
+	if err := dc.dm.VolumeManager.GetLv().PVAddTag(device, diskIDTag(id, device)); err != nil {
+		log.Warnf("add disk id tag to pv %s failed %v", device, err)
+	}
+}
+
 // NeedLeaderElection implements controller-runtime's manager.LeaderElectionRunnable.
 func (dc *deviceCheck) NeedLeaderElection() bool {
 	return false
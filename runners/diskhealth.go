@@ -0,0 +1,245 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package runners
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+
+	"github.com/carina-io/carina/api"
+	deviceManager "github.com/carina-io/carina/pkg/devicemanager"
+	"github.com/carina-io/carina/pkg/devicemanager/types"
+	"github.com/carina-io/carina/utils/log"
+)
+
+const (
+	DiskConditionHealthy = "Healthy"
+	DiskConditionWarning = "Warning"
+	DiskConditionFailing = "Failing"
+)
+
+// 磁盘健康告警阈值
+const (
+	diskTemperatureThreshold    = 60
+	diskPercentageUsedThreshold = 90
+)
+
+var _ manager.LeaderElectionRunnable = &diskHealthCheck{}
+
+// diskHealthCheck 定时采集磁盘组中磁盘的SMART信息，超过阈值时产生事件
+type diskHealthCheck struct {
+	dm       *deviceManager.DeviceManager
+	recorder record.EventRecorder
+	interval time.Duration
+}
+
+func NewDiskHealthCheck(dm *deviceManager.DeviceManager, recorder record.EventRecorder) manager.Runnable {
+	return &diskHealthCheck{
+		dm:       dm,
+		recorder: recorder,
+		interval: 10 * time.Minute,
+	}
+}
+
+func (d *diskHealthCheck) Start(ctx context.Context) error {
+	log.Info("Starting disk health check...")
+	d.checkDiskHealth()
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			d.checkDiskHealth()
+		case <-ctx.Done():
+			log.Info("Stop disk health check...")
+			return nil
+		}
+	}
+}
+
+func (d *diskHealthCheck) checkDiskHealth() {
+	previous := map[string]api.DiskHealth{}
+	for _, h := range d.dm.GetDiskHealth() {
+		previous[h.Disk] = h
+	}
+
+	groupDisks := d.groupDisks()
+	disks := []string{}
+	for disk := range groupDisks {
+		disks = append(disks, disk)
+	}
+	sort.Strings(disks)
+
+	health := []api.DiskHealth{}
+	for _, disk := range disks {
+		h, err := d.dm.Smart.GetDiskHealth(disk)
+		if err != nil {
+			log.Debugf("get smart info of %s failed %v", disk, err)
+			continue
+		}
+		h.Group = groupDisks[disk]
+		evaluateDiskHealth(h)
+		health = append(health, *h)
+
+		condition := DiskConditionHealthy
+		if p, ok := previous[disk]; ok {
+			condition = p.Condition
+		}
+		if condition != h.Condition {
+			d.recordEvent(h)
+		}
+	}
+
+	if !equality.Semantic.DeepEqual(health, d.dm.GetDiskHealth()) {
+		d.dm.SetDiskHealth(health)
+		d.dm.NoticeUpdateCapacity(deviceManager.DiskHealthCheck, nil)
+	}
+}
+
+// groupDisks 磁盘组中的物理磁盘，key为磁盘路径，value为磁盘组名称
+func (d *diskHealthCheck) groupDisks() map[string]string {
+	resp := map[string]string{}
+	diskSelectGroup := d.dm.GetNodeDiskSelectGroup()
+
+	vgs, err := d.dm.VolumeManager.GetCurrentVgStruct()
+	if err != nil {
+		log.Errorf("get current vg struct failed %v", err)
+	}
+	for _, vg := range vgs {
+		ds, ok := diskSelectGroup[vg.VGName]
+		if !ok {
+			continue
+		}
+		// raid磁盘组的pv为md阵列，检查阵列成员
+		if strings.ToLower(ds.Policy) == "raid" {
+			raid, err := d.dm.Raid.GetRaid(vg.VGName)
+			if err != nil || raid == nil {
+				continue
+			}
+			for _, m := range raid.Members {
+				if m.Device != "" {
+					resp[m.Device] = vg.VGName
+				}
+			}
+			continue
+		}
+		for _, pv := range vg.PVS {
+			resp[d.physicalDisk(pv.PVName)] = vg.VGName
+		}
+	}
+
+	localDisk, err := d.dm.Partition.ListDevicesDetail("")
+	if err != nil {
+		log.Errorf("get local disk failed %v", err)
+		return resp
+	}
+	for _, ds := range diskSelectGroup {
+		if strings.ToLower(ds.Policy) != "raw" {
+			continue
+		}
+		diskSelector, err := newDiskMatcher(ds)
+		if err != nil {
+			log.Warnf("disk selector %s error %v ", ds.Name, err)
+			continue
+		}
+		for _, disk := range localDisk {
+			if disk.Type != types.DiskType {
+				continue
+			}
+			if diskSelector.match(disk.Name, disk, udevInfo(d.dm, diskSelector, disk.Name)) {
+				resp[disk.Name] = ds.Name
+			}
+		}
+	}
+	return resp
+}
+
+// physicalDisk pv为分区时返回其所在磁盘
+func (d *diskHealthCheck) physicalDisk(device string) string {
+	disks, err := d.dm.Partition.ListDevicesDetailWithoutFilter(device)
+	if err != nil || len(disks) != 1 {
+		return device
+	}
+	if disks[0].Type == types.PartType && disks[0].ParentName != "" {
+		return disks[0].ParentName
+	}
+	return device
+}
+
+func (d *diskHealthCheck) recordEvent(h *api.DiskHealth) {
+	node := &corev1.Node{}
+	if err := d.dm.Cache.Get(context.Background(), client.ObjectKey{Name: d.dm.NodeName}, node); err != nil {
+		log.Errorf("get node %s error %s", d.dm.NodeName, err.Error())
+		return
+	}
+	switch h.Condition {
+	case DiskConditionFailing:
+		d.recorder.Eventf(node, corev1.EventTypeWarning, "DiskFailing", "disk %s of group %s is failing: %s", h.Disk, h.Group, h.Message)
+	case DiskConditionWarning:
+		d.recorder.Eventf(node, corev1.EventTypeWarning, "DiskHealthWarning", "disk %s of group %s: %s", h.Disk, h.Group, h.Message)
+	default:
+		d.recorder.Eventf(node, corev1.EventTypeNormal, "DiskHealthRecovered", "disk %s of group %s is healthy", h.Disk, h.Group)
+	}
+}
+
+// evaluateDiskHealth 根据阈值判断磁盘状态
+func evaluateDiskHealth(h *api.DiskHealth) {
+	failing := []string{}
+	warning := []string{}
+	if !h.Passed {
+		failing = append(failing, "SMART overall-health self-assessment failed")
+	}
+	if h.ReallocatedSectors > 0 {
+		warning = append(warning, fmt.Sprintf("%d reallocated sectors", h.ReallocatedSectors))
+	}
+	if h.PendingSectors > 0 {
+		warning = append(warning, fmt.Sprintf("%d pending sectors", h.PendingSectors))
+	}
+	if h.MediaErrors > 0 {
+		warning = append(warning, fmt.Sprintf("%d media errors", h.MediaErrors))
+	}
+	if h.PercentageUsed >= diskPercentageUsedThreshold {
+		warning = append(warning, fmt.Sprintf("%d%% endurance used", h.PercentageUsed))
+	}
+	if h.Temperature >= diskTemperatureThreshold {
+		warning = append(warning, fmt.Sprintf("temperature %d Celsius", h.Temperature))
+	}
+
+	switch {
+	case len(failing) > 0:
+		h.Condition = DiskConditionFailing
+	case len(warning) > 0:
+		h.Condition = DiskConditionWarning
+	default:
+		h.Condition = DiskConditionHealthy
+	}
+	h.Message = strings.Join(append(failing, warning...), ", ")
+}
+
+// NeedLeaderElection implements controller-runtime's manager.LeaderElectionRunnable.
+func (d *diskHealthCheck) NeedLeaderElection() bool {
+	return false
+}
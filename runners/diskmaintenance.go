@@ -0,0 +1,159 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package runners
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+
+	"github.com/carina-io/carina/api"
+	deviceManager "github.com/carina-io/carina/pkg/devicemanager"
+	"github.com/carina-io/carina/utils"
+	"github.com/carina-io/carina/utils/log"
+)
+
+const (
+	DiskPhaseCordoned = "Cordoned"
+	DiskPhaseDraining = "Draining"
+	DiskPhaseDrained  = "Drained"
+	DiskPhaseFailed   = "Failed"
+)
+
+var _ manager.LeaderElectionRunnable = &diskMaintenance{}
+
+// diskMaintenance 处理NodeStorageResource注解中标记维护的磁盘，迁移磁盘上的数据后将其移出vg
+type diskMaintenance struct {
+	dm       *deviceManager.DeviceManager
+	interval time.Duration
+}
+
+func NewDiskMaintenance(dm *deviceManager.DeviceManager) manager.Runnable {
+	return &diskMaintenance{
+		dm:       dm,
+		interval: 10 * time.Second,
+	}
+}
+
+func (d *diskMaintenance) Start(ctx context.Context) error {
+	log.Info("Starting disk maintenance...")
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			d.drainCordonedDisks()
+		case <-ctx.Done():
+			log.Info("Stop disk maintenance...")
+			return nil
+		}
+	}
+}
+
+func (d *diskMaintenance) drainCordonedDisks() {
+	cordoned := d.dm.GetCordonedDisks()
+	changed := false
+
+	previous := map[string]api.DiskMaintenance{}
+	for _, m := range d.dm.GetDiskMaintenances() {
+		previous[m.Disk] = m
+		if utils.ContainsString(cordoned, m.Disk) {
+			continue
+		}
+		// 取消维护，磁盘若仍在vg中则恢复分配
+		log.Infof("uncordon disk %s", m.Disk)
+		if err := d.dm.VolumeManager.CordonDisk(m.Disk, false); err != nil {
+			log.Warnf("uncordon disk %s failed %s", m.Disk, err.Error())
+			continue
+		}
+		d.dm.RemoveDiskMaintenance(m.Disk)
+		changed = true
+	}
+
+	if len(cordoned) > 0 {
+		pvVg := map[string]string{}
+		vgs, err := d.dm.VolumeManager.GetCurrentVgStruct()
+		if err != nil {
+			log.Errorf("get current vg struct failed %s", err.Error())
+			return
+		}
+		diskSelectGroup := d.dm.GetNodeDiskSelectGroup()
+		for _, vg := range vgs {
+			if _, ok := diskSelectGroup[vg.VGName]; !ok {
+				continue
+			}
+			for _, pv := range vg.PVS {
+				pvVg[pv.PVName] = vg.VGName
+			}
+		}
+
+		for _, disk := range cordoned {
+			m := d.drainDisk(disk, pvVg[disk], previous[disk])
+			if m != previous[disk] {
+				d.dm.SetDiskMaintenance(m)
+				changed = true
+			}
+		}
+	}
+
+	if changed {
+		d.dm.NoticeUpdateCapacity(deviceManager.DiskMaintenance, nil)
+	}
+}
+
+func (d *diskMaintenance) drainDisk(disk, vgName string, previous api.DiskMaintenance) api.DiskMaintenance {
+	m := api.DiskMaintenance{Disk: disk, VGName: vgName, Phase: DiskPhaseCordoned}
+	if vgName == "" {
+		// 已经完成迁移并移出vg，或者是raw磁盘
+		if previous.Phase == DiskPhaseDraining || previous.Phase == DiskPhaseDrained {
+			m.VGName = previous.VGName
+			m.Phase = DiskPhaseDrained
+			m.Progress = "100.00"
+		}
+		return m
+	}
+
+	if err := d.dm.VolumeManager.CordonDisk(disk, true); err != nil {
+		m.Phase = DiskPhaseFailed
+		m.Message = err.Error()
+		return m
+	}
+
+	percent, done, err := d.dm.VolumeManager.DrainDiskInVg(disk, vgName)
+	if err != nil {
+		log.Warnf("drain disk %s in vg %s failed %s", disk, vgName, err.Error())
+		m.Phase = DiskPhaseFailed
+		m.Message = err.Error()
+		return m
+	}
+	m.Progress = fmt.Sprintf("%.2f", percent)
+	if done {
+		log.Infof("disk %s has been drained and removed from vg %s", disk, vgName)
+		m.Phase = DiskPhaseDrained
+		return m
+	}
+	log.Infof("draining disk %s in vg %s, progress %s%%", disk, vgName, m.Progress)
+	m.Phase = DiskPhaseDraining
+	return m
+}
+
+// NeedLeaderElection implements controller-runtime's manager.LeaderElectionRunnable.
+func (d *diskMaintenance) NeedLeaderElection() bool {
+	return false
+}
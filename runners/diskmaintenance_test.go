@@ -0,0 +1,117 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package runners
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/carina-io/carina/api"
+	deviceManager "github.com/carina-io/carina/pkg/devicemanager"
+	"github.com/carina-io/carina/pkg/devicemanager/volume"
+)
+
+// fakeVolume 只实现磁盘维护用到的操作
+type fakeVolume struct {
+	volume.LocalVolume
+	cordonErr error
+	percent   float64
+	done      bool
+	drainErr  error
+	cordoned  []string
+}
+
+func (f *fakeVolume) CordonDisk(disk string, cordon bool) error {
+	if f.cordonErr != nil {
+		return f.cordonErr
+	}
+	if cordon {
+		f.cordoned = append(f.cordoned, disk)
+	}
+	return nil
+}
+
+func (f *fakeVolume) DrainDiskInVg(disk, vgName string) (float64, bool, error) {
+	return f.percent, f.done, f.drainErr
+}
+
+func TestDrainDisk(t *testing.T) {
+	cases := []struct {
+		name     string
+		vgName   string
+		previous api.DiskMaintenance
+		fake     *fakeVolume
+		want     api.DiskMaintenance
+	}{
+		// raw磁盘或不属于任何vg的磁盘只标记维护
+		{name: "not in vg", fake: &fakeVolume{}, want: api.DiskMaintenance{Disk: "/dev/sdb", Phase: DiskPhaseCordoned}},
+		{name: "start", vgName: "carina-vg-hdd", fake: &fakeVolume{}, want: api.DiskMaintenance{Disk: "/dev/sdb", VGName: "carina-vg-hdd", Phase: DiskPhaseDraining, Progress: "0.00"}},
+		{
+			name:     "draining",
+			vgName:   "carina-vg-hdd",
+			previous: api.DiskMaintenance{Disk: "/dev/sdb", VGName: "carina-vg-hdd", Phase: DiskPhaseDraining, Progress: "0.00"},
+			fake:     &fakeVolume{percent: 45},
+			want:     api.DiskMaintenance{Disk: "/dev/sdb", VGName: "carina-vg-hdd", Phase: DiskPhaseDraining, Progress: "45.00"},
+		},
+		{
+			name:     "drained",
+			vgName:   "carina-vg-hdd",
+			previous: api.DiskMaintenance{Disk: "/dev/sdb", VGName: "carina-vg-hdd", Phase: DiskPhaseDraining, Progress: "45.00"},
+			fake:     &fakeVolume{percent: 100, done: true},
+			want:     api.DiskMaintenance{Disk: "/dev/sdb", VGName: "carina-vg-hdd", Phase: DiskPhaseDrained, Progress: "100.00"},
+		},
+		// 移出vg后保留迁移前的vg
+		{
+			name:     "reduced",
+			previous: api.DiskMaintenance{Disk: "/dev/sdb", VGName: "carina-vg-hdd", Phase: DiskPhaseDrained, Progress: "100.00"},
+			fake:     &fakeVolume{},
+			want:     api.DiskMaintenance{Disk: "/dev/sdb", VGName: "carina-vg-hdd", Phase: DiskPhaseDrained, Progress: "100.00"},
+		},
+		{
+			name:   "cordon failed",
+			vgName: "carina-vg-hdd",
+			fake:   &fakeVolume{cordonErr: errors.New("pvchange failed")},
+			want:   api.DiskMaintenance{Disk: "/dev/sdb", VGName: "carina-vg-hdd", Phase: DiskPhaseFailed, Message: "pvchange failed"},
+		},
+		// 失败后重试，迁移成功则恢复为Draining
+		{
+			name:     "drain failed",
+			vgName:   "carina-vg-hdd",
+			previous: api.DiskMaintenance{Disk: "/dev/sdb", VGName: "carina-vg-hdd", Phase: DiskPhaseDraining, Progress: "0.00"},
+			fake:     &fakeVolume{drainErr: errors.New("don't have enough space")},
+			want:     api.DiskMaintenance{Disk: "/dev/sdb", VGName: "carina-vg-hdd", Phase: DiskPhaseFailed, Message: "don't have enough space"},
+		},
+		{
+			name:     "retry",
+			vgName:   "carina-vg-hdd",
+			previous: api.DiskMaintenance{Disk: "/dev/sdb", VGName: "carina-vg-hdd", Phase: DiskPhaseFailed, Message: "don't have enough space"},
+			fake:     &fakeVolume{percent: 10},
+			want:     api.DiskMaintenance{Disk: "/dev/sdb", VGName: "carina-vg-hdd", Phase: DiskPhaseDraining, Progress: "10.00"},
+		},
+	}
+	for _, c := range cases {
+		d := &diskMaintenance{dm: &deviceManager.DeviceManager{VolumeManager: c.fake}}
+
+		assert.Equal(t, c.want, d.drainDisk("/dev/sdb", c.vgName, c.previous), c.name)
+		// 迁移前先禁止分配
+		if c.vgName != "" && c.fake.cordonErr == nil {
+			assert.Equal(t, []string{"/dev/sdb"}, c.fake.cordoned, c.name)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package runners
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/carina-io/carina/api"
+	"github.com/carina-io/carina/pkg/configuration"
+	"github.com/carina-io/carina/pkg/devicemanager/types"
+)
+
+// diskIDTagPrefix pv标签前缀，标签格式为 carina.storage.io/disk-id:<稳定标识>:<加入时的设备名>
+const diskIDTagPrefix = "carina.storage.io/disk-id:"
+
+var tagInvalidChar = regexp.MustCompile(`[^A-Za-z0-9_+.\-]`)
+
+// diskMatcher 根据diskSelector配置匹配磁盘，同一字段内的正则任一匹配即可，不同字段需全部满足
+type diskMatcher struct {
+	re         *regexp.Regexp
+	serial     *regexp.Regexp
+	wwn        *regexp.Regexp
+	model      *regexp.Regexp
+	idPath     *regexp.Regexp
+	byId       *regexp.Regexp
+	rotational string
+	minSize    uint64
+	maxSize    uint64
+	needUdev   bool
+}
+
+func newDiskMatcher(ds configuration.DiskSelectorItem) (*diskMatcher, error) {
+	m := &diskMatcher{
+		rotational: strings.ToLower(ds.Rotational),
+		needUdev:   ds.HasUdevSelector(),
+	}
+	m.minSize, m.maxSize = ds.SizeRange()
+
+	var err error
+	// re为空时保持原有行为，匹配所有磁盘
+	if m.re, err = regexp.Compile(strings.Join(ds.Re, "|")); err != nil {
+		return nil, err
+	}
+	for _, f := range []struct {
+		re  **regexp.Regexp
+		exp []string
+	}{
+		{&m.serial, ds.Serial},
+		{&m.wwn, ds.Wwn},
+		{&m.model, ds.Model},
+		{&m.idPath, ds.IdPath},
+		{&m.byId, ds.ById},
+	} {
+		if len(f.exp) == 0 {
+			continue
+		}
+		if *f.re, err = regexp.Compile(strings.Join(f.exp, "|")); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+func (m *diskMatcher) String() string {
+	return m.re.String()
+}
+
+// match 判断磁盘是否满足条件，未配置udev条件时udev可以为nil
+func (m *diskMatcher) match(name string, disk *types.LocalDisk, udev *api.UdevInfo) bool {
+	if !m.re.MatchString(name) {
+		return false
+	}
+	if disk != nil {
+		if m.rotational == "true" && disk.Rotational != "1" {
+			return false
+		}
+		if m.rotational == "false" && disk.Rotational != "0" {
+			return false
+		}
+		if m.minSize > 0 && disk.Size < m.minSize {
+			return false
+		}
+		if m.maxSize > 0 && disk.Size > m.maxSize {
+			return false
+		}
+	}
+	if !m.needUdev {
+		return true
+	}
+	if udev == nil {
+		return false
+	}
+	for _, f := range []struct {
+		re  *regexp.Regexp
+		key string
+	}{
+		{m.serial, "ID_SERIAL"},
+		{m.wwn, "ID_WWN"},
+		{m.model, "ID_MODEL"},
+		{m.idPath, "ID_PATH"},
+	} {
+		if f.re != nil && !f.re.MatchString(udev.Properties[f.key]) {
+			return false
+		}
+	}
+	if m.byId != nil {
+		matched := false
+		for _, link := range udev.Symlinks {
+			if strings.HasPrefix(link, "/dev/disk/by-id/") && m.byId.MatchString(filepath.Base(link)) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// stableDiskID 返回不随设备名变化的磁盘标识，优先使用WWN，其次是序列号
+func stableDiskID(udev *api.UdevInfo) string {
+	if udev == nil {
+		return ""
+	}
+	if wwn := udev.Properties["ID_WWN"]; wwn != "" {
+		return "wwn-" + tagInvalidChar.ReplaceAllString(wwn, "_")
+	}
+	if serial := udev.Properties["ID_SERIAL"]; serial != "" {
+		return "serial-" + tagInvalidChar.ReplaceAllString(serial, "_")
+	}
+	return ""
+}
+
+func diskIDTag(id, name string) string {
+	return diskIDTagPrefix + id + ":" + filepath.Base(name)
+}
+
+// parseDiskIDTag 从pv标签中解析磁盘稳定标识以及加入卷组时的设备名
+func parseDiskIDTag(tags string) (string, string) {
+	for _, tag := range strings.Split(tags, ",") {
+		if !strings.HasPrefix(tag, diskIDTagPrefix) {
+			continue
+		}
+		fields := strings.Split(strings.TrimPrefix(tag, diskIDTagPrefix), ":")
+		if len(fields) != 2 {
+			continue
+		}
+		return fields[0], "/dev/" + fields[1]
+	}
+	return "", ""
+}
@@ -0,0 +1,120 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package runners
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/carina-io/carina/api"
+	"github.com/carina-io/carina/pkg/configuration"
+	"github.com/carina-io/carina/pkg/devicemanager/types"
+)
+
+func TestDiskMatcher(t *testing.T) {
+	ssd := &types.LocalDisk{Rotational: "0", Size: 200 << 30}
+	hdd := &types.LocalDisk{Rotational: "1", Size: 4 << 40}
+	udev := &api.UdevInfo{
+		Properties: map[string]string{
+			"ID_SERIAL": "Samsung_SSD_870_S5Y1NX0R",
+			"ID_WWN":    "0x5002538e40a1b2c3",
+			"ID_MODEL":  "Samsung_SSD_870",
+			"ID_PATH":   "pci-0000:00:17.0-ata-2",
+		},
+		Symlinks: []string{"/dev/disk/by-path/pci-0000:00:17.0-ata-2", "/dev/disk/by-id/ata-Samsung_SSD_870_S5Y1NX0R"},
+	}
+	cases := []struct {
+		name string
+		ds   configuration.DiskSelectorItem
+		disk string
+		info *types.LocalDisk
+		udev *api.UdevInfo
+		want bool
+	}{
+		{name: "empty re matches all", disk: "/dev/sdb", info: hdd, want: true},
+		{name: "re", ds: configuration.DiskSelectorItem{Re: []string{"loop[0-9]", "sd[b-c]"}}, disk: "/dev/sdc", info: hdd, want: true},
+		{name: "re not match", ds: configuration.DiskSelectorItem{Re: []string{"loop[0-9]", "sd[b-c]"}}, disk: "/dev/sdd", info: hdd, want: false},
+		{name: "rotational true", ds: configuration.DiskSelectorItem{Rotational: "True"}, disk: "/dev/sdb", info: hdd, want: true},
+		{name: "rotational true rejects ssd", ds: configuration.DiskSelectorItem{Rotational: "true"}, disk: "/dev/sdb", info: ssd, want: false},
+		{name: "rotational false rejects hdd", ds: configuration.DiskSelectorItem{Rotational: "false"}, disk: "/dev/sdb", info: hdd, want: false},
+		{name: "size in range", ds: configuration.DiskSelectorItem{MinSize: "100Gi", MaxSize: "1Ti"}, disk: "/dev/sdb", info: ssd, want: true},
+		{name: "size below min", ds: configuration.DiskSelectorItem{MinSize: "1Ti"}, disk: "/dev/sdb", info: ssd, want: false},
+		{name: "size above max", ds: configuration.DiskSelectorItem{MaxSize: "1Ti"}, disk: "/dev/sdb", info: hdd, want: false},
+		{name: "invalid size is ignored", ds: configuration.DiskSelectorItem{MinSize: "large"}, disk: "/dev/sdb", info: ssd, want: true},
+		{name: "no disk info skips disk checks", ds: configuration.DiskSelectorItem{Rotational: "true", MinSize: "1Ti"}, disk: "/dev/sdb", want: true},
+		{name: "serial and model", ds: configuration.DiskSelectorItem{Serial: []string{"^Samsung"}, Model: []string{"870$"}}, disk: "/dev/sdb", info: ssd, udev: udev, want: true},
+		{name: "model not match", ds: configuration.DiskSelectorItem{Serial: []string{"^Samsung"}, Model: []string{"^INTEL"}}, disk: "/dev/sdb", info: ssd, udev: udev, want: false},
+		{name: "wwn", ds: configuration.DiskSelectorItem{Wwn: []string{"0x5002538e40a1b2c3"}}, disk: "/dev/sdb", udev: udev, want: true},
+		{name: "id path not match", ds: configuration.DiskSelectorItem{IdPath: []string{"ata-3$"}}, disk: "/dev/sdb", udev: udev, want: false},
+		{name: "by-id", ds: configuration.DiskSelectorItem{ById: []string{"^ata-Samsung"}}, disk: "/dev/sdb", udev: udev, want: true},
+		{name: "by-id ignores other links", ds: configuration.DiskSelectorItem{ById: []string{"^pci-0000"}}, disk: "/dev/sdb", udev: udev, want: false},
+		{name: "udev selector without udev info", ds: configuration.DiskSelectorItem{Serial: []string{"^Samsung"}}, disk: "/dev/sdb", info: ssd, want: false},
+		{name: "udev matches but re not", ds: configuration.DiskSelectorItem{Re: []string{"nvme"}, Serial: []string{"^Samsung"}}, disk: "/dev/sdb", udev: udev, want: false},
+		{name: "missing udev property", ds: configuration.DiskSelectorItem{Wwn: []string{"^0x"}}, disk: "/dev/sdb", udev: &api.UdevInfo{}, want: false},
+	}
+	for _, c := range cases {
+		m, err := newDiskMatcher(c.ds)
+		assert.NoError(t, err, c.name)
+		assert.Equal(t, c.want, m.match(c.disk, c.info, c.udev), c.name)
+	}
+
+	for _, ds := range []configuration.DiskSelectorItem{
+		{Re: []string{"sd[b"}},
+		{Serial: []string{"(Samsung"}},
+		{ById: []string{"*"}},
+	} {
+		_, err := newDiskMatcher(ds)
+		assert.Error(t, err)
+	}
+}
+
+func TestStableDiskID(t *testing.T) {
+	cases := []struct {
+		udev *api.UdevInfo
+		want string
+	}{
+		{udev: nil, want: ""},
+		{udev: &api.UdevInfo{}, want: ""},
+		{udev: &api.UdevInfo{Properties: map[string]string{"ID_WWN": "0x5002538e40a1b2c3", "ID_SERIAL": "S5Y1NX0R"}}, want: "wwn-0x5002538e40a1b2c3"},
+		{udev: &api.UdevInfo{Properties: map[string]string{"ID_SERIAL": "QEMU HARDDISK:drive/0"}}, want: "serial-QEMU_HARDDISK_drive_0"},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.want, stableDiskID(c.udev))
+	}
+}
+
+func TestParseDiskIDTag(t *testing.T) {
+	cases := []struct {
+		tags     string
+		wantID   string
+		wantName string
+	}{
+		{tags: diskIDTag("wwn-0x5002538e40a1b2c3", "/dev/sdb"), wantID: "wwn-0x5002538e40a1b2c3", wantName: "/dev/sdb"},
+		{tags: "carina.storage.io/cache-group:carina-vg-ssd," + diskIDTag("serial-S5Y1NX0R", "/dev/nvme0n1"), wantID: "serial-S5Y1NX0R", wantName: "/dev/nvme0n1"},
+		{tags: "", wantID: "", wantName: ""},
+		{tags: "carina.storage.io/cache-group:carina-vg-ssd", wantID: "", wantName: ""},
+		// 格式不正确的标签被忽略
+		{tags: diskIDTagPrefix + "wwn-0x5002538e40a1b2c3", wantID: "", wantName: ""},
+		{tags: diskIDTagPrefix + "wwn-1:sdb:extra," + diskIDTag("wwn-2", "sdc"), wantID: "wwn-2", wantName: "/dev/sdc"},
+	}
+	for _, c := range cases {
+		id, name := parseDiskIDTag(c.tags)
+		assert.Equal(t, c.wantID, id, c.tags)
+		assert.Equal(t, c.wantName, name, c.tags)
+	}
+}
@@ -33,6 +33,7 @@ import (
 	"github.com/carina-io/carina/api"
 	carinav1beta1 "github.com/carina-io/carina/api/v1beta1"
 	deviceManager "github.com/carina-io/carina/pkg/devicemanager"
+	"github.com/carina-io/carina/pkg/devicemanager/volume"
 	"github.com/carina-io/carina/utils"
 	"github.com/carina-io/carina/utils/log"
 	apierrs "k8s.io/apimachinery/pkg/api/errors"
@@ -182,9 +183,18 @@ func (r *nodeStorageResourceReconciler) generateStatus() carinav1beta1.NodeStora
 	}
 
 	r.generateLvmStatus(&status)
+	r.generateRaidVolumeStatus(&status)
 	r.generateDiskStatus(&status)
 	r.generateRaidStatus(&status)
 
+	if maintenances := r.dm.GetDiskMaintenances(); len(maintenances) > 0 {
+		status.DiskMaintenances = maintenances
+	}
+
+	if health := r.dm.GetDiskHealth(); len(health) > 0 {
+		status.DiskHealth = health
+	}
+
 	return status
 }
 
@@ -202,18 +212,60 @@ func (r *nodeStorageResourceReconciler) generateLvmStatus(status *carinav1beta1.
 		status.VgGroups = append(status.VgGroups, vg)
 	}
 
+	cordoned := r.dm.GetCordonedDisks()
 	for _, v := range status.VgGroups {
-		sizeGb := v.VGSize>>30 + 1
-		freeGb := uint64(0)
-		if v.VGFree > carina.DefaultReservedSpace {
-			freeGb = (v.VGFree-carina.DefaultReservedSpace)>>30 + 1
+		// 维护中的磁盘不再计入容量
+		// 缓存磁盘组的磁盘只用于lvmcache，单独计算缓存磁盘组容量
+		cacheSize, cacheFree := map[string]uint64{}, map[string]uint64{}
+		for _, pv := range v.PVS {
+			if utils.ContainsString(cordoned, pv.PVName) {
+				v.VGSize -= pv.PVSize
+				v.VGFree -= pv.PVFree
+				continue
+			}
+			if group := volume.CacheGroup(pv.PVTags); group != "" {
+				v.VGSize -= pv.PVSize
+				v.VGFree -= pv.PVFree
+				cacheSize[group] += pv.PVSize
+				cacheFree[group] += pv.PVFree
+			}
+		}
+		for group, size := range cacheSize {
+			status.Capacity[fmt.Sprintf("%s%s", carina.DeviceCapacityKeyPrefix, group)] = *resource.NewQuantity(int64(size), resource.BinarySI)
+			status.Allocatable[fmt.Sprintf("%s%s", carina.DeviceCapacityKeyPrefix, group)] = *resource.NewQuantity(int64(cacheFree[group]), resource.BinarySI)
+		}
+		// 按磁盘组配置的reservedSpace以及maxAllocatableRatio保留空间
+		reserved := diskSelectGroup[v.VGName].ReservedBytes(v.VGSize)
+		free := uint64(0)
+		if v.VGFree > reserved {
+			free = v.VGFree - reserved
 		}
-		status.Capacity[fmt.Sprintf("%s%s", carina.DeviceCapacityKeyPrefix, v.VGName)] = *resource.NewQuantity(int64(sizeGb), resource.BinarySI)
-		status.Allocatable[fmt.Sprintf("%s%s", carina.DeviceCapacityKeyPrefix, v.VGName)] = *resource.NewQuantity(int64(freeGb), resource.BinarySI)
+		status.Capacity[fmt.Sprintf("%s%s", carina.DeviceCapacityKeyPrefix, v.VGName)] = *resource.NewQuantity(int64(v.VGSize), resource.BinarySI)
+		status.Allocatable[fmt.Sprintf("%s%s", carina.DeviceCapacityKeyPrefix, v.VGName)] = *resource.NewQuantity(int64(free), resource.BinarySI)
+
+		// thin pool可扩容至vg剩余空间，按超分比例计算thin卷容量
+		poolSize, virtualSize, err := r.dm.VolumeManager.ThinPoolUsage(v.VGName)
+		if err != nil {
+			log.Errorf("Get thin pool usage of %s error %s", v.VGName, err.Error())
+			continue
+		}
+		overcommit := diskSelectGroup[v.VGName].ThinOvercommit()
+		thinCapacity := float64(v.VGSize) * overcommit
+		status.Capacity[fmt.Sprintf("%s%s", carina.ThinCapacityKeyPrefix, v.VGName)] = *resource.NewQuantity(int64(thinCapacity), resource.BinarySI)
+		status.Allocatable[fmt.Sprintf("%s%s", carina.ThinCapacityKeyPrefix, v.VGName)] = *resource.NewQuantity(thinAllocatable(poolSize, virtualSize, free, overcommit), resource.BinarySI)
 	}
 
 }
 
+// thinAllocatable thin卷可分配容量，pool容量加上vg可分配空间按超分比例计算，再减去已分配的thin卷虚拟容量
+func thinAllocatable(poolSize, virtualSize, vgAllocatable uint64, overcommit float64) int64 {
+	allocatable := float64(poolSize+vgAllocatable)*overcommit - float64(virtualSize)
+	if allocatable < 0 {
+		return 0
+	}
+	return int64(allocatable)
+}
+
 func (r *nodeStorageResourceReconciler) generateDiskStatus(status *carinav1beta1.NodeStorageResourceStatus) {
 	diskSelectGroup := r.dm.GetNodeDiskSelectGroup()
 	localDisk, err := r.dm.Partition.ListDevicesDetail("")
@@ -224,7 +276,7 @@ func (r *nodeStorageResourceReconciler) generateDiskStatus(status *carinav1beta1
 	blockClass := map[string][]string{}
 	hasMatchedDisk := map[string]int8{}
 	for _, ds := range diskSelectGroup {
-		if strings.ToLower(ds.Policy) == "lvm" {
+		if strings.ToLower(ds.Policy) != "raw" {
 			continue
 		}
 		diskSelector, err := regexp.Compile(strings.Join(ds.Re, "|"))
@@ -277,10 +329,16 @@ func (r *nodeStorageResourceReconciler) generateDiskStatus(status *carinav1beta1
 		}
 	}
 
+	cordoned := r.dm.GetCordonedDisks()
 	for group, diskos := range groupDiskos {
 		for _, disk := range diskos {
 			var avail uint64
 
+			if utils.ContainsString(cordoned, disk.Path) {
+				log.Info("Disk:", disk.Path, " is cordoned")
+				continue
+			}
+
 			fs := disk.FreeSpaces()
 			if len(fs) < 1 {
 				log.Info("Disk:", disk.Path, " size:", disk.Size, " avail:", avail, " free:", fs)
@@ -294,14 +352,52 @@ func (r *nodeStorageResourceReconciler) generateDiskStatus(status *carinav1beta1
 			//剩余容量选择可用分区剩余空间最大容量
 			avail = fs[0].Size()
 			log.Info("Disk:", disk.Path, " size:", disk.Size, " avail:", avail, " free:", fs)
-			status.Capacity[fmt.Sprintf("%s%s/%s", carina.DeviceCapacityKeyPrefix, group, disk.Name)] = *resource.NewQuantity(int64(disk.Size>>30), resource.BinarySI)
-			status.Allocatable[fmt.Sprintf("%s%s/%s", carina.DeviceCapacityKeyPrefix, group, disk.Name)] = *resource.NewQuantity(int64(avail>>30), resource.BinarySI)
+			status.Capacity[fmt.Sprintf("%s%s/%s", carina.DeviceCapacityKeyPrefix, group, disk.Name)] = *resource.NewQuantity(int64(disk.Size), resource.BinarySI)
+			status.Allocatable[fmt.Sprintf("%s%s/%s", carina.DeviceCapacityKeyPrefix, group, disk.Name)] = *resource.NewQuantity(int64(avail), resource.BinarySI)
 		}
 	}
 }
 
 func (r *nodeStorageResourceReconciler) generateRaidStatus(status *carinav1beta1.NodeStorageResourceStatus) {
-	//TODO
+	diskSelectGroup := r.dm.GetNodeDiskSelectGroup()
+	raids, err := r.dm.Raid.ListRaids()
+	if err != nil {
+		log.Errorf("List raid error %s", err.Error())
+		return
+	}
+	for _, raid := range raids {
+		if ds, ok := diskSelectGroup[raid.Name]; !ok || strings.ToLower(ds.Policy) != "raid" {
+			continue
+		}
+		status.RAIDs = append(status.RAIDs, raid)
+	}
+}
+
+// generateRaidVolumeStatus lvm raid卷的同步状态，pv丢失时为Degraded
+func (r *nodeStorageResourceReconciler) generateRaidVolumeStatus(status *carinav1beta1.NodeStorageResourceStatus) {
+	for _, vg := range status.VgGroups {
+		raidStatus, err := r.dm.VolumeManager.RaidVolumeStatus(vg.VGName)
+		if err != nil {
+			log.Errorf("Get raid volume status of %s error %s", vg.VGName, err.Error())
+			continue
+		}
+		for _, rs := range raidStatus {
+			state := "Synced"
+			if rs.HealthStatus != "" {
+				state = "Degraded"
+			} else if rs.SyncPercent != "" && rs.SyncPercent != "100.00" {
+				state = "Syncing"
+			}
+			status.RaidVolumes = append(status.RaidVolumes, api.RaidVolume{
+				Name:        rs.LVName,
+				VGName:      rs.VGName,
+				Level:       rs.SegType,
+				State:       state,
+				SyncPercent: rs.SyncPercent,
+				Health:      rs.HealthStatus,
+			})
+		}
+	}
 }
 
 // NeedLeaderElection implements controller-runtime's manager.LeaderElectionRunnable.
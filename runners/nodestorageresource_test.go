@@ -0,0 +1,45 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package runners
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestThinAllocatable(t *testing.T) {
+	cases := []struct {
+		name          string
+		poolSize      uint64
+		virtualSize   uint64
+		vgAllocatable uint64
+		overcommit    float64
+		want          int64
+	}{
+		{name: "no pool", vgAllocatable: 90 << 30, overcommit: 1, want: 90 << 30},
+		// pool可扩容至vg剩余空间
+		{name: "pool and vg", poolSize: 10 << 30, virtualSize: 8 << 30, vgAllocatable: 90 << 30, overcommit: 1, want: 92 << 30},
+		{name: "overcommit", poolSize: 10 << 30, virtualSize: 100 << 30, vgAllocatable: 90 << 30, overcommit: 2, want: 100 << 30},
+		// vg空间不足保留空间时只按pool容量计算
+		{name: "vg reserved", poolSize: 10 << 30, virtualSize: 5 << 30, overcommit: 1.5, want: 10 << 30},
+		{name: "over allocated", poolSize: 10 << 30, virtualSize: 30 << 30, vgAllocatable: 10 << 30, overcommit: 1, want: 0},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.want, thinAllocatable(c.poolSize, c.virtualSize, c.vgAllocatable, c.overcommit), c.name)
+	}
+}
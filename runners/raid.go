@@ -0,0 +1,220 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package runners
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+
+	"github.com/carina-io/carina/api"
+	"github.com/carina-io/carina/pkg/configuration"
+	deviceManager "github.com/carina-io/carina/pkg/devicemanager"
+	"github.com/carina-io/carina/pkg/devicemanager/types"
+	"github.com/carina-io/carina/utils"
+	"github.com/carina-io/carina/utils/log"
+)
+
+var _ manager.LeaderElectionRunnable = &raidCheck{}
+
+// raidCheck 管理raid磁盘组，使用匹配的空磁盘创建md阵列并作为pv加入同名vg，阵列降级时自动加入匹配的空磁盘重建
+type raidCheck struct {
+	dm       *deviceManager.DeviceManager
+	interval time.Duration
+	// 上次巡检时的阵列状态，变化时更新NodeStorageResource
+	last []api.Raid
+}
+
+func NewRaidCheck(dm *deviceManager.DeviceManager) manager.Runnable {
+	return &raidCheck{
+		dm:       dm,
+		interval: 30 * time.Second,
+	}
+}
+
+func (r *raidCheck) Start(ctx context.Context) error {
+	log.Info("Starting raid check...")
+	// 节点重启后阵列可能未被自动组装
+	if err := r.dm.Raid.Assemble(); err != nil {
+		log.Debugf("assemble raid %v", err)
+	}
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			r.checkRaids()
+		case <-ctx.Done():
+			log.Info("Stop raid check...")
+			return nil
+		}
+	}
+}
+
+func (r *raidCheck) checkRaids() {
+	groups := []configuration.DiskSelectorItem{}
+	for _, ds := range r.dm.GetNodeDiskSelectGroup() {
+		if strings.ToLower(ds.Policy) == "raid" {
+			groups = append(groups, ds)
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Name < groups[j].Name
+	})
+
+	changed := false
+	if len(groups) > 0 {
+		localDisk, err := r.dm.Partition.ListDevicesDetail("")
+		if err != nil {
+			log.Error("get local disk failed: " + err.Error())
+			return
+		}
+		cordoned := r.dm.GetCordonedDisks()
+		// 同一磁盘只能用于一个阵列
+		used := map[string]bool{}
+		for _, ds := range groups {
+			if r.ensureRaid(ds, localDisk, cordoned, used) {
+				changed = true
+			}
+		}
+	}
+
+	raids, err := r.dm.Raid.ListRaids()
+	if err != nil {
+		log.Errorf("list raid failed %v", err)
+	} else if !equality.Semantic.DeepEqual(raids, r.last) {
+		r.last = raids
+		changed = true
+	}
+	if changed {
+		r.dm.NoticeUpdateCapacity(deviceManager.RaidCheck, nil)
+	}
+}
+
+// ensureRaid 创建阵列、加入vg以及替换故障成员，返回阵列或vg是否发生变化
+func (r *raidCheck) ensureRaid(ds configuration.DiskSelectorItem, localDisk []*types.LocalDisk, cordoned []string, used map[string]bool) bool {
+	raid, err := r.dm.Raid.GetRaid(ds.Name)
+	if err != nil {
+		log.Errorf("get raid %s failed %v", ds.Name, err)
+		return false
+	}
+	candidates := r.candidates(ds, localDisk, cordoned, used)
+	changed := false
+
+	if raid == nil {
+		level, devices := ds.RaidSpec()
+		if len(candidates) < devices {
+			log.Infof("%s %s requires %d disks, %d eligible", level, ds.Name, devices, len(candidates))
+			return false
+		}
+		members := candidates[:devices]
+		candidates = candidates[devices:]
+		log.Infof("create %s %s with disks %v", level, ds.Name, members)
+		if err := r.dm.Raid.CreateRaid(ds.Name, level, members); err != nil {
+			log.Errorf("create raid %s failed %v", ds.Name, err)
+			return false
+		}
+		for _, d := range members {
+			used[d] = true
+		}
+		changed = true
+		raid, err = r.dm.Raid.GetRaid(ds.Name)
+		if err != nil || raid == nil {
+			log.Errorf("get raid %s failed %v", ds.Name, err)
+			return changed
+		}
+	}
+
+	// 阵列作为pv加入同名vg
+	pvs, err := r.dm.VolumeManager.GetCurrentPvStruct()
+	if err != nil {
+		log.Errorf("get pv failed %v", err)
+		return changed
+	}
+	inVg := false
+	for _, pv := range pvs {
+		if pv.PVName == raid.Path && pv.VGName == ds.Name {
+			inVg = true
+			break
+		}
+	}
+	if inVg {
+		// 阵列reshape扩容后调整pv容量
+		if err := r.dm.VolumeManager.GetLv().PVResize(raid.Path); err != nil {
+			log.Errorf("resize %s error", raid.Path)
+		}
+	} else {
+		if err := r.dm.VolumeManager.AddNewDiskToVg(raid.Path, ds.Name); err != nil {
+			log.Errorf("add raid %s to vg %s failed %v", raid.Path, ds.Name, err)
+		} else {
+			changed = true
+		}
+	}
+
+	if !raid.Degraded {
+		return changed
+	}
+	log.Warnf("raid %s is degraded, state: %s", ds.Name, raid.State)
+	if err := r.dm.Raid.RemoveFailedDevices(raid.Path); err != nil {
+		log.Warnf("remove failed devices of raid %s failed %v", ds.Name, err)
+	}
+	// 已有备用盘或正在重建时无需再加入磁盘
+	if raid.SpareDevices > 0 || raid.RebuildProgress != "" {
+		return changed
+	}
+	if len(candidates) == 0 {
+		log.Warnf("raid %s is degraded, no eligible disk to replace the failed member", ds.Name)
+		return changed
+	}
+	log.Infof("add disk %s to degraded raid %s", candidates[0], ds.Name)
+	if err := r.dm.Raid.AddDevice(raid.Path, candidates[0]); err != nil {
+		log.Errorf("add disk %s to raid %s failed %v", candidates[0], ds.Name, err)
+		return changed
+	}
+	used[candidates[0]] = true
+	return true
+}
+
+// candidates 匹配磁盘组条件的空磁盘，按设备名排序
+func (r *raidCheck) candidates(ds configuration.DiskSelectorItem, localDisk []*types.LocalDisk, cordoned []string, used map[string]bool) []string {
+	diskSelector, err := newDiskMatcher(ds)
+	if err != nil {
+		log.Warnf("disk selector %s error %v ", ds.Name, err)
+		return nil
+	}
+	resp := []string{}
+	for _, d := range localDisk {
+		if used[d.Name] || utils.ContainsString(cordoned, d.Name) {
+			continue
+		}
+		if !eligibleDisk(r.dm, diskSelector, ds.Name, d) {
+			continue
+		}
+		resp = append(resp, d.Name)
+	}
+	sort.Strings(resp)
+	return resp
+}
+
+// NeedLeaderElection implements controller-runtime's manager.LeaderElectionRunnable.
+func (r *raidCheck) NeedLeaderElection() bool {
+	return false
+}
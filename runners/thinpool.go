@@ -0,0 +1,81 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package runners
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+
+	deviceManager "github.com/carina-io/carina/pkg/devicemanager"
+	"github.com/carina-io/carina/utils/log"
+)
+
+var _ manager.LeaderElectionRunnable = &thinPoolCheck{}
+
+// thinPoolCheck 定时检查thin pool使用率，超过阈值自动扩容
+type thinPoolCheck struct {
+	dm       *deviceManager.DeviceManager
+	interval time.Duration
+}
+
+func NewThinPoolCheck(dm *deviceManager.DeviceManager) manager.Runnable {
+	return &thinPoolCheck{
+		dm:       dm,
+		interval: 30 * time.Second,
+	}
+}
+
+func (t *thinPoolCheck) Start(ctx context.Context) error {
+	log.Info("Starting thin pool check...")
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			t.extendThinPool()
+		case <-ctx.Done():
+			log.Info("Stop thin pool check...")
+			return nil
+		}
+	}
+}
+
+func (t *thinPoolCheck) extendThinPool() {
+	var extended bool
+	for vgName, ds := range t.dm.GetNodeDiskSelectGroup() {
+		if strings.ToLower(ds.Policy) == "raw" {
+			continue
+		}
+		ok, err := t.dm.VolumeManager.ExtendThinPool(vgName)
+		if err != nil {
+			log.Warnf("extend thin pool of %s failed %s", vgName, err.Error())
+			continue
+		}
+		extended = extended || ok
+	}
+	if extended {
+		t.dm.NoticeUpdateCapacity(deviceManager.ThinPoolExtend, nil)
+	}
+}
+
+// NeedLeaderElection implements controller-runtime's manager.LeaderElectionRunnable.
+func (t *thinPoolCheck) NeedLeaderElection() bool {
+	return false
+}
@@ -23,11 +23,12 @@ import (
 	"github.com/carina-io/carina"
 	carinav1 "github.com/carina-io/carina/api/v1"
 	deviceManager "github.com/carina-io/carina/pkg/devicemanager"
+	"github.com/carina-io/carina/pkg/devicemanager/types"
 	"github.com/carina-io/carina/utils"
 	"github.com/carina-io/carina/utils/log"
 	v1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"strconv"
@@ -92,6 +93,12 @@ func (t *troubleShoot) cleanupOrphanVolume() {
 		}
 	}
 
+	// 无法确定集群标识时不能区分卷的归属，不做清理
+	if t.dm.ClusterID == "" {
+		log.Warnf("%s cluster id is unknown, skip cleanup orphan volume", logPrefix)
+		return
+	}
+
 	// step.3 获取集群中logicVolume对象
 	log.Infof("%s get all logicVolume in cluster", logPrefix)
 	lvList := &carinav1.LogicVolumeList{}
@@ -101,77 +108,63 @@ func (t *troubleShoot) cleanupOrphanVolume() {
 		return
 	}
 
-	// step.4 对比本地volume与logicVolume是否一致， 集群中没有的便删除本地的
+	// step.4 根据归属标签对比本地volume与logicVolume是否一致，只处理属于本集群的卷
 	log.Infof("%s cleanup orphan volume", logPrefix)
-	mapLvList := make(map[string]bool)
-	for _, v := range lvList.Items {
+	mapLvList := make(map[string]*carinav1.LogicVolume)
+	for i, v := range lvList.Items {
 		//skip raw logicVolume
 		if v.Annotations[carina.VolumeManagerType] == carina.RawVolumeType {
 			continue
 		}
-		mapLvList[fmt.Sprintf("%s%s", carina.VolumePrefix, v.Name)] = true
+		mapLvList[fmt.Sprintf("%s%s", carina.VolumePrefix, v.Name)] = &lvList.Items[i]
 	}
 
 	var deleteVolume bool
 	for _, v := range volumeList {
-		if _, ok := mapLvList[v.LVName]; !ok && strings.HasPrefix(v.LVName, carina.VolumePrefix) { // filter thin volume
-			// version upgrade causes lv.status to be empty. set the remedy here
-			pv := new(v1.PersistentVolume)
-			err = t.dm.Cache.Get(context.Background(), types.NamespacedName{Name: v.LVName[7:]}, pv)
-			if err != nil {
-				log.Warnf("get persistent volume %s %s", v.LVName[7:], err.Error())
-			}
-			if pv != nil && len(pv.Name) != 0 {
-				major, _ := strconv.ParseUint(pv.Spec.CSI.VolumeAttributes[carina.VolumeDeviceMajor], 10, 32)
-				minor, _ := strconv.ParseUint(pv.Spec.CSI.VolumeAttributes[carina.VolumeDeviceMinor], 10, 32)
-				deviceGroup := pv.Spec.CSI.VolumeAttributes[carina.DeviceDiskKey]
-				if len(deviceGroup) == 0 {
-					deviceGroup = pv.Spec.CSI.VolumeAttributes[carina.DeviceCapacityKeyPrefix+"disk-type"]
-				}
-				newLv := &carinav1.LogicVolume{
-					TypeMeta: metav1.TypeMeta{
-						Kind:       "LogicVolume",
-						APIVersion: "carina.storage.io/v1",
-					},
-					ObjectMeta: metav1.ObjectMeta{
-						Name: pv.Name,
-						Annotations: map[string]string{
-							carina.VolumeManagerType: carina.LvmVolumeType,
-						},
-						Finalizers: []string{carina.LogicVolumeFinalizer},
-					},
-					Spec: carinav1.LogicVolumeSpec{
-						NodeName:    pv.Spec.CSI.VolumeAttributes[carina.VolumeDeviceNode],
-						DeviceGroup: deviceGroup,
-						Size:        *pv.Spec.Capacity.Storage(),
-						NameSpace:   pv.Spec.CSI.VolumeAttributes["csi.storage.k8s.io/pvc/namespace"],
-						Pvc:         pv.Spec.CSI.VolumeAttributes["csi.storage.k8s.io/pvc/name"],
-					},
-					Status: carinav1.LogicVolumeStatus{
-						VolumeID:    pv.Spec.CSI.VolumeHandle,
-						Code:        0,
-						Message:     "",
-						CurrentSize: pv.Spec.Capacity.Storage(),
-						Status:      "Success",
-						DeviceMajor: uint32(major),
-						DeviceMinor: uint32(minor),
-					},
-				}
-				log.Infof("repair lv %s", newLv.Name)
-				err = t.dm.Client.Create(context.Background(), newLv)
-				if err != nil {
-					log.Errorf("create logic volume failed %s %s", newLv.Name, err.Error())
+		// filter thin pool and snapshot
+		if !strings.HasPrefix(v.LVName, carina.VolumePrefix) {
+			continue
+		}
+		lv, ok := mapLvList[v.LVName]
+		owner := v.Owner()
+		if owner == nil {
+			// 升级前创建的卷没有归属标签，存在对应的logicVolume时补上标签，否则无法确认归属，不做处理
+			if ok {
+				log.Infof("%s tag volume %s %s", logPrefix, v.VGName, v.LVName)
+				if err := t.dm.VolumeManager.TagVolume(v.LVName, v.VGName, t.dm.VolumeOwner(lv)); err != nil {
+					log.Errorf("%s tag volume vg %s lv %s error %s", logPrefix, v.VGName, v.LVName, err.Error())
 				}
-				continue
-			}
-
-			log.Infof("%s remove volume %s %s", logPrefix, v.VGName, v.LVName)
-			err := t.dm.VolumeManager.DeleteVolume(v.LVName, v.VGName)
-			if err != nil {
-				log.Errorf("%s delete volume vg %s lv %s error %s", logPrefix, v.VGName, v.LVName, err.Error())
 			} else {
-				deleteVolume = true
+				log.Warnf("%s skip volume %s %s without owner tags", logPrefix, v.VGName, v.LVName)
 			}
+			continue
+		}
+		if owner.ClusterID != t.dm.ClusterID {
+			log.Debugf("%s skip volume %s %s owned by cluster %s", logPrefix, v.VGName, v.LVName, owner.ClusterID)
+			continue
+		}
+		if ok {
+			continue
+		}
+
+		// version upgrade causes lv.status to be empty. set the remedy here
+		pv := new(v1.PersistentVolume)
+		err = t.dm.Cache.Get(context.Background(), client.ObjectKey{Name: owner.PVName}, pv)
+		if err == nil {
+			t.repairLogicVolume(pv, carina.LvmVolumeType, owner)
+			continue
+		}
+		if !apierrs.IsNotFound(err) {
+			log.Warnf("get persistent volume %s %s", owner.PVName, err.Error())
+			continue
+		}
+
+		log.Infof("%s remove volume %s %s", logPrefix, v.VGName, v.LVName)
+		err := t.dm.VolumeManager.DeleteVolume(v.LVName, v.VGName)
+		if err != nil {
+			log.Errorf("%s delete volume vg %s lv %s error %s", logPrefix, v.VGName, v.LVName, err.Error())
+		} else {
+			deleteVolume = true
 		}
 	}
 
@@ -182,8 +175,62 @@ func (t *troubleShoot) cleanupOrphanVolume() {
 	log.Infof("%s volume check finished.", logPrefix)
 }
 
+// repairLogicVolume 根据pv和卷的归属信息重建logicVolume
+func (t *troubleShoot) repairLogicVolume(pv *v1.PersistentVolume, volumeType string, owner *types.VolumeOwner) {
+	major, _ := strconv.ParseUint(pv.Spec.CSI.VolumeAttributes[carina.VolumeDeviceMajor], 10, 32)
+	minor, _ := strconv.ParseUint(pv.Spec.CSI.VolumeAttributes[carina.VolumeDeviceMinor], 10, 32)
+	deviceGroup := pv.Spec.CSI.VolumeAttributes[carina.DeviceDiskKey]
+	if len(deviceGroup) == 0 {
+		deviceGroup = pv.Spec.CSI.VolumeAttributes[carina.DeviceCapacityKeyPrefix+"disk-type"]
+	}
+	namespace := pv.Spec.CSI.VolumeAttributes["csi.storage.k8s.io/pvc/namespace"]
+	pvc := pv.Spec.CSI.VolumeAttributes["csi.storage.k8s.io/pvc/name"]
+	if owner != nil && owner.PVCName != "" {
+		namespace, pvc = owner.PVCNamespace, owner.PVCName
+	}
+	newLv := &carinav1.LogicVolume{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "LogicVolume",
+			APIVersion: "carina.storage.io/v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name: pv.Name,
+			Annotations: map[string]string{
+				carina.VolumeManagerType: volumeType,
+			},
+			Finalizers: []string{carina.LogicVolumeFinalizer},
+		},
+		Spec: carinav1.LogicVolumeSpec{
+			NodeName:    pv.Spec.CSI.VolumeAttributes[carina.VolumeDeviceNode],
+			DeviceGroup: deviceGroup,
+			Size:        *pv.Spec.Capacity.Storage(),
+			NameSpace:   namespace,
+			Pvc:         pvc,
+		},
+		Status: carinav1.LogicVolumeStatus{
+			VolumeID:    pv.Spec.CSI.VolumeHandle,
+			Code:        0,
+			Message:     "",
+			CurrentSize: pv.Spec.Capacity.Storage(),
+			Status:      "Success",
+			DeviceMajor: uint32(major),
+			DeviceMinor: uint32(minor),
+		},
+	}
+	log.Infof("repair lv %s", newLv.Name)
+	if err := t.dm.Client.Create(context.Background(), newLv); err != nil {
+		log.Errorf("create logic volume failed %s %s", newLv.Name, err.Error())
+	}
+}
+
 // 清理裸盘分区和logicVolume的对应关系
 func (t *troubleShoot) cleanupOrphanPartition() {
+	// 无法确定集群标识时不能区分分区的归属，不做清理
+	if t.dm.ClusterID == "" {
+		log.Warnf("%s cluster id is unknown, skip cleanup orphan partition", logPrefix)
+		return
+	}
+
 	// step.1 获取所有本地 磁盘分区，一个lv其实就是对应一个分区
 	log.Infof("%s get all local partition", "CleanupOrphanPartition")
 
@@ -203,7 +250,7 @@ func (t *troubleShoot) cleanupOrphanPartition() {
 		return
 	}
 
-	// step.4 对比本地分区与logicVolume是否一致， 集群中没有的便删除本地磁盘分区
+	// step.4 对比本地分区与logicVolume是否一致，集群中没有的便删除本地磁盘分区，只处理属于本集群的分区
 	log.Infof("%s cleanup orphan parttions", logPrefix)
 	mapLvList := map[string]bool{}
 	for _, v := range lvList.Items {
@@ -215,6 +262,8 @@ func (t *troubleShoot) cleanupOrphanPartition() {
 		mapLvList[utils.PartitionName(v.Name)] = true
 	}
 	log.Infof("MapLvList:%v", mapLvList)
+
+	var pvList *v1.PersistentVolumeList
 	var deletePartion bool
 	for _, d := range disklist {
 		if d.Type == "part" {
@@ -234,14 +283,32 @@ func (t *troubleShoot) cleanupOrphanPartition() {
 				continue
 			}
 			log.Infof("Check parttions %s %d %d", p.Name, p.Start, p.Last)
-			if _, ok := mapLvList[p.Name]; !ok {
-				log.Warnf("Remove parttions %s %d %d", p.Name, p.Start, p.Last)
-				if err := t.dm.Partition.DeletePartitionByPartNumber(disk, p.Number); err != nil {
-					log.Errorf("Delete parttions in disk name: %s  number: %d error: %s", disk.Name, p.Number, err.Error())
-				} else {
-					deletePartion = true
+			if _, ok := mapLvList[p.Name]; ok {
+				continue
+			}
+			if !types.PartitionOwned(p.ID, t.dm.ClusterID) {
+				log.Warnf("Skip parttions %s without owner of this cluster", p.Name)
+				continue
+			}
+
+			if pvList == nil {
+				pvList = &v1.PersistentVolumeList{}
+				if err := t.dm.Cache.List(context.Background(), pvList); err != nil {
+					log.Errorf("%s list persistent volume error %s", logPrefix, err.Error())
+					return
 				}
 			}
+			if pv := partitionPV(pvList, p.Name, p.ID, t.dm.ClusterID); pv != nil {
+				t.repairLogicVolume(pv, carina.RawVolumeType, nil)
+				continue
+			}
+
+			log.Warnf("Remove parttions %s %d %d", p.Name, p.Start, p.Last)
+			if err := t.dm.Partition.DeletePartitionByPartNumber(disk, p.Number); err != nil {
+				log.Errorf("Delete parttions in disk name: %s  number: %d error: %s", disk.Name, p.Number, err.Error())
+			} else {
+				deletePartion = true
+			}
 		}
 	}
 	if deletePartion {
@@ -250,6 +317,19 @@ func (t *troubleShoot) cleanupOrphanPartition() {
 	log.Infof("%s volume check finished.", logPrefix)
 }
 
+// partitionPV 根据分区名称和分区GUID找到分区对应的pv
+func partitionPV(pvList *v1.PersistentVolumeList, name string, id [16]byte, clusterID string) *v1.PersistentVolume {
+	for i, pv := range pvList.Items {
+		if pv.Spec.CSI == nil || pv.Spec.CSI.Driver != carina.CSIPluginName {
+			continue
+		}
+		if utils.PartitionName(pv.Name) == name && types.PartitionID(clusterID, pv.Name) == id {
+			return &pvList.Items[i]
+		}
+	}
+	return nil
+}
+
 func (t *troubleShoot) NeedLeaderElection() bool {
 	return false
 }
@@ -25,6 +25,8 @@ const (
 	VolumeDeviceNode = "carina.storage.io/node"
 	// DeviceCapacityKeyPrefix device plugin
 	DeviceCapacityKeyPrefix = "carina.storage.io/"
+	// ThinCapacityKeyPrefix thin pool capacity of device group, with overcommit
+	ThinCapacityKeyPrefix = "thin.carina.storage.io/"
 
 	// VolumeBackendDiskType bcahce scheduler
 	VolumeBackendDiskType = "carina.storage.io/backend-disk-group-name"
@@ -36,4 +38,14 @@ const (
 	RawVolumeType = "raw"
 	//ExclusivityDisk  true or false  is the key indicates that only the disk is used by one pod
 	ExclusivityDisk = "carina.storage.io/exclusively-raw-disk"
+	// ThinProvisioning true or false, provision lvm volume from the thin pool of device group
+	ThinProvisioning = "carina.storage.io/thin-provisioning"
+	// VolumeRaidLevel value: raid1|raid5|raid10, create lvm raid volume to tolerate a single disk failure
+	VolumeRaidLevel = "carina.storage.io/raid-level"
+	// VolumeMirrors number of additional copies of raid1|raid10 volume, defaults to 1
+	VolumeMirrors = "carina.storage.io/mirrors"
+	// VolumeStripes number of stripes of striped|raid5|raid10 volume, raid5|raid10 defaults to 2
+	VolumeStripes = "carina.storage.io/stripes"
+	// VolumeAlignment volume sizes are rounded up to the default lvm extent size
+	VolumeAlignment = 4 << 20
 )
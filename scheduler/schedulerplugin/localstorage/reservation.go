@@ -0,0 +1,188 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package localstorage
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/carina-io/carina/scheduler/configuration"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+)
+
+// reservationTimeout 预留容量的最长保留时间，避免LogicVolume事件丢失时容量一直被占用
+const reservationTimeout = 10 * time.Minute
+
+// reservation 已调度但尚未创建LogicVolume的pvc容量请求
+type reservation struct {
+	podUID      types.UID
+	nodeName    string
+	deviceGroup string
+	pvcRequest
+	reservedAt time.Time
+}
+
+// ledger 记录各节点、各设备组的预留容量
+// 同一批pod在NodeStorageResource更新前可能都选择同一节点，Filter/Score时需要减去预留容量
+type ledger struct {
+	lock sync.Mutex
+	// key为pvc namespace/name，bcache卷的一个pvc同时预留缓存盘与数据盘容量
+	reservations map[string][]*reservation
+}
+
+func newLedger() *ledger {
+	return &ledger{
+		reservations: map[string][]*reservation{},
+	}
+}
+
+// reserve 为pod在节点上预留pvc请求容量
+func (l *ledger) reserve(podUID types.UID, nodeName string, pvcRequestMap map[string][]*pvcRequest) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	now := time.Now()
+	for deviceGroup, pvcRequests := range pvcRequestMap {
+		for _, pvcR := range pvcRequests {
+			klog.V(3).Infof("reserve pvc: %s, node: %s, deviceGroup: %s, request: %d", pvcR.pvc, nodeName, deviceGroup, pvcR.request)
+			l.reservations[pvcR.pvc] = append(l.reservations[pvcR.pvc], &reservation{
+				podUID:      podUID,
+				nodeName:    nodeName,
+				deviceGroup: deviceGroup,
+				pvcRequest:  *pvcR,
+				reservedAt:  now,
+			})
+		}
+	}
+}
+
+// releasePod 释放pod的全部预留容量
+func (l *ledger) releasePod(podUID types.UID) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	for pvc, reservations := range l.reservations {
+		remain := []*reservation{}
+		for _, r := range reservations {
+			if r.podUID != podUID {
+				remain = append(remain, r)
+			}
+		}
+		if len(remain) == 0 {
+			delete(l.reservations, pvc)
+		} else {
+			l.reservations[pvc] = remain
+		}
+	}
+}
+
+// releasePvc 释放pvc的预留容量
+func (l *ledger) releasePvc(pvc string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if _, ok := l.reservations[pvc]; ok {
+		klog.V(3).Infof("release reservation of pvc: %s", pvc)
+		delete(l.reservations, pvc)
+	}
+}
+
+// podPvcs pod预留容量的pvc
+func (l *ledger) podPvcs(podUID types.UID) []string {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	pvcs := []string{}
+	for pvc, reservations := range l.reservations {
+		for _, r := range reservations {
+			if r.podUID == podUID {
+				pvcs = append(pvcs, pvc)
+				break
+			}
+		}
+	}
+	return pvcs
+}
+
+// subtract 从节点可分配容量中减去其他pod的预留容量，容量单位与NodeStorageResource一致(字节)
+func (l *ledger) subtract(nodeName string, podUID types.UID, allocatableMap map[string]int64) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	for pvc, reservations := range l.reservations {
+		for _, r := range reservations {
+			if time.Since(r.reservedAt) > reservationTimeout {
+				klog.V(3).Infof("reservation of pvc %s timeout", pvc)
+				delete(l.reservations, pvc)
+				break
+			}
+			if r.nodeName != nodeName || r.podUID == podUID {
+				continue
+			}
+			if configuration.CheckRawDeviceGroup(r.deviceGroup) {
+				subtractRawDisk(allocatableMap, r.deviceGroup, r.exclusive, r.request)
+				continue
+			}
+			if allocatable, ok := allocatableMap[r.deviceGroup]; ok {
+				allocatableMap[r.deviceGroup] = allocatable - r.request
+				if allocatableMap[r.deviceGroup] < 0 {
+					allocatableMap[r.deviceGroup] = 0
+				}
+			}
+		}
+	}
+}
+
+// subtractRawDisk 与minimumValueMinus一致，从满足请求的最小磁盘中减去预留容量
+func subtractRawDisk(allocatableMap map[string]int64, deviceGroup string, exclusive bool, requestBytes int64) {
+	disks := []string{}
+	for lvGroup := range allocatableMap {
+		if strings.HasPrefix(lvGroup, deviceGroup+"/") {
+			disks = append(disks, lvGroup)
+		}
+	}
+	sort.Slice(disks, func(i, j int) bool {
+		if allocatableMap[disks[i]] == allocatableMap[disks[j]] {
+			return disks[i] < disks[j]
+		}
+		return allocatableMap[disks[i]] < allocatableMap[disks[j]]
+	})
+	for _, disk := range disks {
+		if allocatableMap[disk] < requestBytes {
+			continue
+		}
+		if exclusive {
+			allocatableMap[disk] = 0
+		} else {
+			allocatableMap[disk] -= requestBytes
+		}
+		return
+	}
+}
+
+// onLogicVolumeAdd LogicVolume创建后，节点容量将由NodeStorageResource体现，释放对应pvc的预留容量
+func (ls *LocalStorage) onLogicVolumeAdd(obj interface{}) {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return
+	}
+	namespace, _, _ := unstructured.NestedString(u.Object, "spec", "nameSpace")
+	pvc, _, _ := unstructured.NestedString(u.Object, "spec", "pvc")
+	if pvc == "" {
+		return
+	}
+	ls.ledger.releasePvc(namespace + "/" + pvc)
+}
@@ -0,0 +1,107 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+package localstorage
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	lcorev1 "k8s.io/client-go/listers/core/v1"
+	lstoragev1 "k8s.io/client-go/listers/storage/v1"
+	"k8s.io/client-go/tools/cache"
+
+	carina "github.com/carina-io/carina/scheduler"
+)
+
+func TestLedger(t *testing.T) {
+	a := assert.New(t)
+	l := newLedger()
+	l.reserve("pod-a", "node1", map[string][]*pvcRequest{
+		"carina-vg-ssd": {{request: 10 << 30, pvc: "default/pvc-a"}},
+	})
+	l.reserve("pod-b", "node1", map[string][]*pvcRequest{
+		"carina-vg-ssd": {{request: 5<<30 + 4<<20, pvc: "default/pvc-b"}},
+	})
+
+	allocatable := map[string]int64{"carina-vg-ssd": 100 << 30}
+	l.subtract("node1", "pod-c", allocatable)
+	a.Equal(int64(85<<30-4<<20), allocatable["carina-vg-ssd"])
+
+	// 不减去pod自身的预留
+	allocatable = map[string]int64{"carina-vg-ssd": 100 << 30}
+	l.subtract("node1", "pod-a", allocatable)
+	a.Equal(int64(95<<30-4<<20), allocatable["carina-vg-ssd"])
+
+	allocatable = map[string]int64{"carina-vg-ssd": 100 << 30}
+	l.subtract("node2", "pod-c", allocatable)
+	a.Equal(int64(100<<30), allocatable["carina-vg-ssd"])
+
+	l.releasePvc("default/pvc-a")
+	l.releasePod("pod-b")
+	allocatable = map[string]int64{"carina-vg-ssd": 100 << 30}
+	l.subtract("node1", "pod-c", allocatable)
+	a.Equal(int64(100<<30), allocatable["carina-vg-ssd"])
+}
+
+// 同一pod中同一磁盘组的多个pvc需全部预留
+func TestLedgerMultiplePvcs(t *testing.T) {
+	a := assert.New(t)
+	scIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	pvcIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	a.NoError(scIndexer.Add(&storagev1.StorageClass{
+		ObjectMeta:  metav1.ObjectMeta{Name: "csi-carina-sc"},
+		Provisioner: carina.CSIPluginName,
+		Parameters:  map[string]string{carina.DeviceDiskKey: "carina-vg-ssd"},
+	}))
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "app", UID: "pod-a"}}
+	for _, name := range []string{"data", "log"} {
+		scName := "csi-carina-sc"
+		a.NoError(pvcIndexer.Add(&v1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name},
+			Spec: v1.PersistentVolumeClaimSpec{
+				StorageClassName: &scName,
+				Resources:        v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceStorage: resource.MustParse("10Gi")}},
+			},
+		}))
+		pod.Spec.Volumes = append(pod.Spec.Volumes, v1.Volume{Name: name, VolumeSource: v1.VolumeSource{PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{ClaimName: name}}})
+	}
+	ls := &LocalStorage{
+		scLister:  lstoragev1.NewStorageClassLister(scIndexer),
+		pvcLister: lcorev1.NewPersistentVolumeClaimLister(pvcIndexer),
+		ledger:    newLedger(),
+	}
+
+	pvcRequestMap, _, _, err := ls.getPvcRequestMap(pod)
+	a.NoError(err)
+	a.Equal(2, len(pvcRequestMap["carina-vg-ssd"]))
+
+	ls.ledger.reserve(pod.UID, "node1", pvcRequestMap)
+	allocatable := map[string]int64{"carina-vg-ssd": 100 << 30}
+	ls.ledger.subtract("node1", "pod-b", allocatable)
+	a.Equal(int64(80<<30), allocatable["carina-vg-ssd"])
+}
+
+func TestSubtractRawDisk(t *testing.T) {
+	allocatable := map[string]int64{"raw/sdb": 20, "raw/sdc": 10, "raw/sdd": 5}
+	subtractRawDisk(allocatable, "raw", false, 8)
+	assert.Equal(t, map[string]int64{"raw/sdb": 20, "raw/sdc": 2, "raw/sdd": 5}, allocatable)
+	subtractRawDisk(allocatable, "raw", true, 8)
+	assert.Equal(t, map[string]int64{"raw/sdb": 0, "raw/sdc": 2, "raw/sdd": 5}, allocatable)
+}
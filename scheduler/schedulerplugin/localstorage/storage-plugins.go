@@ -52,15 +52,21 @@ type LocalStorage struct {
 	lvLister      cache.GenericLister
 	nsrLister     cache.GenericLister
 	dynamicClient dynamic.Interface
+	// 已调度但尚未创建LogicVolume的预留容量
+	ledger *ledger
 }
 
 type pvcRequest struct {
 	exclusive bool
 	request   int64
+	// pvc namespace/name
+	pvc string
 }
 
 var _ framework.FilterPlugin = &LocalStorage{}
 var _ framework.ScorePlugin = &LocalStorage{}
+var _ framework.ReservePlugin = &LocalStorage{}
+var _ framework.PreBindPlugin = &LocalStorage{}
 
 // New type PluginFactory = func(configuration *runtime.Unknown, f FrameworkHandle) (Plugin, error)
 func New(_ runtime.Object, handle framework.Handle) (framework.Plugin, error) {
@@ -69,20 +75,25 @@ func New(_ runtime.Object, handle framework.Handle) (framework.Plugin, error) {
 	pvLister := handle.SharedInformerFactory().Core().V1().PersistentVolumes().Lister()
 	dynamicClient := newDynamicClientFromConfig()
 	dynamicSharedInformerFactory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(dynamicClient, 0, v1.NamespaceAll, nil)
-	lvLister := dynamicSharedInformerFactory.ForResource(carinav1.GroupVersion.WithResource("logicvolumes")).Lister()
+	lvInformer := dynamicSharedInformerFactory.ForResource(carinav1.GroupVersion.WithResource("logicvolumes"))
 	nsrLister := dynamicSharedInformerFactory.ForResource(carinav1beta1.GroupVersion.WithResource("nodestorageresources")).Lister()
-	ctx := context.TODO()
-	dynamicSharedInformerFactory.Start(ctx.Done())
-	dynamicSharedInformerFactory.WaitForCacheSync(ctx.Done())
-	return &LocalStorage{
+	ls := &LocalStorage{
 		handle:        handle,
 		pvcLister:     pvcLister,
 		scLister:      scLister,
 		pvLister:      pvLister,
-		lvLister:      lvLister,
+		lvLister:      lvInformer.Lister(),
 		nsrLister:     nsrLister,
 		dynamicClient: dynamicClient,
-	}, nil
+		ledger:        newLedger(),
+	}
+	if _, err := lvInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{AddFunc: ls.onLogicVolumeAdd}); err != nil {
+		return nil, err
+	}
+	ctx := context.TODO()
+	dynamicSharedInformerFactory.Start(ctx.Done())
+	dynamicSharedInformerFactory.WaitForCacheSync(ctx.Done())
+	return ls, nil
 }
 
 func (ls *LocalStorage) Name() string {
@@ -107,7 +118,7 @@ func (ls *LocalStorage) Filter(ctx context.Context, cycleState *framework.CycleS
 		return framework.NewStatus(framework.Success, "")
 	}
 
-	allocatableMap, err := ls.getAllocatableMap(useRaw, pod.Name, node.Node().Name)
+	allocatableMap, err := ls.getAllocatableMap(useRaw, pod, node.Node().Name)
 	if err != nil {
 		return framework.NewStatus(framework.UnschedulableAndUnresolvable, err.Error())
 	}
@@ -137,9 +148,8 @@ func (ls *LocalStorage) Filter(ctx context.Context, cycleState *framework.CycleS
 			for _, pvcR := range pvcRequests {
 				requestTotalBytes += pvcR.request
 			}
-			requestTotalGb := (requestTotalBytes-1)>>30 + 1
-			if requestTotalGb > allocatableMap[scDeviceGroup] {
-				klog.V(3).Infof("mismatch pod: %s, node: %s, request: %d, scDeviceGroup:%s, allocatable: %d", pod.Name, node.Node().Name, requestTotalGb, scDeviceGroup, allocatableMap[scDeviceGroup])
+			if requestTotalBytes > allocatableMap[scDeviceGroup] {
+				klog.V(3).Infof("mismatch pod: %s, node: %s, request: %d, scDeviceGroup:%s, allocatable: %d", pod.Name, node.Node().Name, requestTotalBytes, scDeviceGroup, allocatableMap[scDeviceGroup])
 				return framework.NewStatus(framework.UnschedulableAndUnresolvable, "node storage resource insufficient")
 			}
 		}
@@ -166,7 +176,7 @@ func (ls *LocalStorage) Score(ctx context.Context, state *framework.CycleState,
 		return 5, framework.NewStatus(framework.Success, "")
 	}
 
-	allocatableMap, err := ls.getAllocatableMap(useRaw, pod.Name, nodeName)
+	allocatableMap, err := ls.getAllocatableMap(useRaw, pod, nodeName)
 	if err != nil {
 		return 0, framework.NewStatus(framework.UnschedulableAndUnresolvable, err.Error())
 	}
@@ -181,7 +191,6 @@ func (ls *LocalStorage) Score(ctx context.Context, state *framework.CycleState,
 		for _, pvcR := range pvcRequests {
 			requestTotalBytes += pvcR.request
 		}
-		requestTotalGb := (requestTotalBytes-1)>>30 + 1
 
 		var allocatableTotal int64
 		if configuration.CheckRawDeviceGroup(scDeviceGroup) {
@@ -197,10 +206,10 @@ func (ls *LocalStorage) Score(ctx context.Context, state *framework.CycleState,
 
 		count++
 		if configuration.SchedulerStrategy() == configuration.Schedulerspreadout {
-			scoref += 1.0 - float64(requestTotalGb)/float64(allocatableTotal)
+			scoref += 1.0 - float64(requestTotalBytes)/float64(allocatableTotal)
 		}
 		if configuration.SchedulerStrategy() == configuration.SchedulerBinpack {
-			scoref += float64(requestTotalGb) / float64(allocatableTotal)
+			scoref += float64(requestTotalBytes) / float64(allocatableTotal)
 		}
 	}
 
@@ -215,6 +224,42 @@ func (ls *LocalStorage) ScoreExtensions() framework.ScoreExtensions {
 	return nil
 }
 
+// Reserve 预留pod所需的节点容量，直到LogicVolume创建或者绑定失败
+func (ls *LocalStorage) Reserve(ctx context.Context, state *framework.CycleState, pod *v1.Pod, nodeName string) *framework.Status {
+	pvcRequestMap, _, _, err := ls.getPvcRequestMap(pod)
+	if err != nil {
+		klog.V(3).ErrorS(err, "failed to get pvc/sc, pod: %s, node: %s", pod.Name, nodeName)
+		return framework.NewStatus(framework.Error, err.Error())
+	}
+	if len(pvcRequestMap) == 0 {
+		return framework.NewStatus(framework.Success, "")
+	}
+	ls.ledger.reserve(pod.UID, nodeName, pvcRequestMap)
+	return framework.NewStatus(framework.Success, "")
+}
+
+// Unreserve 绑定失败时释放预留容量
+func (ls *LocalStorage) Unreserve(ctx context.Context, state *framework.CycleState, pod *v1.Pod, nodeName string) {
+	klog.V(3).Infof("unreserve pod: %s, node: %s", pod.Name, nodeName)
+	ls.ledger.releasePod(pod.UID)
+}
+
+// PreBind pvc已经绑定时LogicVolume已经存在，释放其预留容量
+func (ls *LocalStorage) PreBind(ctx context.Context, state *framework.CycleState, pod *v1.Pod, nodeName string) *framework.Status {
+	for _, key := range ls.ledger.podPvcs(pod.UID) {
+		namespace, name, _ := strings.Cut(key, "/")
+		pvc, err := ls.pvcLister.PersistentVolumeClaims(namespace).Get(name)
+		if err != nil {
+			klog.V(3).Infof("failed to get pvc %s, err: %s", key, err.Error())
+			continue
+		}
+		if pvc.Status.Phase == v1.ClaimBound {
+			ls.ledger.releasePvc(key)
+		}
+	}
+	return framework.NewStatus(framework.Success, "")
+}
+
 func (ls *LocalStorage) getPvcRequestMap(pod *v1.Pod) (map[string][]*pvcRequest, string, bool, error) {
 	nodeName := ""
 	pvcRequestMap := map[string][]*pvcRequest{}
@@ -279,7 +324,7 @@ func (ls *LocalStorage) getPvcRequestMap(pod *v1.Pod) (map[string][]*pvcRequest,
 				return pvcRequestMap, nodeName, useRaw, errors.New("carina.storage.io/cache-disk-ratio should be in 1-100")
 			}
 			cacheRequestBytes := pvc.Spec.Resources.Requests.Storage().Value() * ratio / 100
-			pvcRequestMap[cacheGroup] = append(pvcRequestMap[cacheGroup], &pvcRequest{false, cacheRequestBytes})
+			pvcRequestMap[cacheGroup] = append(pvcRequestMap[cacheGroup], &pvcRequest{false, cacheRequestBytes, pvc.Namespace + "/" + pvc.Name})
 		}
 
 		if deviceGroup == "" {
@@ -290,13 +335,46 @@ func (ls *LocalStorage) getPvcRequestMap(pod *v1.Pod) (map[string][]*pvcRequest,
 		if sc.Parameters[carina.ExclusivityDisk] == "true" {
 			exclusive = true
 		}
-		pvcRequestMap[deviceGroup] = append(pvcRequestMap[cacheGroup], &pvcRequest{exclusive, pvc.Spec.Resources.Requests.Storage().Value()})
+		// thin卷按thin pool超分后的容量计算
+		if !configuration.CheckRawDeviceGroup(deviceGroup) && sc.Parameters[carina.ThinProvisioning] == "true" {
+			deviceGroup = carina.ThinCapacityKeyPrefix + deviceGroup
+		}
+		request := alignRequestBytes(pvc.Spec.Resources.Requests.Storage().Value())
+		if !configuration.CheckRawDeviceGroup(deviceGroup) {
+			request = raidRequestBytes(sc.Parameters, request)
+		}
+		pvcRequestMap[deviceGroup] = append(pvcRequestMap[deviceGroup], &pvcRequest{exclusive, request, pvc.Namespace + "/" + pvc.Name})
 	}
 	klog.V(3).Infof("pvcRequestMap: %v, node: %s, useRaw: %v", pvcRequestMap, nodeName, useRaw)
 	return pvcRequestMap, nodeName, useRaw, nil
 }
 
-func (ls *LocalStorage) getAllocatableMap(useRaw bool, podName, nodeName string) (map[string]int64, error) {
+// alignRequestBytes 与csi controller一致，按lvm默认PE大小向上对齐
+func alignRequestBytes(request int64) int64 {
+	return (request + carina.VolumeAlignment - 1) / carina.VolumeAlignment * carina.VolumeAlignment
+}
+
+// raidRequestBytes raid卷的副本以及校验条带同样占用磁盘组容量
+func raidRequestBytes(params map[string]string, request int64) int64 {
+	mirrors, _ := strconv.ParseInt(params[carina.VolumeMirrors], 10, 64)
+	stripes, _ := strconv.ParseInt(params[carina.VolumeStripes], 10, 64)
+	switch strings.ToLower(params[carina.VolumeRaidLevel]) {
+	case "raid1", "raid10":
+		if mirrors < 1 {
+			mirrors = 1
+		}
+		return request * (mirrors + 1)
+	case "raid5":
+		if stripes < 2 {
+			stripes = 2
+		}
+		return (request*(stripes+1) + stripes - 1) / stripes
+	}
+	return request
+}
+
+func (ls *LocalStorage) getAllocatableMap(useRaw bool, pod *v1.Pod, nodeName string) (map[string]int64, error) {
+	podName := pod.Name
 	var lvExclusivityDisks []string
 	var err error
 	allocatableMap := map[string]int64{}
@@ -315,6 +393,11 @@ func (ls *LocalStorage) getAllocatableMap(useRaw bool, podName, nodeName string)
 	}
 
 	for groupDetail, allocatable := range nsr.Status.Allocatable {
+		// thin pool容量保留完整key，与pvcRequestMap中thin卷的key对应
+		if strings.HasPrefix(groupDetail, carina.ThinCapacityKeyPrefix) {
+			allocatableMap[groupDetail] = allocatable.Value()
+			continue
+		}
 		if !strings.HasPrefix(groupDetail, carina.DeviceCapacityKeyPrefix) {
 			continue
 		}
@@ -331,6 +414,8 @@ func (ls *LocalStorage) getAllocatableMap(useRaw bool, podName, nodeName string)
 			allocatableMap[lvGroup] = allocatable.Value()
 		}
 	}
+	// 减去其他pod已预留但尚未体现在NodeStorageResource中的容量
+	ls.ledger.subtract(nodeName, pod.UID, allocatableMap)
 	klog.V(3).Infof("allocatableMap: %v", allocatableMap)
 
 	if len(allocatableMap) == 0 {
@@ -346,10 +431,9 @@ func minimumValueMinus(array []int64, pvcR *pvcRequest) int {
 	sort.Slice(array, func(i, j int) bool {
 		return array[i] < array[j]
 	})
-	requestGb := (pvcR.request-1)>>30 + 1
 	index := -1
 	for i, a := range array {
-		if a >= requestGb {
+		if a >= pvcR.request {
 			index = i
 			break
 		}
@@ -360,7 +444,7 @@ func minimumValueMinus(array []int64, pvcR *pvcRequest) int {
 	if pvcR.exclusive {
 		array[index] = 0
 	} else {
-		array[index] = array[index] - requestGb
+		array[index] = array[index] - pvcR.request
 	}
 
 	return index
@@ -27,7 +27,7 @@ func TestMinimumValueMinus(t *testing.T) {
 		result []int64
 	}{
 		{array: []int64{3, 4, 5, 2, 5, 23, 1}, value: 3, result: []int64{1, 2, 0, 4, 5, 5, 23}},
-		{array: []int64{3, 4, 5, 2, 5, 23, 1}, value: 33, result: []int64{}},
+		{array: []int64{3, 4, 5, 2, 5, 23, 1}, value: 33, result: []int64{1, 2, 3, 4, 5, 5, 23}},
 	}
 
 	a := assert.New(t)
@@ -36,3 +36,23 @@ func TestMinimumValueMinus(t *testing.T) {
 		a.Equal(e.array, e.result)
 	}
 }
+
+func TestRaidRequestBytes(t *testing.T) {
+	table := []struct {
+		params map[string]string
+		result int64
+	}{
+		{params: map[string]string{}, result: 12},
+		{params: map[string]string{"carina.storage.io/stripes": "3"}, result: 12},
+		{params: map[string]string{"carina.storage.io/raid-level": "raid1"}, result: 24},
+		{params: map[string]string{"carina.storage.io/raid-level": "raid1", "carina.storage.io/mirrors": "2"}, result: 36},
+		{params: map[string]string{"carina.storage.io/raid-level": "raid10", "carina.storage.io/stripes": "3"}, result: 24},
+		{params: map[string]string{"carina.storage.io/raid-level": "raid5"}, result: 18},
+		{params: map[string]string{"carina.storage.io/raid-level": "raid5", "carina.storage.io/stripes": "4"}, result: 15},
+	}
+
+	a := assert.New(t)
+	for _, e := range table {
+		a.Equal(e.result, raidRequestBytes(e.params, 12))
+	}
+}
@@ -40,10 +40,26 @@ type Executor interface {
 	ExecuteCommandWithOutputFileTimeout(timeout time.Duration, command, outfileArg string, arg ...string) (string, error)
 	ExecuteCommandWithTimeout(timeout time.Duration, command string, arg ...string) (string, error)
 	ExecuteCommandResidentBinary(timeout time.Duration, command string, arg ...string) error
+	// WithContext returns an Executor whose commands are bound to ctx, when ctx is done
+	// the running command is killed together with its process group
+	WithContext(ctx context.Context) Executor
 }
 
 // CommandExecutor is the type of the Executor
 type CommandExecutor struct {
+	ctx context.Context
+}
+
+// WithContext returns a copy of the executor bound to ctx
+func (c *CommandExecutor) WithContext(ctx context.Context) Executor {
+	return &CommandExecutor{ctx: ctx}
+}
+
+func (c *CommandExecutor) context() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
 }
 
 // ExecuteCommand starts a process and wait for its completion
@@ -52,19 +68,23 @@ func (c *CommandExecutor) ExecuteCommand(command string, arg ...string) error {
 }
 
 // ExecuteCommandWithEnv starts a process with env variables and wait for its completion
-func (*CommandExecutor) ExecuteCommandWithEnv(env []string, command string, arg ...string) error {
-	cmd, stdout, stderr, err := startCommand(env, command, arg...)
-	if err != nil {
-		return err
+func (c *CommandExecutor) ExecuteCommandWithEnv(env []string, command string, arg ...string) error {
+	ctx := c.context()
+	if err := ctx.Err(); err != nil {
+		return contextError(ctx, command, arg...)
 	}
 
-	logOutput(stdout, stderr)
-
-	if err := cmd.Wait(); err != nil {
+	cmd, stdout, stderr, err := startCommand(env, command, arg...)
+	if err != nil {
 		return err
 	}
 
-	return nil
+	// the pipes must be drained before Wait, so both run in the background and
+	// are interrupted by killing the process group
+	return waitCommand(ctx, cmd, func() error {
+		logOutput(stdout, stderr)
+		return cmd.Wait()
+	}, command, arg...)
 }
 
 // ExecuteCommandWithTimeout starts a process and wait for its completion with timeout.
@@ -121,19 +141,15 @@ func (*CommandExecutor) ExecuteCommandWithTimeout(timeout time.Duration, command
 }
 
 // ExecuteCommandWithOutput executes a command with output
-func (*CommandExecutor) ExecuteCommandWithOutput(command string, arg ...string) (string, error) {
+func (c *CommandExecutor) ExecuteCommandWithOutput(command string, arg ...string) (string, error) {
 	logCommand(command, arg...)
-	// #nosec G204 Rook controls the input to the exec arguments
-	cmd := exec.Command(command, arg...)
-	return runCommandWithOutput(cmd, false)
+	return runCommandWithOutput(c.context(), false, command, arg...)
 }
 
 // ExecuteCommandWithCombinedOutput executes a command with combined output
-func (*CommandExecutor) ExecuteCommandWithCombinedOutput(command string, arg ...string) (string, error) {
+func (c *CommandExecutor) ExecuteCommandWithCombinedOutput(command string, arg ...string) (string, error) {
 	logCommand(command, arg...)
-	// #nosec G204 Rook controls the input to the exec arguments
-	cmd := exec.Command(command, arg...)
-	return runCommandWithOutput(cmd, true)
+	return runCommandWithOutput(c.context(), true, command, arg...)
 }
 
 // ExecuteCommandWithOutputFileTimeout Same as ExecuteCommandWithOutputFile but with a timeout limit.
@@ -236,8 +252,7 @@ func (*CommandExecutor) ExecuteCommandResidentBinary(timeout time.Duration, comm
 func startCommand(env []string, command string, arg ...string) (*exec.Cmd, io.ReadCloser, io.ReadCloser, error) {
 	logCommand(command, arg...)
 
-	// #nosec G204 Rook controls the input to the exec arguments
-	cmd := exec.Command(command, arg...)
+	cmd := newCommand(command, arg...)
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
 		log.Warnf("failed to open stdout pipe: %+v", err)
@@ -275,27 +290,72 @@ func logOutput(stdout, stderr io.ReadCloser) {
 	logFromReader(stdout)
 }
 
-func runCommandWithOutput(cmd *exec.Cmd, combinedOutput bool) (string, error) {
-	var output []byte
-	var err error
-	var out string
+func runCommandWithOutput(ctx context.Context, combinedOutput bool, command string, arg ...string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", contextError(ctx, command, arg...)
+	}
 
+	var stdout, stderr bytes.Buffer
+	cmd := newCommand(command, arg...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
 	if combinedOutput {
-		output, err = cmd.CombinedOutput()
-	} else {
-		output, err = cmd.Output()
-		if err != nil {
-			output = []byte(fmt.Sprintf("%s. %s", string(output), assertErrorType(err)))
+		cmd.Stderr = &stdout
+	}
+
+	err := cmd.Start()
+	if err == nil {
+		err = waitCommand(ctx, cmd, cmd.Wait, command, arg...)
+	}
+
+	output := stdout.String()
+	if err != nil && !combinedOutput {
+		msg := stderr.String()
+		if msg == "" {
+			msg = assertErrorType(err)
 		}
+		output = fmt.Sprintf("%s. %s", output, msg)
 	}
 
-	out = strings.TrimSpace(string(output))
+	return strings.TrimSpace(output), err
+}
+
+// newCommand runs the command in its own process group so that it can be killed
+// together with the children it spawned
+func newCommand(command string, arg ...string) *exec.Cmd {
+	// #nosec G204 Rook controls the input to the exec arguments
+	cmd := exec.Command(command, arg...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	return cmd
+}
 
-	if err != nil {
-		return out, err
+// waitCommand runs wait in the background, if ctx is done first the process group
+// of the started cmd is killed and an error naming the command is returned
+func waitCommand(ctx context.Context, cmd *exec.Cmd, wait func() error, command string, arg ...string) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- wait()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		log.Warnf("%s, killing process group %d", contextError(ctx, command, arg...), cmd.Process.Pid)
+		if err := syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL); err != nil && err != syscall.ESRCH {
+			log.Errorf("Failed to kill process group of %s: %v", command, err)
+		}
+		<-done
+		return contextError(ctx, command, arg...)
 	}
+}
 
-	return out, nil
+func contextError(ctx context.Context, command string, arg ...string) error {
+	cmdline := strings.TrimSpace(command + " " + strings.Join(arg, " "))
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("command \"%s\" timed out: %w", cmdline, ctx.Err())
+	}
+	return fmt.Errorf("command \"%s\" canceled: %w", cmdline, ctx.Err())
 }
 
 func logCommand(command string, arg ...string) {
@@ -0,0 +1,48 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package exec
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExecuteCommandWithContext(t *testing.T) {
+	a := assert.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	executor := (&CommandExecutor{}).WithContext(ctx)
+
+	// 子进程持有输出管道，需要连同进程组一起结束才能返回
+	start := time.Now()
+	_, err := executor.ExecuteCommandWithOutput("sh", "-c", "sleep 10 & sleep 10")
+	a.Less(time.Since(start), 5*time.Second)
+	a.True(errors.Is(err, context.DeadlineExceeded))
+	a.Contains(err.Error(), "sh -c sleep 10 & sleep 10")
+	a.Contains(err.Error(), "timed out")
+
+	err = executor.ExecuteCommand("true")
+	a.True(errors.Is(err, context.DeadlineExceeded))
+
+	out, err := (&CommandExecutor{}).ExecuteCommandWithCombinedOutput("sh", "-c", "echo out; echo err >&2")
+	a.NoError(err)
+	a.Equal("out\nerr", out)
+}
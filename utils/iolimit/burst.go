@@ -0,0 +1,105 @@
+/*
+  Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package iolimit
+
+import (
+	"math"
+	"time"
+)
+
+// saturatedRatio io速率达到基础限速的该比例时认为设备已被限速
+const saturatedRatio = 0.9
+
+// IOStat io.stat中设备的累计读写量
+type IOStat struct {
+	Rbytes uint64
+	Wbytes uint64
+	Rios   uint64
+	Wios   uint64
+}
+
+// BurstBucket 突发令牌桶，令牌以秒为单位，突发期间每秒消耗一个令牌，
+// 非突发期间每秒补充一个令牌直到桶满
+type BurstBucket struct {
+	capacity float64
+	tokens   float64
+	bursting bool
+	last     time.Time
+}
+
+func NewBurstBucket(seconds uint64) *BurstBucket {
+	return &BurstBucket{
+		capacity: float64(seconds),
+		tokens:   float64(seconds),
+	}
+}
+
+func (b *BurstBucket) Bursting() bool {
+	return b.bursting
+}
+
+// Next 根据经过的时间更新令牌，设备达到基础限速且有令牌时进入突发，
+// 令牌耗尽或设备不再达到基础限速时退出突发，返回是否处于突发状态
+func (b *BurstBucket) Next(now time.Time, saturated bool) bool {
+	if !b.last.IsZero() {
+		elapsed := now.Sub(b.last).Seconds()
+		if b.bursting {
+			b.tokens -= elapsed
+		} else {
+			b.tokens = math.Min(b.capacity, b.tokens+elapsed)
+		}
+	}
+	b.last = now
+	if b.tokens <= 0 {
+		b.tokens = 0
+		b.bursting = false
+		return false
+	}
+	if b.bursting {
+		b.bursting = saturated
+	} else {
+		b.bursting = saturated && b.tokens >= 1
+	}
+	return b.bursting
+}
+
+// Saturated 两次采样间的io速率是否达到了基础限速
+func Saturated(iolt *IOLimit, prev, cur IOStat, elapsed time.Duration) bool {
+	seconds := elapsed.Seconds()
+	if seconds <= 0 {
+		return false
+	}
+	checks := []struct {
+		limit uint64
+		prev  uint64
+		cur   uint64
+	}{
+		{iolt.Rbps, prev.Rbytes, cur.Rbytes},
+		{iolt.Riops, prev.Rios, cur.Rios},
+		{iolt.Wbps, prev.Wbytes, cur.Wbytes},
+		{iolt.Wiops, prev.Wios, cur.Wios},
+	}
+	for _, c := range checks {
+		if c.limit == 0 || c.cur < c.prev {
+			continue
+		}
+		if float64(c.cur-c.prev)/seconds >= float64(c.limit)*saturatedRatio {
+			return true
+		}
+	}
+	return false
+}
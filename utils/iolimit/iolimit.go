@@ -19,6 +19,7 @@ package iolimit
 import (
 	"fmt"
 	"github.com/carina-io/carina/utils"
+	"github.com/carina-io/carina/utils/log"
 	libcontainercgroups "github.com/opencontainers/runc/libcontainer/cgroups"
 	cgroupsystemd "github.com/opencontainers/runc/libcontainer/cgroups/systemd"
 	v1 "k8s.io/api/core/v1"
@@ -40,31 +41,56 @@ type CgroupName []string
 
 var errTemplate = "the pod(uid %s)'s cgroup blkio path(%s) is not exist"
 
-func SetIOLimit(blkIO *PodBlkIO) error {
+// SetIOLimit 将各设备的io配置写入pod的cgroup，返回各设备实际生效的配置
+func SetIOLimit(blkIO *PodBlkIO) (map[string]string, error) {
 	blkPath := getPodBlkIOCgroupPath(blkIO)
 	if !utils.DirExists(blkPath) {
-		return fmt.Errorf(errTemplate, blkIO.PodUid, blkPath)
+		return nil, fmt.Errorf(errTemplate, blkIO.PodUid, blkPath)
 	}
+	effective := map[string]string{}
 	if libcontainercgroups.IsCgroup2UnifiedMode() {
 		ioMaxPath := path.Join(blkPath, Cgroupv2BlkIOThrottle)
 		if !utils.FileExists(ioMaxPath) {
-			return fmt.Errorf(errTemplate, blkIO.PodUid, ioMaxPath)
+			return nil, fmt.Errorf(errTemplate, blkIO.PodUid, ioMaxPath)
 		}
 		for deviceNo, deviceIOLimit := range blkIO.DeviceIOSet {
 			ioStr := getCG2IOLimitStr(deviceNo, deviceIOLimit)
 			if err := os.WriteFile(ioMaxPath, []byte(ioStr), 0600); err != nil {
-				return fmt.Errorf("failed to write ioStr(%s) to path(%s)", ioStr, ioMaxPath)
+				return nil, fmt.Errorf("failed to write ioStr(%s) to path(%s)", ioStr, ioMaxPath)
 			}
+			settings := []string{strings.TrimPrefix(ioStr, deviceNo+" ")}
+			weight, err := setCG2IOWeight(blkPath, deviceNo, deviceIOLimit.Weight)
+			if err != nil {
+				return nil, err
+			}
+			if weight != "" {
+				settings = append(settings, weight)
+			}
+			latency, err := setCG2IOLatency(blkPath, deviceNo, deviceIOLimit.Latency)
+			if err != nil {
+				return nil, err
+			}
+			if latency != "" {
+				settings = append(settings, latency)
+			}
+			if burst := deviceIOLimit.Burst; burst != nil {
+				settings = append(settings, fmt.Sprintf("burst(%s seconds=%d)",
+					strings.TrimPrefix(getCG2IOLimitStr(deviceNo, deviceIOLimit.BurstLimit()), deviceNo+" "), burst.Seconds))
+			}
+			effective[deviceNo] = strings.Join(settings, " ")
 		}
-		return nil
+		return effective, nil
 	}
 	ioLimitPath := getCG1IOLimitPaths(blkPath, blkIO.PodUid)
 	for _, blkIOPath := range ioLimitPath {
 		if !utils.FileExists(blkIOPath) {
-			return fmt.Errorf(errTemplate, blkIO.PodUid, blkIOPath)
+			return nil, fmt.Errorf(errTemplate, blkIO.PodUid, blkIOPath)
 		}
 	}
 	for deviceNo, iolt := range blkIO.DeviceIOSet {
+		if iolt.Weight != 0 || iolt.Latency != 0 || iolt.Burst != nil {
+			log.Warnf("%s, %s and burst are only supported by cgroup v2, ignore them, pod uid: %s device: %s", IOWeight, IOLatency, blkIO.PodUid, deviceNo)
+		}
 		for _, throttle := range GetSupportedIOThrottles() {
 			line := deviceNo
 			switch throttle {
@@ -93,16 +119,133 @@ func SetIOLimit(blkIO *PodBlkIO) error {
 					line += " 0"
 				}
 			default:
-				return fmt.Errorf("unsupported throttle type %s", throttle)
+				return nil, fmt.Errorf("unsupported throttle type %s", throttle)
 			}
 			if err := os.WriteFile(ioLimitPath[throttle], []byte(line), 0600); err != nil {
-				return fmt.Errorf("failed to write ioStr(%s) to path(%s)", line, ioLimitPath[throttle])
+				return nil, fmt.Errorf("failed to write ioStr(%s) to path(%s)", line, ioLimitPath[throttle])
 			}
 		}
+		effective[deviceNo] = strings.TrimPrefix(getCG2IOLimitStr(deviceNo, iolt), deviceNo+" ")
+	}
+	return effective, nil
+}
+
+// SetIOMax 只更新设备的io.max，用于突发限速的切换
+func SetIOMax(blkIO *PodBlkIO, deviceNo string, iolt *IOLimit) error {
+	ioMaxPath := path.Join(getPodBlkIOCgroupPath(blkIO), Cgroupv2BlkIOThrottle)
+	if !utils.FileExists(ioMaxPath) {
+		return fmt.Errorf(errTemplate, blkIO.PodUid, ioMaxPath)
+	}
+	ioStr := getCG2IOLimitStr(deviceNo, iolt)
+	if err := os.WriteFile(ioMaxPath, []byte(ioStr), 0600); err != nil {
+		return fmt.Errorf("failed to write ioStr(%s) to path(%s)", ioStr, ioMaxPath)
 	}
 	return nil
 }
 
+// GetIOStat 读取pod cgroup的io.stat，key为设备号
+func GetIOStat(blkIO *PodBlkIO) (map[string]IOStat, error) {
+	ioStatPath := path.Join(getPodBlkIOCgroupPath(blkIO), Cgroupv2IOStat)
+	content, err := os.ReadFile(ioStatPath)
+	if err != nil {
+		return nil, fmt.Errorf(errTemplate, blkIO.PodUid, ioStatPath)
+	}
+	return parseCG2IOStat(string(content)), nil
+}
+
+// IsCgroup2 节点是否使用cgroup v2
+func IsCgroup2() bool {
+	return libcontainercgroups.IsCgroup2UnifiedMode()
+}
+
+// setCG2IOWeight 设备使用bfq调度器时写io.bfq.weight，否则写io.weight，weight为0时恢复默认权重
+func setCG2IOWeight(blkPath, deviceNo string, weight uint64) (string, error) {
+	file := Cgroupv2IOWeight
+	if isBfqScheduler(deviceNo) && utils.FileExists(path.Join(blkPath, Cgroupv2IOBfqWeight)) {
+		file = Cgroupv2IOBfqWeight
+		if weight > MaxIOBfqWeight {
+			weight = MaxIOBfqWeight
+		}
+	}
+	line := deviceNo + " default"
+	if weight != 0 {
+		line = fmt.Sprintf("%s %d", deviceNo, weight)
+	}
+	return writeCG2Setting(blkPath, file, line, weight != 0, fmt.Sprintf("%s=%d", file, weight))
+}
+
+// setCG2IOLatency latency单位为微秒，为0时清除延迟目标
+func setCG2IOLatency(blkPath, deviceNo string, latency uint64) (string, error) {
+	line := deviceNo + " target=max"
+	if latency != 0 {
+		line = fmt.Sprintf("%s target=%d", deviceNo, latency)
+	}
+	return writeCG2Setting(blkPath, Cgroupv2IOLatency, line, latency != 0, fmt.Sprintf("%s=%dus", Cgroupv2IOLatency, latency))
+}
+
+// writeCG2Setting 内核未开启对应控制器时该文件不存在，清除配置时忽略错误
+func writeCG2Setting(blkPath, file, line string, set bool, desc string) (string, error) {
+	settingPath := path.Join(blkPath, file)
+	if !utils.FileExists(settingPath) {
+		if set {
+			return "", fmt.Errorf("%s is not supported, path(%s) is not exist", file, settingPath)
+		}
+		return "", nil
+	}
+	if err := os.WriteFile(settingPath, []byte(line), 0600); err != nil {
+		if set {
+			return "", fmt.Errorf("failed to write %s to path(%s): %v", line, settingPath, err)
+		}
+		log.Debugf("failed to reset %s, path(%s): %v", line, settingPath, err)
+		return "", nil
+	}
+	if !set {
+		return "", nil
+	}
+	return desc, nil
+}
+
+func isBfqScheduler(deviceNo string) bool {
+	content, err := os.ReadFile(path.Join("/sys/dev/block", deviceNo, "queue", "scheduler"))
+	if err != nil {
+		return false
+	}
+	return strings.Contains(string(content), "[bfq]")
+}
+
+func parseCG2IOStat(content string) map[string]IOStat {
+	stats := map[string]IOStat{}
+	for _, line := range strings.Split(content, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		stat := IOStat{}
+		for _, field := range fields[1:] {
+			kv := strings.SplitN(field, "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			v, err := strconv.ParseUint(kv[1], 10, 64)
+			if err != nil {
+				continue
+			}
+			switch kv[0] {
+			case "rbytes":
+				stat.Rbytes = v
+			case "wbytes":
+				stat.Wbytes = v
+			case "rios":
+				stat.Rios = v
+			case "wios":
+				stat.Wios = v
+			}
+		}
+		stats[fields[0]] = stat
+	}
+	return stats
+}
+
 func NewCgroupName(base CgroupName, components ...string) CgroupName {
 	for _, component := range components {
 		if strings.Contains(component, "/") || strings.Contains(component, "_") {
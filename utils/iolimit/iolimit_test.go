@@ -21,6 +21,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	v1 "k8s.io/api/core/v1"
 	"testing"
+	"time"
 )
 
 func TestGetPodBlkIOCgroupPath(t *testing.T) {
@@ -77,3 +78,47 @@ func TestGetPodBlkIOCgroupPath(t *testing.T) {
 		}
 	}
 }
+
+func TestParseCG2IOStat(t *testing.T) {
+	a := assert.New(t)
+	stats := parseCG2IOStat("253:3 rbytes=4096 wbytes=8192 rios=1 wios=2 dbytes=0 dios=0\n8:16 rbytes=10 wbytes=20 rios=3 wios=4\n")
+	a.Len(stats, 2)
+	a.Equal(IOStat{Rbytes: 4096, Wbytes: 8192, Rios: 1, Wios: 2}, stats["253:3"])
+	a.Equal(IOStat{Rbytes: 10, Wbytes: 20, Rios: 3, Wios: 4}, stats["8:16"])
+}
+
+func TestIOLimitValidate(t *testing.T) {
+	a := assert.New(t)
+	a.NoError((&IOLimit{Weight: 100, Latency: 2000}).Validate())
+	a.Error((&IOLimit{Weight: 10001}).Validate())
+	a.NoError((&IOLimit{Wbps: 100, Burst: &IOBurst{Wbps: 200, Seconds: 30}}).Validate())
+	a.Error((&IOLimit{Wbps: 100, Burst: &IOBurst{Wbps: 200}}).Validate())
+	a.Error((&IOLimit{Wbps: 100, Burst: &IOBurst{Wbps: 50, Seconds: 30}}).Validate())
+	a.Error((&IOLimit{Burst: &IOBurst{Rbps: 200, Seconds: 30}}).Validate())
+	a.Error((&IOLimit{Wbps: 100, Burst: &IOBurst{Seconds: 30}}).Validate())
+
+	iolt := &IOLimit{Rbps: 100, Wbps: 100, Burst: &IOBurst{Wbps: 300, Seconds: 30}}
+	burst := iolt.BurstLimit()
+	a.Equal(uint64(100), burst.Rbps)
+	a.Equal(uint64(300), burst.Wbps)
+	a.Equal(uint64(100), iolt.Wbps)
+}
+
+func TestBurstBucket(t *testing.T) {
+	a := assert.New(t)
+	iolt := &IOLimit{Wbps: 1000}
+	a.True(Saturated(iolt, IOStat{Wbytes: 0}, IOStat{Wbytes: 1900}, 2*time.Second))
+	a.False(Saturated(iolt, IOStat{Wbytes: 0}, IOStat{Wbytes: 1000}, 2*time.Second))
+
+	now := time.Now()
+	b := NewBurstBucket(3)
+	a.False(b.Next(now, false))
+	a.True(b.Next(now.Add(time.Second), true))
+	a.True(b.Next(now.Add(2*time.Second), true))
+	a.True(b.Next(now.Add(3*time.Second), true))
+	// 令牌耗尽后退出突发
+	a.False(b.Next(now.Add(4*time.Second), true))
+	// 补充令牌后可以再次突发
+	a.True(b.Next(now.Add(6*time.Second), true))
+	a.False(b.Next(now.Add(7*time.Second), false))
+}
@@ -16,7 +16,11 @@
 
 package iolimit
 
-import "k8s.io/api/core/v1"
+import (
+	"fmt"
+
+	"k8s.io/api/core/v1"
+)
 
 const (
 	BlkIOThrottleReadBPS   = "blkio.throttle.read_bps_device"
@@ -24,6 +28,25 @@ const (
 	BlkIOThrottleWriteBPS  = "blkio.throttle.write_bps_device"
 	BlkIOThrottleWriteIOPS = "blkio.throttle.write_iops_device"
 	Cgroupv2BlkIOThrottle  = "io.max"
+	Cgroupv2IOWeight       = "io.weight"
+	Cgroupv2IOBfqWeight    = "io.bfq.weight"
+	Cgroupv2IOLatency      = "io.latency"
+	Cgroupv2IOStat         = "io.stat"
+)
+
+// 以下配置仅在cgroup v2下生效，可以通过pod或pvc注解设置
+const (
+	IOWeight         = "io.weight"
+	IOLatency        = "io.latency"
+	IOBurstReadBPS   = "io.burst.read_bps_device"
+	IOBurstReadIOPS  = "io.burst.read_iops_device"
+	IOBurstWriteBPS  = "io.burst.write_bps_device"
+	IOBurstWriteIOPS = "io.burst.write_iops_device"
+	IOBurstSeconds   = "io.burst.seconds"
+
+	MinIOWeight    uint64 = 1
+	MaxIOWeight    uint64 = 10000
+	MaxIOBfqWeight uint64 = 1000
 )
 
 // DeviceIOSet key is device number
@@ -40,6 +63,22 @@ type IOLimit struct {
 	Riops uint64
 	Wbps  uint64
 	Wiops uint64
+	// Weight 按比例分配io的权重，0表示使用默认权重
+	Weight uint64
+	// Latency io延迟目标，单位微秒，0表示不设置
+	Latency uint64
+	// Burst 突发限速，nil表示不允许突发
+	Burst *IOBurst
+}
+
+// IOBurst 突发期间临时放宽的io.max限速，0表示该项不突发
+type IOBurst struct {
+	Rbps  uint64
+	Riops uint64
+	Wbps  uint64
+	Wiops uint64
+	// Seconds 令牌桶容量，即最长连续突发秒数
+	Seconds uint64
 }
 
 func (bd1 *IOLimit) Equal(bd2 *IOLimit) bool {
@@ -61,9 +100,113 @@ func (bd1 *IOLimit) Equal(bd2 *IOLimit) bool {
 	if bd1.Wbps != bd2.Wbps {
 		return false
 	}
+	if bd1.Weight != bd2.Weight {
+		return false
+	}
+	if bd1.Latency != bd2.Latency {
+		return false
+	}
+	if bd1.Burst == bd2.Burst {
+		return true
+	}
+	if bd1.Burst == nil || bd2.Burst == nil {
+		return false
+	}
+	return *bd1.Burst == *bd2.Burst
+}
+
+// IsEmpty 未设置任何io配置
+func (bd1 *IOLimit) IsEmpty() bool {
+	return bd1 == nil || bd1.Equal(&IOLimit{})
+}
+
+// Validate 校验突发配置，突发限速必须大于对应的基础限速
+func (bd1 *IOLimit) Validate() error {
+	if bd1.Weight != 0 && (bd1.Weight < MinIOWeight || bd1.Weight > MaxIOWeight) {
+		return fmt.Errorf("%s %d out of range [%d, %d]", IOWeight, bd1.Weight, MinIOWeight, MaxIOWeight)
+	}
+	if bd1.Burst == nil {
+		return nil
+	}
+	if bd1.Burst.Seconds == 0 {
+		return fmt.Errorf("%s must be greater than 0", IOBurstSeconds)
+	}
+	checks := []struct {
+		name  string
+		base  uint64
+		burst uint64
+	}{
+		{IOBurstReadBPS, bd1.Rbps, bd1.Burst.Rbps},
+		{IOBurstReadIOPS, bd1.Riops, bd1.Burst.Riops},
+		{IOBurstWriteBPS, bd1.Wbps, bd1.Burst.Wbps},
+		{IOBurstWriteIOPS, bd1.Wiops, bd1.Burst.Wiops},
+	}
+	var bursts int
+	for _, c := range checks {
+		if c.burst == 0 {
+			continue
+		}
+		if c.base == 0 {
+			return fmt.Errorf("%s requires the corresponding throttle", c.name)
+		}
+		if c.burst <= c.base {
+			return fmt.Errorf("%s %d must be greater than the throttle %d", c.name, c.burst, c.base)
+		}
+		bursts++
+	}
+	if bursts == 0 {
+		return fmt.Errorf("%s is set without any burst throttle", IOBurstSeconds)
+	}
+	return nil
+}
+
+// BurstLimit 突发期间写入io.max的限速
+func (bd1 *IOLimit) BurstLimit() *IOLimit {
+	limit := *bd1
+	if bd1.Burst == nil {
+		return &limit
+	}
+	if bd1.Burst.Rbps != 0 {
+		limit.Rbps = bd1.Burst.Rbps
+	}
+	if bd1.Burst.Riops != 0 {
+		limit.Riops = bd1.Burst.Riops
+	}
+	if bd1.Burst.Wbps != 0 {
+		limit.Wbps = bd1.Burst.Wbps
+	}
+	if bd1.Burst.Wiops != 0 {
+		limit.Wiops = bd1.Burst.Wiops
+	}
+	return &limit
+}
+
+func (set DeviceIOSet) Equal(other DeviceIOSet) bool {
+	if len(set) != len(other) {
+		return false
+	}
+	for deviceNo, iolt := range set {
+		if !iolt.Equal(other[deviceNo]) {
+			return false
+		}
+	}
+	return true
+}
+
+// IsEmpty 所有设备都未设置io配置
+func (set DeviceIOSet) IsEmpty() bool {
+	for _, iolt := range set {
+		if !iolt.IsEmpty() {
+			return false
+		}
+	}
 	return true
 }
 
 func GetSupportedIOThrottles() []string {
 	return []string{BlkIOThrottleReadBPS, BlkIOThrottleReadIOPS, BlkIOThrottleWriteBPS, BlkIOThrottleWriteIOPS}
 }
+
+func GetSupportedIOSettings() []string {
+	return []string{IOWeight, IOLatency, IOBurstReadBPS, IOBurstReadIOPS, IOBurstWriteBPS, IOBurstWriteIOPS, IOBurstSeconds}
+}
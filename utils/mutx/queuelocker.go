@@ -0,0 +1,121 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package mutx
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// QueuedLocks 按key加锁，锁被占用时调用者排队等待，按FIFO顺序获得锁
+// 每个key的等待队列长度有上限，等待可通过context取消或超时
+type QueuedLocks struct {
+	mux        sync.Mutex
+	queues     map[string]*lockQueue
+	maxWaiters int
+}
+
+type lockQueue struct {
+	held    bool
+	waiters []chan struct{}
+}
+
+// NewQueuedLocks returns new QueuedLocks, maxWaiters is the queue length limit of each key.
+func NewQueuedLocks(maxWaiters int) *QueuedLocks {
+	return &QueuedLocks{
+		queues:     map[string]*lockQueue{},
+		maxWaiters: maxWaiters,
+	}
+}
+
+// Acquire 获取key的锁，锁被占用时排队等待，直到获得锁、ctx结束或者等待队列已满
+func (ql *QueuedLocks) Acquire(ctx context.Context, key string) error {
+	ql.mux.Lock()
+	q, ok := ql.queues[key]
+	if !ok {
+		q = &lockQueue{}
+		ql.queues[key] = q
+	}
+	if !q.held {
+		q.held = true
+		ql.mux.Unlock()
+		return nil
+	}
+	if len(q.waiters) >= ql.maxWaiters {
+		ql.mux.Unlock()
+		return fmt.Errorf("too many operations waiting for lock %s", key)
+	}
+	ready := make(chan struct{})
+	q.waiters = append(q.waiters, ready)
+	ql.mux.Unlock()
+
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+		ql.mux.Lock()
+		for i, w := range q.waiters {
+			if w == ready {
+				q.waiters = append(q.waiters[:i], q.waiters[i+1:]...)
+				ql.mux.Unlock()
+				return fmt.Errorf("wait for lock %s: %w", key, ctx.Err())
+			}
+		}
+		ql.mux.Unlock()
+		// 取消的同时已获得锁，交给下一个等待者
+		ql.Release(key)
+		return fmt.Errorf("wait for lock %s: %w", key, ctx.Err())
+	}
+}
+
+// AcquireAll 按顺序获取多个key的锁，失败时释放已获得的锁
+func (ql *QueuedLocks) AcquireAll(ctx context.Context, keys ...string) error {
+	for i, key := range keys {
+		if err := ql.Acquire(ctx, key); err != nil {
+			for j := i - 1; j >= 0; j-- {
+				ql.Release(keys[j])
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// Release 释放key的锁，有等待者时直接交给队首的等待者
+func (ql *QueuedLocks) Release(key string) {
+	ql.mux.Lock()
+	defer ql.mux.Unlock()
+	q, ok := ql.queues[key]
+	if !ok {
+		return
+	}
+	if len(q.waiters) > 0 {
+		ready := q.waiters[0]
+		q.waiters = q.waiters[1:]
+		close(ready)
+		return
+	}
+	delete(ql.queues, key)
+}
+
+// ReleaseAll 按相反顺序释放多个key的锁
+func (ql *QueuedLocks) ReleaseAll(keys ...string) {
+	for i := len(keys) - 1; i >= 0; i-- {
+		ql.Release(keys[i])
+	}
+}
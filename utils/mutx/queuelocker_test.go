@@ -0,0 +1,73 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package mutx
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueuedLocksFIFO(t *testing.T) {
+	a := assert.New(t)
+	ql := NewQueuedLocks(10)
+	a.NoError(ql.Acquire(context.Background(), "vg1"))
+	// 不同key互不影响
+	a.NoError(ql.Acquire(context.Background(), "vg2"))
+	ql.Release("vg2")
+
+	order := make(chan int, 3)
+	for i := 0; i < 3; i++ {
+		go func(i int) {
+			_ = ql.Acquire(context.Background(), "vg1")
+			order <- i
+			ql.Release("vg1")
+		}(i)
+		// 保证按顺序进入等待队列
+		time.Sleep(20 * time.Millisecond)
+	}
+	ql.Release("vg1")
+	for i := 0; i < 3; i++ {
+		a.Equal(i, <-order)
+	}
+}
+
+func TestQueuedLocksTimeout(t *testing.T) {
+	a := assert.New(t)
+	ql := NewQueuedLocks(1)
+	a.NoError(ql.Acquire(context.Background(), "vg1"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	done := make(chan error)
+	go func() { done <- ql.Acquire(ctx, "vg1") }()
+	time.Sleep(10 * time.Millisecond)
+
+	// 等待队列已满
+	a.Error(ql.Acquire(context.Background(), "vg1"))
+
+	err := <-done
+	a.True(errors.Is(err, context.DeadlineExceeded))
+
+	// 超时的等待者不会获得锁
+	ql.Release("vg1")
+	a.NoError(ql.Acquire(context.Background(), "vg1"))
+	ql.Release("vg1")
+}